@@ -0,0 +1,51 @@
+import { describe, expect, it } from 'vitest'
+import { applyEvent, createSSEParser, subscribeEvents } from '../eventStream'
+
+describe('createSSEParser', () => {
+  it('parses events split across chunks and skips comments', () => {
+    const seen = []
+    const parse = createSSEParser((e) => seen.push(e))
+    parse('retry: 3000\n\n: ping\n\nid: 7\nevent: checkpoint\nda')
+    parse('ta: {"tree_size":3}\n\n')
+    expect(seen).toEqual([{ id: '7', event: 'checkpoint', data: '{"tree_size":3}' }])
+  })
+})
+
+describe('applyEvent', () => {
+  it('tracks status transitions and the newest checkpoint', () => {
+    let state = { evidence: {}, checkpoint: null }
+    state = applyEvent(state, { event: 'evidence.status', data: '{"evidence_id":"e1","to":"stored"}' })
+    state = applyEvent(state, { event: 'evidence.status', data: '{"evidence_id":"e1","from":"stored","to":"sequenced","leaf_index":4}' })
+    state = applyEvent(state, { event: 'checkpoint', data: '{"tree_size":5}' })
+    state = applyEvent(state, { event: 'checkpoint', data: '{"tree_size":4}' })
+    expect(state.evidence.e1).toEqual({ status: 'sequenced', leafIndex: 4 })
+    expect(state.checkpoint.tree_size).toBe(5)
+  })
+})
+
+describe('subscribeEvents', () => {
+  it('sends the bearer token and resumes with Last-Event-ID', async () => {
+    const calls = []
+    const encoder = new TextEncoder()
+    const fetchImpl = async (url, opts) => {
+      calls.push({ url, headers: opts.headers })
+      const chunks = calls.length === 1 ? [encoder.encode('id: 9\nevent: checkpoint\ndata: {"tree_size":1}\n\n')] : []
+      return {
+        ok: true,
+        body: {
+          getReader: () => ({
+            read: async () => (chunks.length > 0 ? { value: chunks.shift(), done: false } : { done: true }),
+          }),
+        },
+      }
+    }
+    const events = []
+    const stop = subscribeEvents({ token: 't', types: ['checkpoint'], onEvent: (e) => events.push(e), retryMs: 1, fetchImpl })
+    await new Promise((resolve) => setTimeout(resolve, 30))
+    stop()
+    expect(events[0].id).toBe('9')
+    expect(calls[0].url).toBe('/api/v1/events?types=checkpoint')
+    expect(calls[0].headers.Authorization).toBe('Bearer t')
+    expect(calls[1].headers['Last-Event-ID']).toBe('9')
+  })
+})
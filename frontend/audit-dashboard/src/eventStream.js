@@ -0,0 +1,112 @@
+// Live updates from vault-api's /api/v1/events server-sent event stream.
+// EventSource cannot send an Authorization header, so the stream is read
+// with fetch and parsed here; reconnects resume from the last event ID.
+
+export const EVENT_STATUS = 'evidence.status'
+export const EVENT_CHECKPOINT = 'checkpoint'
+
+// createSSEParser returns a function that accepts text chunks and calls
+// onEvent({ id, event, data }) for every complete event. Comments and
+// retry hints are ignored.
+export function createSSEParser(onEvent) {
+  let buffer = ''
+  let id = ''
+  let event = ''
+  let data = []
+
+  const dispatch = () => {
+    if (data.length > 0) {
+      onEvent({ id, event: event || 'message', data: data.join('\n') })
+    }
+    event = ''
+    data = []
+  }
+
+  return (chunk) => {
+    buffer += chunk
+    let nl
+    while ((nl = buffer.search(/\r?\n/)) >= 0) {
+      const line = buffer.slice(0, nl)
+      buffer = buffer.slice(nl + (buffer[nl] === '\r' ? 2 : 1))
+      if (line === '') {
+        dispatch()
+        continue
+      }
+      if (line.startsWith(':')) continue
+      const colon = line.indexOf(':')
+      const field = colon < 0 ? line : line.slice(0, colon)
+      let value = colon < 0 ? '' : line.slice(colon + 1)
+      if (value.startsWith(' ')) value = value.slice(1)
+      if (field === 'id') id = value
+      else if (field === 'event') event = value
+      else if (field === 'data') data.push(value)
+    }
+  }
+}
+
+// applyEvent folds one parsed event into dashboard state:
+// { evidence: { [id]: { status, leafIndex } }, checkpoint }.
+export function applyEvent(state, evt) {
+  const payload = JSON.parse(evt.data)
+  if (evt.event === EVENT_STATUS) {
+    const prev = state.evidence[payload.evidence_id] || {}
+    return {
+      ...state,
+      evidence: {
+        ...state.evidence,
+        [payload.evidence_id]: {
+          status: payload.to,
+          leafIndex: payload.leaf_index ?? prev.leafIndex ?? null,
+        },
+      },
+    }
+  }
+  if (evt.event === EVENT_CHECKPOINT) {
+    if (state.checkpoint && state.checkpoint.tree_size >= payload.tree_size) return state
+    return { ...state, checkpoint: payload }
+  }
+  return state
+}
+
+// subscribeEvents streams events until the returned function is called.
+// The connection is re-established after errors with Last-Event-ID set.
+export function subscribeEvents({ baseUrl = '', token, types = [], onEvent, onError, retryMs = 3000, fetchImpl = fetch }) {
+  let lastId = ''
+  let stopped = false
+  let controller = null
+
+  const connect = async () => {
+    while (!stopped) {
+      controller = new AbortController()
+      const query = types.length > 0 ? `?types=${encodeURIComponent(types.join(','))}` : ''
+      const headers = { Accept: 'text/event-stream' }
+      if (token) headers.Authorization = `Bearer ${token}`
+      if (lastId) headers['Last-Event-ID'] = lastId
+      try {
+        const resp = await fetchImpl(`${baseUrl}/api/v1/events${query}`, { headers, signal: controller.signal })
+        if (!resp.ok) throw new Error(`event stream returned ${resp.status}`)
+        const parse = createSSEParser((evt) => {
+          if (evt.id) lastId = evt.id
+          onEvent(evt)
+        })
+        const reader = resp.body.getReader()
+        const decoder = new TextDecoder()
+        for (;;) {
+          const { value, done } = await reader.read()
+          if (done) break
+          parse(decoder.decode(value, { stream: true }))
+        }
+      } catch (err) {
+        if (stopped) return
+        if (onError) onError(err)
+      }
+      if (!stopped) await new Promise((resolve) => setTimeout(resolve, retryMs))
+    }
+  }
+  connect()
+
+  return () => {
+    stopped = true
+    if (controller) controller.abort()
+  }
+}
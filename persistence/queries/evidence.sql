@@ -1,7 +1,7 @@
 -- name: InsertEvidence :one
 INSERT INTO evidence (id, content_type, content_hash, payload_ref, labels, ingested_by)
 VALUES ($1, $2, $3, $4, $5, $6)
-ON CONFLICT (content_hash) DO NOTHING
+ON CONFLICT DO NOTHING
 RETURNING id, content_hash, leaf_index;
 
 -- name: GetEvidenceByID :one
@@ -14,7 +14,7 @@ ON CONFLICT DO NOTHING;
 
 -- name: InsertSTH :one
 INSERT INTO signed_tree_heads (tree_size, root_hash, key_id, signature)
-VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING RETURNING id;
+VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING RETURNING tree_size;
 
 -- name: LatestSTH :one
 SELECT tree_size, root_hash, key_id, signature, published_at
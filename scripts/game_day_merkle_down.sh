@@ -54,7 +54,7 @@ api_post_evidence(){
 
 api_get_proof_code(){
   local id="$1"
-  curl -sS -o /dev/null -w "%{http_code}" "$API_URL/api/v1/evidence/$id/proof"
+  curl -sS -o /dev/null -w "%{http_code}" -H "Authorization: Bearer $TOKEN" "$API_URL/api/v1/evidence/$id/proof"
 }
 
 start_epoch="$(date +%s)"
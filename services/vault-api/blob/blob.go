@@ -0,0 +1,197 @@
+package blob
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ErrNotFound is returned for unknown staging uploads or object refs.
+var ErrNotFound = errors.New("blob not found")
+
+const refPrefix = "sha256:"
+
+var (
+	uploadIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,128}$`)
+	hashPattern     = regexp.MustCompile(`^[0-9a-f]{64}$`)
+)
+
+// Store keeps evidence payloads. Writes go to a staging object first and are
+// committed under their content hash, so duplicate payloads share storage.
+type Store interface {
+	// Create starts an empty staging object.
+	Create(ctx context.Context, uploadID string) error
+	// Append streams r onto the end of a staging object and returns the
+	// number of bytes written.
+	Append(ctx context.Context, uploadID string, r io.Reader) (int64, error)
+	// Size reports the current length of a staging object.
+	Size(ctx context.Context, uploadID string) (int64, error)
+	// Commit moves a staging object to its content-addressed location and
+	// returns the payload ref stored on the evidence record.
+	Commit(ctx context.Context, uploadID, contentHash string) (string, error)
+	// Abort discards a staging object.
+	Abort(ctx context.Context, uploadID string) error
+	// Open reads a committed payload.
+	Open(ctx context.Context, ref string) (io.ReadCloser, error)
+}
+
+var (
+	mu      sync.Mutex
+	current Store
+)
+
+// Current returns the process-wide payload store, creating a filesystem store
+// under BLOB_STORE_DIR (default: <tmp>/vault-payloads) on first use.
+func Current() (Store, error) {
+	mu.Lock()
+	defer mu.Unlock()
+	if current != nil {
+		return current, nil
+	}
+	dir := strings.TrimSpace(os.Getenv("BLOB_STORE_DIR"))
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "vault-payloads")
+	}
+	fs, err := NewFileStore(dir)
+	if err != nil {
+		return nil, err
+	}
+	current = fs
+	return current, nil
+}
+
+// SetCurrent overrides the process-wide store (used by tests and wiring).
+func SetCurrent(s Store) {
+	mu.Lock()
+	defer mu.Unlock()
+	current = s
+}
+
+// FileStore is a Store on the local filesystem.
+type FileStore struct {
+	root string
+}
+
+func NewFileStore(root string) (*FileStore, error) {
+	for _, sub := range []string{"staging", "objects"} {
+		if err := os.MkdirAll(filepath.Join(root, sub), 0o700); err != nil {
+			return nil, fmt.Errorf("create blob dir: %w", err)
+		}
+	}
+	return &FileStore{root: root}, nil
+}
+
+func (f *FileStore) stagingPath(uploadID string) (string, error) {
+	if !uploadIDPattern.MatchString(uploadID) {
+		return "", fmt.Errorf("invalid upload id %q", uploadID)
+	}
+	return filepath.Join(f.root, "staging", uploadID), nil
+}
+
+func (f *FileStore) objectPath(contentHash string) (string, error) {
+	if !hashPattern.MatchString(contentHash) {
+		return "", fmt.Errorf("invalid content hash %q", contentHash)
+	}
+	return filepath.Join(f.root, "objects", contentHash[:2], contentHash), nil
+}
+
+func (f *FileStore) Create(ctx context.Context, uploadID string) error {
+	p, err := f.stagingPath(uploadID)
+	if err != nil {
+		return err
+	}
+	fh, err := os.OpenFile(p, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	return fh.Close()
+}
+
+func (f *FileStore) Append(ctx context.Context, uploadID string, r io.Reader) (int64, error) {
+	p, err := f.stagingPath(uploadID)
+	if err != nil {
+		return 0, err
+	}
+	fh, err := os.OpenFile(p, os.O_WRONLY|os.O_APPEND, 0o600)
+	if os.IsNotExist(err) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(fh, r)
+	if cerr := fh.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+func (f *FileStore) Size(ctx context.Context, uploadID string) (int64, error) {
+	p, err := f.stagingPath(uploadID)
+	if err != nil {
+		return 0, err
+	}
+	st, err := os.Stat(p)
+	if os.IsNotExist(err) {
+		return 0, ErrNotFound
+	}
+	if err != nil {
+		return 0, err
+	}
+	return st.Size(), nil
+}
+
+func (f *FileStore) Commit(ctx context.Context, uploadID, contentHash string) (string, error) {
+	src, err := f.stagingPath(uploadID)
+	if err != nil {
+		return "", err
+	}
+	dst, err := f.objectPath(contentHash)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(src); os.IsNotExist(err) {
+		return "", ErrNotFound
+	}
+	if _, err := os.Stat(dst); err == nil {
+		// identical content is already stored
+		_ = os.Remove(src)
+		return refPrefix + contentHash, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+		return "", err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return "", err
+	}
+	return refPrefix + contentHash, nil
+}
+
+func (f *FileStore) Abort(ctx context.Context, uploadID string) error {
+	p, err := f.stagingPath(uploadID)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (f *FileStore) Open(ctx context.Context, ref string) (io.ReadCloser, error) {
+	p, err := f.objectPath(strings.TrimPrefix(ref, refPrefix))
+	if err != nil {
+		return nil, err
+	}
+	fh, err := os.Open(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return fh, err
+}
@@ -0,0 +1,81 @@
+package blob
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestFileStoreStageAppendCommit(t *testing.T) {
+	ctx := context.Background()
+	fs, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	if err := fs.Create(ctx, "up-1"); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	for _, chunk := range []string{"hello ", "world"} {
+		if _, err := fs.Append(ctx, "up-1", strings.NewReader(chunk)); err != nil {
+			t.Fatalf("append: %v", err)
+		}
+	}
+	if n, err := fs.Size(ctx, "up-1"); err != nil || n != 11 {
+		t.Fatalf("size = %d, %v; want 11", n, err)
+	}
+
+	sum := sha256.Sum256([]byte("hello world"))
+	hash := hex.EncodeToString(sum[:])
+	ref, err := fs.Commit(ctx, "up-1", hash)
+	if err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+	if ref != "sha256:"+hash {
+		t.Fatalf("unexpected ref %q", ref)
+	}
+	if _, err := fs.Size(ctx, "up-1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("staging object should be gone after commit, got %v", err)
+	}
+
+	rc, err := fs.Open(ctx, ref)
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer rc.Close()
+	got, _ := io.ReadAll(rc)
+	if string(got) != "hello world" {
+		t.Fatalf("unexpected payload %q", got)
+	}
+
+	// committing identical content again reuses the stored object
+	if err := fs.Create(ctx, "up-2"); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := fs.Append(ctx, "up-2", strings.NewReader("hello world")); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if ref2, err := fs.Commit(ctx, "up-2", hash); err != nil || ref2 != ref {
+		t.Fatalf("duplicate commit = %q, %v", ref2, err)
+	}
+}
+
+func TestFileStoreRejectsUnsafeNames(t *testing.T) {
+	ctx := context.Background()
+	fs, err := NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("new store: %v", err)
+	}
+	if err := fs.Create(ctx, "../escape"); err == nil {
+		t.Fatalf("expected invalid upload id error")
+	}
+	if _, err := fs.Open(ctx, "sha256:../../etc/passwd"); err == nil {
+		t.Fatalf("expected invalid ref error")
+	}
+	if _, err := fs.Append(ctx, "missing", strings.NewReader("x")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, got %v", err)
+	}
+}
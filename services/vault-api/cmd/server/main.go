@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"net/http"
 	"os"
 	"time"
@@ -8,14 +9,44 @@ import (
 	"github.com/go-chi/chi/v5"
 	"github.com/rs/zerolog/log"
 
+	"github.com/SaridakisStamatisChristos/vault-api/domain/merkle"
 	"github.com/SaridakisStamatisChristos/vault-api/handler"
+	"github.com/SaridakisStamatisChristos/vault-api/internal/certauth"
 	"github.com/SaridakisStamatisChristos/vault-api/middleware"
+	"github.com/SaridakisStamatisChristos/vault-api/store"
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		os.Exit(runMigrate(os.Args[2:], os.Stdout, os.Stderr))
+	}
 	if err := middleware.ValidateAuthStartupConfig(); err != nil {
 		log.Fatal().Err(err).Msg("invalid auth startup configuration")
 	}
+	if err := middleware.ValidateRBACConfig(); err != nil {
+		log.Fatal().Err(err).Msg("invalid RBAC configuration")
+	}
+	if err := middleware.ConfigureRateLimit(); err != nil {
+		log.Fatal().Err(err).Msg("invalid rate limit configuration")
+	}
+	tlsConfig, err := certauth.TLSConfigFromEnv()
+	if err != nil {
+		log.Fatal().Err(err).Msg("invalid TLS configuration")
+	}
+	if err := middleware.ConfigureClientCertAuth(); err != nil {
+		log.Fatal().Err(err).Msg("invalid client certificate mapping")
+	}
+	if os.Getenv("CLIENT_CERT_MAPPING_FILE") != "" && (tlsConfig == nil || tlsConfig.ClientCAs == nil) {
+		log.Fatal().Msg("CLIENT_CERT_MAPPING_FILE requires TLS_CLIENT_CA_FILE")
+	}
+	if err := handler.ValidateIngestConfig(); err != nil {
+		log.Fatal().Err(err).Msg("invalid ingest configuration")
+	}
+	// without DATABASE_URL or STORE_DIR the vault runs on the in-memory store
+	s, err := store.Init(context.Background())
+	if err != nil {
+		log.Fatal().Err(err).Msg("failed to initialise store")
+	}
 
 	r := chi.NewRouter()
 	r.Use(middleware.SecurityHeaders)
@@ -31,20 +62,39 @@ func main() {
 	})
 	r.Handle("/metrics", middleware.MetricsHandler())
 
-	// API routes (minimal in-memory implementation for tests)
-	h := handler.NewIngestHandler()
-	handler.StartCommitter(1 * time.Second)
+	// API routes; all evidence state lives in the store
+	h := handler.NewIngestHandler(s, merkle.NewMemoryEngine())
+	h.StartCommitter(1 * time.Second)
+	h.StartPromiseChecker(5 * time.Second)
+	if err := handler.StartAuditForwarder(context.Background()); err != nil {
+		log.Fatal().Err(err).Msg("invalid audit forwarder configuration")
+	}
+	if err := h.StartWebhookDispatcher(context.Background()); err != nil {
+		log.Fatal().Err(err).Msg("invalid webhook configuration")
+	}
+	if err := h.StartPipeline(context.Background()); err != nil {
+		log.Fatal().Err(err).Msg("invalid ingest pipeline configuration")
+	}
+	if err := startPolicyEngine(context.Background(), h); err != nil {
+		log.Fatal().Err(err).Msg("invalid policy bundle configuration")
+	}
+	middleware.SetAPIKeyVerifier(h.VerifyAPIKey)
+	// every API route is limited per client address, authenticates, is
+	// limited per subject, resolves the tenant it acts for and then checks
+	// route permissions; /api/v1/tenants/{tenant} serves the same routes
+	// for the named tenant
 	r.Route("/api/v1", func(r chi.Router) {
-		r.Post("/evidence", h.Ingest)
-		r.Get("/evidence/{id}", h.GetEvidence)
-		r.Get("/evidence/{id}/proof", h.GetProof)
-
-		// audit and checkpoint endpoints (JWT middleware)
-		r.With(middleware.JWT).Get("/audit", h.GetAudit)
-		r.With(middleware.JWT).Get("/checkpoints", h.GetCheckpointsHistory)
-		r.With(middleware.JWT).Get("/checkpoints/latest", h.GetCheckpointsLatest)
-		r.With(middleware.JWT).Get("/checkpoints/latest/verify", h.VerifyLatestCheckpoint)
-		r.With(middleware.JWT).Get("/checkpoints/{treeSize}/verify", h.VerifyCheckpointByTreeSize)
+		r.Use(middleware.RateLimitIP)
+		r.Use(middleware.JWT)
+		r.Use(middleware.RateLimit)
+		r.Group(func(r chi.Router) {
+			r.Use(h.ResolveTenant)
+			apiRoutes(r, h)
+		})
+		r.Route("/tenants/{tenant}", func(r chi.Router) {
+			r.Use(h.ResolveTenant)
+			apiRoutes(r, h)
+		})
 	})
 
 	addr := os.Getenv("HTTP_ADDR")
@@ -57,10 +107,65 @@ func main() {
 		Handler:      r,
 		ReadTimeout:  30 * time.Second,
 		WriteTimeout: 60 * time.Second,
+		TLSConfig:    tlsConfig,
 	}
 
-	log.Info().Msgf("starting vault-api on %s", addr)
-	if err := srv.ListenAndServe(); err != nil {
+	if tlsConfig != nil {
+		log.Info().Msgf("starting vault-api on %s (TLS)", addr)
+		err = srv.ListenAndServeTLS("", "")
+	} else {
+		log.Info().Msgf("starting vault-api on %s", addr)
+		err = srv.ListenAndServe()
+	}
+	if err != nil {
 		log.Error().Err(err).Msg("server exited")
 	}
 }
+
+// apiRoutes registers the evidence API on r.
+func apiRoutes(r chi.Router, h *handler.IngestHandler) {
+	write := middleware.Require(middleware.PermEvidenceWrite)
+	read := middleware.Require(middleware.PermEvidenceRead)
+	manage := middleware.Require(middleware.PermEvidenceManage)
+	audit := middleware.Require(middleware.PermAuditRead)
+	checkpoints := middleware.Require(middleware.PermCheckpointRead)
+	webhooks := middleware.Require(middleware.PermWebhookManage)
+	r.With(write).Post("/evidence", h.Ingest)
+	r.With(write).Post("/evidence:batch", h.IngestBatch)
+	r.With(write).Post("/evidence/upload", h.Upload)
+	r.With(write).Post("/uploads", h.CreateUpload)
+	r.With(write).Head("/uploads/{id}", h.UploadStatus)
+	r.With(write).Patch("/uploads/{id}", h.AppendUpload)
+	r.With(write).Delete("/uploads/{id}", h.AbortUpload)
+	r.With(write).Post("/uploads/{id}/complete", h.CompleteUpload)
+	r.With(read).Get("/evidence", h.SearchEvidence)
+	r.With(read).Get("/promises/key", h.PromiseKey)
+	r.With(read).Get("/evidence/{id}", h.GetEvidence)
+	r.With(read).Get("/evidence/{id}/proof", h.GetProof)
+	r.With(middleware.Require(middleware.PermPayloadRead)).Get("/evidence/{id}/payload", h.GetPayload)
+	r.With(read).Get("/events", h.Events)
+	// any authenticated caller may read its own quotas
+	r.Get("/quotas/me", h.GetMyQuotas)
+
+	r.With(audit).Get("/audit", h.GetAudit)
+	r.With(audit).Get("/audit/export", h.ExportAudit)
+	r.With(checkpoints).Get("/checkpoints", h.GetCheckpointsHistory)
+	r.With(checkpoints).Get("/checkpoints/latest", h.GetCheckpointsLatest)
+	r.With(checkpoints).Get("/checkpoints/latest/verify", h.VerifyLatestCheckpoint)
+	r.With(checkpoints).Get("/checkpoints/{treeSize}/verify", h.VerifyCheckpointByTreeSize)
+	r.With(manage).Post("/evidence/{id}/status", h.SetEvidenceStatus)
+	r.With(manage).Delete("/evidence/{id}/hold", h.ReleaseHold)
+	r.With(webhooks).Post("/webhooks", h.CreateWebhook)
+	r.With(webhooks).Get("/webhooks", h.ListWebhooks)
+	r.With(webhooks).Get("/webhooks/dead-letters", h.WebhookDeadLetters)
+	r.With(webhooks).Post("/webhooks/deliveries/{deliveryID}/redeliver", h.RedeliverWebhook)
+	r.With(webhooks).Get("/webhooks/{id}", h.GetWebhook)
+	r.With(webhooks).Delete("/webhooks/{id}", h.DeleteWebhook)
+	r.With(webhooks).Get("/webhooks/{id}/deliveries", h.WebhookDeliveries)
+	r.With(middleware.Require(middleware.PermPolicyEvaluate)).Post("/policy/evaluate", h.EvaluateAccess)
+	keys := middleware.Require(middleware.PermAdminKeys)
+	r.With(keys).Post("/api-keys", h.CreateAPIKey)
+	r.With(keys).Get("/api-keys", h.ListAPIKeys)
+	r.With(keys).Delete("/api-keys/{id}", h.RevokeAPIKey)
+	r.With(keys).Post("/api-keys/{id}/rotate", h.RotateAPIKey)
+}
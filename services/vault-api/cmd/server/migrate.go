@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+)
+
+const migrateUsage = `usage: vault-api migrate <command>
+
+Commands run against DATABASE_URL:
+  up      apply pending migrations
+  status  list embedded and applied migrations
+  verify  exit 1 on drift, 2 when migrations are pending
+`
+
+// runMigrate implements the migrate admin command and returns the process
+// exit code.
+func runMigrate(args []string, stdout, stderr io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprint(stderr, migrateUsage)
+		return 64
+	}
+	dbURL := os.Getenv("DATABASE_URL")
+	if dbURL == "" {
+		fmt.Fprintln(stderr, "migrate: DATABASE_URL is not set")
+		return 64
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+	pool, err := pgxpool.New(ctx, dbURL)
+	if err != nil {
+		fmt.Fprintln(stderr, "migrate:", err)
+		return 1
+	}
+	defer pool.Close()
+	m := store.NewMigrator(pool)
+
+	switch args[0] {
+	case "up":
+		done, err := m.Up(ctx)
+		for _, mig := range done {
+			fmt.Fprintf(stdout, "applied %04d_%s\n", mig.Version, mig.Name)
+		}
+		if err != nil {
+			fmt.Fprintln(stderr, "migrate:", err)
+			return 1
+		}
+		if len(done) == 0 {
+			fmt.Fprintln(stdout, "schema is up to date")
+		}
+	case "status":
+		status, err := m.Status(ctx)
+		if err != nil {
+			fmt.Fprintln(stderr, "migrate:", err)
+			return 1
+		}
+		w := tabwriter.NewWriter(stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tSTATE\tAPPLIED AT")
+		for _, st := range status {
+			at := "-"
+			if !st.AppliedAt.IsZero() {
+				at = st.AppliedAt.UTC().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\t%s\n", st.Version, st.Name, st.State, at)
+		}
+		w.Flush()
+	case "verify":
+		err := m.Verify(ctx)
+		switch {
+		case errors.Is(err, store.ErrMigrationsPending):
+			fmt.Fprintln(stderr, "migrate:", err)
+			return 2
+		case err != nil:
+			fmt.Fprintln(stderr, "migrate:", err)
+			return 1
+		}
+		fmt.Fprintln(stdout, "schema matches the embedded migrations")
+	default:
+		fmt.Fprint(stderr, migrateUsage)
+		return 64
+	}
+	return 0
+}
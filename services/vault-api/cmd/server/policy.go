@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+
+	"github.com/rs/zerolog/log"
+
+	"github.com/SaridakisStamatisChristos/vault-api/handler"
+	"github.com/SaridakisStamatisChristos/vault-api/internal/regopolicy"
+	"github.com/SaridakisStamatisChristos/vault-api/middleware"
+)
+
+// startPolicyEngine delegates authorization to the Rego bundle at
+// OPA_BUNDLE_DIR, recording decisions in h's audit trail, and watches the
+// bundle for changes. Without a bundle the role mapping decides.
+func startPolicyEngine(ctx context.Context, h *handler.IngestHandler) error {
+	interval, err := regopolicy.ReloadIntervalFromEnv()
+	if err != nil {
+		return err
+	}
+	engine, err := regopolicy.LoadFromEnv(ctx)
+	if err != nil || engine == nil {
+		return err
+	}
+	middleware.SetDecisionRecorder(h.RecordAuthzDecision)
+	middleware.SetAuthorizer(engine)
+	go engine.Watch(ctx, interval)
+	log.Info().Str("policy_version", engine.Version()).Dur("reload_interval", interval).Msg("Rego policy engine enabled")
+	return nil
+}
@@ -0,0 +1,59 @@
+package evidence
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+const (
+	MaxLabels          = 64
+	MaxLabelValueBytes = 256
+)
+
+var labelKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._/-]{0,62}$`)
+
+// ValidateLabels checks label keys and values accepted at ingest.
+func ValidateLabels(labels map[string]string) error {
+	if len(labels) > MaxLabels {
+		return fmt.Errorf("too many labels: %d > %d", len(labels), MaxLabels)
+	}
+	for k, v := range labels {
+		if !labelKeyPattern.MatchString(k) {
+			return fmt.Errorf("invalid label key %q", k)
+		}
+		if len(v) > MaxLabelValueBytes {
+			return fmt.Errorf("label %q value exceeds %d bytes", k, MaxLabelValueBytes)
+		}
+	}
+	return nil
+}
+
+// ParseLabelSelector splits a "key:value" selector as used by the search API.
+func ParseLabelSelector(s string) (string, string, error) {
+	k, v, ok := strings.Cut(s, ":")
+	if !ok || !labelKeyPattern.MatchString(k) {
+		return "", "", fmt.Errorf("invalid label selector %q", s)
+	}
+	return k, v, nil
+}
+
+// ParseLabelSelectors turns repeated key:value selectors into a label map.
+// Conflicting values for one key are rejected since they can never match.
+func ParseLabelSelectors(selectors []string) (map[string]string, error) {
+	out := make(map[string]string, len(selectors))
+	for _, s := range selectors {
+		k, v, err := ParseLabelSelector(s)
+		if err != nil {
+			return nil, err
+		}
+		if prev, ok := out[k]; ok && prev != v {
+			return nil, fmt.Errorf("conflicting selectors for label %q", k)
+		}
+		out[k] = v
+	}
+	if err := ValidateLabels(out); err != nil {
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,37 @@
+package evidence
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateLabels(t *testing.T) {
+	if err := ValidateLabels(map[string]string{"case": "1234", "source/host": "fw-01"}); err != nil {
+		t.Fatalf("expected valid labels, got %v", err)
+	}
+	for name, labels := range map[string]map[string]string{
+		"uppercase key": {"Case": "1"},
+		"empty key":     {"": "1"},
+		"long value":    {"case": strings.Repeat("x", MaxLabelValueBytes+1)},
+	} {
+		if err := ValidateLabels(labels); err == nil {
+			t.Fatalf("%s: expected error", name)
+		}
+	}
+}
+
+func TestParseLabelSelectors(t *testing.T) {
+	got, err := ParseLabelSelectors([]string{"case:1234", "note:a:b"})
+	if err != nil {
+		t.Fatalf("parse: %v", err)
+	}
+	if got["case"] != "1234" || got["note"] != "a:b" {
+		t.Fatalf("unexpected selectors %v", got)
+	}
+	if _, err := ParseLabelSelectors([]string{"case"}); err == nil {
+		t.Fatalf("expected error for selector without value")
+	}
+	if _, err := ParseLabelSelectors([]string{"case:1", "case:2"}); err == nil {
+		t.Fatalf("expected error for conflicting selectors")
+	}
+}
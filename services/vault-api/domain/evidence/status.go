@@ -0,0 +1,86 @@
+package evidence
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Status is the lifecycle state of an evidence record.
+type Status string
+
+const (
+	// StatusReceived: the payload is hashed and the record admitted but not
+	// yet persisted.
+	StatusReceived Status = "received"
+	// StatusStored: record and payload are durable and await sequencing.
+	StatusStored Status = "stored"
+	// StatusSequenced: the record has a leaf index.
+	StatusSequenced Status = "sequenced"
+	// StatusCheckpointed: a signed checkpoint covers the leaf.
+	StatusCheckpointed Status = "checkpointed"
+	// StatusExported: the record was included in an exported bundle.
+	StatusExported Status = "exported"
+	// StatusOnHold: a legal hold freezes the record; it cannot be redacted.
+	StatusOnHold Status = "on-hold"
+	// StatusRedacted: the record was redacted. This is terminal.
+	StatusRedacted Status = "redacted"
+)
+
+// ErrInvalidTransition is returned for transitions the lifecycle forbids.
+var ErrInvalidTransition = errors.New("invalid status transition")
+
+// progress orders the statuses a record moves through automatically.
+var progress = map[Status]int{
+	StatusReceived:     0,
+	StatusStored:       1,
+	StatusSequenced:    2,
+	StatusCheckpointed: 3,
+	StatusExported:     4,
+}
+
+// ParseStatus validates a status name.
+func ParseStatus(s string) (Status, error) {
+	st := Status(s)
+	if _, ok := progress[st]; ok || st == StatusOnHold || st == StatusRedacted {
+		return st, nil
+	}
+	return "", fmt.Errorf("unknown status %q", s)
+}
+
+// Advance reports whether the vault may move a record from current to next
+// on its own. Only forward moves along the progression are allowed; held
+// and redacted records are never moved automatically.
+func Advance(current, next Status) bool {
+	from, ok := progress[current]
+	if !ok {
+		return false
+	}
+	to, ok := progress[next]
+	return ok && to > from
+}
+
+// CheckManualTransition validates an operator-requested transition to
+// on-hold, redacted or exported. Requesting the current status is allowed
+// and is a no-op for the caller to detect.
+func CheckManualTransition(current, next Status) error {
+	if current == next {
+		return nil
+	}
+	switch next {
+	case StatusOnHold:
+		if current == StatusRedacted {
+			return fmt.Errorf("%w: redacted evidence cannot be put on hold", ErrInvalidTransition)
+		}
+	case StatusRedacted:
+		if current == StatusOnHold {
+			return fmt.Errorf("%w: evidence on hold cannot be redacted", ErrInvalidTransition)
+		}
+	case StatusExported:
+		if current != StatusCheckpointed {
+			return fmt.Errorf("%w: only checkpointed evidence can be exported", ErrInvalidTransition)
+		}
+	default:
+		return fmt.Errorf("%w: %s is set by the vault", ErrInvalidTransition, next)
+	}
+	return nil
+}
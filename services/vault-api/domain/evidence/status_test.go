@@ -0,0 +1,45 @@
+package evidence
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestAdvance(t *testing.T) {
+	if !Advance(StatusStored, StatusSequenced) || !Advance(StatusSequenced, StatusCheckpointed) {
+		t.Fatalf("expected forward progress to be allowed")
+	}
+	if Advance(StatusCheckpointed, StatusSequenced) {
+		t.Fatalf("status must not move backwards")
+	}
+	if Advance(StatusOnHold, StatusCheckpointed) || Advance(StatusRedacted, StatusSequenced) {
+		t.Fatalf("held or redacted records must not advance automatically")
+	}
+}
+
+func TestCheckManualTransition(t *testing.T) {
+	for _, tc := range []struct {
+		from, to Status
+		ok       bool
+	}{
+		{StatusSequenced, StatusOnHold, true},
+		{StatusStored, StatusRedacted, true},
+		{StatusCheckpointed, StatusExported, true},
+		{StatusSequenced, StatusExported, false},
+		{StatusOnHold, StatusRedacted, false},
+		{StatusRedacted, StatusOnHold, false},
+		{StatusStored, StatusSequenced, false},
+		{StatusRedacted, StatusRedacted, true},
+	} {
+		err := CheckManualTransition(tc.from, tc.to)
+		if tc.ok != (err == nil) {
+			t.Fatalf("%s -> %s: got %v", tc.from, tc.to, err)
+		}
+		if err != nil && !errors.Is(err, ErrInvalidTransition) {
+			t.Fatalf("expected ErrInvalidTransition, got %v", err)
+		}
+	}
+	if _, err := ParseStatus("pending"); err == nil {
+		t.Fatalf("expected unknown status to be rejected")
+	}
+}
@@ -0,0 +1,145 @@
+package merkle
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sync"
+)
+
+// LeafHash returns the RFC 6962 hash of leaf data: SHA-256(0x00 || leaf).
+func LeafHash(leaf []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x00})
+	h.Write(leaf)
+	return h.Sum(nil)
+}
+
+// NodeHash returns the RFC 6962 interior node hash: SHA-256(0x01 || l || r).
+func NodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0x01})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// MemoryEngine is an in-process RFC 6962 tree. It stands in for the remote
+// merkle engine in tests and single-replica deployments; its state is lost
+// on restart.
+type MemoryEngine struct {
+	mu     sync.Mutex
+	leaves [][]byte
+}
+
+// NewMemoryEngine returns an empty tree.
+func NewMemoryEngine() *MemoryEngine {
+	return &MemoryEngine{}
+}
+
+func (e *MemoryEngine) AppendLeaf(leaf []byte) (int64, []byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.leaves = append(e.leaves, LeafHash(leaf))
+	return int64(len(e.leaves) - 1), rootOf(e.leaves), nil
+}
+
+func (e *MemoryEngine) InclusionProof(leafIndex int64) (*InclusionProof, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	size := int64(len(e.leaves))
+	if leafIndex < 0 || leafIndex >= size {
+		return nil, fmt.Errorf("leaf index %d outside tree of size %d", leafIndex, size)
+	}
+	return &InclusionProof{
+		LeafIndex: leafIndex,
+		TreeSize:  size,
+		Root:      hex.EncodeToString(rootOf(e.leaves)),
+		Path:      hexAll(auditPath(leafIndex, e.leaves)),
+	}, nil
+}
+
+func (e *MemoryEngine) ConsistencyProof(oldSize int64, newSize int64) (*ConsistencyProof, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if oldSize <= 0 || oldSize > newSize || newSize > int64(len(e.leaves)) {
+		return nil, fmt.Errorf("invalid consistency range %d..%d for tree of size %d", oldSize, newSize, len(e.leaves))
+	}
+	return &ConsistencyProof{
+		OldSize: oldSize,
+		NewSize: newSize,
+		Path:    hexAll(subproof(oldSize, e.leaves[:newSize], true)),
+	}, nil
+}
+
+func (e *MemoryEngine) TreeSize() (int64, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return int64(len(e.leaves)), nil
+}
+
+func (e *MemoryEngine) Root() ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return rootOf(e.leaves), nil
+}
+
+// splitPoint is the largest power of two smaller than n.
+func splitPoint(n int64) int64 {
+	k := int64(1)
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// rootOf computes MTH over leaf hashes.
+func rootOf(hashes [][]byte) []byte {
+	switch n := int64(len(hashes)); n {
+	case 0:
+		sum := sha256.Sum256(nil)
+		return sum[:]
+	case 1:
+		return hashes[0]
+	default:
+		k := splitPoint(n)
+		return NodeHash(rootOf(hashes[:k]), rootOf(hashes[k:]))
+	}
+}
+
+// auditPath is PATH(m, D[n]) from RFC 6962 section 2.1.1.
+func auditPath(m int64, hashes [][]byte) [][]byte {
+	n := int64(len(hashes))
+	if n <= 1 {
+		return nil
+	}
+	k := splitPoint(n)
+	if m < k {
+		return append(auditPath(m, hashes[:k]), rootOf(hashes[k:]))
+	}
+	return append(auditPath(m-k, hashes[k:]), rootOf(hashes[:k]))
+}
+
+// subproof is SUBPROOF(m, D[n], b) from RFC 6962 section 2.1.2.
+func subproof(m int64, hashes [][]byte, complete bool) [][]byte {
+	n := int64(len(hashes))
+	if m == n {
+		if complete {
+			return nil
+		}
+		return [][]byte{rootOf(hashes)}
+	}
+	k := splitPoint(n)
+	if m <= k {
+		return append(subproof(m, hashes[:k], complete), rootOf(hashes[k:]))
+	}
+	return append(subproof(m-k, hashes[k:], false), rootOf(hashes[:k]))
+}
+
+func hexAll(hashes [][]byte) []string {
+	out := make([]string, len(hashes))
+	for i, h := range hashes {
+		out[i] = hex.EncodeToString(h)
+	}
+	return out
+}
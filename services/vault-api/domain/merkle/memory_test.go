@@ -0,0 +1,143 @@
+package merkle
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"testing"
+)
+
+// verifyInclusion follows RFC 9162 section 2.1.3.2.
+func verifyInclusion(t *testing.T, leaf []byte, p *InclusionProof) bool {
+	t.Helper()
+	fn, sn := p.LeafIndex, p.TreeSize-1
+	r := LeafHash(leaf)
+	for _, s := range p.Path {
+		h := mustHex(t, s)
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			r = NodeHash(h, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = NodeHash(r, h)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && hex.EncodeToString(r) == p.Root
+}
+
+// verifyConsistency follows RFC 9162 section 2.1.4.2.
+func verifyConsistency(t *testing.T, oldRoot, newRoot []byte, p *ConsistencyProof) bool {
+	t.Helper()
+	var path [][]byte
+	for _, s := range p.Path {
+		path = append(path, mustHex(t, s))
+	}
+	if p.OldSize == p.NewSize {
+		return len(path) == 0 && bytes.Equal(oldRoot, newRoot)
+	}
+	if p.OldSize&(p.OldSize-1) == 0 {
+		path = append([][]byte{oldRoot}, path...)
+	}
+	if len(path) == 0 {
+		return false
+	}
+	fn, sn := p.OldSize-1, p.NewSize-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := path[0], path[0]
+	for _, c := range path[1:] {
+		if sn == 0 {
+			return false
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = NodeHash(c, fr)
+			sr = NodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = NodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	return sn == 0 && bytes.Equal(fr, oldRoot) && bytes.Equal(sr, newRoot)
+}
+
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("bad hex %q: %v", s, err)
+	}
+	return b
+}
+
+func TestMemoryEngineRoots(t *testing.T) {
+	e := NewMemoryEngine()
+	root, _ := e.Root()
+	empty := sha256.Sum256(nil)
+	if !bytes.Equal(root, empty[:]) {
+		t.Fatalf("empty root %x", root)
+	}
+	idx, root, err := e.AppendLeaf([]byte("a"))
+	if err != nil || idx != 0 || !bytes.Equal(root, LeafHash([]byte("a"))) {
+		t.Fatalf("first append: %d %x %v", idx, root, err)
+	}
+	idx, root, _ = e.AppendLeaf([]byte("b"))
+	if idx != 1 || !bytes.Equal(root, NodeHash(LeafHash([]byte("a")), LeafHash([]byte("b")))) {
+		t.Fatalf("second append: %d %x", idx, root)
+	}
+}
+
+func TestMemoryEngineProofs(t *testing.T) {
+	e := NewMemoryEngine()
+	var leaves [][]byte
+	var roots [][]byte
+	for i := 0; i < 13; i++ {
+		leaf := []byte(fmt.Sprintf("leaf-%d", i))
+		leaves = append(leaves, leaf)
+		_, root, _ := e.AppendLeaf(leaf)
+		roots = append(roots, root)
+	}
+	for i, leaf := range leaves {
+		p, err := e.InclusionProof(int64(i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !verifyInclusion(t, leaf, p) {
+			t.Fatalf("inclusion proof for leaf %d does not verify", i)
+		}
+		if verifyInclusion(t, []byte("other"), p) {
+			t.Fatalf("inclusion proof for leaf %d accepted the wrong leaf", i)
+		}
+	}
+	for m := int64(1); m <= 13; m++ {
+		for n := m; n <= 13; n++ {
+			p, err := e.ConsistencyProof(m, n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !verifyConsistency(t, roots[m-1], roots[n-1], p) {
+				t.Fatalf("consistency proof %d..%d does not verify", m, n)
+			}
+		}
+	}
+	if _, err := e.InclusionProof(13); err == nil {
+		t.Fatal("expected error for leaf outside the tree")
+	}
+	if _, err := e.ConsistencyProof(0, 3); err == nil {
+		t.Fatal("expected error for empty old tree")
+	}
+}
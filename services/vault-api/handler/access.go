@@ -0,0 +1,224 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/SaridakisStamatisChristos/vault-api/blob"
+	"github.com/SaridakisStamatisChristos/vault-api/internal/abac"
+	"github.com/SaridakisStamatisChristos/vault-api/middleware"
+	"github.com/SaridakisStamatisChristos/vault-api/service"
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// accessPolicy holds the attribute rules loaded from ABAC_POLICY_FILE. A
+// policy that failed to load denies every evidence access.
+type accessPolicy struct {
+	policy *abac.Policy
+	err    error
+}
+
+func loadAccessPolicy() accessPolicy {
+	p, err := abac.LoadFromEnv()
+	if err != nil {
+		log.Error().Err(err).Msg("invalid ABAC policy; evidence access will be denied")
+	}
+	return accessPolicy{policy: p, err: err}
+}
+
+func (a accessPolicy) evaluate(s abac.Subject, perm middleware.Permission, labels map[string]string) abac.Decision {
+	if a.err != nil {
+		return abac.Decision{Reason: "access policy failed to load"}
+	}
+	return a.policy.Evaluate(s, string(perm), labels)
+}
+
+func (a accessPolicy) version() string {
+	if a.policy == nil {
+		return ""
+	}
+	return a.policy.Version
+}
+
+func subjectFromContext(ctx context.Context) abac.Subject {
+	return abac.Subject{ID: middleware.SubjectFromContext(ctx), Roles: middleware.RolesFromContext(ctx), Claims: middleware.ClaimsFromContext(ctx)}
+}
+
+// authorize asks the configured policy engine, then the attribute rules,
+// whether the caller may use perm on rec. Both record their decisions in
+// the audit trail as configured.
+func (h *IngestHandler) authorize(ctx context.Context, perm middleware.Permission, rec *service.Record) bool {
+	res := middleware.Resource{Type: "evidence", ID: rec.ID, Labels: rec.Labels, Status: evidenceStatus(rec)}
+	if d, ok := middleware.AuthorizeResource(ctx, perm, res); ok && !d.Allowed {
+		return false
+	}
+	s := subjectFromContext(ctx)
+	d := h.access.evaluate(s, perm, rec.Labels)
+	if h.access.err != nil || h.access.policy.Logs(d) {
+		action := "access_allowed"
+		if !d.Allowed {
+			action = "access_denied"
+		}
+		h.vaultFor(ctx).RecordAuditMetadata(ctx, action, rec.ID, s.ID, map[string]string{
+			"permission":     string(perm),
+			"rule":           d.Rule,
+			"reason":         d.Reason,
+			"policy_version": h.access.version(),
+		})
+	}
+	return d.Allowed
+}
+
+// permits is authorize without the audit trail, for filtering the records
+// a listing or stream shows; auditHidden then reports what it hid.
+func (h *IngestHandler) permits(ctx context.Context, perm middleware.Permission, rec *service.Record) bool {
+	res := middleware.Resource{Type: "evidence", ID: rec.ID, Labels: rec.Labels, Status: evidenceStatus(rec)}
+	if d, ok := middleware.CheckResource(ctx, perm, res); ok && !d.Allowed {
+		return false
+	}
+	return h.access.evaluate(subjectFromContext(ctx), perm, rec.Labels).Allowed
+}
+
+// auditHidden writes one access_denied entry for a request that hid
+// hidden records from the caller, when the access policy logs denials.
+// resourceID names the listing or stream.
+func (h *IngestHandler) auditHidden(ctx context.Context, perm middleware.Permission, resourceID string, hidden int) {
+	if hidden == 0 || (h.access.err == nil && !h.access.policy.Logs(abac.Decision{})) {
+		return
+	}
+	s := subjectFromContext(ctx)
+	h.vaultFor(ctx).RecordAuditMetadata(ctx, "access_denied", resourceID, s.ID, map[string]string{
+		"permission":     string(perm),
+		"hidden":         strconv.Itoa(hidden),
+		"reason":         "records filtered from the response",
+		"policy_version": h.access.version(),
+	})
+}
+
+// RecordAuthzDecision writes a policy engine decision to the audit trail.
+// It is installed with middleware.SetDecisionRecorder.
+func (h *IngestHandler) RecordAuthzDecision(ctx context.Context, in middleware.AuthzInput, d middleware.Decision) {
+	action := "authz_allowed"
+	if !d.Allowed {
+		action = "authz_denied"
+	}
+	resourceID := in.Path
+	if in.Resource != nil {
+		resourceID = in.Resource.ID
+	}
+	perms := make([]string, 0, len(in.Permissions))
+	for _, p := range in.Permissions {
+		perms = append(perms, string(p))
+	}
+	h.vaultFor(ctx).RecordAuditMetadata(ctx, action, resourceID, in.Subject, map[string]string{
+		"engine":         d.Engine,
+		"policy_version": d.PolicyVersion,
+		"reason":         d.Reason,
+		"permissions":    strings.Join(perms, ","),
+		"method":         in.Method,
+		"path":           in.Path,
+	})
+}
+
+// readableRecord loads id for perm. Records the caller may not access are
+// reported as missing so their existence does not leak.
+func (h *IngestHandler) readableRecord(w http.ResponseWriter, r *http.Request, id string, perm middleware.Permission) (service.Record, bool) {
+	rec, err := h.vaultFor(r.Context()).Get(r.Context(), id)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		return rec, false
+	case err != nil:
+		log.Error().Err(err).Str("evidence_id", id).Msg("load evidence")
+		w.WriteHeader(http.StatusInternalServerError)
+		return rec, false
+	}
+	if !h.authorize(r.Context(), perm, &rec) {
+		w.WriteHeader(http.StatusNotFound)
+		return rec, false
+	}
+	return rec, true
+}
+
+// GetPayload streams the stored payload of a record.
+func (h *IngestHandler) GetPayload(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	rec, ok := h.readableRecord(w, r, id, middleware.PermPayloadRead)
+	if !ok {
+		return
+	}
+	if rec.PayloadRef == "" {
+		writeJSONError(w, http.StatusNotFound, "no_payload", "evidence has no stored payload")
+		return
+	}
+	bs, err := blob.Current()
+	if err != nil {
+		log.Error().Err(err).Msg("open blob store")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	rc, err := bs.Open(r.Context(), rec.PayloadRef)
+	if err != nil {
+		log.Error().Err(err).Str("evidence_id", id).Msg("open payload")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	defer rc.Close()
+	contentType := rec.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-SHA256", rec.ContentHash)
+	if rec.Size > 0 {
+		w.Header().Set("Content-Length", strconv.FormatInt(rec.Size, 10))
+	}
+	h.vaultFor(r.Context()).RecordAudit(r.Context(), "payload_read", id, middleware.SubjectFromContext(r.Context()))
+	if _, err := io.Copy(w, rc); err != nil {
+		log.Warn().Err(err).Str("evidence_id", id).Msg("stream payload")
+	}
+}
+
+// EvaluateAccess is a dry run of the access policy: it returns the decision
+// for the given subject, permission and evidence (by evidence_id or labels)
+// without recording it.
+func (h *IngestHandler) EvaluateAccess(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Subject    abac.Subject      `json:"subject"`
+		Permission string            `json:"permission"`
+		EvidenceID string            `json:"evidence_id"`
+		Labels     map[string]string `json:"labels"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	if req.Permission == "" {
+		writeJSONError(w, http.StatusBadRequest, "invalid_request", "permission is required")
+		return
+	}
+	labels := req.Labels
+	if req.EvidenceID != "" {
+		rec, err := h.vaultFor(r.Context()).Get(r.Context(), req.EvidenceID)
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			w.WriteHeader(http.StatusNotFound)
+			return
+		case err != nil:
+			log.Error().Err(err).Str("evidence_id", req.EvidenceID).Msg("load evidence")
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		labels = rec.Labels
+	}
+	d := h.access.evaluate(req.Subject, middleware.Permission(req.Permission), labels)
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"decision": d, "policy_version": h.access.version()})
+}
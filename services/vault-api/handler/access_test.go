@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/SaridakisStamatisChristos/vault-api/middleware"
+	"github.com/SaridakisStamatisChristos/vault-api/service"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const ownCasesPolicy = `{
+  "version": "2026-10-01",
+  "log_decisions": "deny",
+  "rules": [
+    {"name": "own-cases", "permissions": ["evidence:read", "payload:read", "bundle:export"], "claim": "cases", "label": "case", "exempt_roles": ["auditor"]}
+  ]
+}`
+
+func useAccessPolicy(t *testing.T, policy string) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "abac.json")
+	if err := os.WriteFile(path, []byte(policy), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("ABAC_POLICY_FILE", path)
+}
+
+// investigatorToken is a dev-mode token whose unverified claims carry cases.
+func investigatorToken(t *testing.T, sub string, cases ...string) string {
+	t.Helper()
+	tok, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": sub, "cases": cases}).SignedString([]byte("dev"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+func TestAccessPolicyLimitsEvidenceToOwnCases(t *testing.T) {
+	t.Setenv("ENABLE_TEST_JWT", "true")
+	t.Setenv("INGEST_DEDUP_POLICY", service.DedupAllowDuplicate)
+	useTempBlobStore(t)
+	useAccessPolicy(t, ownCasesPolicy)
+	h := newTestHandler(t)
+	mine := ingestLabelled(t, h, "mine", map[string]string{"case": "1234"})
+	theirs := ingestLabelled(t, h, "theirs", map[string]string{"case": "9999"})
+	unlabelled := ingestLabelled(t, h, "unlabelled", nil)
+
+	r := chi.NewRouter()
+	r.Use(middleware.JWT)
+	r.Get("/api/v1/evidence", h.SearchEvidence)
+	r.Get("/api/v1/evidence/{id}", h.GetEvidence)
+	r.Get("/api/v1/evidence/{id}/payload", h.GetPayload)
+	get := func(path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw
+	}
+	investigator := investigatorToken(t, "inv-1", "1234")
+
+	if rw := get("/api/v1/evidence/"+mine, investigator); rw.Code != http.StatusOK {
+		t.Fatalf("own case: expected 200 got %d", rw.Code)
+	}
+	for _, id := range []string{theirs, unlabelled} {
+		if rw := get("/api/v1/evidence/"+id, investigator); rw.Code != http.StatusNotFound {
+			t.Fatalf("foreign evidence %s: expected 404 got %d", id, rw.Code)
+		}
+		if rw := get("/api/v1/evidence/"+id+"/payload", investigator); rw.Code != http.StatusNotFound {
+			t.Fatalf("foreign payload %s: expected 404 got %d", id, rw.Code)
+		}
+	}
+	rw := get("/api/v1/evidence/"+mine+"/payload", investigator)
+	if rw.Code != http.StatusOK || rw.Body.String() != "mine" || rw.Header().Get("Content-Type") != "text/plain" {
+		t.Fatalf("payload: %d %q %s", rw.Code, rw.Body.String(), rw.Header().Get("Content-Type"))
+	}
+
+	var page searchResponse
+	if err := json.NewDecoder(get("/api/v1/evidence", investigator).Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 1 || page.Items[0].ID != mine {
+		t.Fatalf("search must only return own cases: %+v", page.Items)
+	}
+	if err := json.NewDecoder(get("/api/v1/evidence", "auditor-token").Body).Decode(&page); err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Items) != 3 {
+		t.Fatalf("exempt role must see every record, got %d", len(page.Items))
+	}
+
+	audits, err := h.vault.Audits(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	denied, filtered := 0, 0
+	for _, a := range audits {
+		if a.Action != "access_denied" {
+			continue
+		}
+		if a.ResourceID == "/api/v1/evidence" {
+			// the search is audited once, not per hidden hit
+			filtered++
+			if a.Actor != "inv-1" || a.Metadata["hidden"] != "2" || a.Metadata["policy_version"] != "2026-10-01" {
+				t.Fatalf("filtered search not logged: %+v", a)
+			}
+			continue
+		}
+		denied++
+		if a.Actor != "inv-1" || a.Metadata["rule"] != "own-cases" || a.Metadata["policy_version"] != "2026-10-01" || a.Metadata["permission"] == "" {
+			t.Fatalf("decision not logged with its rule: %+v", a)
+		}
+	}
+	// two reads and two payloads were denied
+	if denied != 4 || filtered != 1 {
+		t.Fatalf("expected 4 logged denials and 1 filtered search, got %d and %d", denied, filtered)
+	}
+}
+
+func TestDuplicateIngestDoesNotNameUnreadableRecords(t *testing.T) {
+	t.Setenv("ENABLE_TEST_JWT", "true")
+	useTempBlobStore(t)
+	useAccessPolicy(t, ownCasesPolicy)
+	for _, policy := range []string{service.DedupReject, service.DedupReturnExisting} {
+		t.Run(policy, func(t *testing.T) {
+			t.Setenv("INGEST_DEDUP_POLICY", policy)
+			h := newTestHandler(t)
+			mine := ingestLabelled(t, h, "mine", map[string]string{"case": "1234"})
+			ingestLabelled(t, h, "theirs", map[string]string{"case": "9999"})
+			r := chi.NewRouter()
+			r.With(middleware.JWT).Post("/api/v1/evidence", h.Ingest)
+			ingest := func(payload string) (int, map[string]interface{}) {
+				body, _ := json.Marshal(map[string]interface{}{"content_type": "text/plain", "payload": []byte(payload)})
+				req := httptest.NewRequest(http.MethodPost, "/api/v1/evidence", strings.NewReader(string(body)))
+				req.Header.Set("Authorization", "Bearer "+investigatorToken(t, "inv-1", "1234"))
+				rw := httptest.NewRecorder()
+				r.ServeHTTP(rw, req)
+				var got map[string]interface{}
+				_ = json.NewDecoder(rw.Body).Decode(&got)
+				return rw.Code, got
+			}
+
+			code, got := ingest("theirs")
+			if code != http.StatusConflict || got["error"] != service.ConflictDuplicateContent {
+				t.Fatalf("expected a plain 409 for another case's content, got %d %v", code, got)
+			}
+			if got["id"] != nil || got["content_hash"] != nil {
+				t.Fatalf("the unreadable record must not be named: %v", got)
+			}
+			if _, got := ingest("mine"); got["id"] != mine {
+				t.Fatalf("a readable duplicate is named: %v", got)
+			}
+		})
+	}
+}
+
+func TestEvaluateAccessDryRun(t *testing.T) {
+	t.Setenv("INGEST_DEDUP_POLICY", service.DedupAllowDuplicate)
+	useTempBlobStore(t)
+	useAccessPolicy(t, ownCasesPolicy)
+	h := newTestHandler(t)
+	id := ingestLabelled(t, h, "mine", map[string]string{"case": "1234"})
+
+	evaluate := func(body string) (int, map[string]interface{}) {
+		rw := httptest.NewRecorder()
+		h.EvaluateAccess(rw, httptest.NewRequest(http.MethodPost, "/api/v1/policy/evaluate", strings.NewReader(body)))
+		var out map[string]interface{}
+		_ = json.NewDecoder(rw.Body).Decode(&out)
+		return rw.Code, out
+	}
+	code, out := evaluate(`{"subject":{"sub":"inv-2","claims":{"cases":["77"]}},"permission":"evidence:read","evidence_id":"` + id + `"}`)
+	decision, _ := out["decision"].(map[string]interface{})
+	if code != http.StatusOK || decision["allowed"] != false || decision["rule"] != "own-cases" || out["policy_version"] != "2026-10-01" {
+		t.Fatalf("unexpected dry run: %d %+v", code, out)
+	}
+	_, out = evaluate(`{"subject":{"sub":"inv-2","claims":{"cases":["77"]}},"permission":"evidence:read","labels":{"case":"77"}}`)
+	if decision, _ := out["decision"].(map[string]interface{}); decision["allowed"] != true {
+		t.Fatalf("expected labels to be evaluated: %+v", out)
+	}
+	if code, _ := evaluate(`{"subject":{},"evidence_id":"` + id + `"}`); code != http.StatusBadRequest {
+		t.Fatalf("missing permission: expected 400 got %d", code)
+	}
+
+	audits, _ := h.vault.Audits(context.Background())
+	for _, a := range audits {
+		if strings.HasPrefix(a.Action, "access_") {
+			t.Fatalf("dry run must not be recorded: %+v", a)
+		}
+	}
+}
+
+func TestInvalidAccessPolicyDeniesEvidence(t *testing.T) {
+	useTempBlobStore(t)
+	useAccessPolicy(t, `{"rules":[{"name":"broken"}]}`)
+	if err := ValidateIngestConfig(); err == nil {
+		t.Fatal("expected startup validation to reject the policy")
+	}
+	h := newTestHandler(t)
+	id := ingestLabelled(t, h, "x", nil)
+	r := chi.NewRouter()
+	r.Get("/api/v1/evidence/{id}", h.GetEvidence)
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/api/v1/evidence/"+id, nil))
+	if rw.Code != http.StatusNotFound {
+		t.Fatalf("expected fail-closed 404 got %d", rw.Code)
+	}
+}
@@ -0,0 +1,304 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/internal/apikey"
+	"github.com/SaridakisStamatisChristos/vault-api/middleware"
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// apiKeyTouchInterval is how stale a key's last-used time may get before a
+// request writes it again, so busy keys do not write on every request.
+const apiKeyTouchInterval = time.Minute
+
+// maxRotationGrace caps how long a rotated key keeps working.
+const maxRotationGrace = 24 * time.Hour
+
+// apiKeySubject is the subject requests made with key id act as.
+func apiKeySubject(id string) string {
+	return "apikey:" + id
+}
+
+// VerifyAPIKey resolves a presented API key for middleware.JWT. The key
+// acts as apiKeySubject(id) for its own tenant only.
+func (h *IngestHandler) VerifyAPIKey(ctx context.Context, key string) (middleware.APIKeyIdentity, bool) {
+	id, secret, ok := apikey.Parse(key)
+	if !ok {
+		return middleware.APIKeyIdentity{}, false
+	}
+	k, err := h.keys.GetAPIKey(ctx, id)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Error().Err(err).Str("key_id", id).Msg("look up API key")
+		}
+		return middleware.APIKeyIdentity{}, false
+	}
+	now := time.Now().UTC()
+	if !apikey.Verify(secret, k.Hash) || !k.Active(now) {
+		return middleware.APIKeyIdentity{}, false
+	}
+	if now.Sub(k.LastUsedAt) >= apiKeyTouchInterval {
+		if err := h.keys.TouchAPIKey(ctx, id, now); err != nil {
+			log.Warn().Err(err).Str("key_id", id).Msg("record API key use")
+		}
+	}
+	scopes := make([]middleware.Permission, len(k.Scopes))
+	for i, s := range k.Scopes {
+		scopes[i] = middleware.Permission(s)
+	}
+	return middleware.APIKeyIdentity{
+		Subject: apiKeySubject(id),
+		Scopes:  scopes,
+		Claims:  map[string]interface{}{h.tenants.Claim: k.Tenant, "api_key_id": id, "api_key_name": k.Name},
+	}, true
+}
+
+// apiKeyView is an API key as the admin API shows it. Key is only set when
+// the key is issued.
+type apiKeyView struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Scopes     []string   `json:"scopes"`
+	Subject    string     `json:"subject"`
+	CreatedBy  string     `json:"created_by"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	Active     bool       `json:"active"`
+	Key        string     `json:"key,omitempty"`
+}
+
+func newAPIKeyView(k store.APIKey) apiKeyView {
+	opt := func(t time.Time) *time.Time {
+		if t.IsZero() {
+			return nil
+		}
+		return &t
+	}
+	return apiKeyView{
+		ID:         k.ID,
+		Name:       k.Name,
+		Scopes:     k.Scopes,
+		Subject:    apiKeySubject(k.ID),
+		CreatedBy:  k.CreatedBy,
+		CreatedAt:  k.CreatedAt,
+		ExpiresAt:  opt(k.ExpiresAt),
+		LastUsedAt: opt(k.LastUsedAt),
+		RevokedAt:  opt(k.RevokedAt),
+		Active:     k.Active(time.Now()),
+	}
+}
+
+// checkScopes validates requested scopes. Callers may only hand out
+// permissions they hold themselves.
+func checkScopes(ctx context.Context, scopes []string) (status int, code, detail string) {
+	if len(scopes) == 0 {
+		return http.StatusBadRequest, "invalid_scopes", "at least one scope is required"
+	}
+	for _, s := range scopes {
+		known := false
+		for _, p := range middleware.AllPermissions {
+			known = known || string(p) == s
+		}
+		if !known {
+			return http.StatusBadRequest, "invalid_scopes", "unknown permission " + s
+		}
+		if !middleware.HasPermission(ctx, middleware.Permission(s)) {
+			return http.StatusForbidden, "scope_not_held", "caller does not hold " + s
+		}
+	}
+	return 0, "", ""
+}
+
+// issueAPIKey stores a new key for the caller's tenant and returns it with
+// its secret.
+func (h *IngestHandler) issueAPIKey(ctx context.Context, name string, scopes []string, expires time.Time) (apiKeyView, error) {
+	key, id, hash, err := apikey.Generate()
+	if err != nil {
+		return apiKeyView{}, err
+	}
+	k := store.APIKey{
+		ID:        id,
+		Tenant:    tenantOf(ctx),
+		Name:      name,
+		Hash:      hash,
+		Scopes:    scopes,
+		CreatedBy: middleware.SubjectFromContext(ctx),
+		CreatedAt: time.Now().UTC(),
+		ExpiresAt: expires,
+	}
+	if err := h.keys.CreateAPIKey(ctx, k); err != nil {
+		return apiKeyView{}, err
+	}
+	view := newAPIKeyView(k)
+	view.Key = key
+	return view, nil
+}
+
+// tenantAPIKey returns key id if it belongs to the caller's tenant.
+func (h *IngestHandler) tenantAPIKey(ctx context.Context, id string) (*store.APIKey, error) {
+	k, err := h.keys.GetAPIKey(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if k.Tenant != tenantOf(ctx) {
+		return nil, store.ErrNotFound
+	}
+	return k, nil
+}
+
+func writeAPIKeyLookupError(w http.ResponseWriter, err error) {
+	if errors.Is(err, store.ErrNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	log.Error().Err(err).Msg("look up API key")
+	w.WriteHeader(http.StatusInternalServerError)
+}
+
+func writeAPIKey(w http.ResponseWriter, status int, view apiKeyView) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(view)
+}
+
+// CreateAPIKey issues a key for the caller's tenant. The key is only
+// returned in this response.
+func (h *IngestHandler) CreateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Name      string     `json:"name"`
+		Scopes    []string   `json:"scopes"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	ctx := r.Context()
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > 200 {
+		writeJSONError(w, http.StatusBadRequest, "invalid_name", "name must be 1 to 200 bytes")
+		return
+	}
+	if status, code, detail := checkScopes(ctx, req.Scopes); status != 0 {
+		writeJSONError(w, status, code, detail)
+		return
+	}
+	var expires time.Time
+	if req.ExpiresAt != nil {
+		if !req.ExpiresAt.After(time.Now()) {
+			writeJSONError(w, http.StatusBadRequest, "invalid_expiry", "expires_at must be in the future")
+			return
+		}
+		expires = req.ExpiresAt.UTC()
+	}
+	view, err := h.issueAPIKey(ctx, req.Name, req.Scopes, expires)
+	if err != nil {
+		log.Error().Err(err).Msg("create API key")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	h.vaultFor(ctx).RecordAuditMetadata(ctx, "api_key_create", view.ID, middleware.SubjectFromContext(ctx), map[string]string{
+		"name":   view.Name,
+		"scopes": strings.Join(view.Scopes, ","),
+	})
+	writeAPIKey(w, http.StatusCreated, view)
+}
+
+// ListAPIKeys lists the caller's tenant's keys, revoked ones included,
+// without their secrets.
+func (h *IngestHandler) ListAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.keys.ListAPIKeys(r.Context(), tenantOf(r.Context()))
+	if err != nil {
+		log.Error().Err(err).Msg("list API keys")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	entries := make([]apiKeyView, len(keys))
+	for i, k := range keys {
+		entries[i] = newAPIKeyView(k)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"entries": entries})
+}
+
+// RevokeAPIKey revokes a key at once. Revoked keys stay listed.
+func (h *IngestHandler) RevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	id := chi.URLParam(r, "id")
+	if _, err := h.tenantAPIKey(ctx, id); err != nil {
+		writeAPIKeyLookupError(w, err)
+		return
+	}
+	if _, err := h.keys.RevokeAPIKey(ctx, id, time.Now().UTC()); err != nil {
+		log.Error().Err(err).Str("key_id", id).Msg("revoke API key")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	h.vaultFor(ctx).RecordAudit(ctx, "api_key_revoke", id, middleware.SubjectFromContext(ctx))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RotateAPIKey replaces a key with a new one of the same name, scopes and
+// lifetime. The old key keeps working for grace_seconds, so clients can
+// switch over without failed requests.
+func (h *IngestHandler) RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		GraceSeconds int64 `json:"grace_seconds"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	grace := time.Duration(req.GraceSeconds) * time.Second
+	if grace < 0 || grace > maxRotationGrace {
+		writeJSONError(w, http.StatusBadRequest, "invalid_grace", "grace_seconds must be between 0 and 86400")
+		return
+	}
+	ctx := r.Context()
+	old, err := h.tenantAPIKey(ctx, chi.URLParam(r, "id"))
+	if err != nil {
+		writeAPIKeyLookupError(w, err)
+		return
+	}
+	now := time.Now().UTC()
+	if !old.Active(now) {
+		writeJSONError(w, http.StatusConflict, "key_inactive", "revoked or expired keys cannot be rotated")
+		return
+	}
+	if status, code, detail := checkScopes(ctx, old.Scopes); status != 0 {
+		writeJSONError(w, status, code, detail)
+		return
+	}
+	var expires time.Time
+	if !old.ExpiresAt.IsZero() {
+		expires = now.Add(old.ExpiresAt.Sub(old.CreatedAt))
+	}
+	view, err := h.issueAPIKey(ctx, old.Name, old.Scopes, expires)
+	if err != nil {
+		log.Error().Err(err).Msg("create API key")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if _, err := h.keys.RevokeAPIKey(ctx, old.ID, now.Add(grace)); err != nil {
+		// the new key works; the old one is left for a retried revocation
+		log.Error().Err(err).Str("key_id", old.ID).Msg("revoke rotated API key")
+	}
+	h.vaultFor(ctx).RecordAuditMetadata(ctx, "api_key_rotate", old.ID, middleware.SubjectFromContext(ctx), map[string]string{
+		"replaced_by":   view.ID,
+		"grace_seconds": strconv.FormatInt(req.GraceSeconds, 10),
+	})
+	writeAPIKey(w, http.StatusCreated, view)
+}
@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/middleware"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestAPIKeys(t *testing.T) {
+	t.Setenv("ENABLE_TEST_JWT", "true")
+	t.Setenv("RBAC_ROLE_PERMISSIONS", `{"admin":["admin:keys","evidence:write","evidence:read"],"ingester":["evidence:write"]}`)
+	useTempBlobStore(t)
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	if err := os.WriteFile(path, []byte(`{"tenants":[{"id":"acme"}]}`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TENANTS_FILE", path)
+	h := newTestHandler(t)
+	middleware.SetAPIKeyVerifier(h.VerifyAPIKey)
+	t.Cleanup(func() { middleware.SetAPIKeyVerifier(nil) })
+
+	routes := func(r chi.Router) {
+		r.Use(h.ResolveTenant)
+		keys := middleware.Require(middleware.PermAdminKeys)
+		r.With(keys).Post("/api-keys", h.CreateAPIKey)
+		r.With(keys).Get("/api-keys", h.ListAPIKeys)
+		r.With(keys).Delete("/api-keys/{id}", h.RevokeAPIKey)
+		r.With(keys).Post("/api-keys/{id}/rotate", h.RotateAPIKey)
+		r.With(middleware.Require(middleware.PermEvidenceWrite)).Post("/evidence", h.Ingest)
+		r.With(middleware.Require(middleware.PermEvidenceRead)).Get("/evidence", h.SearchEvidence)
+	}
+	r := chi.NewRouter()
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(middleware.JWT)
+		r.Group(routes)
+		r.Route("/tenants/{tenant}", routes)
+	})
+	do := func(token, method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw
+	}
+	create := func(token, body string) (int, apiKeyView) {
+		t.Helper()
+		rw := do(token, http.MethodPost, "/api/v1/api-keys", body)
+		var v apiKeyView
+		_ = json.NewDecoder(rw.Body).Decode(&v)
+		return rw.Code, v
+	}
+	ingest := func(token, prefix string) int {
+		body, _ := json.Marshal(map[string]interface{}{"content_type": "text/plain", "payload": []byte(token + time.Now().String())})
+		return do(token, http.MethodPost, prefix+"/evidence", string(body)).Code
+	}
+
+	if code, _ := create("ingester-token", `{"name":"ci","scopes":["evidence:write"]}`); code != http.StatusForbidden {
+		t.Fatalf("ingester created a key: %d", code)
+	}
+	for body, want := range map[string]int{
+		`{"name":"ci","scopes":["evidence:delete"]}`:                                    http.StatusBadRequest,
+		`{"name":"ci","scopes":[]}`:                                                     http.StatusBadRequest,
+		`{"name":"","scopes":["evidence:write"]}`:                                       http.StatusBadRequest,
+		`{"name":"ci","scopes":["evidence:write"],"expires_at":"2001-01-01T00:00:00Z"}`: http.StatusBadRequest,
+		`{"name":"ci","scopes":["audit:read"]}`:                                         http.StatusForbidden,
+	} {
+		if code, _ := create("admin-token", body); code != want {
+			t.Errorf("%s: %d, want %d", body, code, want)
+		}
+	}
+
+	expires := time.Now().Add(48 * time.Hour).UTC().Truncate(time.Second)
+	code, ci := create("admin-token", `{"name":"ci","scopes":["evidence:write"],"expires_at":"`+expires.Format(time.RFC3339)+`"}`)
+	if code != http.StatusCreated || !strings.HasPrefix(ci.Key, "vk_") || ci.Subject != "apikey:"+ci.ID || ci.CreatedBy != "admin-token" || !ci.Active || !ci.ExpiresAt.Equal(expires) {
+		t.Fatalf("create: %d %+v", code, ci)
+	}
+
+	// the key grants its scopes, for its own tenant only
+	if code := ingest(ci.Key, "/api/v1"); code != http.StatusAccepted {
+		t.Fatalf("ingest with key: %d", code)
+	}
+	if code := do(ci.Key, http.MethodGet, "/api/v1/evidence", "").Code; code != http.StatusForbidden {
+		t.Fatalf("search without the scope: %d", code)
+	}
+	if code := ingest(ci.Key, "/api/v1/tenants/acme"); code != http.StatusNotFound {
+		t.Fatalf("ingest into another tenant: %d", code)
+	}
+	if code := ingest(ci.Key[:len(ci.Key)-2]+"xx", "/api/v1"); code != http.StatusUnauthorized {
+		t.Fatalf("wrong secret: %d", code)
+	}
+
+	rw := do("admin-token", http.MethodGet, "/api/v1/api-keys", "")
+	var list struct {
+		Entries []map[string]interface{} `json:"entries"`
+	}
+	if err := json.NewDecoder(rw.Body).Decode(&list); err != nil || len(list.Entries) != 1 {
+		t.Fatalf("list: %d %+v %v", rw.Code, list, err)
+	}
+	if e := list.Entries[0]; e["id"] != ci.ID || e["last_used_at"] == nil || e["key"] != nil || e["hash"] != nil {
+		t.Fatalf("listed key: %+v", e)
+	}
+	if rw := do("admin-token", http.MethodGet, "/api/v1/tenants/acme/api-keys", ""); rw.Code != http.StatusNotFound {
+		t.Fatalf("admin without the acme claim listed acme keys: %d", rw.Code)
+	}
+
+	// rotation issues a new key; the old one works through the grace period
+	rw = do("admin-token", http.MethodPost, "/api/v1/api-keys/"+ci.ID+"/rotate", `{"grace_seconds":60}`)
+	var rotated apiKeyView
+	if err := json.NewDecoder(rw.Body).Decode(&rotated); err != nil || rw.Code != http.StatusCreated || rotated.ID == ci.ID || rotated.Name != "ci" || rotated.ExpiresAt == nil {
+		t.Fatalf("rotate: %d %+v %v", rw.Code, rotated, err)
+	}
+	if ingest(ci.Key, "/api/v1") != http.StatusAccepted || ingest(rotated.Key, "/api/v1") != http.StatusAccepted {
+		t.Fatal("both keys must work during the grace period")
+	}
+	if rw := do("admin-token", http.MethodPost, "/api/v1/api-keys/"+ci.ID+"/rotate", `{"grace_seconds":-1}`); rw.Code != http.StatusBadRequest {
+		t.Fatalf("negative grace: %d", rw.Code)
+	}
+
+	if rw := do("admin-token", http.MethodDelete, "/api/v1/api-keys/"+ci.ID, ""); rw.Code != http.StatusNoContent {
+		t.Fatalf("revoke: %d", rw.Code)
+	}
+	if code := ingest(ci.Key, "/api/v1"); code != http.StatusUnauthorized {
+		t.Fatalf("revoked key: %d", code)
+	}
+	if rw := do("admin-token", http.MethodPost, "/api/v1/api-keys/"+ci.ID+"/rotate", ""); rw.Code != http.StatusConflict {
+		t.Fatalf("rotating a revoked key: %d", rw.Code)
+	}
+	if rw := do("admin-token", http.MethodDelete, "/api/v1/api-keys/missing", ""); rw.Code != http.StatusNotFound {
+		t.Fatalf("revoke unknown key: %d", rw.Code)
+	}
+
+	audits, err := h.vault.Audits(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	actions := map[string]string{}
+	for _, a := range audits {
+		actions[a.Action+" "+a.ResourceID] = a.Actor
+	}
+	if actions["api_key_create "+ci.ID] != "admin-token" || actions["api_key_rotate "+ci.ID] != "admin-token" || actions["api_key_revoke "+ci.ID] != "admin-token" {
+		t.Fatalf("key administration not audited: %v", actions)
+	}
+	ingestedByKey := false
+	for _, a := range audits {
+		ingestedByKey = ingestedByKey || (a.Action == "ingest" && a.Actor == ci.Subject)
+	}
+	if !ingestedByKey {
+		t.Fatalf("ingest not attributed to the key: %v", actions)
+	}
+}
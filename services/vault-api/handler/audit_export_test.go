@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/middleware"
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestExportAuditFormats(t *testing.T) {
+	t.Setenv("ENABLE_TEST_JWT", "true")
+
+	h := newTestHandler(t)
+	for _, a := range []store.AuditEntry{
+		{ID: "a1", Action: "ingest", ResourceID: "ev-old", Actor: "collector", Timestamp: time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{ID: "a2", Action: "ingest", ResourceID: "ev-new", Actor: "collector", Timestamp: time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)},
+	} {
+		if err := h.vault.Store().SaveAudit(context.Background(), a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := chi.NewRouter()
+	r.With(middleware.JWT, middleware.Require(middleware.PermAuditRead)).Get("/api/v1/audit/export", h.ExportAudit)
+
+	tests := []struct {
+		name     string
+		query    string
+		token    string
+		wantCode int
+		wantCT   string
+		wantHas  []string
+		wantNot  []string
+	}{
+		{name: "ndjson default", query: "", token: "auditor-token", wantCode: 200, wantCT: "application/x-ndjson", wantHas: []string{`"resource_id":"ev-old"`, `"resource_id":"ev-new"`}},
+		{name: "cef", query: "?format=cef", token: "auditor-token", wantCode: 200, wantCT: "text/plain", wantHas: []string{"CEF:0|MerkleEvidenceVault|vault-api|", "cs1=ev-new"}},
+		{name: "syslog since", query: "?format=syslog&since=2026-02-01T00:00:00Z", token: "auditor-token", wantCode: 200, wantCT: "text/plain", wantHas: []string{"<110>1 ", `resource="ev-new"`}, wantNot: []string{"ev-old"}},
+		{name: "unknown format", query: "?format=xml", token: "auditor-token", wantCode: 400},
+		{name: "bad since", query: "?since=yesterday", token: "auditor-token", wantCode: 400},
+		{name: "non auditor", query: "", token: "ingester-token", wantCode: 403},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/audit/export"+tt.query, nil)
+			req.Header.Set("Authorization", "Bearer "+tt.token)
+			rw := httptest.NewRecorder()
+			r.ServeHTTP(rw, req)
+			if rw.Code != tt.wantCode {
+				t.Fatalf("expected %d got %d", tt.wantCode, rw.Code)
+			}
+			if tt.wantCT != "" && !strings.HasPrefix(rw.Header().Get("Content-Type"), tt.wantCT) {
+				t.Fatalf("expected content type %s got %s", tt.wantCT, rw.Header().Get("Content-Type"))
+			}
+			body := rw.Body.String()
+			for _, s := range tt.wantHas {
+				if !strings.Contains(body, s) {
+					t.Fatalf("expected %q in body:\n%s", s, body)
+				}
+			}
+			for _, s := range tt.wantNot {
+				if strings.Contains(body, s) {
+					t.Fatalf("did not expect %q in body:\n%s", s, body)
+				}
+			}
+		})
+	}
+}
+
+func TestExportAuditPagesThroughTheTrail(t *testing.T) {
+	t.Setenv("ENABLE_TEST_JWT", "true")
+
+	h := newTestHandler(t)
+	at := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	total := exportPageSize + 3
+	for i := 0; i < total; i++ {
+		a := store.AuditEntry{ID: fmt.Sprintf("a%04d", i), Action: "ingest", ResourceID: "ev", Timestamp: at.Add(time.Duration(i/2) * time.Second)}
+		if err := h.vault.Store().SaveAudit(context.Background(), a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := chi.NewRouter()
+	r.With(middleware.JWT, middleware.Require(middleware.PermAuditRead)).Get("/api/v1/audit/export", h.ExportAudit)
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/audit/export?since=2026-01-01T00:00:01Z", nil)
+	req.Header.Set("Authorization", "Bearer auditor-token")
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, req)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("export: %d", rw.Code)
+	}
+	lines := strings.Split(strings.TrimSpace(rw.Body.String()), "\n")
+	// the first two entries are before since
+	if len(lines) != total-2 || !strings.Contains(lines[0], `"a0002"`) || !strings.Contains(lines[len(lines)-1], fmt.Sprintf(`"a%04d"`, total-1)) {
+		t.Fatalf("export returned %d entries from %s to %s", len(lines), lines[0], lines[len(lines)-1])
+	}
+}
@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/SaridakisStamatisChristos/vault-api/domain/evidence"
+	"github.com/SaridakisStamatisChristos/vault-api/internal/promise"
+	"github.com/SaridakisStamatisChristos/vault-api/middleware"
+	"github.com/SaridakisStamatisChristos/vault-api/service"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const defaultMaxBatchItems = 1000
+
+type batchItem struct {
+	ContentType    string            `json:"content_type"`
+	Payload        []byte            `json:"payload"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	IdempotencyKey string            `json:"idempotency_key,omitempty"`
+}
+
+type batchResult struct {
+	Index       int    `json:"index"`
+	ID          string `json:"id,omitempty"`
+	ContentHash string `json:"content_hash,omitempty"`
+	Status      string `json:"status,omitempty"`
+	Duplicate   bool   `json:"duplicate,omitempty"`
+	Error       string `json:"error,omitempty"`
+	// Quota names the exhausted quota of a quota_exceeded item.
+	Quota *service.QuotaViolation `json:"quota,omitempty"`
+
+	Promise *promise.Promise `json:"promise,omitempty"`
+}
+
+func maxBatchItems() (int, error) {
+	raw := strings.TrimSpace(os.Getenv("INGEST_MAX_BATCH_ITEMS"))
+	if raw == "" {
+		return defaultMaxBatchItems, nil
+	}
+	n, err := strconv.Atoi(raw)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid INGEST_MAX_BATCH_ITEMS %q", raw)
+	}
+	return n, nil
+}
+
+// IngestBatch accepts many evidence items in one request, either as a JSON
+// array or as NDJSON (Content-Type: application/x-ndjson). Each item gets
+// its own result; the newly created records are queued as one group so the
+// committer assigns them consecutive leaf indices.
+func (h *IngestHandler) IngestBatch(w http.ResponseWriter, r *http.Request) {
+	limit, err := maxBatchItems()
+	if err != nil {
+		limit = defaultMaxBatchItems
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, h.upload.MaxJSONBytes)
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+
+	var raw []json.RawMessage
+	if mediaType == "application/x-ndjson" || mediaType == "application/jsonl" {
+		raw, err = readNDJSON(r.Body, limit)
+	} else {
+		err = json.NewDecoder(r.Body).Decode(&raw)
+	}
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(raw) == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if len(raw) > limit {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	actor := middleware.SubjectFromContext(r.Context())
+	results := make([]batchResult, len(raw))
+	var created []service.Record
+	group := uuid.NewString()
+	failed := 0
+	for i, msg := range raw {
+		res := batchResult{Index: i}
+		var item batchItem
+		switch {
+		case json.Unmarshal(msg, &item) != nil:
+			res.Error = "invalid_item"
+		case len(item.Payload) == 0:
+			res.Error = "empty_payload"
+		case len(item.IdempotencyKey) > maxIdempotencyKeyLen:
+			res.Error = "invalid_idempotency_key"
+		case evidence.ValidateLabels(item.Labels) != nil:
+			res.Error = "invalid_labels"
+		}
+		if res.Error != "" {
+			failed++
+			results[i] = res
+			continue
+		}
+
+		// refuse before storing, so an over-quota item leaves no blob
+		qv, err := h.vaultFor(r.Context()).CheckQuota(r.Context(), actor, int64(len(item.Payload)))
+		if err != nil || qv != nil {
+			if err != nil {
+				log.Error().Err(err).Int("index", i).Msg("read quota usage")
+				res.Error = "storage_failed"
+			} else {
+				res.Error, res.Quota = service.ConflictQuotaExceeded, qv
+			}
+			failed++
+			results[i] = res
+			continue
+		}
+		ev := evidence.NewEvidence("", item.ContentType, item.Payload, actor)
+		ref, err := storeBlob(r.Context(), bytes.NewReader(item.Payload), ev.ContentHash)
+		if err != nil {
+			log.Error().Err(err).Int("index", i).Msg("store batch payload")
+			res.Error = "storage_failed"
+			failed++
+			results[i] = res
+			continue
+		}
+		draft := service.Record{ContentType: item.ContentType, ContentHash: ev.ContentHash, PayloadRef: ref, Labels: item.Labels, Size: int64(len(item.Payload)), Group: group}
+		adm, err := h.admit(r.Context(), actor, strings.TrimSpace(item.IdempotencyKey), draft, false)
+		res.ContentHash = ev.ContentHash
+		if err != nil {
+			log.Error().Err(err).Int("index", i).Msg("admit batch item")
+			res.Error = "storage_failed"
+			failed++
+			results[i] = res
+			continue
+		}
+		if adm.Conflict != "" {
+			res.ID = adm.Record.ID
+			res.Error = adm.Conflict
+			res.Quota = adm.Quota
+			failed++
+			results[i] = res
+			continue
+		}
+		if adm.Created && !adm.Relayed {
+			created = append(created, adm.Record)
+		}
+		res.ID = adm.Record.ID
+		res.Status = evidenceStatus(&adm.Record)
+		res.Duplicate = adm.Replayed
+		res.Promise = adm.Promise
+		results[i] = res
+	}
+	h.vaultFor(r.Context()).Enqueue(created...)
+
+	status := http.StatusAccepted
+	if failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"accepted": len(raw) - failed,
+		"failed":   failed,
+		"results":  results,
+	})
+}
+
+// readNDJSON splits r into one raw JSON value per non-empty line. It stops
+// reading once more than limit items have been seen.
+func readNDJSON(r io.Reader, limit int) ([]json.RawMessage, error) {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 64<<20)
+	var out []json.RawMessage
+	for sc.Scan() {
+		line := bytes.TrimSpace(sc.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		out = append(out, json.RawMessage(append([]byte(nil), line...)))
+		if len(out) > limit {
+			break
+		}
+	}
+	return out, sc.Err()
+}
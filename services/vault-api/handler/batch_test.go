@@ -0,0 +1,122 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/SaridakisStamatisChristos/vault-api/service"
+	"github.com/go-chi/chi/v5"
+)
+
+type batchResponse struct {
+	Accepted int           `json:"accepted"`
+	Failed   int           `json:"failed"`
+	Results  []batchResult `json:"results"`
+}
+
+func postBatch(t *testing.T, r http.Handler, contentType, body string) (int, batchResponse) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/evidence:batch", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, req)
+	var got batchResponse
+	_ = json.NewDecoder(rw.Body).Decode(&got)
+	return rw.Code, got
+}
+
+func b64(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+func TestIngestBatchJSONAndNDJSON(t *testing.T) {
+	useTempBlobStore(t)
+	t.Setenv("INGEST_DEDUP_POLICY", service.DedupReturnExisting)
+	h := newTestHandler(t)
+	r := chi.NewRouter()
+	r.Post("/api/v1/evidence:batch", h.IngestBatch)
+
+	code, got := postBatch(t, r, "application/json", `[
+		{"content_type":"text/plain","payload":"`+b64("seg-1")+`"},
+		{"content_type":"text/plain","payload":"`+b64("seg-2")+`"}
+	]`)
+	if code != http.StatusAccepted || got.Accepted != 2 || got.Failed != 0 {
+		t.Fatalf("json batch: code=%d resp=%+v", code, got)
+	}
+	for i, res := range got.Results {
+		if res.Index != i || res.ID == "" || len(res.ContentHash) != 64 || res.Status != "stored" {
+			t.Fatalf("unexpected result %d: %+v", i, res)
+		}
+	}
+
+	ndjson := strings.Join([]string{
+		`{"content_type":"text/plain","payload":"` + b64("seg-3") + `"}`,
+		``,
+		`{"content_type":"text/plain","payload":""}`,
+		`not json`,
+		`{"content_type":"text/plain","payload":"` + b64("seg-1") + `"}`,
+	}, "\n")
+	code, got = postBatch(t, r, "application/x-ndjson", ndjson)
+	if code != http.StatusMultiStatus || got.Accepted != 2 || got.Failed != 2 {
+		t.Fatalf("ndjson batch: code=%d resp=%+v", code, got)
+	}
+	if got.Results[1].Error != "empty_payload" || got.Results[2].Error != "invalid_item" {
+		t.Fatalf("expected per-item errors, got %+v", got.Results)
+	}
+	if !got.Results[3].Duplicate {
+		t.Fatalf("expected duplicate of seg-1 to be reported, got %+v", got.Results[3])
+	}
+}
+
+func TestIngestBatchItemLimit(t *testing.T) {
+	useTempBlobStore(t)
+	t.Setenv("INGEST_MAX_BATCH_ITEMS", "2")
+	h := newTestHandler(t)
+	r := chi.NewRouter()
+	r.Post("/api/v1/evidence:batch", h.IngestBatch)
+
+	item := `{"payload":"` + b64("x") + `"}`
+	code, _ := postBatch(t, r, "application/json", "["+item+","+item+","+item+"]")
+	if code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 got %d", code)
+	}
+	code, _ = postBatch(t, r, "application/json", "[]")
+	if code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for empty batch got %d", code)
+	}
+}
+
+func TestCommitterSequencesBatchContiguously(t *testing.T) {
+	useTempBlobStore(t)
+	t.Setenv("INGEST_DEDUP_POLICY", service.DedupAllowDuplicate)
+	h := newTestHandler(t)
+	r := chi.NewRouter()
+	r.Post("/api/v1/evidence:batch", h.IngestBatch)
+
+	single := doIngest(t, h, "", []byte("before"))
+	_, batch := postBatch(t, r, "application/json", `[{"payload":"`+b64("b1")+`"},{"payload":"`+b64("b2")+`"},{"payload":"`+b64("b3")+`"}]`)
+	after := doIngest(t, h, "", []byte("after"))
+
+	for i := 0; i < 3; i++ {
+		h.vault.CommitNext(context.Background())
+	}
+
+	leaf := func(id string) int64 {
+		rec, err := h.vault.Get(context.Background(), id)
+		if err != nil || rec.LeafIndex == nil {
+			t.Fatalf("record %s not sequenced: %v", id, err)
+		}
+		return *rec.LeafIndex
+	}
+	if leaf(single.ID) != 0 || leaf(after.ID) != 4 {
+		t.Fatalf("singles should bracket the batch: before=%d after=%d", leaf(single.ID), leaf(after.ID))
+	}
+	for i, res := range batch.Results {
+		if got := leaf(res.ID); got != int64(i+1) {
+			t.Fatalf("batch item %d got leaf %d, want %d", i, got, i+1)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"context"
 	"crypto/ed25519"
 	"encoding/base64"
 	"encoding/json"
@@ -29,7 +30,7 @@ func TestCheckpointSigningIntegration(t *testing.T) {
 		sig := ed25519.Sign(priv, b)
 		enc := base64.StdEncoding.EncodeToString(sig)
 		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(map[string]string{"signature": enc})
+		json.NewEncoder(w).Encode(map[string]string{"signature": enc, "key_ref": "test:ed25519"})
 	}))
 	defer signer.Close()
 
@@ -42,15 +43,11 @@ func TestCheckpointSigningIntegration(t *testing.T) {
 	os.Setenv("ENABLE_TEST_JWT", "true")
 	defer os.Unsetenv("ENABLE_TEST_JWT")
 
-	// seed an entry so tree_size > 0
-	mu.Lock()
-	storeMap = map[string]*evidenceRecord{"abc": {ID: "abc", LeafIndex: func() *int64 { x := int64(1); return &x }()}}
-	mu.Unlock()
-
-	// build router same as server
-	h := NewIngestHandler()
+	// build router same as server, seeding entries so tree_size > 0
+	h := newTestHandler(t)
+	seedLeaves(t, h, "zero", "abc")
 	r := chi.NewRouter()
-	r.With(middleware.JWT).Get("/api/v1/checkpoints/latest", h.GetCheckpointsLatest)
+	r.With(middleware.JWT, middleware.Require(middleware.PermCheckpointRead)).Get("/api/v1/checkpoints/latest", h.GetCheckpointsLatest)
 
 	// create request with auditor token
 	req := httptest.NewRequest("GET", "/api/v1/checkpoints/latest", nil)
@@ -89,3 +86,33 @@ func TestCheckpointSigningIntegration(t *testing.T) {
 
 	log.Info().Msg("checkpoint signing integration test passed")
 }
+
+func TestCheckpointSigningFailureIsNotStored(t *testing.T) {
+	fail := true
+	signer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"signature": "c2ln", "key_ref": "test:ed25519"})
+	}))
+	defer signer.Close()
+	t.Setenv("CHECKPOINT_SIGNING_URL", signer.URL+"/sign")
+
+	h := newTestHandler(t)
+	seedLeaves(t, h, "zero", "abc")
+	ctx := context.Background()
+	if _, err := h.vault.LatestCheckpoint(ctx); err == nil {
+		t.Fatal("a failed signing call must fail the checkpoint")
+	}
+	if cps, err := h.vault.Store().ListCheckpoints(ctx, 0); err != nil || len(cps) != 0 {
+		t.Fatalf("no checkpoint may be stored without a signature: %+v %v", cps, err)
+	}
+
+	fail = false
+	cp, err := h.vault.LatestCheckpoint(ctx)
+	if err != nil || cp.Signature != "c2ln" || cp.KeyRef != "test:ed25519" {
+		t.Fatalf("the tree size must be signed on retry: %+v %v", cp, err)
+	}
+}
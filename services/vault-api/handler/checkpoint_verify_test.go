@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"context"
 	"crypto/ed25519"
 	"encoding/base64"
 	"encoding/json"
@@ -8,8 +9,11 @@ import (
 	"net/http/httptest"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/SaridakisStamatisChristos/vault-api/middleware"
+	"github.com/SaridakisStamatisChristos/vault-api/service"
+	"github.com/SaridakisStamatisChristos/vault-api/store"
 	"github.com/go-chi/chi/v5"
 )
 
@@ -24,14 +28,10 @@ func TestCheckpointVerifyLatest(t *testing.T) {
 	defer os.Unsetenv("CHECKPOINT_VERIFY_PUBLIC_KEY_B64")
 	defer os.Unsetenv("ENABLE_TEST_JWT")
 
-	resetCheckpointState()
-	mu.Lock()
-	storeMap = map[string]*evidenceRecord{"abc": {ID: "abc", LeafIndex: func() *int64 { x := int64(1); return &x }()}}
-	mu.Unlock()
-
-	h := NewIngestHandler()
+	h := newTestHandler(t)
+	seedLeaves(t, h, "zero", "abc")
 	r := chi.NewRouter()
-	r.With(middleware.JWT).Get("/api/v1/checkpoints/latest/verify", h.VerifyLatestCheckpoint)
+	r.With(middleware.JWT, middleware.Require(middleware.PermCheckpointRead)).Get("/api/v1/checkpoints/latest/verify", h.VerifyLatestCheckpoint)
 
 	req := httptest.NewRequest(http.MethodGet, "/api/v1/checkpoints/latest/verify", nil)
 	req.Header.Set("Authorization", "Bearer auditor-token")
@@ -68,18 +68,12 @@ func TestCheckpointHistoryAndVerifyByTreeSize(t *testing.T) {
 	defer os.Unsetenv("CHECKPOINT_VERIFY_PUBLIC_KEY_B64")
 	defer os.Unsetenv("ENABLE_TEST_JWT")
 
-	resetCheckpointState()
-	mu.Lock()
-	storeMap = map[string]*evidenceRecord{
-		"one": {ID: "one", LeafIndex: func() *int64 { x := int64(0); return &x }()},
-	}
-	mu.Unlock()
-
-	h := NewIngestHandler()
+	h := newTestHandler(t)
+	seedLeaves(t, h, "one")
 	r := chi.NewRouter()
-	r.With(middleware.JWT).Get("/api/v1/checkpoints", h.GetCheckpointsHistory)
-	r.With(middleware.JWT).Get("/api/v1/checkpoints/latest", h.GetCheckpointsLatest)
-	r.With(middleware.JWT).Get("/api/v1/checkpoints/{treeSize}/verify", h.VerifyCheckpointByTreeSize)
+	r.With(middleware.JWT, middleware.Require(middleware.PermCheckpointRead)).Get("/api/v1/checkpoints", h.GetCheckpointsHistory)
+	r.With(middleware.JWT, middleware.Require(middleware.PermCheckpointRead)).Get("/api/v1/checkpoints/latest", h.GetCheckpointsLatest)
+	r.With(middleware.JWT, middleware.Require(middleware.PermCheckpointRead)).Get("/api/v1/checkpoints/{treeSize}/verify", h.VerifyCheckpointByTreeSize)
 
 	// materialize first checkpoint (tree_size=1)
 	req := httptest.NewRequest(http.MethodGet, "/api/v1/checkpoints/latest", nil)
@@ -91,9 +85,7 @@ func TestCheckpointHistoryAndVerifyByTreeSize(t *testing.T) {
 	}
 
 	// grow tree and materialize second checkpoint (tree_size=2)
-	mu.Lock()
-	storeMap["two"] = &evidenceRecord{ID: "two", LeafIndex: func() *int64 { x := int64(1); return &x }()}
-	mu.Unlock()
+	seedLeaves(t, h, "two")
 	rw = httptest.NewRecorder()
 	r.ServeHTTP(rw, req)
 	if rw.Code != http.StatusOK {
@@ -109,7 +101,7 @@ func TestCheckpointHistoryAndVerifyByTreeSize(t *testing.T) {
 		t.Fatalf("history expected 200 got %d", hRW.Code)
 	}
 	var list struct {
-		Entries []checkpointResponse `json:"entries"`
+		Entries []service.Checkpoint `json:"entries"`
 	}
 	if err := json.NewDecoder(hRW.Body).Decode(&list); err != nil {
 		t.Fatalf("decode history: %v", err)
@@ -160,9 +152,17 @@ func setupCheckpointSigner(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey,
 	return pub, priv, signer
 }
 
-func resetCheckpointState() {
-	mu.Lock()
-	defer mu.Unlock()
-	checkpointHistory = map[int64]checkpointResponse{}
-	checkpointOrder = []int64{}
+// seedLeaves stores one sequenced record per id, in order.
+func seedLeaves(t *testing.T, h *IngestHandler, ids ...string) {
+	t.Helper()
+	ctx := context.Background()
+	s := h.vault.Store()
+	for _, id := range ids {
+		if err := s.SaveEvidence(ctx, store.Evidence{ID: id, ContentHash: sha256Hex([]byte(id)), Status: "stored", IngestedAt: time.Now().UTC()}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.AssignLeaves(ctx, ids); err != nil {
+		t.Fatal(err)
+	}
 }
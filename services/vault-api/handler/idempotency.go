@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/domain/evidence"
+	"github.com/SaridakisStamatisChristos/vault-api/internal/abac"
+	"github.com/SaridakisStamatisChristos/vault-api/internal/promise"
+	"github.com/SaridakisStamatisChristos/vault-api/internal/tenant"
+	"github.com/SaridakisStamatisChristos/vault-api/service"
+)
+
+const maxIdempotencyKeyLen = 255
+
+func loadDedupConfig() (service.Dedup, error) {
+	cfg := service.Dedup{Policy: service.DedupReturnExisting, Window: 24 * time.Hour}
+	if v := strings.ToLower(strings.TrimSpace(os.Getenv("INGEST_DEDUP_POLICY"))); v != "" {
+		switch v {
+		case service.DedupReject, service.DedupReturnExisting, service.DedupAllowDuplicate:
+			cfg.Policy = v
+		default:
+			return cfg, fmt.Errorf("invalid INGEST_DEDUP_POLICY %q", v)
+		}
+	}
+	if v := strings.TrimSpace(os.Getenv("INGEST_DEDUP_WINDOW")); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return cfg, fmt.Errorf("invalid INGEST_DEDUP_WINDOW %q", v)
+		}
+		cfg.Window = d
+	}
+	return cfg, nil
+}
+
+// ValidateIngestConfig reports ingest settings that would otherwise be
+// silently replaced by defaults.
+func ValidateIngestConfig() error {
+	if _, err := loadDedupConfig(); err != nil {
+		return err
+	}
+	if _, err := loadUploadConfig(); err != nil {
+		return err
+	}
+	if _, err := maxBatchItems(); err != nil {
+		return err
+	}
+	if _, err := loadWaitConfig(); err != nil {
+		return err
+	}
+	if _, err := promise.SignerFromEnv(); err != nil {
+		return err
+	}
+	if _, err := tenant.LoadFromEnv(); err != nil {
+		return err
+	}
+	_, err := abac.LoadFromEnv()
+	return err
+}
+
+// evidenceStatus reports the lifecycle status of rec, deriving it from the
+// leaf index for records that predate status tracking.
+func evidenceStatus(rec *service.Record) string {
+	switch {
+	case rec == nil:
+		return string(evidence.StatusStored)
+	case rec.Status != "":
+		return string(rec.Status)
+	case rec.LeafIndex != nil:
+		return string(evidence.StatusSequenced)
+	default:
+		return string(evidence.StatusStored)
+	}
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/domain/merkle"
+	"github.com/SaridakisStamatisChristos/vault-api/service"
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+)
+
+// newTestHandler serves a fresh in-memory vault. Configuration is read from
+// the environment, so set it first.
+func newTestHandler(t *testing.T) *IngestHandler {
+	t.Helper()
+	return NewIngestHandler(store.NewMemoryStore(), merkle.NewMemoryEngine())
+}
+
+type ingestResult struct {
+	Code     int
+	Replayed string
+	ID       string `json:"id"`
+	Hash     string `json:"content_hash"`
+	Error    string `json:"error"`
+}
+
+func doIngest(t *testing.T, h *IngestHandler, key string, payload []byte) ingestResult {
+	t.Helper()
+	body, _ := json.Marshal(map[string]interface{}{"content_type": "text/plain", "payload": payload})
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/evidence", bytes.NewReader(body))
+	if key != "" {
+		req.Header.Set("Idempotency-Key", key)
+	}
+	rw := httptest.NewRecorder()
+	h.Ingest(rw, req)
+	var got ingestResult
+	_ = json.NewDecoder(rw.Body).Decode(&got)
+	got.Code = rw.Code
+	got.Replayed = rw.Header().Get("Idempotent-Replayed")
+	return got
+}
+
+func TestIngestIdempotencyKey(t *testing.T) {
+	useTempBlobStore(t)
+	t.Setenv("INGEST_DEDUP_POLICY", service.DedupAllowDuplicate)
+	h := newTestHandler(t)
+
+	first := doIngest(t, h, "k-1", []byte("alpha"))
+	if first.Code != http.StatusAccepted || first.ID == "" || len(first.Hash) != 64 {
+		t.Fatalf("unexpected first ingest: %+v", first)
+	}
+	retry := doIngest(t, h, "k-1", []byte("alpha"))
+	if retry.Code != http.StatusOK || retry.ID != first.ID || retry.Replayed != "true" {
+		t.Fatalf("expected replay of %s, got %+v", first.ID, retry)
+	}
+	reused := doIngest(t, h, "k-1", []byte("beta"))
+	if reused.Code != http.StatusUnprocessableEntity || reused.Error != "idempotency_key_reused" {
+		t.Fatalf("expected 422 for reused key, got %+v", reused)
+	}
+	other := doIngest(t, h, "k-2", []byte("alpha"))
+	if other.Code != http.StatusAccepted || other.ID == first.ID {
+		t.Fatalf("allow-duplicate should create a new record, got %+v", other)
+	}
+}
+
+func TestIngestContentDedupPolicies(t *testing.T) {
+	tests := []struct {
+		policy   string
+		wantCode int
+		sameID   bool
+	}{
+		{policy: service.DedupReturnExisting, wantCode: http.StatusOK, sameID: true},
+		{policy: service.DedupReject, wantCode: http.StatusConflict, sameID: true},
+		{policy: service.DedupAllowDuplicate, wantCode: http.StatusAccepted, sameID: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			useTempBlobStore(t)
+			t.Setenv("INGEST_DEDUP_POLICY", tt.policy)
+			h := newTestHandler(t)
+
+			first := doIngest(t, h, "", []byte("same bytes"))
+			second := doIngest(t, h, "", []byte("same bytes"))
+			if second.Code != tt.wantCode {
+				t.Fatalf("expected %d got %d", tt.wantCode, second.Code)
+			}
+			if (second.ID == first.ID) != tt.sameID {
+				t.Fatalf("first=%s second=%s sameID want %v", first.ID, second.ID, tt.sameID)
+			}
+		})
+	}
+}
+
+func TestIngestDedupWindowExpiry(t *testing.T) {
+	useTempBlobStore(t)
+	t.Setenv("INGEST_DEDUP_POLICY", service.DedupReturnExisting)
+	t.Setenv("INGEST_DEDUP_WINDOW", "1m")
+	h := newTestHandler(t)
+
+	// the same content and key, ingested before the window
+	ctx := context.Background()
+	s := h.vault.Store()
+	then := time.Now().UTC().Add(-2 * time.Minute)
+	hash := sha256Hex([]byte("payload"))
+	if err := s.SaveEvidence(ctx, store.Evidence{ID: "old", ContentHash: hash, Status: "stored", IngestedAt: then}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ClaimIdempotencyKey(ctx, store.IdempotencyKey{Scope: service.IdempotencyScope("", "k"), EvidenceID: "old", ContentHash: hash, CreatedAt: then}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	second := doIngest(t, h, "k", []byte("payload"))
+	if second.Code != http.StatusAccepted || second.ID == "old" {
+		t.Fatalf("expected a fresh record outside the window, got %+v", second)
+	}
+}
+
+func TestLoadDedupConfigRejectsInvalidValues(t *testing.T) {
+	t.Setenv("INGEST_DEDUP_POLICY", "maybe")
+	if err := ValidateIngestConfig(); err == nil {
+		t.Fatalf("expected error for invalid policy")
+	}
+	t.Setenv("INGEST_DEDUP_POLICY", "")
+	t.Setenv("INGEST_DEDUP_WINDOW", "-1h")
+	if err := ValidateIngestConfig(); err == nil {
+		t.Fatalf("expected error for negative window")
+	}
+}
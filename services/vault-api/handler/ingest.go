@@ -1,10 +1,13 @@
 package handler
 
 import (
+	"bytes"
 	"context"
 	"crypto/ed25519"
 	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"strconv"
@@ -12,54 +15,97 @@ import (
 	"sync"
 	"time"
 
+	"github.com/SaridakisStamatisChristos/vault-api/domain/evidence"
+	"github.com/SaridakisStamatisChristos/vault-api/domain/merkle"
+	"github.com/SaridakisStamatisChristos/vault-api/internal/promise"
+	"github.com/SaridakisStamatisChristos/vault-api/internal/tenant"
+	"github.com/SaridakisStamatisChristos/vault-api/internal/webhook"
 	"github.com/SaridakisStamatisChristos/vault-api/middleware"
+	"github.com/SaridakisStamatisChristos/vault-api/service"
 	"github.com/SaridakisStamatisChristos/vault-api/store"
-	"github.com/google/uuid"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
 )
 
-type IngestHandler struct{}
-
-func NewIngestHandler() *IngestHandler { return &IngestHandler{} }
-
-type evidenceRecord struct {
-	ID        string `json:"id"`
-	LeafIndex *int64 `json:"leaf_index,omitempty"`
+// IngestHandler serves the evidence API. It keeps no evidence state of its
+// own: everything goes through the vault service and its store, so any
+// number of replicas can share one store.
+type IngestHandler struct {
+	// vault is the default tenant's vault; vaults holds every tenant's,
+	// keyed by tenant ID.
+	vault   *service.Vault
+	vaults  map[string]*service.Vault
+	tenants *tenant.Config
+	dedup   service.Dedup
+	upload  uploadConfig
+	wait    waitConfig
+	// promises signs the inclusion promise returned with every admission.
+	promises *promise.Signer
+	access   accessPolicy
+	// keys holds every tenant's API keys.
+	keys store.APIKeyStore
+	// webhooks holds every tenant's webhook dispatcher, keyed by tenant ID.
+	webhooks map[string]*webhook.Dispatcher
 }
 
-type auditEntry struct {
-	ID        string    `json:"id"`
-	Actor     string    `json:"actor"`
-	Timestamp time.Time `json:"timestamp"`
+// NewIngestHandler serves the vaults kept in s, one per tenant configured
+// by TENANTS_FILE. engine caches the Merkle tree of the default tenant's
+// leaf sequence and is caught up on demand.
+func NewIngestHandler(s store.Store, engine merkle.Engine) *IngestHandler {
+	dedup, err := loadDedupConfig()
+	if err != nil {
+		log.Error().Err(err).Msg("invalid ingest dedup configuration; using defaults")
+	}
+	upload, err := loadUploadConfig()
+	if err != nil {
+		log.Error().Err(err).Msg("invalid upload configuration; using defaults")
+	}
+	wait, err := loadWaitConfig()
+	if err != nil {
+		log.Error().Err(err).Msg("invalid ingest wait configuration; using defaults")
+	}
+	signer, err := promise.SignerFromEnv()
+	if err != nil {
+		log.Error().Err(err).Msg("invalid promise signing configuration; using an ephemeral key")
+		signer, _ = promise.NewSigner(nil, promise.DefaultMaxMergeDelay)
+	}
+	if signer.Ephemeral {
+		log.Warn().Str("key_id", signer.KeyID()).Msg("PROMISE_SIGNING_KEY_B64 not set; inclusion promises use an ephemeral key")
+	}
+	// StartWebhookDispatcher reports an invalid configuration
+	hooks, _ := webhook.ConfigFromEnv()
+	tenants := loadTenants()
+	vaults, dispatchers, err := newTenantVaults(s, engine, tenants, hooks)
+	if err != nil {
+		log.Error().Err(err).Msg("open tenant stores; serving the default tenant only")
+		tenants = tenant.Single()
+		vaults, dispatchers, _ = newTenantVaults(s, engine, tenants, hooks)
+	}
+	return &IngestHandler{vault: vaults[store.DefaultTenant], vaults: vaults, tenants: tenants, dedup: dedup, upload: upload, wait: wait, promises: signer, access: loadAccessPolicy(), keys: s, webhooks: dispatchers}
 }
 
+// checkpointPayload is what checkpoint signatures cover. Origin is omitted
+// for the default tenant, whose checkpoints predate it.
 type checkpointPayload struct {
+	Origin   string `json:"origin,omitempty"`
 	TreeSize int64  `json:"tree_size"`
 	RootHash string `json:"root_hash"`
 }
 
-type checkpointResponse struct {
-	TreeSize  int64  `json:"tree_size"`
-	RootHash  string `json:"root_hash"`
-	Signature string `json:"signature"`
-	KeyRef    string `json:"key_ref,omitempty"`
-}
-
-var (
-	mu sync.Mutex
-	// keep old globals for memory fallback compatibility; store package
-	// will be used when initialized.
-	storeMap                = map[string]*evidenceRecord{}
-	next              int64 = 0
-	audits                  = []auditEntry{}
-	checkpointHistory       = map[int64]checkpointResponse{}
-	checkpointOrder         = []int64{}
-)
+// mu guards the process-wide dispatchers.
+var mu sync.Mutex
 
 func (h *IngestHandler) Ingest(w http.ResponseWriter, r *http.Request) {
 	var req struct {
-		ContentType string `json:"content_type"`
-		Payload     []byte `json:"payload"`
+		ContentType string            `json:"content_type"`
+		Payload     []byte            `json:"payload"`
+		Labels      map[string]string `json:"labels"`
 	}
+	if _, _, err := h.waitParams(r); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_wait", err.Error())
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, h.upload.MaxJSONBytes)
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		w.WriteHeader(400)
 		return
@@ -68,50 +114,158 @@ func (h *IngestHandler) Ingest(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(400)
 		return
 	}
+	if err := evidence.ValidateLabels(req.Labels); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_labels", err.Error())
+		return
+	}
+	key := strings.TrimSpace(r.Header.Get("Idempotency-Key"))
+	if len(key) > maxIdempotencyKeyLen {
+		w.WriteHeader(400)
+		return
+	}
 
-	id := uuid.NewString()
-	mu.Lock()
-	storeMap[id] = &evidenceRecord{ID: id, LeafIndex: nil}
 	actor := middleware.SubjectFromContext(r.Context())
-	audits = append(audits, auditEntry{ID: id, Actor: actor, Timestamp: time.Now()})
-	mu.Unlock()
+	if h.overQuota(w, r, actor, int64(len(req.Payload))) {
+		return
+	}
+	ev := evidence.NewEvidence("", req.ContentType, req.Payload, actor)
+	draft := service.Record{ContentType: req.ContentType, ContentHash: ev.ContentHash, Labels: req.Labels, Size: int64(len(req.Payload))}
+	ref, err := storeBlob(r.Context(), bytes.NewReader(req.Payload), ev.ContentHash)
+	if err != nil {
+		log.Error().Err(err).Msg("store evidence payload")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	draft.PayloadRef = ref
+	h.writeIngestResult(w, r, actor, key, draft)
+}
 
-	if s := store.Current(); s != nil {
-		_ = s.SaveEvidence(r.Context(), id)
-		_ = s.SaveAudit(r.Context(), store.AuditEntry{ID: "", ResourceID: id, Actor: actor, Timestamp: time.Now()})
+// admission is the vault's admission decision with the inclusion promise
+// answered to the client.
+type admission struct {
+	service.Admission
+	// Promise commits to sequencing the record within the maximum merge
+	// delay; it is nil for conflicts.
+	Promise *promise.Promise
+}
+
+// admit creates a stored evidence record from draft unless the
+// Idempotency-Key or the dedup policy resolve it to an existing record.
+// draft must carry the finalised content hash. With queue set a new record
+// is queued for sequencing on its own. An existing record the caller may
+// not read is not named: the admission becomes a duplicate_content
+// conflict without a record.
+func (h *IngestHandler) admit(ctx context.Context, actor, key string, draft service.Record, queue bool) (admission, error) {
+	req := service.AdmitRequest{Actor: actor, Provenance: middleware.ClientCertFingerprint(ctx), Key: key, Draft: draft, Dedup: h.dedup}
+	var (
+		adm admission
+		err error
+	)
+	if queue {
+		adm.Admission, err = h.vaultFor(ctx).AdmitOne(ctx, req)
+	} else {
+		adm.Admission, err = h.vaultFor(ctx).Admit(ctx, req)
+	}
+	if err != nil {
+		return adm, err
+	}
+	existing := adm.Conflict == service.ConflictDuplicateContent || adm.Replayed
+	if existing && !h.authorize(ctx, middleware.PermEvidenceRead, &adm.Record) {
+		return admission{Admission: service.Admission{Conflict: service.ConflictDuplicateContent}}, nil
 	}
+	if adm.Conflict != "" {
+		return adm, nil
+	}
+	adm.Promise = h.promiseFor(adm.Record)
+	return adm, nil
+}
 
-	resp := map[string]interface{}{"id": id, "content_hash": "", "status": "pending"}
+func writeJSONError(w http.ResponseWriter, status int, code, detail string) {
 	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(202)
-	_ = json.NewEncoder(w).Encode(resp)
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": code, "detail": detail})
 }
 
-func (h *IngestHandler) GetAudit(w http.ResponseWriter, r *http.Request) {
-	roles := middleware.RolesFromContext(r.Context())
-	allowed := false
-	for _, rr := range roles {
-		if rr == "auditor" {
-			allowed = true
-			break
+// writeIngestResult admits a single draft and answers the request, waiting
+// for inclusion first when the client asked for it with ?wait=inclusion.
+func (h *IngestHandler) writeIngestResult(w http.ResponseWriter, r *http.Request, actor, key string, draft service.Record) {
+	adm, err := h.admit(r.Context(), actor, key, draft, true)
+	if err != nil {
+		log.Error().Err(err).Msg("admit evidence")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if wait, _, _ := h.waitParams(r); wait {
+		h.writeReceipt(w, r, adm)
+		return
+	}
+	writeAdmission(w, adm)
+}
+
+func writeAdmission(w http.ResponseWriter, adm admission) {
+	w.Header().Set("Content-Type", "application/json")
+	switch adm.Conflict {
+	case service.ConflictIdempotencyKeyReused:
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": adm.Conflict})
+		return
+	case service.ConflictDuplicateContent:
+		body := map[string]interface{}{"error": adm.Conflict}
+		if adm.Record.ID != "" {
+			body["id"], body["content_hash"] = adm.Record.ID, adm.Record.ContentHash
+		}
+		w.WriteHeader(http.StatusConflict)
+		_ = json.NewEncoder(w).Encode(body)
+		return
+	case service.ConflictQuotaExceeded:
+		body := map[string]interface{}{"error": adm.Conflict, "detail": "ingest quota exceeded"}
+		if adm.Quota != nil {
+			body["detail"], body["quota"] = adm.Quota.String(), adm.Quota
 		}
+		w.WriteHeader(http.StatusForbidden)
+		_ = json.NewEncoder(w).Encode(body)
+		return
+	}
+	resp := map[string]interface{}{"id": adm.Record.ID, "content_hash": adm.Record.ContentHash, "status": evidenceStatus(&adm.Record)}
+	if adm.Record.Size > 0 {
+		resp["size"] = adm.Record.Size
 	}
-	if !allowed {
-		w.WriteHeader(403)
+	if adm.Promise != nil {
+		resp["promise"] = adm.Promise
+	}
+	if adm.Replayed {
+		if adm.Record.LeafIndex != nil {
+			resp["leaf_index"] = *adm.Record.LeafIndex
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusAccepted)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (h *IngestHandler) GetAudit(w http.ResponseWriter, r *http.Request) {
+	audits, err := h.vaultFor(r.Context()).Audits(r.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("list audit entries")
+		w.WriteHeader(http.StatusInternalServerError)
 		return
 	}
-	mu.Lock()
-	defer mu.Unlock()
 	var entries []map[string]interface{}
 	for _, a := range audits {
-		entries = append(entries, map[string]interface{}{"action": "ingest", "resource_id": a.ID})
+		entry := map[string]interface{}{"action": a.Action, "resource_id": a.ResourceID}
+		if len(a.Metadata) > 0 {
+			entry["metadata"] = a.Metadata
+		}
+		entries = append(entries, entry)
 	}
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(map[string]interface{}{"entries": entries})
 }
 
 func (h *IngestHandler) GetCheckpointsLatest(w http.ResponseWriter, r *http.Request) {
-	cp, status := buildLatestCheckpoint(r.Context())
+	cp, status := h.buildLatestCheckpoint(r.Context())
 	if status != http.StatusOK {
 		w.WriteHeader(status)
 		return
@@ -122,140 +276,122 @@ func (h *IngestHandler) GetCheckpointsLatest(w http.ResponseWriter, r *http.Requ
 
 // GetCheckpointsHistory returns known checkpoints (latest first).
 func (h *IngestHandler) GetCheckpointsHistory(w http.ResponseWriter, r *http.Request) {
-	if !hasCheckpointAccess(r.Context()) {
-		w.WriteHeader(http.StatusForbidden)
-		return
-	}
-	_, _ = buildLatestCheckpoint(r.Context())
+	_, _ = h.buildLatestCheckpoint(r.Context())
 
-	mu.Lock()
-	defer mu.Unlock()
-	entries := make([]checkpointResponse, 0, len(checkpointOrder))
-	for i := len(checkpointOrder) - 1; i >= 0; i-- {
-		ts := checkpointOrder[i]
-		entries = append(entries, checkpointHistory[ts])
+	entries, err := h.vaultFor(r.Context()).Checkpoints(r.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("list checkpoints")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(map[string]interface{}{"entries": entries})
 }
 
 // VerifyLatestCheckpoint verifies the latest checkpoint signature against
-// CHECKPOINT_VERIFY_PUBLIC_KEY_B64 and returns a verification verdict.
+// the tenant's verify key (CHECKPOINT_VERIFY_PUBLIC_KEY_B64 by default) and
+// returns a verification verdict.
 func (h *IngestHandler) VerifyLatestCheckpoint(w http.ResponseWriter, r *http.Request) {
-	cp, status := buildLatestCheckpoint(r.Context())
+	cp, status := h.buildLatestCheckpoint(r.Context())
 	if status != http.StatusOK {
 		w.WriteHeader(status)
 		return
 	}
-	verifyCheckpointResponse(w, *cp)
+	h.verifyCheckpointResponse(w, r.Context(), *cp)
 }
 
 // VerifyCheckpointByTreeSize verifies a specific checkpoint by tree size.
 func (h *IngestHandler) VerifyCheckpointByTreeSize(w http.ResponseWriter, r *http.Request) {
-	if !hasCheckpointAccess(r.Context()) {
-		w.WriteHeader(http.StatusForbidden)
-		return
-	}
-	prefix := "/api/v1/checkpoints/"
-	path := r.URL.Path
-	if !strings.HasPrefix(path, prefix) || !strings.HasSuffix(path, "/verify") {
-		w.WriteHeader(http.StatusNotFound)
-		return
-	}
-	part := strings.TrimSuffix(strings.TrimPrefix(path, prefix), "/verify")
-	part = strings.Trim(part, "/")
-	treeSize, err := strconv.ParseInt(part, 10, 64)
+	treeSize, err := strconv.ParseInt(chi.URLParam(r, "treeSize"), 10, 64)
 	if err != nil || treeSize <= 0 {
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
 
-	mu.Lock()
-	cp, ok := checkpointHistory[treeSize]
-	mu.Unlock()
-	if !ok {
+	cp, err := h.vaultFor(r.Context()).Checkpoint(r.Context(), treeSize)
+	if errors.Is(err, store.ErrNotFound) {
 		w.WriteHeader(http.StatusNotFound)
 		return
 	}
-	verifyCheckpointResponse(w, cp)
+	if err != nil {
+		log.Error().Err(err).Int64("tree_size", treeSize).Msg("load checkpoint")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	h.verifyCheckpointResponse(w, r.Context(), cp)
 }
 
-func buildLatestCheckpoint(ctx context.Context) (*checkpointResponse, int) {
-	if !hasCheckpointAccess(ctx) {
-		return nil, http.StatusForbidden
+func (h *IngestHandler) buildLatestCheckpoint(ctx context.Context) (*service.Checkpoint, int) {
+	cp, err := h.vaultFor(ctx).LatestCheckpoint(ctx)
+	switch {
+	case errors.Is(err, service.ErrEmptyTree):
+		return nil, http.StatusNotFound
+	case err != nil:
+		log.Error().Err(err).Msg("materialize checkpoint")
+		return nil, http.StatusInternalServerError
 	}
+	return &cp, http.StatusOK
+}
 
-	var maxLeaf int64 = -1
-	mu.Lock()
-	for _, rec := range storeMap {
-		if rec.LeafIndex != nil && *rec.LeafIndex > maxLeaf {
-			maxLeaf = *rec.LeafIndex
-		}
-	}
-	mu.Unlock()
+// checkpointSigner signs a tenant's checkpoints for its origin with the
+// service at url, or CHECKPOINT_SIGNING_URL when url is empty. Only when
+// neither is set does it return a development placeholder; a failed
+// signing call is an error, so the checkpoint is not stored and is signed
+// on a later attempt.
+type checkpointSigner struct {
+	url    string
+	origin string
+}
 
-	treeSize := int64(0)
-	if maxLeaf >= 0 {
-		treeSize = maxLeaf + 1
+func (c checkpointSigner) SignCheckpoint(ctx context.Context, treeSize int64, rootHash string) (string, string, error) {
+	svc := c.url
+	if svc == "" {
+		svc = os.Getenv("CHECKPOINT_SIGNING_URL")
 	}
-	if treeSize == 0 {
-		return nil, http.StatusNotFound
+	if svc == "" {
+		return strings.Repeat("a", 64), "local:dev-default", nil
 	}
-
-	mu.Lock()
-	if cp, exists := checkpointHistory[treeSize]; exists {
-		mu.Unlock()
-		return &cp, http.StatusOK
+	payloadBytes, err := json.Marshal(checkpointPayload{Origin: c.origin, TreeSize: treeSize, RootHash: rootHash})
+	if err != nil {
+		return "", "", err
 	}
-	mu.Unlock()
-
-	root := strings.Repeat("0", 64)
-	payload := checkpointPayload{TreeSize: treeSize, RootHash: root}
-	payloadBytes, _ := json.Marshal(payload)
-	signature := strings.Repeat("a", 64)
-	keyRef := "local:dev-default"
-
-	if svc := os.Getenv("CHECKPOINT_SIGNING_URL"); svc != "" {
-		client := &http.Client{Timeout: 3 * time.Second}
-		resp, err := client.Post(svc, "application/json", strings.NewReader(string(payloadBytes)))
-		if err == nil {
-			defer resp.Body.Close()
-			if resp.StatusCode == http.StatusOK {
-				var got map[string]string
-				if err := json.NewDecoder(resp.Body).Decode(&got); err == nil {
-					if s, ok := got["signature"]; ok && s != "" {
-						signature = s
-					}
-					if kr, ok := got["key_ref"]; ok && kr != "" {
-						keyRef = kr
-					}
-				}
-			}
-		}
+	ctx, cancel := context.WithTimeout(ctx, 3*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, svc, bytes.NewReader(payloadBytes))
+	if err != nil {
+		return "", "", err
 	}
-
-	cp := checkpointResponse{TreeSize: treeSize, RootHash: root, Signature: signature, KeyRef: keyRef}
-	mu.Lock()
-	if _, exists := checkpointHistory[treeSize]; !exists {
-		checkpointOrder = append(checkpointOrder, treeSize)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("checkpoint signer: %w", err)
 	}
-	checkpointHistory[treeSize] = cp
-	mu.Unlock()
-	return &cp, http.StatusOK
-}
-
-func hasCheckpointAccess(ctx context.Context) bool {
-	roles := middleware.RolesFromContext(ctx)
-	for _, rr := range roles {
-		if rr == "auditor" || rr == "ingester" {
-			return true
-		}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("checkpoint signer: status %d", resp.StatusCode)
+	}
+	var got struct {
+		Signature string `json:"signature"`
+		KeyRef    string `json:"key_ref"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		return "", "", fmt.Errorf("checkpoint signer: %w", err)
 	}
-	return false
+	if got.Signature == "" || got.KeyRef == "" {
+		return "", "", errors.New("checkpoint signer: reply lacks signature or key_ref")
+	}
+	return got.Signature, got.KeyRef, nil
 }
 
-func verifyCheckpointResponse(w http.ResponseWriter, cp checkpointResponse) {
-	pubB64 := strings.TrimSpace(os.Getenv("CHECKPOINT_VERIFY_PUBLIC_KEY_B64"))
+// verifyCheckpointResponse checks cp against the verify key of the tenant
+// the request acts for.
+func (h *IngestHandler) verifyCheckpointResponse(w http.ResponseWriter, ctx context.Context, cp service.Checkpoint) {
+	tenantID := tenantOf(ctx)
+	t, _ := h.tenants.Lookup(tenantID)
+	pubB64 := t.CheckpointVerifyKeyB64
+	if pubB64 == "" {
+		pubB64 = strings.TrimSpace(os.Getenv("CHECKPOINT_VERIFY_PUBLIC_KEY_B64"))
+	}
 	if pubB64 == "" {
 		w.WriteHeader(http.StatusServiceUnavailable)
 		_ = json.NewEncoder(w).Encode(map[string]interface{}{"verified": false, "reason": "missing CHECKPOINT_VERIFY_PUBLIC_KEY_B64"})
@@ -267,7 +403,7 @@ func verifyCheckpointResponse(w http.ResponseWriter, cp checkpointResponse) {
 		_ = json.NewEncoder(w).Encode(map[string]interface{}{"verified": false, "reason": "invalid CHECKPOINT_VERIFY_PUBLIC_KEY_B64"})
 		return
 	}
-	payload, err := json.Marshal(checkpointPayload{TreeSize: cp.TreeSize, RootHash: cp.RootHash})
+	payload, err := json.Marshal(checkpointPayload{Origin: cp.Origin, TreeSize: cp.TreeSize, RootHash: cp.RootHash})
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		return
@@ -276,9 +412,13 @@ func verifyCheckpointResponse(w http.ResponseWriter, cp checkpointResponse) {
 	if err != nil {
 		w.Header().Set("Content-Type", "application/json")
 		_ = json.NewEncoder(w).Encode(map[string]interface{}{"verified": false, "reason": "checkpoint signature is not base64", "tree_size": cp.TreeSize, "root_hash": cp.RootHash, "key_ref": cp.KeyRef})
+		h.publishVerificationFailure(ctx, cp, "checkpoint signature is not base64")
 		return
 	}
 	verified := ed25519.Verify(ed25519.PublicKey(pubRaw), payload, sig)
+	if !verified {
+		h.publishVerificationFailure(ctx, cp, "signature mismatch")
+	}
 	w.Header().Set("Content-Type", "application/json")
 	_ = json.NewEncoder(w).Encode(map[string]interface{}{
 		"verified":  verified,
@@ -290,69 +430,50 @@ func verifyCheckpointResponse(w http.ResponseWriter, cp checkpointResponse) {
 }
 
 func (h *IngestHandler) GetEvidence(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Path[len("/api/v1/evidence/"):]
-	if s := store.Current(); s != nil {
-		ev, err := s.GetEvidence(r.Context(), id)
-		if err != nil {
-			w.WriteHeader(404)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		_ = json.NewEncoder(w).Encode(map[string]interface{}{"leaf_index": ev.LeafIndex})
-		return
-	}
-	mu.Lock()
-	rec, ok := storeMap[id]
-	mu.Unlock()
+	id := chi.URLParam(r, "id")
+	rec, ok := h.readableRecord(w, r, id, middleware.PermEvidenceRead)
 	if !ok {
-		w.WriteHeader(404)
 		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	_ = json.NewEncoder(w).Encode(map[string]interface{}{"leaf_index": rec.LeafIndex})
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"leaf_index": rec.LeafIndex, "status": evidenceStatus(&rec)})
 }
 
 func (h *IngestHandler) GetProof(w http.ResponseWriter, r *http.Request) {
-	id := r.URL.Path[len("/api/v1/evidence/"):]
-	if len(id) > 6 && id[len(id)-6:] == "/proof" {
-		id = id[:len(id)-6]
-	}
-	mu.Lock()
-	rec, ok := storeMap[id]
-	mu.Unlock()
-	if !ok || rec.LeafIndex == nil {
+	id := chi.URLParam(r, "id")
+	if _, ok := h.readableRecord(w, r, id, middleware.PermEvidenceRead); !ok {
+		return
+	}
+	proof, err := h.vaultFor(r.Context()).Proof(r.Context(), id)
+	switch {
+	case errors.Is(err, store.ErrNotFound), errors.Is(err, service.ErrNotSequenced):
 		w.WriteHeader(404)
 		return
+	case err != nil:
+		log.Error().Err(err).Str("evidence_id", id).Msg("build inclusion proof")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
 	}
 	w.Header().Set("Content-Type", "application/json")
-	root := strings.Repeat("0", 64)
-	_ = json.NewEncoder(w).Encode(map[string]interface{}{"leaf_index": *rec.LeafIndex, "tree_size": *rec.LeafIndex + 1, "root": root, "path": []string{}})
+	_ = json.NewEncoder(w).Encode(proof)
 }
 
-func StartCommitter(period time.Duration) {
+// StartCommitter sequences all of every tenant's pending evidence every
+// period and signs a checkpoint whenever a tree grew.
+func (h *IngestHandler) StartCommitter(period time.Duration) {
 	go func() {
 		for {
 			time.Sleep(period)
-			if s := store.Current(); s != nil {
-				if ev, err := s.AssignNextPendingLeaf(context.Background()); err == nil && ev != nil {
-					mu.Lock()
-					if m, ok := storeMap[ev.ID]; ok {
-						m.LeafIndex = ev.LeafIndex
+			ctx := context.Background()
+			for id, v := range h.vaults {
+				// with the pipeline the consumer sequences asynchronously,
+				// so sign whenever the tree has grown since the last tick
+				if v.CommitPending(ctx) > 0 || v.Pipelined() {
+					if _, err := v.LatestCheckpoint(ctx); err != nil && !errors.Is(err, service.ErrEmptyTree) {
+						log.Warn().Err(err).Str("tenant", id).Msg("checkpoint failed; will retry")
 					}
-					mu.Unlock()
-					continue
-				}
-			}
-			mu.Lock()
-			for _, rec := range storeMap {
-				if rec.LeafIndex == nil {
-					idx := next
-					next++
-					rec.LeafIndex = &idx
-					break
 				}
 			}
-			mu.Unlock()
 		}
 	}()
 }
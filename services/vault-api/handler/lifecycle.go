@@ -0,0 +1,271 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/domain/evidence"
+	"github.com/SaridakisStamatisChristos/vault-api/internal/events"
+	"github.com/SaridakisStamatisChristos/vault-api/internal/webhook"
+	"github.com/SaridakisStamatisChristos/vault-api/middleware"
+	"github.com/SaridakisStamatisChristos/vault-api/service"
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// eventHistory is how many events are retained for Last-Event-ID resumes.
+const eventHistory = 1024
+
+// sseHeartbeat keeps idle event streams alive through proxies.
+const sseHeartbeat = 15 * time.Second
+
+// eventHub carries the default tenant's events and tenantHubs the other
+// tenants', created on first use. Subscribers only see their tenant's hub.
+// The hubs are per process: a subscriber only hears of changes made by the
+// replica it is connected to, and event IDs are that replica's own.
+var (
+	eventHub   = events.NewHub(eventHistory)
+	tenantHubs = map[string]*events.Hub{}
+)
+
+func eventHubFor(tenant string) *events.Hub {
+	if tenant == store.DefaultTenant {
+		return eventHub
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	hub, ok := tenantHubs[tenant]
+	if !ok {
+		hub = events.NewHub(eventHistory)
+		tenantHubs[tenant] = hub
+	}
+	return hub
+}
+
+// vaultObserver fans a tenant's vault notifications out to its SSE
+// subscribers and webhooks, and to the SIEM forwarder.
+type vaultObserver struct {
+	tenant   string
+	webhooks *webhook.Dispatcher
+}
+
+func (o vaultObserver) StatusChanged(c events.StatusChange) {
+	eventHubFor(o.tenant).Publish(events.TypeEvidenceStatus, c)
+}
+
+func (o vaultObserver) Sequenced(rec service.Record) {
+	if rec.LeafIndex == nil {
+		return
+	}
+	o.webhooks.Publish(context.Background(), webhook.EventEvidenceSequenced, map[string]interface{}{"evidence_id": rec.ID, "content_hash": rec.ContentHash, "leaf_index": *rec.LeafIndex})
+}
+
+func (o vaultObserver) CheckpointPublished(cp service.Checkpoint, previous *service.Checkpoint) {
+	eventHubFor(o.tenant).Publish(events.TypeCheckpoint, cp)
+	o.webhooks.Publish(context.Background(), webhook.EventCheckpointPublished, cp)
+	if previous != nil && previous.KeyRef != cp.KeyRef {
+		o.webhooks.Publish(context.Background(), webhook.EventKeyRotated, map[string]interface{}{"previous_key_ref": previous.KeyRef, "key_ref": cp.KeyRef, "tree_size": cp.TreeSize})
+	}
+}
+
+func (o vaultObserver) Audited(entry store.AuditEntry) {
+	forwardAudit(o.tenant, entry)
+}
+
+func (o vaultObserver) UsageMeasured(u service.Usage) {
+	middleware.SetTenantUsage(o.tenant, u.Evidence, u.StorageBytes, u.BytesToday)
+}
+
+func (o vaultObserver) QuotaExceeded(q service.QuotaViolation) {
+	middleware.RecordQuotaExceeded(o.tenant, q.Scope, q.Quota)
+}
+
+// SetEvidenceStatus applies an operator transition: on-hold, redacted or
+// exported. The route requires evidence:manage; marking evidence exported
+// also requires bundle:export.
+func (h *IngestHandler) SetEvidenceStatus(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Status string `json:"status"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	next, err := evidence.ParseStatus(req.Status)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_status", err.Error())
+		return
+	}
+	if next == evidence.StatusExported && !middleware.HasPermission(r.Context(), middleware.PermBundleExport) {
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	id := chi.URLParam(r, "id")
+	current, ok := h.readableRecord(w, r, id, middleware.PermEvidenceManage)
+	if !ok {
+		return
+	}
+	if next == evidence.StatusExported && !h.authorize(r.Context(), middleware.PermBundleExport, &current) {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	rec, err := h.vaultFor(r.Context()).SetStatus(r.Context(), id, next, middleware.SubjectFromContext(r.Context()))
+	writeTransition(w, id, rec, err)
+}
+
+// ReleaseHold lifts a legal hold. The record returns to the status it had
+// when held, advanced by any sequencing or checkpoint since.
+func (h *IngestHandler) ReleaseHold(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if _, ok := h.readableRecord(w, r, id, middleware.PermEvidenceManage); !ok {
+		return
+	}
+	rec, err := h.vaultFor(r.Context()).ReleaseHold(r.Context(), id, middleware.SubjectFromContext(r.Context()))
+	writeTransition(w, id, rec, err)
+}
+
+func writeTransition(w http.ResponseWriter, id string, rec service.Record, err error) {
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case errors.Is(err, evidence.ErrInvalidTransition):
+		writeJSONError(w, http.StatusConflict, "invalid_transition", err.Error())
+		return
+	case err != nil:
+		log.Error().Err(err).Str("evidence_id", id).Msg("persist evidence status")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"id": rec.ID, "status": rec.Status, "leaf_index": rec.LeafIndex})
+}
+
+// Events streams evidence status transitions and new checkpoints as
+// server-sent events. Clients resume with Last-Event-ID (or last_event_id)
+// and may restrict the stream with types=evidence.status,checkpoint.
+// Status events are sent only for records the subject may read, checked
+// against the record as it is when the event is sent; the first one hidden
+// is audited once for the stream.
+func (h *IngestHandler) Events(w http.ResponseWriter, r *http.Request) {
+	want := map[string]bool{}
+	for _, t := range r.URL.Query()["types"] {
+		for _, name := range splitList(t) {
+			if name != events.TypeEvidenceStatus && name != events.TypeCheckpoint {
+				writeJSONError(w, http.StatusBadRequest, "invalid_types", "unknown event type "+strconv.Quote(name))
+				return
+			}
+			want[name] = true
+		}
+	}
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("last_event_id")
+	}
+	var after uint64
+	if lastID != "" {
+		var err error
+		if after, err = strconv.ParseUint(lastID, 10, 64); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+
+	sub, replay := eventHubFor(tenantOf(r.Context())).Subscribe(after)
+	defer sub.Cancel()
+
+	rc := http.NewResponseController(w)
+	_ = rc.SetWriteDeadline(time.Time{})
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "retry: 3000\n\n")
+
+	hid := false
+	send := func(e events.Event) error {
+		if len(want) > 0 && !want[e.Type] {
+			return nil
+		}
+		if ok, denied := h.readableEvent(r.Context(), e); !ok {
+			if denied && !hid {
+				// one entry per stream, not per hidden event
+				h.auditHidden(r.Context(), middleware.PermEvidenceRead, r.URL.Path, 1)
+				hid = true
+			}
+			return nil
+		}
+		data, err := json.Marshal(e.Data)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+		return err
+	}
+	for _, e := range replay {
+		if err := send(e); err != nil {
+			return
+		}
+	}
+	_ = rc.Flush()
+
+	heartbeat := time.NewTicker(sseHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e, ok := <-sub.C:
+			if !ok {
+				// dropped as a slow consumer; the client reconnects and resumes
+				return
+			}
+			if err := send(e); err != nil {
+				return
+			}
+		case <-heartbeat.C:
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// readableEvent reports whether the stream's subject may see e, and
+// whether the access policy is what hid it.
+func (h *IngestHandler) readableEvent(ctx context.Context, e events.Event) (ok, denied bool) {
+	change, isStatus := e.Data.(events.StatusChange)
+	if !isStatus {
+		return true, false
+	}
+	rec, err := h.vaultFor(ctx).Get(ctx, change.EvidenceID)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Error().Err(err).Str("evidence_id", change.EvidenceID).Msg("load evidence for event")
+		}
+		return false, false
+	}
+	ok = h.permits(ctx, middleware.PermEvidenceRead, &rec)
+	return ok, !ok
+}
+
+// splitList splits a comma-separated query value, dropping empty items.
+func splitList(v string) []string {
+	var out []string
+	for _, p := range strings.Split(v, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
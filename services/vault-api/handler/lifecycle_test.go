@@ -0,0 +1,230 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/internal/events"
+	"github.com/SaridakisStamatisChristos/vault-api/middleware"
+	"github.com/SaridakisStamatisChristos/vault-api/service"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestEvidenceLifecyclePublishesTransitions(t *testing.T) {
+	useTempBlobStore(t)
+	h := newTestHandler(t)
+
+	sub, _ := eventHub.Subscribe(0)
+	defer sub.Cancel()
+
+	res := doIngest(t, h, "", []byte("lifecycle"))
+	h.vault.CommitNext(context.Background())
+	if _, err := h.vault.LatestCheckpoint(context.Background()); err != nil {
+		t.Fatalf("checkpoint: %v", err)
+	}
+
+	var got []string
+	timeout := time.After(time.Second)
+	for len(got) < 5 {
+		select {
+		case e := <-sub.C:
+			switch d := e.Data.(type) {
+			case events.StatusChange:
+				if d.EvidenceID == res.ID {
+					got = append(got, d.To)
+				}
+			case service.Checkpoint:
+				got = append(got, "checkpoint")
+			}
+		case <-timeout:
+			t.Fatalf("timed out; events so far %v", got)
+		}
+	}
+	want := "received,stored,sequenced,checkpoint,checkpointed"
+	if strings.Join(got, ",") != want {
+		t.Fatalf("got transitions %v, want %s", got, want)
+	}
+}
+
+func TestManualStatusTransitions(t *testing.T) {
+	t.Setenv("ENABLE_TEST_JWT", "true")
+	useTempBlobStore(t)
+	h := newTestHandler(t)
+	r := chi.NewRouter()
+	r.With(middleware.JWT, middleware.Require(middleware.PermEvidenceManage)).Post("/api/v1/evidence/{id}/status", h.SetEvidenceStatus)
+	r.With(middleware.JWT, middleware.Require(middleware.PermEvidenceManage)).Delete("/api/v1/evidence/{id}/hold", h.ReleaseHold)
+
+	res := doIngest(t, h, "", []byte("held"))
+	h.vault.CommitNext(context.Background())
+	if _, err := h.vault.LatestCheckpoint(context.Background()); err != nil {
+		t.Fatalf("checkpoint: %v", err)
+	}
+
+	call := func(method, path, token, body string) (int, string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		var out struct {
+			Status string `json:"status"`
+		}
+		_ = json.NewDecoder(rw.Body).Decode(&out)
+		return rw.Code, out.Status
+	}
+	statusPath := "/api/v1/evidence/" + res.ID + "/status"
+
+	if code, _ := call(http.MethodPost, statusPath, "ingester-token", `{"status":"on-hold"}`); code != http.StatusForbidden {
+		t.Fatalf("ingester must not change status, got %d", code)
+	}
+	if code, st := call(http.MethodPost, statusPath, "auditor-token", `{"status":"on-hold"}`); code != http.StatusOK || st != "on-hold" {
+		t.Fatalf("hold: %d %s", code, st)
+	}
+	if code, _ := call(http.MethodPost, statusPath, "auditor-token", `{"status":"redacted"}`); code != http.StatusConflict {
+		t.Fatalf("redacting held evidence must conflict, got %d", code)
+	}
+	if code, _ := call(http.MethodPost, statusPath, "auditor-token", `{"status":"sequenced"}`); code != http.StatusConflict {
+		t.Fatalf("vault-managed status must not be settable, got %d", code)
+	}
+	if code, st := call(http.MethodDelete, "/api/v1/evidence/"+res.ID+"/hold", "auditor-token", ""); code != http.StatusOK || st != "checkpointed" {
+		t.Fatalf("release: %d %s", code, st)
+	}
+	if code, st := call(http.MethodPost, statusPath, "auditor-token", `{"status":"exported"}`); code != http.StatusOK || st != "exported" {
+		t.Fatalf("export: %d %s", code, st)
+	}
+	if code, _ := call(http.MethodPost, "/api/v1/evidence/missing/status", "auditor-token", `{"status":"redacted"}`); code != http.StatusNotFound {
+		t.Fatalf("expected 404 got %d", code)
+	}
+
+	audits, err := h.vault.Audits(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actions []string
+	for _, a := range audits {
+		if a.ResourceID == res.ID && a.Action != "ingest" {
+			actions = append(actions, a.Action)
+		}
+	}
+	if strings.Join(actions, ",") != "evidence_hold,evidence_release,evidence_export" {
+		t.Fatalf("unexpected audit trail %v", actions)
+	}
+}
+
+func TestEventsStreamSSE(t *testing.T) {
+	t.Setenv("ENABLE_TEST_JWT", "true")
+	h := newTestHandler(t)
+	r := chi.NewRouter()
+	r.With(middleware.JWT, middleware.Require(middleware.PermEvidenceRead)).Get("/api/v1/events", h.Events)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	before := eventHub.Publish(events.TypeCheckpoint, service.Checkpoint{TreeSize: 1})
+	eventHub.Publish(events.TypeEvidenceStatus, events.StatusChange{EvidenceID: "skip-me", To: "stored"})
+	replayed := eventHub.Publish(events.TypeCheckpoint, service.Checkpoint{TreeSize: 2})
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/events?types=checkpoint", nil)
+	req.Header.Set("Authorization", "Bearer auditor-token")
+	req.Header.Set("Last-Event-ID", strconv.FormatUint(before.ID, 10))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Fatalf("unexpected response %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	sc := bufio.NewScanner(resp.Body)
+	var lines []string
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(line, "id: ") || strings.HasPrefix(line, "event: ") || strings.HasPrefix(line, "data: ") {
+			lines = append(lines, line)
+		}
+		if len(lines) == 3 {
+			break
+		}
+	}
+	if len(lines) != 3 || lines[0] != "id: "+strconv.FormatUint(replayed.ID, 10) || lines[1] != "event: checkpoint" || !strings.Contains(lines[2], `"tree_size":2`) {
+		t.Fatalf("unexpected stream %v", lines)
+	}
+
+	req, _ = http.NewRequest(http.MethodGet, srv.URL+"/api/v1/events", nil)
+	req.Header.Set("Authorization", "Bearer someone-else")
+	resp2, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	resp2.Body.Close()
+	if resp2.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected 403 got %d", resp2.StatusCode)
+	}
+}
+
+func TestEventsStreamOnlyReadableRecords(t *testing.T) {
+	t.Setenv("ENABLE_TEST_JWT", "true")
+	t.Setenv("INGEST_DEDUP_POLICY", service.DedupAllowDuplicate)
+	useTempBlobStore(t)
+	useAccessPolicy(t, ownCasesPolicy)
+	h := newTestHandler(t)
+	mine := ingestLabelled(t, h, "mine", map[string]string{"case": "1234"})
+	theirs := ingestLabelled(t, h, "theirs", map[string]string{"case": "9999"})
+	r := chi.NewRouter()
+	r.With(middleware.JWT).Get("/api/v1/events", h.Events)
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	before := eventHub.Publish(events.TypeCheckpoint, service.Checkpoint{TreeSize: 1})
+	eventHub.Publish(events.TypeEvidenceStatus, events.StatusChange{EvidenceID: theirs, To: "on-hold"})
+	eventHub.Publish(events.TypeEvidenceStatus, events.StatusChange{EvidenceID: theirs, From: "on-hold", To: "stored"})
+	eventHub.Publish(events.TypeEvidenceStatus, events.StatusChange{EvidenceID: "unknown", To: "on-hold"})
+	eventHub.Publish(events.TypeEvidenceStatus, events.StatusChange{EvidenceID: mine, To: "on-hold"})
+	eventHub.Publish(events.TypeCheckpoint, service.Checkpoint{TreeSize: 2})
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/api/v1/events", nil)
+	req.Header.Set("Authorization", "Bearer "+investigatorToken(t, "inv-1", "1234"))
+	req.Header.Set("Last-Event-ID", strconv.FormatUint(before.ID, 10))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer resp.Body.Close()
+
+	sc := bufio.NewScanner(resp.Body)
+	var data []string
+	for sc.Scan() {
+		if line := sc.Text(); strings.HasPrefix(line, "data: ") {
+			data = append(data, line)
+			if strings.Contains(line, `"tree_size":2`) {
+				break
+			}
+		}
+	}
+	if len(data) != 2 || !strings.Contains(data[0], mine) {
+		t.Fatalf("expected only the own-case status event before the checkpoint, got %v", data)
+	}
+	audits, err := h.vault.Audits(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	denied := 0
+	for _, a := range audits {
+		if a.Action != "access_denied" {
+			continue
+		}
+		denied++
+		if a.ResourceID != "/api/v1/events" || a.Actor != "inv-1" {
+			t.Fatalf("hidden events not audited against the stream: %+v", a)
+		}
+	}
+	if denied != 1 {
+		t.Fatalf("the stream must be audited once, not per hidden event: got %d", denied)
+	}
+}
@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"context"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/internal/pipeline"
+	"github.com/SaridakisStamatisChristos/vault-api/middleware"
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+	"github.com/rs/zerolog/log"
+)
+
+// StartPipeline starts the ingest pipeline configured by PIPELINE_BROKER.
+// It is a no-op when the pipeline is not configured.
+func (h *IngestHandler) StartPipeline(ctx context.Context) error {
+	cfg, ok, err := pipeline.ConfigFromEnv()
+	if err != nil || !ok {
+		return err
+	}
+	broker, err := pipeline.NewBroker(cfg)
+	if err != nil {
+		return err
+	}
+	if c, ok := broker.(interface{ Close() }); ok {
+		go func() {
+			<-ctx.Done()
+			c.Close()
+		}()
+	}
+	h.startPipeline(ctx, broker, cfg)
+	log.Info().Str("broker", cfg.Broker).Str("topic", cfg.IngestTopic).Str("group", cfg.Group).Msg("ingest pipeline started")
+	return nil
+}
+
+// startPipeline runs the consumer, which sequences records through each
+// tenant's store, and the relay that drains the store's outbox. Admissions
+// then announce themselves through the outbox in the same transaction as
+// the evidence row, and the committer publishes queued groups instead of
+// assigning leaf indices. The vaults' trees catch up from the store, and
+// records still pending cfg.ReconcileAfter after their event left the
+// outbox are sequenced directly.
+func (h *IngestHandler) startPipeline(ctx context.Context, broker pipeline.Broker, cfg pipeline.Config) {
+	route := pipeline.Appenders{Default: store.DefaultTenant, ByTenant: map[string]*pipeline.Appender{}}
+	publisher := pipeline.NewPublisher(broker, cfg)
+	for id, v := range h.vaults {
+		route.ByTenant[id] = pipeline.NewAppender(v.Leaves())
+		v.UsePipeline(publisher, cfg.IngestTopic)
+	}
+	consumer := pipeline.NewConsumer(broker, cfg, route.Handle)
+	consumer.OnDeadLetter = func(pipeline.Message, error) { middleware.RecordPipelineDeadLetter() }
+	go consumer.Start(ctx)
+	// the outbox is shared by all tenants
+	go pipeline.NewRelay(h.vault.Store(), broker, cfg).Run(ctx)
+	if cfg.ReconcileAfter > 0 {
+		go h.reconcileStranded(ctx, cfg.ReconcileAfter)
+	}
+}
+
+// reconcileStranded sequences stranded records every after/4 until ctx
+// ends.
+func (h *IngestHandler) reconcileStranded(ctx context.Context, after time.Duration) {
+	t := time.NewTicker(after / 4)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-t.C:
+			for id, v := range h.vaults {
+				n, err := v.SequenceStranded(ctx, now.Add(-after))
+				if err != nil {
+					log.Warn().Err(err).Str("tenant", id).Msg("stranded records not sequenced; will retry")
+				} else if n > 0 {
+					log.Warn().Int("records", n).Str("tenant", id).Msg("sequenced records stranded by the pipeline")
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/domain/merkle"
+	"github.com/SaridakisStamatisChristos/vault-api/internal/pipeline"
+	"github.com/SaridakisStamatisChristos/vault-api/service"
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestPipelineSequencesThroughConsumer(t *testing.T) {
+	useTempBlobStore(t)
+	t.Setenv("INGEST_DEDUP_POLICY", service.DedupAllowDuplicate)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	cfg := pipeline.DefaultConfig()
+	cfg.RetryBackoff = time.Millisecond
+	broker := pipeline.NewMemoryBroker(3)
+	h := newTestHandler(t)
+	h.startPipeline(ctx, broker, cfg)
+
+	r := chi.NewRouter()
+	r.Post("/api/v1/evidence:batch", h.IngestBatch)
+	first := doIngest(t, h, "", []byte("one"))
+	_, batch := postBatch(t, r, "application/json", `[{"payload":"`+b64("b1")+`"},{"payload":"`+b64("b2")+`"},{"payload":"`+b64("b3")+`"}]`)
+	second := doIngest(t, h, "", []byte("two"))
+
+	if h.vault.CommitNext(ctx) {
+		t.Fatal("with the pipeline the committer must not assign leaves itself")
+	}
+	// republishing is harmless: the consumer skips sequenced records
+	h.vault.Enqueue(service.Record{ID: first.ID})
+	h.vault.CommitNext(ctx)
+
+	ids := []string{first.ID, second.ID}
+	for _, res := range batch.Results {
+		ids = append(ids, res.ID)
+	}
+	leaves := map[int64]string{}
+	for _, id := range ids {
+		wctx, wcancel := context.WithTimeout(ctx, 2*time.Second)
+		leaf, err := h.vault.WaitForLeaf(wctx, id)
+		wcancel()
+		if err != nil {
+			t.Fatalf("record %s was not sequenced: %v", id, err)
+		}
+		if other, dup := leaves[leaf]; dup {
+			t.Fatalf("leaf %d assigned to %s and %s", leaf, other, id)
+		}
+		leaves[leaf] = id
+	}
+	prev := int64(-1)
+	for _, res := range batch.Results {
+		rec, err := h.vault.Get(ctx, res.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		leaf := *rec.LeafIndex
+		if leaf <= prev {
+			t.Fatalf("batch order not preserved: %d after %d", leaf, prev)
+		}
+		prev = leaf
+		if got := evidenceStatus(&rec); got != "sequenced" {
+			t.Fatalf("expected sequenced status, got %s", got)
+		}
+	}
+	if cp, err := h.vault.LatestCheckpoint(ctx); err != nil || cp.TreeSize != int64(len(ids)) {
+		t.Fatalf("expected a checkpoint over %d leaves, got %+v %v", len(ids), cp, err)
+	}
+}
+
+func TestPipelineRelaysOutboxWrittenBeforeCrash(t *testing.T) {
+	useTempBlobStore(t)
+	t.Setenv("INGEST_DEDUP_POLICY", service.DedupAllowDuplicate)
+	s := store.NewMemoryStore()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	cfg := pipeline.DefaultConfig()
+	cfg.RetryBackoff = time.Millisecond
+
+	// a previous process admitted these and died before anything was published
+	crashed := NewIngestHandler(s, merkle.NewMemoryEngine())
+	crashed.vault.UsePipeline(nil, cfg.IngestTopic)
+	r := chi.NewRouter()
+	r.Post("/api/v1/evidence:batch", crashed.IngestBatch)
+	single := doIngest(t, crashed, "", []byte("one"))
+	_, batch := postBatch(t, r, "application/json", `[{"payload":"`+b64("b1")+`"},{"payload":"`+b64("b2")+`"}]`)
+	if queued := crashed.vault.Pending(); queued != 0 {
+		t.Fatalf("outbox admissions must not be queued in memory, got %d groups", queued)
+	}
+
+	h := NewIngestHandler(s, merkle.NewMemoryEngine())
+	h.startPipeline(ctx, pipeline.NewMemoryBroker(2), cfg)
+
+	ids := []string{single.ID, batch.Results[0].ID, batch.Results[1].ID}
+	for _, id := range ids {
+		wctx, wcancel := context.WithTimeout(ctx, 2*time.Second)
+		_, err := h.vault.WaitForLeaf(wctx, id)
+		wcancel()
+		if err != nil {
+			t.Fatalf("record %s was not relayed and sequenced: %v", id, err)
+		}
+		ev, err := s.GetEvidence(ctx, id)
+		if err != nil || ev.LeafIndex == nil || ev.Status != "sequenced" {
+			t.Fatalf("leaf index not persisted for %s: %+v %v", id, ev, err)
+		}
+	}
+	if cp, err := h.vault.LatestCheckpoint(ctx); err != nil || cp.TreeSize != 3 {
+		t.Fatalf("expected a checkpoint over 3 leaves, got %+v %v", cp, err)
+	}
+	if left, _ := s.ClaimOutbox(ctx, 10, time.Second); len(left) != 0 {
+		t.Fatalf("outbox not drained: %+v", left)
+	}
+}
+
+func TestPipelineContinuesLeafSequenceAfterRestart(t *testing.T) {
+	useTempBlobStore(t)
+	t.Setenv("INGEST_DEDUP_POLICY", service.DedupAllowDuplicate)
+	s := store.NewMemoryStore()
+	cfg := pipeline.DefaultConfig()
+	cfg.RetryBackoff = time.Millisecond
+
+	// a previous process sequenced two records through its pipeline
+	ctx, stop := context.WithCancel(context.Background())
+	before := NewIngestHandler(s, merkle.NewMemoryEngine())
+	before.startPipeline(ctx, pipeline.NewMemoryBroker(2), cfg)
+	var ids []string
+	for _, p := range []string{"one", "two"} {
+		ids = append(ids, doIngest(t, before, "", []byte(p)).ID)
+	}
+	for _, id := range ids {
+		wctx, wcancel := context.WithTimeout(ctx, 2*time.Second)
+		_, err := before.vault.WaitForLeaf(wctx, id)
+		wcancel()
+		if err != nil {
+			t.Fatalf("record %s was not sequenced: %v", id, err)
+		}
+	}
+	stop()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	h := NewIngestHandler(s, merkle.NewMemoryEngine())
+	h.startPipeline(ctx, pipeline.NewMemoryBroker(2), cfg)
+	third := doIngest(t, h, "", []byte("three"))
+	wctx, wcancel := context.WithTimeout(ctx, 2*time.Second)
+	defer wcancel()
+	leaf, err := h.vault.WaitForLeaf(wctx, third.ID)
+	if err != nil || leaf != 2 {
+		t.Fatalf("the restarted pipeline must continue at leaf 2, got %d %v", leaf, err)
+	}
+	if cp, err := h.vault.LatestCheckpoint(ctx); err != nil || cp.TreeSize != 3 {
+		t.Fatalf("expected a checkpoint over 3 leaves, got %+v %v", cp, err)
+	}
+}
+
+func TestPipelineSequencesRecordsStrandedAfterRelay(t *testing.T) {
+	useTempBlobStore(t)
+	t.Setenv("INGEST_DEDUP_POLICY", service.DedupAllowDuplicate)
+	s := store.NewMemoryStore()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	cfg := pipeline.DefaultConfig()
+	cfg.RetryBackoff = time.Millisecond
+	cfg.ReconcileAfter = 40 * time.Millisecond
+
+	// the event was published and acknowledged, then lost before the
+	// consumer appended it
+	crashed := NewIngestHandler(s, merkle.NewMemoryEngine())
+	crashed.vault.UsePipeline(nil, cfg.IngestTopic)
+	rec := doIngest(t, crashed, "", []byte("one"))
+	msgs, err := s.ClaimOutbox(ctx, 10, time.Minute)
+	if err != nil || len(msgs) != 1 || msgs[0].EvidenceID != rec.ID {
+		t.Fatalf("expected the record's outbox message, got %+v %v", msgs, err)
+	}
+	if err := s.MarkOutboxDelivered(ctx, []int64{msgs[0].ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewIngestHandler(s, merkle.NewMemoryEngine())
+	h.startPipeline(ctx, pipeline.NewMemoryBroker(2), cfg)
+	wctx, wcancel := context.WithTimeout(ctx, 2*time.Second)
+	defer wcancel()
+	if leaf, err := h.vault.WaitForLeaf(wctx, rec.ID); err != nil || leaf != 0 {
+		t.Fatalf("stranded record was not sequenced: %d %v", leaf, err)
+	}
+}
@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/internal/promise"
+	"github.com/SaridakisStamatisChristos/vault-api/middleware"
+	"github.com/SaridakisStamatisChristos/vault-api/service"
+	"github.com/rs/zerolog/log"
+)
+
+// promiseFor signs the inclusion promise for rec. The timestamp is the
+// original ingest time, so replays return the same promise.
+func (h *IngestHandler) promiseFor(rec service.Record) *promise.Promise {
+	p := h.promises.Sign(rec.ID, rec.ContentHash, rec.IngestedAt)
+	return &p
+}
+
+// PromiseKey publishes the key that verifies inclusion promises.
+func (h *IngestHandler) PromiseKey(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"algorithm":          "ed25519",
+		"key_id":             h.promises.KeyID(),
+		"public_key":         base64.StdEncoding.EncodeToString(h.promises.PublicKey()),
+		"max_merge_delay_ms": h.promises.MaxMergeDelay().Milliseconds(),
+		"ephemeral":          h.promises.Ephemeral,
+	})
+}
+
+// StartPromiseChecker periodically verifies that every issued promise was
+// honoured by sequencing before its deadline.
+func (h *IngestHandler) StartPromiseChecker(period time.Duration) {
+	go func() {
+		for {
+			time.Sleep(period)
+			h.checkPromises(context.Background(), time.Now())
+		}
+	}()
+}
+
+// checkPromises reports breached promises of every tenant in the metrics;
+// the vaults log and audit them.
+func (h *IngestHandler) checkPromises(ctx context.Context, now time.Time) int {
+	var breached, outstanding int
+	for id, v := range h.vaults {
+		b, o, err := v.CheckPromises(ctx, now, h.promises.MaxMergeDelay())
+		if err != nil {
+			log.Warn().Err(err).Str("tenant", id).Msg("inclusion promise check failed; will retry")
+		}
+		breached += b
+		outstanding += o
+	}
+	middleware.SetPromisesOutstanding(outstanding)
+	if breached > 0 {
+		middleware.RecordPromiseBreaches(breached)
+	}
+	return breached
+}
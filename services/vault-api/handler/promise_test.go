@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/internal/promise"
+	"github.com/SaridakisStamatisChristos/vault-api/service"
+)
+
+func TestIngestReturnsVerifiablePromise(t *testing.T) {
+	useTempBlobStore(t)
+	t.Setenv("INGEST_DEDUP_POLICY", service.DedupReturnExisting)
+	h := newTestHandler(t)
+
+	ingest := func() promise.Promise {
+		body, _ := json.Marshal(map[string]interface{}{"payload": []byte("promised")})
+		rw := httptest.NewRecorder()
+		h.Ingest(rw, httptest.NewRequest(http.MethodPost, "/api/v1/evidence", bytes.NewReader(body)))
+		var got struct {
+			ID      string          `json:"id"`
+			Hash    string          `json:"content_hash"`
+			Promise promise.Promise `json:"promise"`
+		}
+		_ = json.NewDecoder(rw.Body).Decode(&got)
+		if got.Promise.EvidenceID != got.ID || got.Promise.ContentHash != got.Hash {
+			t.Fatalf("promise does not bind the record: %+v", got)
+		}
+		return got.Promise
+	}
+	first := ingest()
+	if !promise.Verify(h.promises.PublicKey(), first) {
+		t.Fatalf("promise signature does not verify")
+	}
+	if first.MaxMergeDelay != promise.DefaultMaxMergeDelay.Milliseconds() {
+		t.Fatalf("unexpected merge delay %d", first.MaxMergeDelay)
+	}
+	if replay := ingest(); replay.Signature != first.Signature {
+		t.Fatalf("replayed ingest should return the original promise")
+	}
+}
+
+func TestCheckPromisesReportsBreaches(t *testing.T) {
+	useTempBlobStore(t)
+	t.Setenv("INGEST_DEDUP_POLICY", service.DedupAllowDuplicate)
+	h := newTestHandler(t)
+
+	honoured := doIngest(t, h, "", []byte("on time"))
+	h.vault.CommitNext(context.Background())
+	late := doIngest(t, h, "", []byte("late"))
+
+	future := time.Now().Add(promise.DefaultMaxMergeDelay + time.Minute)
+	if n := h.checkPromises(context.Background(), time.Now()); n != 0 {
+		t.Fatalf("no promise is due yet, got %d breaches", n)
+	}
+	if n := h.checkPromises(context.Background(), future); n != 1 {
+		t.Fatalf("expected 1 breach got %d", n)
+	}
+	audits, err := h.vault.Audits(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var breachAudit bool
+	for _, a := range audits {
+		if a.Action == "promise_breached" {
+			breachAudit = a.ResourceID == late.ID
+		}
+		if a.Action == "promise_breached" && a.ResourceID == honoured.ID {
+			t.Fatalf("sequenced record must not be reported")
+		}
+	}
+	if !breachAudit {
+		t.Fatalf("breach should be audited")
+	}
+	if n := h.checkPromises(context.Background(), future); n != 0 {
+		t.Fatalf("breach must be reported only once, got %d", n)
+	}
+}
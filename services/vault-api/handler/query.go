@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/domain/evidence"
+	"github.com/SaridakisStamatisChristos/vault-api/middleware"
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultSearchLimit = 100
+	maxSearchLimit     = 1000
+)
+
+type searchItem struct {
+	ID          string            `json:"id"`
+	ContentHash string            `json:"content_hash"`
+	ContentType string            `json:"content_type,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	IngestedAt  time.Time         `json:"ingested_at"`
+	LeafIndex   *int64            `json:"leaf_index"`
+	Status      string            `json:"status"`
+}
+
+// SearchEvidence lists evidence matching all label=key:value selectors and
+// the optional ingested_after/ingested_before bounds (RFC 3339), ordered by
+// ingestion time. next_cursor is set when more results may follow. Records
+// the access policy hides are dropped, so a page may hold fewer than limit
+// items while more follow; one access_denied entry per page records them.
+func (h *IngestHandler) SearchEvidence(w http.ResponseWriter, r *http.Request) {
+	q, err := parseSearchQuery(r)
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_query", err.Error())
+		return
+	}
+
+	found, err := h.vaultFor(r.Context()).Query(r.Context(), q)
+	if err != nil {
+		log.Error().Err(err).Msg("query evidence")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	items := make([]searchItem, 0, len(found))
+	for i := range found {
+		rec := &found[i]
+		if !h.permits(r.Context(), middleware.PermEvidenceRead, rec) {
+			continue
+		}
+		items = append(items, searchItem{
+			ID:          rec.ID,
+			ContentHash: rec.ContentHash,
+			ContentType: rec.ContentType,
+			Labels:      rec.Labels,
+			IngestedAt:  rec.IngestedAt,
+			LeafIndex:   rec.LeafIndex,
+			Status:      evidenceStatus(rec),
+		})
+	}
+	h.auditHidden(r.Context(), middleware.PermEvidenceRead, r.URL.Path, len(found)-len(items))
+	resp := map[string]interface{}{"items": items}
+	if len(found) == q.Limit {
+		last := found[len(found)-1]
+		resp["next_cursor"] = encodeCursor(last.IngestedAt, last.ID)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func parseSearchQuery(r *http.Request) (store.EvidenceQuery, error) {
+	params := r.URL.Query()
+	q := store.EvidenceQuery{Limit: defaultSearchLimit}
+	labels, err := evidence.ParseLabelSelectors(params["label"])
+	if err != nil {
+		return q, err
+	}
+	if len(labels) > 0 {
+		q.Labels = labels
+	}
+	if v := params.Get("ingested_after"); v != "" {
+		if q.IngestedAfter, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			return q, errors.New("ingested_after must be RFC 3339")
+		}
+	}
+	if v := params.Get("ingested_before"); v != "" {
+		if q.IngestedBefore, err = time.Parse(time.RFC3339Nano, v); err != nil {
+			return q, errors.New("ingested_before must be RFC 3339")
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 || n > maxSearchLimit {
+			return q, errors.New("limit must be between 1 and " + strconv.Itoa(maxSearchLimit))
+		}
+		q.Limit = n
+	}
+	if v := params.Get("cursor"); v != "" {
+		if q.AfterTime, q.AfterID, err = decodeCursor(v); err != nil {
+			return q, err
+		}
+	}
+	return q, nil
+}
+
+// Cursors are opaque to clients: base64url("<unix nanos>|<id>") of the last
+// item returned.
+func encodeCursor(t time.Time, id string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(t.UnixNano(), 10) + "|" + id))
+}
+
+func decodeCursor(s string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return time.Time{}, "", errors.New("invalid cursor")
+	}
+	ts, id, ok := strings.Cut(string(raw), "|")
+	nanos, err := strconv.ParseInt(ts, 10, 64)
+	if !ok || err != nil || id == "" {
+		return time.Time{}, "", errors.New("invalid cursor")
+	}
+	return time.Unix(0, nanos).UTC(), id, nil
+}
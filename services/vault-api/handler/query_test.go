@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type searchResponse struct {
+	Items      []searchItem `json:"items"`
+	NextCursor string       `json:"next_cursor"`
+}
+
+func ingestLabelled(t *testing.T, h *IngestHandler, payload string, labels map[string]string) string {
+	t.Helper()
+	body, _ := json.Marshal(map[string]interface{}{"content_type": "text/plain", "payload": []byte(payload), "labels": labels})
+	rw := httptest.NewRecorder()
+	h.Ingest(rw, httptest.NewRequest(http.MethodPost, "/api/v1/evidence", bytes.NewReader(body)))
+	if rw.Code != http.StatusAccepted {
+		t.Fatalf("ingest %q: got %d", payload, rw.Code)
+	}
+	var got ingestResult
+	_ = json.NewDecoder(rw.Body).Decode(&got)
+	return got.ID
+}
+
+func search(t *testing.T, r http.Handler, query url.Values) (int, searchResponse) {
+	t.Helper()
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/api/v1/evidence?"+query.Encode(), nil))
+	var got searchResponse
+	_ = json.NewDecoder(rw.Body).Decode(&got)
+	return rw.Code, got
+}
+
+func TestSearchEvidenceByLabelWithCursor(t *testing.T) {
+	useTempBlobStore(t)
+	h := newTestHandler(t)
+	r := chi.NewRouter()
+	r.Get("/api/v1/evidence", h.SearchEvidence)
+
+	want := []string{
+		ingestLabelled(t, h, "a", map[string]string{"case": "1234", "source": "fw"}),
+		ingestLabelled(t, h, "b", map[string]string{"case": "1234"}),
+		ingestLabelled(t, h, "c", map[string]string{"case": "1234", "source": "fw"}),
+	}
+	ingestLabelled(t, h, "d", map[string]string{"case": "9999", "source": "fw"})
+
+	var seen []string
+	cursor := ""
+	for page := 0; page < 4; page++ {
+		q := url.Values{"label": {"case:1234"}, "limit": {"2"}}
+		if cursor != "" {
+			q.Set("cursor", cursor)
+		}
+		code, got := search(t, r, q)
+		if code != http.StatusOK {
+			t.Fatalf("search: got %d", code)
+		}
+		for _, it := range got.Items {
+			if it.Labels["case"] != "1234" || len(it.ContentHash) != 64 || it.Status != "stored" {
+				t.Fatalf("unexpected item %+v", it)
+			}
+			seen = append(seen, it.ID)
+		}
+		if cursor = got.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if len(seen) != len(want) {
+		t.Fatalf("expected %d results across pages, got %v", len(want), seen)
+	}
+	for i := range want {
+		if seen[i] != want[i] {
+			t.Fatalf("results out of ingest order: got %v want %v", seen, want)
+		}
+	}
+
+	_, got := search(t, r, url.Values{"label": {"case:1234", "source:fw"}})
+	if len(got.Items) != 2 || got.NextCursor != "" {
+		t.Fatalf("expected 2 results for ANDed selectors, got %+v", got)
+	}
+}
+
+func TestSearchEvidenceRejectsBadParams(t *testing.T) {
+	h := newTestHandler(t)
+	r := chi.NewRouter()
+	r.Get("/api/v1/evidence", h.SearchEvidence)
+
+	for _, q := range []url.Values{
+		{"label": {"case"}},
+		{"ingested_after": {"yesterday"}},
+		{"limit": {"0"}},
+		{"cursor": {"!!"}},
+	} {
+		if code, _ := search(t, r, q); code != http.StatusBadRequest {
+			t.Fatalf("%v: expected 400 got %d", q, code)
+		}
+	}
+}
+
+func TestIngestRejectsInvalidLabels(t *testing.T) {
+	useTempBlobStore(t)
+	h := newTestHandler(t)
+	body, _ := json.Marshal(map[string]interface{}{"payload": []byte("x"), "labels": map[string]string{"Bad Key": "1"}})
+	rw := httptest.NewRecorder()
+	h.Ingest(rw, httptest.NewRequest(http.MethodPost, "/api/v1/evidence", bytes.NewReader(body)))
+	if rw.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 got %d", rw.Code)
+	}
+}
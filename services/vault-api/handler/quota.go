@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/SaridakisStamatisChristos/vault-api/middleware"
+	"github.com/SaridakisStamatisChristos/vault-api/service"
+	"github.com/rs/zerolog/log"
+)
+
+// GetMyQuotas reports the caller's tenant and subject usage against their
+// ingest quotas. Zero limits are unlimited.
+func (h *IngestHandler) GetMyQuotas(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	sub := middleware.SubjectFromContext(ctx)
+	tenantUsage, subjectUsage, err := h.vaultFor(ctx).QuotaStatus(ctx, sub)
+	if err != nil {
+		log.Error().Err(err).Msg("read quota usage")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"tenant":  tenantOf(ctx),
+		"subject": sub,
+		"quotas":  map[string]interface{}{"tenant": tenantUsage, "subject": subjectUsage},
+	})
+}
+
+// overQuota answers the request and reports true when admitting size more
+// bytes for actor would exceed a quota or usage cannot be read.
+func (h *IngestHandler) overQuota(w http.ResponseWriter, r *http.Request, actor string, size int64) bool {
+	qv, err := h.vaultFor(r.Context()).CheckQuota(r.Context(), actor, size)
+	if err != nil {
+		log.Error().Err(err).Msg("read quota usage")
+		w.WriteHeader(http.StatusInternalServerError)
+		return true
+	}
+	if qv == nil {
+		return false
+	}
+	writeAdmission(w, admission{Admission: service.Admission{Conflict: service.ConflictQuotaExceeded, Quota: qv}})
+	return true
+}
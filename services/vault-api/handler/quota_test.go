@@ -0,0 +1,206 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/SaridakisStamatisChristos/vault-api/blob"
+	"github.com/SaridakisStamatisChristos/vault-api/middleware"
+	"github.com/SaridakisStamatisChristos/vault-api/service"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestIngestQuotas(t *testing.T) {
+	t.Setenv("ENABLE_TEST_JWT", "true")
+	useTempBlobStore(t)
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	cfg := `{"tenants":[{"id":"acme","max_evidence":3,"subject_quota":{"max_bytes_per_day":10}}]}`
+	if err := os.WriteFile(path, []byte(cfg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TENANTS_FILE", path)
+	h := newTestHandler(t)
+
+	r := chi.NewRouter()
+	r.Route("/api/v1/tenants/{tenant}", func(r chi.Router) {
+		r.Use(middleware.JWT, h.ResolveTenant)
+		r.Post("/evidence", h.Ingest)
+		r.Get("/quotas/me", h.GetMyQuotas)
+	})
+	token := tenantToken(t, "alice", "acme")
+	do := func(method, path string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw
+	}
+	ingest := func(payload string) *httptest.ResponseRecorder {
+		body, _ := json.Marshal(map[string]interface{}{"content_type": "text/plain", "payload": []byte(payload)})
+		return do(http.MethodPost, "/api/v1/tenants/acme/evidence", body)
+	}
+
+	if rw := ingest("12345678"); rw.Code != http.StatusAccepted {
+		t.Fatalf("first ingest: %d %s", rw.Code, rw.Body.String())
+	}
+	rw := ingest("123")
+	var refused struct {
+		Error  string                 `json:"error"`
+		Detail string                 `json:"detail"`
+		Quota  service.QuotaViolation `json:"quota"`
+	}
+	if err := json.NewDecoder(rw.Body).Decode(&refused); err != nil || rw.Code != http.StatusForbidden || refused.Error != "quota_exceeded" {
+		t.Fatalf("over quota: %d %+v %v", rw.Code, refused, err)
+	}
+	if refused.Quota != (service.QuotaViolation{Scope: "subject", Quota: "max_bytes_per_day", Limit: 10, Used: 8}) || !strings.Contains(refused.Detail, "max_bytes_per_day") {
+		t.Fatalf("refusal does not name the quota: %+v", refused)
+	}
+
+	rw = do(http.MethodGet, "/api/v1/tenants/acme/quotas/me", nil)
+	var got struct {
+		Tenant  string `json:"tenant"`
+		Subject string `json:"subject"`
+		Quotas  struct {
+			Tenant  service.QuotaUsage `json:"tenant"`
+			Subject service.QuotaUsage `json:"subject"`
+		} `json:"quotas"`
+	}
+	if err := json.NewDecoder(rw.Body).Decode(&got); err != nil || rw.Code != http.StatusOK {
+		t.Fatalf("quotas: %d %v", rw.Code, err)
+	}
+	if got.Tenant != "acme" || got.Subject != "alice" || got.Quotas.Tenant.Quota.MaxEvidence != 3 || got.Quotas.Tenant.Usage.Evidence != 1 {
+		t.Fatalf("tenant quota: %+v", got)
+	}
+	if got.Quotas.Subject.Quota.MaxBytesPerDay != 10 || got.Quotas.Subject.Usage != (service.Usage{Evidence: 1, StorageBytes: 8, BytesToday: 8}) {
+		t.Fatalf("subject quota: %+v", got.Quotas.Subject)
+	}
+
+	metrics := httptest.NewRecorder()
+	middleware.MetricsHandler().ServeHTTP(metrics, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	for _, want := range []string{
+		`vault_api_quota_exceeded_total{tenant="acme",scope="subject",quota="max_bytes_per_day"} 1`,
+		`vault_api_tenant_usage{tenant="acme",resource="storage_bytes"} 8`,
+	} {
+		if !strings.Contains(metrics.Body.String(), want) {
+			t.Fatalf("metrics lack %s:\n%s", want, metrics.Body.String())
+		}
+	}
+}
+
+func TestUploadQuotasRefuseBeforeStoring(t *testing.T) {
+	t.Setenv("ENABLE_TEST_JWT", "true")
+	bs := useTempBlobStore(t)
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	cfg := `{"tenants":[{"id":"acme","subject_quota":{"max_bytes_per_day":10}}]}`
+	if err := os.WriteFile(path, []byte(cfg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TENANTS_FILE", path)
+	h := newTestHandler(t)
+
+	r := chi.NewRouter()
+	r.Route("/api/v1/tenants/{tenant}", func(r chi.Router) {
+		r.Use(middleware.JWT, h.ResolveTenant)
+		r.Post("/evidence/upload", h.Upload)
+		r.Post("/uploads", h.CreateUpload)
+		r.Patch("/uploads/{id}", h.AppendUpload)
+		r.Post("/uploads/{id}/complete", h.CompleteUpload)
+	})
+	token := tenantToken(t, "alice", "acme")
+	do := func(method, path string, body []byte, length int64) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.ContentLength = length
+		req.Header.Set("Authorization", "Bearer "+token)
+		req.Header.Set("Content-Type", "text/plain")
+		req.Header.Set(uploadOffsetHeader, "0")
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw
+	}
+	payload := []byte("0123456789a")
+	committed := func() bool {
+		t.Helper()
+		f, err := bs.Open(context.Background(), sha256Hex(payload))
+		if err == nil {
+			f.Close()
+		}
+		return err == nil
+	}
+
+	// declared by Content-Length, then discovered while streaming
+	for _, length := range []int64{int64(len(payload)), -1} {
+		if rw := do(http.MethodPost, "/api/v1/tenants/acme/evidence/upload", payload, length); rw.Code != http.StatusForbidden || !strings.Contains(rw.Body.String(), "max_bytes_per_day") {
+			t.Fatalf("upload with length %d: %d %s", length, rw.Code, rw.Body.String())
+		}
+		if committed() {
+			t.Fatalf("refused upload with length %d was committed", length)
+		}
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{"size": len(payload)})
+	if rw := do(http.MethodPost, "/api/v1/tenants/acme/uploads", body, int64(len(body))); rw.Code != http.StatusForbidden {
+		t.Fatalf("declared size over quota: %d", rw.Code)
+	}
+
+	rw := do(http.MethodPost, "/api/v1/tenants/acme/uploads", nil, 0)
+	var created struct {
+		UploadID string `json:"upload_id"`
+	}
+	if err := json.NewDecoder(rw.Body).Decode(&created); err != nil || rw.Code != http.StatusCreated {
+		t.Fatalf("create upload: %d %v", rw.Code, err)
+	}
+	session := "/api/v1/tenants/acme/uploads/" + created.UploadID
+	if rw := do(http.MethodPatch, session, payload, -1); rw.Code != http.StatusNoContent {
+		t.Fatalf("append: %d", rw.Code)
+	}
+	if rw := do(http.MethodPost, session+"/complete", nil, 0); rw.Code != http.StatusForbidden {
+		t.Fatalf("complete over quota: %d %s", rw.Code, rw.Body.String())
+	}
+	if _, err := bs.Size(context.Background(), created.UploadID); !errors.Is(err, blob.ErrNotFound) {
+		t.Fatalf("refused upload must abort its staged blob, got %v", err)
+	}
+	if committed() {
+		t.Fatal("refused upload was committed")
+	}
+}
+
+func TestBatchQuotasRefuseBeforeStoring(t *testing.T) {
+	t.Setenv("ENABLE_TEST_JWT", "true")
+	bs := useTempBlobStore(t)
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	cfg := `{"tenants":[{"id":"acme","subject_quota":{"max_bytes_per_day":10}}]}`
+	if err := os.WriteFile(path, []byte(cfg), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TENANTS_FILE", path)
+	h := newTestHandler(t)
+	r := chi.NewRouter()
+	r.With(middleware.JWT, h.ResolveTenant).Post("/api/v1/tenants/{tenant}/evidence:batch", h.IngestBatch)
+
+	over := "0123456789a"
+	body := `[{"payload":"` + b64("12345678") + `"},{"payload":"` + b64(over) + `"},{"payload":"` + b64("12") + `"}]`
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/tenants/acme/evidence:batch", strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+tenantToken(t, "alice", "acme"))
+	req.Header.Set("Content-Type", "application/json")
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, req)
+	var got batchResponse
+	if err := json.NewDecoder(rw.Body).Decode(&got); err != nil || rw.Code != http.StatusMultiStatus {
+		t.Fatalf("batch: %d %v", rw.Code, err)
+	}
+	if got.Accepted != 2 || got.Results[1].Error != service.ConflictQuotaExceeded || got.Results[1].Quota == nil || got.Results[1].Quota.Used != 8 {
+		t.Fatalf("over-quota item not refused: %+v", got.Results)
+	}
+	if f, err := bs.Open(context.Background(), sha256Hex([]byte(over))); err == nil {
+		f.Close()
+		t.Fatal("refused batch item was stored")
+	}
+}
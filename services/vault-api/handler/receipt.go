@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/domain/evidence"
+	"github.com/rs/zerolog/log"
+)
+
+// waitConfig bounds how long ?wait=inclusion requests may block.
+type waitConfig struct {
+	Default time.Duration
+	Max     time.Duration
+}
+
+func loadWaitConfig() (waitConfig, error) {
+	cfg := waitConfig{Default: 10 * time.Second, Max: 30 * time.Second}
+	for _, v := range []struct {
+		name string
+		dst  *time.Duration
+	}{
+		{"INGEST_WAIT_TIMEOUT", &cfg.Default},
+		{"INGEST_WAIT_MAX_TIMEOUT", &cfg.Max},
+	} {
+		raw := strings.TrimSpace(os.Getenv(v.name))
+		if raw == "" {
+			continue
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid %s %q", v.name, raw)
+		}
+		*v.dst = d
+	}
+	if cfg.Default > cfg.Max {
+		cfg.Default = cfg.Max
+	}
+	return cfg, nil
+}
+
+// waitParams parses ?wait= and ?timeout=. Ingest handlers call it before
+// admitting anything so a malformed request does not create a record.
+func (h *IngestHandler) waitParams(r *http.Request) (bool, time.Duration, error) {
+	q := r.URL.Query()
+	switch q.Get("wait") {
+	case "":
+		return false, 0, nil
+	case "inclusion":
+	default:
+		return false, 0, errors.New(`wait must be "inclusion"`)
+	}
+	timeout := h.wait.Default
+	if raw := q.Get("timeout"); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return false, 0, errors.New("timeout must be a positive duration such as 5s")
+		}
+		timeout = d
+	}
+	if timeout > h.wait.Max {
+		timeout = h.wait.Max
+	}
+	return true, timeout, nil
+}
+
+// writeReceipt waits until the admitted record is sequenced and covered by a
+// signed checkpoint, then returns the leaf index, inclusion proof and
+// checkpoint together. If the timeout expires first the usual pending
+// response is sent and the client can fall back to polling.
+func (h *IngestHandler) writeReceipt(w http.ResponseWriter, r *http.Request, adm admission) {
+	_, timeout, _ := h.waitParams(r)
+	if adm.Conflict != "" {
+		writeAdmission(w, adm)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	leaf, err := h.vaultFor(ctx).WaitForLeaf(ctx, adm.Record.ID)
+	if err != nil {
+		w.Header().Set("Retry-After", "1")
+		writeAdmission(w, adm)
+		return
+	}
+	proof, cp, err := h.vaultFor(r.Context()).Receipt(r.Context(), leaf)
+	if err != nil {
+		log.Error().Err(err).Str("evidence_id", adm.Record.ID).Msg("build inclusion receipt")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	status := string(evidence.StatusCheckpointed)
+	if rec, err := h.vaultFor(r.Context()).Get(r.Context(), adm.Record.ID); err == nil {
+		status = evidenceStatus(&rec)
+	}
+
+	if adm.Replayed {
+		w.Header().Set("Idempotent-Replayed", "true")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":              adm.Record.ID,
+		"content_hash":    adm.Record.ContentHash,
+		"status":          status,
+		"leaf_index":      leaf,
+		"inclusion_proof": proof,
+		"checkpoint":      cp,
+		"promise":         adm.Promise,
+	})
+}
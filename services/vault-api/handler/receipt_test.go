@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/service"
+)
+
+func ingestWaiting(h *IngestHandler, query string, payload string) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]interface{}{"content_type": "text/plain", "payload": []byte(payload)})
+	rw := httptest.NewRecorder()
+	h.Ingest(rw, httptest.NewRequest(http.MethodPost, "/api/v1/evidence?"+query, bytes.NewReader(body)))
+	return rw
+}
+
+func TestIngestWaitForInclusionReturnsReceipt(t *testing.T) {
+	useTempBlobStore(t)
+	h := newTestHandler(t)
+
+	done := make(chan *httptest.ResponseRecorder)
+	go func() { done <- ingestWaiting(h, "wait=inclusion&timeout=5s", "receipt") }()
+
+	deadline := time.Now().Add(5 * time.Second)
+	var rw *httptest.ResponseRecorder
+	for rw == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("ingest did not return after sequencing")
+		}
+		h.vault.CommitNext(context.Background())
+		select {
+		case rw = <-done:
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if rw.Code != http.StatusOK {
+		t.Fatalf("expected 200 got %d: %s", rw.Code, rw.Body.String())
+	}
+	var got struct {
+		Status         string `json:"status"`
+		LeafIndex      *int64 `json:"leaf_index"`
+		InclusionProof struct {
+			LeafIndex int64  `json:"leaf_index"`
+			TreeSize  int64  `json:"tree_size"`
+			Root      string `json:"root"`
+		} `json:"inclusion_proof"`
+		Checkpoint service.Checkpoint `json:"checkpoint"`
+	}
+	if err := json.NewDecoder(rw.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Status != "checkpointed" || got.LeafIndex == nil || got.InclusionProof.LeafIndex != *got.LeafIndex {
+		t.Fatalf("unexpected receipt %+v", got)
+	}
+	if got.Checkpoint.TreeSize <= *got.LeafIndex || got.Checkpoint.Signature == "" || got.Checkpoint.RootHash != got.InclusionProof.Root {
+		t.Fatalf("checkpoint does not cover the leaf: %+v", got)
+	}
+}
+
+func TestIngestWaitForInclusionTimesOut(t *testing.T) {
+	useTempBlobStore(t)
+	h := newTestHandler(t)
+
+	rw := ingestWaiting(h, "wait=inclusion&timeout=20ms", "slow")
+	if rw.Code != http.StatusAccepted || rw.Header().Get("Retry-After") == "" {
+		t.Fatalf("expected 202 with Retry-After after timeout, got %d", rw.Code)
+	}
+	if rw := ingestWaiting(h, "wait=forever", "bad"); rw.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown wait mode got %d", rw.Code)
+	}
+}
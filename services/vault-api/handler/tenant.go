@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/SaridakisStamatisChristos/vault-api/domain/merkle"
+	"github.com/SaridakisStamatisChristos/vault-api/internal/tenant"
+	"github.com/SaridakisStamatisChristos/vault-api/internal/webhook"
+	"github.com/SaridakisStamatisChristos/vault-api/middleware"
+	"github.com/SaridakisStamatisChristos/vault-api/service"
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// loadTenants reads TENANTS_FILE. A file that fails to load leaves the
+// vault single-tenant; ValidateIngestConfig refuses to start with it.
+func loadTenants() *tenant.Config {
+	cfg, err := tenant.LoadFromEnv()
+	if err != nil {
+		log.Error().Err(err).Msg("invalid tenants configuration; serving the default tenant only")
+		return tenant.Single()
+	}
+	return cfg
+}
+
+// newTenantVaults opens a vault per configured tenant over its view of s,
+// and a webhook dispatcher over the same view. The default tenant's tree is
+// engine; every other tenant gets its own.
+func newTenantVaults(s store.Store, engine merkle.Engine, cfg *tenant.Config, hooks webhook.Config) (map[string]*service.Vault, map[string]*webhook.Dispatcher, error) {
+	vaults := make(map[string]*service.Vault, len(cfg.Tenants))
+	dispatchers := make(map[string]*webhook.Dispatcher, len(cfg.Tenants))
+	for _, t := range cfg.Tenants {
+		ts, e := s, engine
+		if t.ID != store.DefaultTenant {
+			var err error
+			if ts, err = s.ForTenant(t.ID); err != nil {
+				return nil, nil, err
+			}
+			e = merkle.NewMemoryEngine()
+		}
+		dispatchers[t.ID] = webhook.NewDispatcher(hooks, ts)
+		vaults[t.ID] = service.New(ts, e, service.Config{
+			Observer: vaultObserver{tenant: t.ID, webhooks: dispatchers[t.ID]},
+			Signer:   checkpointSigner{url: t.CheckpointSigningURL, origin: t.Origin},
+			Tenant:   t.ID,
+			Origin:   t.Origin,
+			Quotas:   t.Quotas(),
+		})
+	}
+	return vaults, dispatchers, nil
+}
+
+// vaultFor returns the vault of the tenant the request acts for. Requests
+// that did not pass ResolveTenant act for the default tenant.
+func (h *IngestHandler) vaultFor(ctx context.Context) *service.Vault {
+	if v, ok := h.vaults[middleware.TenantFromContext(ctx)]; ok {
+		return v
+	}
+	return h.vault
+}
+
+// webhooksFor returns the webhook dispatcher of the tenant the request
+// acts for.
+func (h *IngestHandler) webhooksFor(ctx context.Context) *webhook.Dispatcher {
+	if d, ok := h.webhooks[middleware.TenantFromContext(ctx)]; ok {
+		return d
+	}
+	return h.webhooks[store.DefaultTenant]
+}
+
+// tenantOf returns the tenant the request acts for.
+func tenantOf(ctx context.Context) string {
+	if t := middleware.TenantFromContext(ctx); t != "" {
+		return t
+	}
+	return store.DefaultTenant
+}
+
+// ResolveTenant selects the tenant a request acts for from the {tenant}
+// route parameter and the caller's tenant claim. It runs after JWT and
+// before the route's permission check. Tenants the caller does not belong
+// to are reported as missing so their existence does not leak.
+func (h *IngestHandler) ResolveTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, err := h.tenants.Resolve(middleware.ClaimsFromContext(r.Context()), chi.URLParam(r, "tenant"))
+		switch {
+		case errors.Is(err, tenant.ErrUnknownTenant), errors.Is(err, tenant.ErrNotMember):
+			writeJSONError(w, http.StatusNotFound, "unknown_tenant", "no such tenant")
+			return
+		case errors.Is(err, tenant.ErrAmbiguous):
+			writeJSONError(w, http.StatusBadRequest, "tenant_required", err.Error())
+			return
+		case err != nil:
+			writeJSONError(w, http.StatusForbidden, "tenant_required", err.Error())
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(middleware.WithTenant(r.Context(), id)))
+	})
+}
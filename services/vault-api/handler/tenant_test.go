@@ -0,0 +1,129 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/SaridakisStamatisChristos/vault-api/middleware"
+	"github.com/go-chi/chi/v5"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func tenantToken(t *testing.T, sub string, tenants ...string) string {
+	t.Helper()
+	tok, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{"sub": sub, "tenant": tenants}).SignedString([]byte("dev"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+func TestTenantsHaveIsolatedLogs(t *testing.T) {
+	pub, _, signer := setupCheckpointSigner(t)
+	defer signer.Close()
+	t.Setenv("ENABLE_TEST_JWT", "true")
+	useTempBlobStore(t)
+	path := filepath.Join(t.TempDir(), "tenants.json")
+	cfg, _ := json.Marshal(map[string]interface{}{"tenants": []map[string]interface{}{
+		{"id": "acme", "origin": "acme.example/log", "max_evidence": 1, "checkpoint_signing_url": signer.URL, "checkpoint_verify_public_key_b64": base64.StdEncoding.EncodeToString(pub)},
+		{"id": "globex"},
+	}})
+	if err := os.WriteFile(path, cfg, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TENANTS_FILE", path)
+	h := newTestHandler(t)
+
+	routes := func(r chi.Router) {
+		r.Use(h.ResolveTenant)
+		r.Post("/evidence", h.Ingest)
+		r.Get("/evidence/{id}", h.GetEvidence)
+		r.Get("/checkpoints/latest", h.GetCheckpointsLatest)
+		r.Get("/checkpoints/latest/verify", h.VerifyLatestCheckpoint)
+	}
+	r := chi.NewRouter()
+	r.Route("/api/v1", func(r chi.Router) {
+		r.Use(middleware.JWT)
+		r.Group(routes)
+		r.Route("/tenants/{tenant}", routes)
+	})
+	do := func(method, path, token string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, bytes.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw
+	}
+	ingest := func(path, token, payload string) (int, string) {
+		body, _ := json.Marshal(map[string]interface{}{"content_type": "text/plain", "payload": []byte(payload)})
+		rw := do(http.MethodPost, path, token, body)
+		var got struct {
+			ID    string `json:"id"`
+			Error string `json:"error"`
+		}
+		_ = json.NewDecoder(rw.Body).Decode(&got)
+		if got.Error != "" {
+			return rw.Code, got.Error
+		}
+		return rw.Code, got.ID
+	}
+	both := tenantToken(t, "ops", "acme", "globex")
+	acmeOnly := tenantToken(t, "acme-user", "acme")
+
+	code, acmeID := ingest("/api/v1/tenants/acme/evidence", both, "same content")
+	if code != http.StatusAccepted {
+		t.Fatalf("acme ingest: %d", code)
+	}
+	// the same content is new to another tenant
+	code, globexID := ingest("/api/v1/tenants/globex/evidence", both, "same content")
+	if code != http.StatusAccepted || globexID == acmeID {
+		t.Fatalf("globex ingest: %d %s", code, globexID)
+	}
+	// a single-tenant caller needs no path prefix
+	if code, got := ingest("/api/v1/evidence", acmeOnly, "second"); code != http.StatusForbidden || got != "quota_exceeded" {
+		t.Fatalf("acme over quota: %d %s", code, got)
+	}
+	if code, got := ingest("/api/v1/evidence", both, "which tenant"); code != http.StatusBadRequest || got != "tenant_required" {
+		t.Fatalf("ambiguous tenant: %d %s", code, got)
+	}
+	if rw := do(http.MethodGet, "/api/v1/tenants/globex/evidence/"+globexID, acmeOnly, nil); rw.Code != http.StatusNotFound {
+		t.Fatalf("foreign tenant: expected 404 got %d", rw.Code)
+	}
+	if rw := do(http.MethodGet, "/api/v1/tenants/globex/evidence/"+acmeID, both, nil); rw.Code != http.StatusNotFound {
+		t.Fatalf("acme evidence under globex: expected 404 got %d", rw.Code)
+	}
+	if rw := do(http.MethodGet, "/api/v1/tenants/acme/evidence/"+acmeID, both, nil); rw.Code != http.StatusOK {
+		t.Fatalf("acme evidence: expected 200 got %d", rw.Code)
+	}
+	if n, _ := h.vault.Store().CountEvidence(context.Background()); n != 0 {
+		t.Fatalf("default tenant holds %d records", n)
+	}
+
+	for _, id := range []string{"acme", "globex"} {
+		h.vaults[id].CommitNext(context.Background())
+	}
+	for tenant, origin := range map[string]string{"acme": "acme.example/log", "globex": "globex"} {
+		rw := do(http.MethodGet, "/api/v1/tenants/"+tenant+"/checkpoints/latest", both, nil)
+		var cp struct {
+			Origin   string `json:"origin"`
+			TreeSize int64  `json:"tree_size"`
+		}
+		if err := json.NewDecoder(rw.Body).Decode(&cp); err != nil || cp.TreeSize != 1 || cp.Origin != origin {
+			t.Fatalf("%s checkpoint: %d %+v %v", tenant, rw.Code, cp, err)
+		}
+	}
+	rw := do(http.MethodGet, "/api/v1/tenants/acme/checkpoints/latest/verify", both, nil)
+	var verdict struct {
+		Verified bool `json:"verified"`
+	}
+	if err := json.NewDecoder(rw.Body).Decode(&verdict); err != nil || !verdict.Verified {
+		t.Fatalf("acme checkpoint not verified with its key: %d %v", rw.Code, err)
+	}
+}
@@ -0,0 +1,625 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/blob"
+	"github.com/SaridakisStamatisChristos/vault-api/domain/evidence"
+	"github.com/SaridakisStamatisChristos/vault-api/middleware"
+	"github.com/SaridakisStamatisChristos/vault-api/service"
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultContentType = "application/octet-stream"
+	uploadOffsetHeader = "Upload-Offset"
+	uploadLengthHeader = "Upload-Length"
+)
+
+var (
+	errPayloadTooLarge = errors.New("payload exceeds configured size limit")
+	// errPayloadRefused reports a payload whose final size was refused; the
+	// response was already written.
+	errPayloadRefused = errors.New("payload refused")
+)
+
+type uploadConfig struct {
+	// MaxUploadBytes caps streamed and resumable payloads.
+	MaxUploadBytes int64
+	// MaxJSONBytes caps the body of the base64 JSON ingest.
+	MaxJSONBytes int64
+	// SessionTTL expires resumable uploads that are not completed.
+	SessionTTL time.Duration
+}
+
+// uploadClaimLease is how long an append holds its upload session without
+// renewing the claim; a replica that dies mid-append releases the session
+// after at most this long.
+const uploadClaimLease = time.Minute
+
+func loadUploadConfig() (uploadConfig, error) {
+	cfg := uploadConfig{MaxUploadBytes: 16 << 30, MaxJSONBytes: 32 << 20, SessionTTL: 24 * time.Hour}
+	for _, v := range []struct {
+		name string
+		dst  *int64
+	}{
+		{"INGEST_MAX_UPLOAD_BYTES", &cfg.MaxUploadBytes},
+		{"INGEST_MAX_JSON_BYTES", &cfg.MaxJSONBytes},
+	} {
+		raw := strings.TrimSpace(os.Getenv(v.name))
+		if raw == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("invalid %s %q", v.name, raw)
+		}
+		*v.dst = n
+	}
+	if raw := strings.TrimSpace(os.Getenv("UPLOAD_SESSION_TTL")); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid UPLOAD_SESSION_TTL %q", raw)
+		}
+		cfg.SessionTTL = d
+	}
+	return cfg, nil
+}
+
+// countingWriter tracks how many bytes were fed to the hash so a partially
+// failed append can be detected.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// storeBlob stages r in the payload store and commits it under contentHash.
+func storeBlob(ctx context.Context, r io.Reader, contentHash string) (string, error) {
+	bs, err := blob.Current()
+	if err != nil {
+		return "", err
+	}
+	id := uuid.NewString()
+	if err := bs.Create(ctx, id); err != nil {
+		return "", err
+	}
+	if _, err := bs.Append(ctx, id, r); err != nil {
+		_ = bs.Abort(ctx, id)
+		return "", err
+	}
+	return bs.Commit(ctx, id, contentHash)
+}
+
+// streamBlob stages r while hashing it and commits the result. It returns
+// errPayloadTooLarge once more than limit bytes have been read, and
+// errPayloadRefused when accept refuses the final size; the staged blob is
+// aborted in both cases.
+func streamBlob(ctx context.Context, r io.Reader, limit int64, accept func(size int64) bool) (contentHash, ref string, size int64, err error) {
+	bs, err := blob.Current()
+	if err != nil {
+		return "", "", 0, err
+	}
+	id := uuid.NewString()
+	if err := bs.Create(ctx, id); err != nil {
+		return "", "", 0, err
+	}
+	hasher := sha256.New()
+	size, err = bs.Append(ctx, id, io.TeeReader(io.LimitReader(r, limit+1), hasher))
+	switch {
+	case err != nil:
+	case size > limit:
+		err = errPayloadTooLarge
+	case !accept(size):
+		err = errPayloadRefused
+	}
+	if err != nil {
+		_ = bs.Abort(ctx, id)
+		return "", "", 0, err
+	}
+	contentHash = hex.EncodeToString(hasher.Sum(nil))
+	ref, err = bs.Commit(ctx, id, contentHash)
+	return contentHash, ref, size, err
+}
+
+// extendDeadlines lifts the server read/write timeouts for long transfers.
+func extendDeadlines(w http.ResponseWriter) {
+	rc := http.NewResponseController(w)
+	_ = rc.SetReadDeadline(time.Time{})
+	_ = rc.SetWriteDeadline(time.Time{})
+}
+
+// Upload ingests a payload streamed as the raw request body or as the
+// "payload" part of a multipart form. The evidence record is created only
+// after the payload is stored and its hash is final. Quotas are checked
+// before the body is read and again before the payload is committed.
+func (h *IngestHandler) Upload(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimSpace(r.Header.Get("Idempotency-Key"))
+	if len(key) > maxIdempotencyKeyLen {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if _, _, err := h.waitParams(r); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_wait", err.Error())
+		return
+	}
+	extendDeadlines(w)
+
+	var (
+		body        io.Reader = r.Body
+		contentType           = r.Header.Get("Content-Type")
+	)
+	labels, err := evidence.ParseLabelSelectors(r.URL.Query()["label"])
+	if err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_labels", err.Error())
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if mediaType == "multipart/form-data" {
+		part, partType, partLabels, err := payloadPart(r)
+		if err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_multipart", err.Error())
+			return
+		}
+		defer part.Close()
+		body, contentType = part, partType
+		for k, v := range partLabels {
+			labels[k] = v
+		}
+		if err := evidence.ValidateLabels(labels); err != nil {
+			writeJSONError(w, http.StatusBadRequest, "invalid_labels", err.Error())
+			return
+		}
+	} else if r.ContentLength > h.upload.MaxUploadBytes {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+
+	// a raw body's Content-Length is its size; a multipart body's also
+	// counts the envelope, so only the record itself is checked up front
+	actor := middleware.SubjectFromContext(r.Context())
+	declared := int64(0)
+	if mediaType != "multipart/form-data" && r.ContentLength > 0 {
+		declared = r.ContentLength
+	}
+	if h.overQuota(w, r, actor, declared) {
+		return
+	}
+	contentHash, ref, size, err := streamBlob(r.Context(), body, h.upload.MaxUploadBytes, func(size int64) bool {
+		return !h.overQuota(w, r, actor, size)
+	})
+	switch {
+	case errors.Is(err, errPayloadRefused):
+		return
+	case errors.Is(err, errPayloadTooLarge):
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		log.Warn().Err(err).Msg("streamed upload failed")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if size == 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	draft := service.Record{ContentType: contentType, ContentHash: contentHash, PayloadRef: ref, Labels: labels, Size: size}
+	h.writeIngestResult(w, r, actor, key, draft)
+}
+
+// payloadPart returns the "payload" file part of a multipart upload. A
+// preceding "content_type" field overrides the part's own Content-Type and a
+// preceding "labels" field carries a JSON object of labels.
+func payloadPart(r *http.Request) (io.ReadCloser, string, map[string]string, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	override := ""
+	var labels map[string]string
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return nil, "", nil, err
+		}
+		switch part.FormName() {
+		case "content_type":
+			b, err := io.ReadAll(io.LimitReader(part, 256))
+			part.Close()
+			if err != nil {
+				return nil, "", nil, err
+			}
+			override = strings.TrimSpace(string(b))
+		case "labels":
+			err := json.NewDecoder(io.LimitReader(part, 64<<10)).Decode(&labels)
+			part.Close()
+			if err != nil {
+				return nil, "", nil, fmt.Errorf("labels field: %w", err)
+			}
+		case "payload":
+			ct := override
+			if ct == "" {
+				ct = part.Header.Get("Content-Type")
+			}
+			return part, ct, labels, nil
+		default:
+			part.Close()
+		}
+	}
+}
+
+// CreateUpload opens a resumable upload session. The optional JSON body
+// declares content_type and the total size in bytes. The session is kept
+// in the store, next to the blob it stages, so any replica can resume it.
+func (h *IngestHandler) CreateUpload(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		ContentType string            `json:"content_type"`
+		Size        *int64            `json:"size"`
+		Labels      map[string]string `json:"labels"`
+	}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(io.LimitReader(r.Body, 64<<10)).Decode(&req); err != nil && err != io.EOF {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
+	if err := evidence.ValidateLabels(req.Labels); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_labels", err.Error())
+		return
+	}
+	length := int64(-1)
+	if req.Size != nil {
+		if *req.Size <= 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if *req.Size > h.upload.MaxUploadBytes {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		length = *req.Size
+	}
+	if req.ContentType == "" {
+		req.ContentType = defaultContentType
+	}
+	actor := middleware.SubjectFromContext(r.Context())
+	if length > 0 && h.overQuota(w, r, actor, length) {
+		return
+	}
+
+	bs, err := blob.Current()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	state, err := sha256.New().(encoding.BinaryMarshaler).MarshalBinary()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	st := h.vaultFor(r.Context()).Store()
+	sess := store.UploadSession{
+		ID:          uuid.NewString(),
+		Actor:       actor,
+		ContentType: req.ContentType,
+		Labels:      req.Labels,
+		Length:      length,
+		HashState:   state,
+		UpdatedAt:   time.Now().UTC(),
+	}
+	h.expireUploads(r.Context(), st, bs, sess.UpdatedAt)
+	if err := bs.Create(r.Context(), sess.ID); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if err := st.CreateUploadSession(r.Context(), sess); err != nil {
+		log.Error().Err(err).Msg("persist upload session")
+		_ = bs.Abort(r.Context(), sess.ID)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Location", strings.TrimSuffix(r.URL.Path, "/")+"/"+sess.ID)
+	w.Header().Set(uploadOffsetHeader, "0")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"upload_id": sess.ID, "offset": 0, "expires_at": sess.UpdatedAt.Add(h.upload.SessionTTL)})
+}
+
+// UploadStatus reports the committed offset so clients can resume.
+func (h *IngestHandler) UploadStatus(w http.ResponseWriter, r *http.Request) {
+	sess, status := h.uploadSession(r)
+	if sess == nil {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(sess.Offset, 10))
+	if sess.Length >= 0 {
+		w.Header().Set(uploadLengthHeader, strconv.FormatInt(sess.Length, 10))
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AppendUpload appends the request body at the offset given in the
+// Upload-Offset header. Bytes received before a dropped connection are kept
+// and reflected in the returned offset.
+func (h *IngestHandler) AppendUpload(w http.ResponseWriter, r *http.Request) {
+	offset, err := strconv.ParseInt(r.Header.Get(uploadOffsetHeader), 10, 64)
+	if err != nil || offset < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	bs, err := blob.Current()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	sess, status := h.uploadSession(r)
+	if sess == nil {
+		w.WriteHeader(status)
+		return
+	}
+	st := h.vaultFor(r.Context()).Store()
+	now := time.Now().UTC()
+	claimed, err := st.ClaimUploadSession(r.Context(), sess.ID, offset, now, now.Add(uploadClaimLease))
+	switch {
+	case errors.Is(err, store.ErrUploadConflict):
+		w.Header().Set(uploadOffsetHeader, strconv.FormatInt(claimed.Offset, 10))
+		w.WriteHeader(http.StatusConflict)
+		return
+	case errors.Is(err, store.ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case err != nil:
+		log.Error().Err(err).Str("upload_id", sess.ID).Msg("claim upload session")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	claim := claimed.Claim
+	limit := h.upload.MaxUploadBytes
+	if claimed.Length >= 0 {
+		limit = claimed.Length
+	}
+	if r.ContentLength > 0 && h.overQuota(w, r, claimed.Actor, offset+r.ContentLength) {
+		h.failUpload(r.Context(), st, bs, sess.ID, claim)
+		return
+	}
+
+	extendDeadlines(w)
+	hasher := sha256.New()
+	if err := hasher.(encoding.BinaryUnmarshaler).UnmarshalBinary(claimed.HashState); err != nil {
+		h.failUpload(r.Context(), st, bs, sess.ID, claim)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	counted := &countingWriter{w: hasher}
+	remaining := limit - offset
+	release := keepUploadClaim(r.Context(), st, sess.ID, claim)
+	n, appendErr := bs.Append(r.Context(), sess.ID, io.TeeReader(io.LimitReader(r.Body, remaining+1), counted))
+	release()
+	if counted.n != n {
+		// the payload store and the hash disagree; the session cannot be resumed
+		h.failUpload(r.Context(), st, bs, sess.ID, claim)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if n > remaining {
+		h.failUpload(r.Context(), st, bs, sess.ID, claim)
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		return
+	}
+	next := offset + n
+	state, err := hasher.(encoding.BinaryMarshaler).MarshalBinary()
+	if err == nil {
+		err = st.SaveUploadProgress(context.WithoutCancel(r.Context()), sess.ID, claim, next, state, time.Now().UTC())
+	}
+	if err != nil {
+		log.Error().Err(err).Str("upload_id", sess.ID).Msg("save upload progress")
+		h.failUpload(r.Context(), st, bs, sess.ID, claim)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set(uploadOffsetHeader, strconv.FormatInt(next, 10))
+	if appendErr != nil {
+		log.Warn().Err(appendErr).Str("upload_id", sess.ID).Int64("offset", next).Msg("upload chunk interrupted")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// CompleteUpload finalises the content hash and creates the evidence record.
+func (h *IngestHandler) CompleteUpload(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimSpace(r.Header.Get("Idempotency-Key"))
+	if len(key) > maxIdempotencyKeyLen {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	if _, _, err := h.waitParams(r); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "invalid_wait", err.Error())
+		return
+	}
+	bs, err := blob.Current()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	sess, status := h.uploadSession(r)
+	if sess == nil {
+		w.WriteHeader(status)
+		return
+	}
+	if sess.Offset == 0 || (sess.Length >= 0 && sess.Offset != sess.Length) {
+		w.Header().Set(uploadOffsetHeader, strconv.FormatInt(sess.Offset, 10))
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	// claiming at the offset just read fails if an append is running or
+	// has moved the session on; deleting under the claim makes this the
+	// only completion
+	st := h.vaultFor(r.Context()).Store()
+	now := time.Now().UTC()
+	claimed, err := st.ClaimUploadSession(r.Context(), sess.ID, sess.Offset, now, now.Add(uploadClaimLease))
+	if err == nil {
+		err = st.DeleteUploadSession(r.Context(), sess.ID, claimed.Claim, now)
+	}
+	switch {
+	case errors.Is(err, store.ErrUploadConflict):
+		w.WriteHeader(http.StatusConflict)
+		return
+	case errors.Is(err, store.ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case err != nil:
+		log.Error().Err(err).Str("upload_id", sess.ID).Msg("close upload session")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	hasher := sha256.New()
+	if err := hasher.(encoding.BinaryUnmarshaler).UnmarshalBinary(claimed.HashState); err != nil {
+		_ = bs.Abort(r.Context(), claimed.ID)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	if h.overQuota(w, r, claimed.Actor, claimed.Offset) {
+		_ = bs.Abort(r.Context(), claimed.ID)
+		return
+	}
+	contentHash := hex.EncodeToString(hasher.Sum(nil))
+	ref, err := bs.Commit(r.Context(), claimed.ID, contentHash)
+	if err != nil {
+		log.Error().Err(err).Str("upload_id", claimed.ID).Msg("commit upload")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	draft := service.Record{ContentType: claimed.ContentType, ContentHash: contentHash, PayloadRef: ref, Labels: claimed.Labels, Size: claimed.Offset}
+	h.writeIngestResult(w, r, claimed.Actor, key, draft)
+}
+
+// AbortUpload discards an in-progress upload.
+func (h *IngestHandler) AbortUpload(w http.ResponseWriter, r *http.Request) {
+	bs, err := blob.Current()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	sess, status := h.uploadSession(r)
+	if sess == nil {
+		w.WriteHeader(status)
+		return
+	}
+	err = h.vaultFor(r.Context()).Store().DeleteUploadSession(r.Context(), sess.ID, "", time.Now().UTC())
+	switch {
+	case errors.Is(err, store.ErrUploadConflict):
+		w.WriteHeader(http.StatusConflict)
+		return
+	case errors.Is(err, store.ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		return
+	case err != nil:
+		log.Error().Err(err).Str("upload_id", sess.ID).Msg("delete upload session")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	_ = bs.Abort(r.Context(), sess.ID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// uploadSession resolves the upload named in the route for the calling
+// subject in the tenant's store.
+func (h *IngestHandler) uploadSession(r *http.Request) (*store.UploadSession, int) {
+	sess, err := h.vaultFor(r.Context()).Store().GetUploadSession(r.Context(), chi.URLParam(r, "id"))
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, http.StatusNotFound
+	}
+	if err != nil {
+		log.Error().Err(err).Msg("load upload session")
+		return nil, http.StatusInternalServerError
+	}
+	if sess.Actor != middleware.SubjectFromContext(r.Context()) || time.Since(sess.UpdatedAt) > h.upload.SessionTTL {
+		return nil, http.StatusNotFound
+	}
+	return sess, http.StatusOK
+}
+
+// keepUploadClaim renews claim every third of uploadClaimLease until the
+// returned release is called, so long appends keep their session.
+func keepUploadClaim(ctx context.Context, st store.UploadStore, id, claim string) (release func()) {
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		t := time.NewTicker(uploadClaimLease / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				if err := st.RenewUploadClaim(ctx, id, claim, time.Now().UTC().Add(uploadClaimLease)); err != nil {
+					log.Warn().Err(err).Str("upload_id", id).Msg("renew upload claim")
+					return
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		wg.Wait()
+	}
+}
+
+// failUpload discards a session whose staged blob can no longer be trusted.
+func (h *IngestHandler) failUpload(ctx context.Context, st store.UploadStore, bs blob.Store, id, claim string) {
+	ctx = context.WithoutCancel(ctx)
+	if err := st.DeleteUploadSession(ctx, id, claim, time.Now().UTC()); err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Warn().Err(err).Str("upload_id", id).Msg("delete failed upload session")
+		return
+	}
+	_ = bs.Abort(ctx, id)
+}
+
+// expireUploads drops the tenant's sessions idle for longer than the TTL,
+// with their staged blobs. Sessions an append still holds are kept.
+func (h *IngestHandler) expireUploads(ctx context.Context, st store.UploadStore, bs blob.Store, now time.Time) {
+	ids, err := st.ExpiredUploadSessions(ctx, now.Add(-h.upload.SessionTTL))
+	if err != nil {
+		log.Warn().Err(err).Msg("list expired upload sessions")
+		return
+	}
+	for _, id := range ids {
+		if err := st.DeleteUploadSession(ctx, id, "", now); err == nil {
+			_ = bs.Abort(ctx, id)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/SaridakisStamatisChristos/vault-api/blob"
+	"github.com/SaridakisStamatisChristos/vault-api/domain/merkle"
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+	"github.com/go-chi/chi/v5"
+)
+
+func useTempBlobStore(t *testing.T) blob.Store {
+	t.Helper()
+	fs, err := blob.NewFileStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("blob store: %v", err)
+	}
+	blob.SetCurrent(fs)
+	t.Cleanup(func() { blob.SetCurrent(nil) })
+	return fs
+}
+
+func uploadRouter(h *IngestHandler) http.Handler {
+	r := chi.NewRouter()
+	r.Post("/api/v1/evidence/upload", h.Upload)
+	r.Post("/api/v1/uploads", h.CreateUpload)
+	r.Head("/api/v1/uploads/{id}", h.UploadStatus)
+	r.Patch("/api/v1/uploads/{id}", h.AppendUpload)
+	r.Delete("/api/v1/uploads/{id}", h.AbortUpload)
+	r.Post("/api/v1/uploads/{id}/complete", h.CompleteUpload)
+	return r
+}
+
+func sha256Hex(b []byte) string {
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestUploadRawBodyHashesAndStoresPayload(t *testing.T) {
+	bs := useTempBlobStore(t)
+	h := newTestHandler(t)
+	r := uploadRouter(h)
+
+	payload := bytes.Repeat([]byte("forensic-image-block "), 4096)
+	req := httptest.NewRequest(http.MethodPost, "/api/v1/evidence/upload", bytes.NewReader(payload))
+	req.Header.Set("Content-Type", "application/x-raw-disk-image")
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, req)
+	if rw.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 got %d body=%s", rw.Code, rw.Body.String())
+	}
+	var got struct {
+		ID   string `json:"id"`
+		Hash string `json:"content_hash"`
+		Size int64  `json:"size"`
+	}
+	if err := json.NewDecoder(rw.Body).Decode(&got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.Hash != sha256Hex(payload) || got.Size != int64(len(payload)) {
+		t.Fatalf("unexpected hash/size: %+v", got)
+	}
+
+	rec, err := h.vault.Get(context.Background(), got.ID)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if rec.ContentType != "application/x-raw-disk-image" {
+		t.Fatalf("unexpected content type %q", rec.ContentType)
+	}
+	rc, err := bs.Open(context.Background(), rec.PayloadRef)
+	if err != nil {
+		t.Fatalf("open payload: %v", err)
+	}
+	defer rc.Close()
+	stored, _ := io.ReadAll(rc)
+	if !bytes.Equal(stored, payload) {
+		t.Fatalf("stored payload differs")
+	}
+}
+
+func TestUploadMultipartAndSizeLimit(t *testing.T) {
+	useTempBlobStore(t)
+	t.Setenv("INGEST_MAX_UPLOAD_BYTES", "16")
+	h := newTestHandler(t)
+	r := uploadRouter(h)
+
+	send := func(payload string) *httptest.ResponseRecorder {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		_ = mw.WriteField("content_type", "text/plain")
+		fw, _ := mw.CreateFormFile("payload", "log.txt")
+		_, _ = fw.Write([]byte(payload))
+		_ = mw.Close()
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/evidence/upload", &buf)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw
+	}
+
+	if rw := send("small log"); rw.Code != http.StatusAccepted {
+		t.Fatalf("expected 202 got %d", rw.Code)
+	}
+	if rw := send(strings.Repeat("x", 17)); rw.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 got %d", rw.Code)
+	}
+	all, err := h.vault.Query(context.Background(), store.EvidenceQuery{Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(all); n != 1 {
+		t.Fatalf("oversized upload must not create evidence, have %d records", n)
+	}
+}
+
+func TestResumableUploadLifecycle(t *testing.T) {
+	useTempBlobStore(t)
+	r := uploadRouter(newTestHandler(t))
+
+	payload := []byte("chunk-one|chunk-two|chunk-three")
+	body, _ := json.Marshal(map[string]interface{}{"content_type": "application/pcap", "size": len(payload)})
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/api/v1/uploads", bytes.NewReader(body)))
+	if rw.Code != http.StatusCreated {
+		t.Fatalf("create expected 201 got %d", rw.Code)
+	}
+	var created struct {
+		UploadID string `json:"upload_id"`
+	}
+	_ = json.NewDecoder(rw.Body).Decode(&created)
+	base := "/api/v1/uploads/" + created.UploadID
+
+	patch := func(offset int, chunk []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPatch, base, bytes.NewReader(chunk))
+		req.Header.Set("Upload-Offset", strconv.Itoa(offset))
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw
+	}
+
+	if rw := patch(0, payload[:10]); rw.Code != http.StatusNoContent || rw.Header().Get("Upload-Offset") != "10" {
+		t.Fatalf("first chunk: code=%d offset=%s", rw.Code, rw.Header().Get("Upload-Offset"))
+	}
+	if rw := patch(0, payload[:10]); rw.Code != http.StatusConflict || rw.Header().Get("Upload-Offset") != "10" {
+		t.Fatalf("stale offset should conflict, got %d", rw.Code)
+	}
+
+	// completing before all declared bytes arrived is refused
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, base+"/complete", nil))
+	if rw.Code != http.StatusBadRequest {
+		t.Fatalf("early complete expected 400 got %d", rw.Code)
+	}
+
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest(http.MethodHead, base, nil))
+	if rw.Header().Get("Upload-Offset") != "10" || rw.Header().Get("Upload-Length") != strconv.Itoa(len(payload)) {
+		t.Fatalf("status headers: %v", rw.Header())
+	}
+	if rw := patch(10, payload[10:]); rw.Code != http.StatusNoContent {
+		t.Fatalf("second chunk: %d", rw.Code)
+	}
+
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, base+"/complete", nil))
+	if rw.Code != http.StatusAccepted {
+		t.Fatalf("complete expected 202 got %d body=%s", rw.Code, rw.Body.String())
+	}
+	var done struct {
+		Hash string `json:"content_hash"`
+	}
+	_ = json.NewDecoder(rw.Body).Decode(&done)
+	if done.Hash != sha256Hex(payload) {
+		t.Fatalf("hash across chunks mismatch: %s", done.Hash)
+	}
+
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest(http.MethodHead, base, nil))
+	if rw.Code != http.StatusNotFound {
+		t.Fatalf("completed session should be gone, got %d", rw.Code)
+	}
+}
+
+func TestResumableUploadRejectsOverDeclaredLength(t *testing.T) {
+	useTempBlobStore(t)
+	r := uploadRouter(newTestHandler(t))
+
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/api/v1/uploads", strings.NewReader(`{"size":4}`)))
+	var created struct {
+		UploadID string `json:"upload_id"`
+	}
+	_ = json.NewDecoder(rw.Body).Decode(&created)
+
+	req := httptest.NewRequest(http.MethodPatch, "/api/v1/uploads/"+created.UploadID, strings.NewReader("too long"))
+	req.Header.Set("Upload-Offset", "0")
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, req)
+	if rw.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected 413 got %d", rw.Code)
+	}
+}
+
+func TestResumableUploadSurvivesRestart(t *testing.T) {
+	useTempBlobStore(t)
+	st := store.NewMemoryStore()
+	r := uploadRouter(NewIngestHandler(st, merkle.NewMemoryEngine()))
+
+	payload := []byte("first-half|second-half")
+	rw := httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, "/api/v1/uploads", strings.NewReader(`{"size":`+strconv.Itoa(len(payload))+`}`)))
+	var created struct {
+		UploadID string `json:"upload_id"`
+	}
+	_ = json.NewDecoder(rw.Body).Decode(&created)
+	base := "/api/v1/uploads/" + created.UploadID
+	req := httptest.NewRequest(http.MethodPatch, base, bytes.NewReader(payload[:11]))
+	req.Header.Set("Upload-Offset", "0")
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, req)
+	if rw.Code != http.StatusNoContent {
+		t.Fatalf("first chunk: %d", rw.Code)
+	}
+
+	// a new process over the same store resumes where the first left off
+	r = uploadRouter(NewIngestHandler(st, merkle.NewMemoryEngine()))
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest(http.MethodHead, base, nil))
+	if rw.Code != http.StatusNoContent || rw.Header().Get("Upload-Offset") != "11" {
+		t.Fatalf("status after restart: code=%d offset=%s", rw.Code, rw.Header().Get("Upload-Offset"))
+	}
+	req = httptest.NewRequest(http.MethodPatch, base, bytes.NewReader(payload[11:]))
+	req.Header.Set("Upload-Offset", "11")
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, req)
+	if rw.Code != http.StatusNoContent {
+		t.Fatalf("second chunk: %d", rw.Code)
+	}
+	rw = httptest.NewRecorder()
+	r.ServeHTTP(rw, httptest.NewRequest(http.MethodPost, base+"/complete", nil))
+	var done struct {
+		Hash string `json:"content_hash"`
+	}
+	_ = json.NewDecoder(rw.Body).Decode(&done)
+	if rw.Code != http.StatusAccepted || done.Hash != sha256Hex(payload) {
+		t.Fatalf("complete after restart: code=%d hash=%s", rw.Code, done.Hash)
+	}
+}
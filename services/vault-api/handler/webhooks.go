@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/SaridakisStamatisChristos/vault-api/internal/webhook"
+	"github.com/SaridakisStamatisChristos/vault-api/middleware"
+	"github.com/SaridakisStamatisChristos/vault-api/service"
+	"github.com/go-chi/chi/v5"
+	"github.com/rs/zerolog/log"
+)
+
+// StartWebhookDispatcher starts delivering every tenant's queued webhook
+// events. It fails on an invalid WEBHOOK_* configuration.
+func (h *IngestHandler) StartWebhookDispatcher(ctx context.Context) error {
+	cfg, err := webhook.ConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	for _, d := range h.webhooks {
+		go d.Run(ctx)
+	}
+	log.Info().Int("max_attempts", cfg.MaxAttempts).Dur("backoff_base", cfg.BackoffBase).Msg("webhook dispatcher started")
+	return nil
+}
+
+func (h *IngestHandler) publishVerificationFailure(ctx context.Context, cp service.Checkpoint, reason string) {
+	h.webhooksFor(ctx).Publish(ctx, webhook.EventVerificationFailed, map[string]interface{}{
+		"tree_size": cp.TreeSize,
+		"root_hash": cp.RootHash,
+		"key_ref":   cp.KeyRef,
+		"reason":    reason,
+	})
+}
+
+// CreateWebhook registers a subscription. The signing secret is only
+// returned in this response.
+func (h *IngestHandler) CreateWebhook(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		URL    string   `json:"url"`
+		Events []string `json:"events"`
+		Secret string   `json:"secret"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 16<<10)).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	actor := middleware.SubjectFromContext(r.Context())
+	sub, secret, err := h.webhooksFor(r.Context()).Subscribe(r.Context(), req.URL, req.Events, req.Secret, actor)
+	switch {
+	case errors.Is(err, webhook.ErrInvalidURL):
+		writeJSONError(w, http.StatusBadRequest, "invalid_url", err.Error())
+		return
+	case errors.Is(err, webhook.ErrInvalidEvent):
+		writeJSONError(w, http.StatusBadRequest, "invalid_events", err.Error())
+		return
+	case err != nil:
+		log.Error().Err(err).Msg("create webhook")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	h.vaultFor(r.Context()).RecordAudit(r.Context(), "webhook_create", sub.ID, actor)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":         sub.ID,
+		"url":        sub.URL,
+		"events":     sub.Events,
+		"created_at": sub.CreatedAt,
+		"secret":     secret,
+	})
+}
+
+func (h *IngestHandler) ListWebhooks(w http.ResponseWriter, r *http.Request) {
+	subs, err := h.webhooksFor(r.Context()).Subscriptions(r.Context())
+	if err != nil {
+		log.Error().Err(err).Msg("list webhooks")
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"entries": subs})
+}
+
+func (h *IngestHandler) GetWebhook(w http.ResponseWriter, r *http.Request) {
+	sub, err := h.webhooksFor(r.Context()).Subscription(r.Context(), chi.URLParam(r, "id"))
+	if !webhookFound(w, err) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(sub)
+}
+
+func (h *IngestHandler) DeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "id")
+	if !webhookFound(w, h.webhooksFor(r.Context()).Unsubscribe(r.Context(), id)) {
+		return
+	}
+	h.vaultFor(r.Context()).RecordAudit(r.Context(), "webhook_delete", id, middleware.SubjectFromContext(r.Context()))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// WebhookDeliveries returns the delivery history of a subscription,
+// newest first, including every attempt.
+func (h *IngestHandler) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	hist, err := h.webhooksFor(r.Context()).History(r.Context(), chi.URLParam(r, "id"))
+	if !webhookFound(w, err) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"entries": hist})
+}
+
+// WebhookDeadLetters lists deliveries that exhausted their retries.
+func (h *IngestHandler) WebhookDeadLetters(w http.ResponseWriter, r *http.Request) {
+	dead, err := h.webhooksFor(r.Context()).DeadLetters(r.Context())
+	if !webhookFound(w, err) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"entries": dead})
+}
+
+// RedeliverWebhook requeues a dead-lettered delivery.
+func (h *IngestHandler) RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "deliveryID")
+	if !webhookFound(w, h.webhooksFor(r.Context()).Redeliver(r.Context(), id)) {
+		return
+	}
+	h.vaultFor(r.Context()).RecordAudit(r.Context(), "webhook_redeliver", id, middleware.SubjectFromContext(r.Context()))
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// webhookFound answers 404 for webhook.ErrNotFound and 500 for any other
+// error, and reports whether err was nil.
+func webhookFound(w http.ResponseWriter, err error) bool {
+	switch {
+	case err == nil:
+		return true
+	case errors.Is(err, webhook.ErrNotFound):
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		log.Error().Err(err).Msg("webhook store")
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+	return false
+}
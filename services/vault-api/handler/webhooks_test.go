@@ -0,0 +1,96 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/internal/webhook"
+	"github.com/SaridakisStamatisChristos/vault-api/middleware"
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+	"github.com/go-chi/chi/v5"
+)
+
+func TestWebhooksReceiveCommitterAndCheckpointEvents(t *testing.T) {
+	t.Setenv("ENABLE_TEST_JWT", "true")
+	useTempBlobStore(t)
+	t.Setenv("WEBHOOK_ALLOW_INSECURE_URLS", "true")
+
+	var (
+		rmu    sync.Mutex
+		events []string
+	)
+	var secret string
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if !webhook.Verify(secret, r.Header.Get(webhook.HeaderSignature), body, time.Now(), 5*time.Minute) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rmu.Lock()
+		events = append(events, r.Header.Get(webhook.HeaderEvent))
+		rmu.Unlock()
+	}))
+	defer receiver.Close()
+
+	h := newTestHandler(t)
+	r := chi.NewRouter()
+	r.With(middleware.JWT, middleware.Require(middleware.PermWebhookManage)).Post("/api/v1/webhooks", h.CreateWebhook)
+	r.With(middleware.JWT, middleware.Require(middleware.PermWebhookManage)).Get("/api/v1/webhooks/{id}/deliveries", h.WebhookDeliveries)
+
+	create := func(token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/api/v1/webhooks", strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		rw := httptest.NewRecorder()
+		r.ServeHTTP(rw, req)
+		return rw
+	}
+	if rw := create("ingester-token", `{}`); rw.Code != http.StatusForbidden {
+		t.Fatalf("ingester must not manage webhooks, got %d", rw.Code)
+	}
+	if rw := create("auditor-token", `{"url":"`+receiver.URL+`","events":["evidence.deleted"]}`); rw.Code != http.StatusBadRequest {
+		t.Fatalf("expected 400 for unknown event, got %d", rw.Code)
+	}
+	rw := create("auditor-token", `{"url":"`+receiver.URL+`","events":["evidence.sequenced","checkpoint.published"]}`)
+	if rw.Code != http.StatusCreated {
+		t.Fatalf("create: %d %s", rw.Code, rw.Body.String())
+	}
+	var created struct {
+		ID     string `json:"id"`
+		Secret string `json:"secret"`
+	}
+	_ = json.NewDecoder(rw.Body).Decode(&created)
+	secret = created.Secret
+
+	doIngest(t, h, "", []byte("hooked"))
+	h.vault.CommitNext(context.Background())
+	if _, err := h.vault.LatestCheckpoint(context.Background()); err != nil {
+		t.Fatalf("checkpoint: %v", err)
+	}
+	h.webhooks[store.DefaultTenant].DeliverDue(context.Background())
+
+	rmu.Lock()
+	got := strings.Join(events, ",")
+	rmu.Unlock()
+	if got != "evidence.sequenced,checkpoint.published" && got != "checkpoint.published,evidence.sequenced" {
+		t.Fatalf("unexpected deliveries %q", got)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/api/v1/webhooks/"+created.ID+"/deliveries", nil)
+	req.Header.Set("Authorization", "Bearer auditor-token")
+	hist := httptest.NewRecorder()
+	r.ServeHTTP(hist, req)
+	var body struct {
+		Entries []webhook.Delivery `json:"entries"`
+	}
+	_ = json.NewDecoder(hist.Body).Decode(&body)
+	if len(body.Entries) != 2 || body.Entries[0].Status != webhook.StatusDelivered {
+		t.Fatalf("unexpected history %+v", body.Entries)
+	}
+}
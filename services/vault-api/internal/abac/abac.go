@@ -0,0 +1,226 @@
+// Package abac evaluates attribute-based access rules that match token
+// claims against evidence labels, such as "the cases claim must contain the
+// record's case label". Rules narrow what RBAC already allows; they never
+// grant access a role lacks.
+package abac
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Match operators.
+const (
+	// MatchContains requires the claim, a string or a list of strings, to
+	// contain the label value.
+	MatchContains = "contains"
+	// MatchEquals requires the claim to be a string equal to the label value.
+	MatchEquals = "equals"
+)
+
+// Decision logging modes.
+const (
+	LogDeny = "deny"
+	LogAll  = "all"
+	LogNone = "none"
+)
+
+// Rule restricts access to evidence carrying Label to subjects whose Claim
+// matches the label value.
+type Rule struct {
+	Name string `json:"name"`
+	// Permissions lists the permissions the rule applies to; empty applies
+	// it to every evidence access.
+	Permissions []string `json:"permissions,omitempty"`
+	Claim       string   `json:"claim"`
+	Label       string   `json:"label"`
+	// Match is MatchContains (default) or MatchEquals.
+	Match string `json:"match,omitempty"`
+	// ExemptRoles bypass the rule, e.g. an oversight role.
+	ExemptRoles []string `json:"exempt_roles,omitempty"`
+	// AllowUnlabeled admits records without Label; by default they are
+	// denied.
+	AllowUnlabeled bool `json:"allow_unlabeled,omitempty"`
+}
+
+// Policy is the policy file format. Every applicable rule must allow an
+// access.
+type Policy struct {
+	Version string `json:"version"`
+	Rules   []Rule `json:"rules"`
+	// LogDecisions selects which decisions are written to the audit trail:
+	// LogDeny (default), LogAll or LogNone.
+	LogDecisions string `json:"log_decisions,omitempty"`
+}
+
+// Subject is the caller an access is evaluated for.
+type Subject struct {
+	ID     string                 `json:"sub"`
+	Roles  []string               `json:"roles"`
+	Claims map[string]interface{} `json:"claims"`
+}
+
+// Decision is the outcome of evaluating a policy.
+type Decision struct {
+	Allowed bool `json:"allowed"`
+	// Rule names the rule that denied the access, or that allowed it when
+	// exactly one rule applied.
+	Rule   string `json:"rule,omitempty"`
+	Reason string `json:"reason"`
+}
+
+// Parse decodes and validates a policy file.
+func Parse(data []byte) (*Policy, error) {
+	var p Policy
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return nil, fmt.Errorf("abac: decode policy: %w", err)
+	}
+	switch p.LogDecisions {
+	case "":
+		p.LogDecisions = LogDeny
+	case LogDeny, LogAll, LogNone:
+	default:
+		return nil, fmt.Errorf("abac: unknown log_decisions %q", p.LogDecisions)
+	}
+	names := map[string]bool{}
+	for i := range p.Rules {
+		r := &p.Rules[i]
+		if r.Name == "" || r.Claim == "" || r.Label == "" {
+			return nil, fmt.Errorf("abac: rule %d: name, claim and label are required", i)
+		}
+		if names[r.Name] {
+			return nil, fmt.Errorf("abac: duplicate rule %q", r.Name)
+		}
+		names[r.Name] = true
+		switch r.Match {
+		case "":
+			r.Match = MatchContains
+		case MatchContains, MatchEquals:
+		default:
+			return nil, fmt.Errorf("abac: rule %q: unknown match %q", r.Name, r.Match)
+		}
+	}
+	return &p, nil
+}
+
+// Load reads a policy file.
+func Load(path string) (*Policy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("abac: %w", err)
+	}
+	return Parse(data)
+}
+
+// LoadFromEnv loads the policy file at ABAC_POLICY_FILE. It returns nil
+// when the variable is unset, which allows every access RBAC allows.
+func LoadFromEnv() (*Policy, error) {
+	path := strings.TrimSpace(os.Getenv("ABAC_POLICY_FILE"))
+	if path == "" {
+		return nil, nil
+	}
+	return Load(path)
+}
+
+// Evaluate decides whether s may use permission on evidence with labels. A
+// nil policy allows everything.
+func (p *Policy) Evaluate(s Subject, permission string, labels map[string]string) Decision {
+	if p == nil {
+		return Decision{Allowed: true, Reason: "no policy"}
+	}
+	var applied []string
+	for _, r := range p.Rules {
+		if !r.appliesTo(permission) {
+			continue
+		}
+		if r.exempts(s.Roles) {
+			continue
+		}
+		applied = append(applied, r.Name)
+		value, labelled := labels[r.Label]
+		if !labelled {
+			if r.AllowUnlabeled {
+				continue
+			}
+			return Decision{Rule: r.Name, Reason: fmt.Sprintf("evidence has no %q label", r.Label)}
+		}
+		if !r.matches(s.Claims[r.Claim], value) {
+			return Decision{Rule: r.Name, Reason: fmt.Sprintf("claim %q does not match label %q", r.Claim, r.Label)}
+		}
+	}
+	switch len(applied) {
+	case 0:
+		return Decision{Allowed: true, Reason: "no applicable rule"}
+	case 1:
+		return Decision{Allowed: true, Rule: applied[0], Reason: "rule matched"}
+	default:
+		return Decision{Allowed: true, Reason: "all rules matched: " + strings.Join(applied, ",")}
+	}
+}
+
+// Logs reports whether d should be written to the audit trail.
+func (p *Policy) Logs(d Decision) bool {
+	if p == nil {
+		return false
+	}
+	switch p.LogDecisions {
+	case LogAll:
+		return true
+	case LogNone:
+		return false
+	default:
+		return !d.Allowed
+	}
+}
+
+func (r Rule) appliesTo(permission string) bool {
+	if len(r.Permissions) == 0 {
+		return true
+	}
+	for _, p := range r.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) exempts(roles []string) bool {
+	for _, exempt := range r.ExemptRoles {
+		for _, role := range roles {
+			if role == exempt {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (r Rule) matches(claim interface{}, value string) bool {
+	if r.Match == MatchEquals {
+		s, ok := claim.(string)
+		return ok && s == value
+	}
+	switch c := claim.(type) {
+	case string:
+		return c == value
+	case []string:
+		for _, s := range c {
+			if s == value {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, it := range c {
+			if s, ok := it.(string); ok && s == value {
+				return true
+			}
+		}
+	}
+	return false
+}
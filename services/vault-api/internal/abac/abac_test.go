@@ -0,0 +1,80 @@
+package abac
+
+import "testing"
+
+func TestEvaluate(t *testing.T) {
+	p, err := Parse([]byte(`{
+	  "version": "v1",
+	  "rules": [
+	    {"name": "own-cases", "permissions": ["evidence:read"], "claim": "cases", "label": "case", "exempt_roles": ["auditor"]},
+	    {"name": "same-unit", "claim": "unit", "label": "unit", "match": "equals", "allow_unlabeled": true}
+	  ]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	investigator := Subject{ID: "inv", Claims: map[string]interface{}{"cases": []interface{}{"1", "2"}, "unit": "north"}}
+
+	tests := []struct {
+		name       string
+		subject    Subject
+		permission string
+		labels     map[string]string
+		allowed    bool
+		rule       string
+	}{
+		{name: "own case", subject: investigator, permission: "evidence:read", labels: map[string]string{"case": "2"}, allowed: true},
+		{name: "foreign case", subject: investigator, permission: "evidence:read", labels: map[string]string{"case": "3"}, rule: "own-cases"},
+		{name: "unlabelled", subject: investigator, permission: "evidence:read", rule: "own-cases"},
+		{name: "exempt role", subject: Subject{Roles: []string{"auditor"}}, permission: "evidence:read", labels: map[string]string{"case": "3"}, allowed: true},
+		{name: "rule scoped to other permission", subject: investigator, permission: "payload:read", labels: map[string]string{"case": "3"}, allowed: true, rule: "same-unit"},
+		{name: "equals mismatch", subject: investigator, permission: "payload:read", labels: map[string]string{"unit": "south"}, rule: "same-unit"},
+		{name: "equals needs a string claim", subject: Subject{Claims: map[string]interface{}{"unit": []interface{}{"north"}}}, permission: "payload:read", labels: map[string]string{"unit": "north"}, rule: "same-unit"},
+		{name: "string claim contains", subject: Subject{Claims: map[string]interface{}{"cases": "3", "unit": "north"}}, permission: "evidence:read", labels: map[string]string{"case": "3", "unit": "north"}, allowed: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := p.Evaluate(tt.subject, tt.permission, tt.labels)
+			if d.Allowed != tt.allowed || (tt.rule != "" && d.Rule != tt.rule) {
+				t.Fatalf("got %+v, want allowed=%v rule=%q", d, tt.allowed, tt.rule)
+			}
+		})
+	}
+}
+
+func TestNilPolicyAllows(t *testing.T) {
+	var p *Policy
+	if d := p.Evaluate(Subject{}, "evidence:read", nil); !d.Allowed {
+		t.Fatalf("nil policy must allow: %+v", d)
+	}
+	if p.Logs(Decision{}) {
+		t.Fatal("nil policy logs nothing")
+	}
+}
+
+func TestLogs(t *testing.T) {
+	allow, deny := Decision{Allowed: true}, Decision{}
+	for mode, want := range map[string][2]bool{"": {false, true}, LogAll: {true, true}, LogNone: {false, false}} {
+		p, err := Parse([]byte(`{"log_decisions":"` + mode + `","rules":[]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Logs(allow) != want[0] || p.Logs(deny) != want[1] {
+			t.Fatalf("mode %q: logs(allow)=%v logs(deny)=%v", mode, p.Logs(allow), p.Logs(deny))
+		}
+	}
+}
+
+func TestParseRejectsInvalidPolicies(t *testing.T) {
+	for _, raw := range []string{
+		`{"rules":[{"name":"r","claim":"cases"}]}`,
+		`{"rules":[{"name":"r","claim":"c","label":"l","match":"regex"}]}`,
+		`{"rules":[{"name":"r","claim":"c","label":"l"},{"name":"r","claim":"c","label":"l"}]}`,
+		`{"log_decisions":"some"}`,
+		`{"rulez":[]}`,
+	} {
+		if _, err := Parse([]byte(raw)); err == nil {
+			t.Fatalf("expected %s to be rejected", raw)
+		}
+	}
+}
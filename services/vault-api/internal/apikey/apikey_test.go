@@ -0,0 +1,32 @@
+package apikey
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestGenerateParseVerify(t *testing.T) {
+	key, id, hash, err := Generate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !Is(key) || strings.Contains(hash, id) {
+		t.Fatalf("key = %q hash = %q", key, hash)
+	}
+	gotID, secret, ok := Parse(key)
+	if !ok || gotID != id || !Verify(secret, hash) {
+		t.Fatalf("parse = %q %q %v", gotID, secret, ok)
+	}
+	if Verify(secret+"x", hash) {
+		t.Fatal("a different secret must not verify")
+	}
+	other, _, _, _ := Generate()
+	if other == key {
+		t.Fatal("keys must be unique")
+	}
+	for _, bad := range []string{"", "eyJhbGciOi.x.y", "vk_", "vk_abc", "vk__secret", "vk_abc_"} {
+		if _, _, ok := Parse(bad); ok {
+			t.Errorf("Parse(%q) accepted", bad)
+		}
+	}
+}
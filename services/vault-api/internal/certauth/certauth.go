@@ -0,0 +1,256 @@
+// Package certauth serves the API over TLS and maps verified client
+// certificates to the subject, roles and claims a bearer token would carry,
+// so devices holding X.509 certificates can ingest without an OIDC client.
+package certauth
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Subject sources: the certificate attribute a rule takes the subject from.
+const (
+	FromCommonName = "common_name"
+	FromDNSName    = "dns_name"
+	FromURI        = "uri"
+	FromEmail      = "email"
+)
+
+// Rule maps the certificates it matches to an identity. Match fields are
+// path.Match patterns; a rule matches when every pattern it sets matches
+// the attribute, or for SANs any of the certificate's values.
+type Rule struct {
+	CommonName         string `json:"common_name,omitempty"`
+	OrganizationalUnit string `json:"organizational_unit,omitempty"`
+	DNSName            string `json:"dns_name,omitempty"`
+	URI                string `json:"uri,omitempty"`
+	Email              string `json:"email,omitempty"`
+	// SubjectFrom selects the attribute that becomes the subject, one of
+	// the From constants; FromCommonName by default. For SANs the first
+	// value matching the rule's pattern is used.
+	SubjectFrom string `json:"subject_from,omitempty"`
+	// SubjectPrefix is prepended to the subject, keeping certificate
+	// subjects apart from token subjects.
+	SubjectPrefix string   `json:"subject_prefix,omitempty"`
+	Roles         []string `json:"roles"`
+	// Claims stand in for token claims, such as the tenant claim or
+	// attributes read by access rules and policies.
+	Claims map[string]interface{} `json:"claims,omitempty"`
+}
+
+// Config is the client certificate mapping file format. Rules are tried in
+// order; certificates matching none are refused.
+type Config struct {
+	Rules []Rule `json:"rules"`
+}
+
+// Identity is what a client certificate authenticates as.
+type Identity struct {
+	Subject string
+	Roles   []string
+	Claims  map[string]interface{}
+	// Fingerprint is Fingerprint of the certificate.
+	Fingerprint string
+}
+
+// Parse decodes and validates a mapping file.
+func Parse(data []byte) (*Config, error) {
+	var c Config
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("certauth: decode mapping: %w", err)
+	}
+	if len(c.Rules) == 0 {
+		return nil, errors.New("certauth: mapping has no rules")
+	}
+	for i := range c.Rules {
+		r := &c.Rules[i]
+		if r.SubjectFrom == "" {
+			r.SubjectFrom = FromCommonName
+		}
+		patterns := map[string]string{FromCommonName: r.CommonName, "organizational_unit": r.OrganizationalUnit, FromDNSName: r.DNSName, FromURI: r.URI, FromEmail: r.Email}
+		set := false
+		for field, p := range patterns {
+			if p == "" {
+				continue
+			}
+			set = true
+			if _, err := path.Match(p, ""); err != nil {
+				return nil, fmt.Errorf("certauth: rule %d: invalid %s pattern %q", i, field, p)
+			}
+		}
+		if !set {
+			return nil, fmt.Errorf("certauth: rule %d matches every certificate; set at least one pattern", i)
+		}
+		switch r.SubjectFrom {
+		case FromCommonName, FromDNSName, FromURI, FromEmail:
+		default:
+			return nil, fmt.Errorf("certauth: rule %d: unknown subject_from %q", i, r.SubjectFrom)
+		}
+		if _, claimsSub := r.Claims["sub"]; claimsSub {
+			return nil, fmt.Errorf("certauth: rule %d: the sub claim is taken from the certificate", i)
+		}
+	}
+	return &c, nil
+}
+
+// LoadFromEnv reads the mapping at CLIENT_CERT_MAPPING_FILE, or returns nil
+// when the variable is unset.
+func LoadFromEnv() (*Config, error) {
+	p := strings.TrimSpace(os.Getenv("CLIENT_CERT_MAPPING_FILE"))
+	if p == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, fmt.Errorf("certauth: %w", err)
+	}
+	return Parse(data)
+}
+
+// Identify returns the identity of the first rule matching cert.
+func (c *Config) Identify(cert *x509.Certificate) (Identity, bool) {
+	for _, r := range c.Rules {
+		sub, ok := r.match(cert)
+		if !ok || sub == "" {
+			continue
+		}
+		return Identity{Subject: r.SubjectPrefix + sub, Roles: r.Roles, Claims: r.Claims, Fingerprint: Fingerprint(cert)}, true
+	}
+	return Identity{}, false
+}
+
+// match reports whether r matches cert and returns the subject it yields.
+func (r Rule) match(cert *x509.Certificate) (string, bool) {
+	values := map[string]string{}
+	if r.CommonName != "" && !matches(r.CommonName, cert.Subject.CommonName) {
+		return "", false
+	}
+	values[FromCommonName] = cert.Subject.CommonName
+	if r.OrganizationalUnit != "" && firstMatch(r.OrganizationalUnit, cert.Subject.OrganizationalUnit) == "" {
+		return "", false
+	}
+	sans := []struct {
+		from, pattern string
+		values        []string
+	}{
+		{FromDNSName, r.DNSName, cert.DNSNames},
+		{FromURI, r.URI, uriStrings(cert)},
+		{FromEmail, r.Email, cert.EmailAddresses},
+	}
+	for _, san := range sans {
+		pattern := san.pattern
+		if pattern == "" {
+			pattern = "*"
+		}
+		v := firstMatch(pattern, san.values)
+		if v == "" && san.pattern != "" {
+			return "", false
+		}
+		values[san.from] = v
+	}
+	return values[r.SubjectFrom], true
+}
+
+func matches(pattern, v string) bool {
+	ok, _ := path.Match(pattern, v)
+	return ok
+}
+
+func firstMatch(pattern string, values []string) string {
+	for _, v := range values {
+		if matches(pattern, v) {
+			return v
+		}
+	}
+	return ""
+}
+
+func uriStrings(cert *x509.Certificate) []string {
+	out := make([]string, len(cert.URIs))
+	for i, u := range cert.URIs {
+		out[i] = u.String()
+	}
+	return out
+}
+
+// Fingerprint identifies cert as "sha256:" and the hex SHA-256 of its DER
+// encoding.
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Client certificate modes of TLS_CLIENT_AUTH.
+const (
+	ClientAuthNone     = "none"
+	ClientAuthOptional = "optional"
+	ClientAuthRequired = "required"
+)
+
+// TLSConfigFromEnv builds the server's TLS configuration from
+// TLS_CERT_FILE, TLS_KEY_FILE, TLS_CLIENT_CA_FILE and TLS_CLIENT_AUTH. It
+// returns nil when TLS_CERT_FILE is unset and the server speaks plain HTTP.
+// With a client CA the mode defaults to optional: certificates are verified
+// when presented, and clients without one may still use bearer tokens.
+func TLSConfigFromEnv() (*tls.Config, error) {
+	certFile := strings.TrimSpace(os.Getenv("TLS_CERT_FILE"))
+	keyFile := strings.TrimSpace(os.Getenv("TLS_KEY_FILE"))
+	caFile := strings.TrimSpace(os.Getenv("TLS_CLIENT_CA_FILE"))
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv("TLS_CLIENT_AUTH")))
+	if certFile == "" {
+		if keyFile != "" || caFile != "" || mode != "" {
+			return nil, errors.New("TLS_KEY_FILE, TLS_CLIENT_CA_FILE and TLS_CLIENT_AUTH require TLS_CERT_FILE")
+		}
+		return nil, nil
+	}
+	if keyFile == "" {
+		return nil, errors.New("TLS_CERT_FILE requires TLS_KEY_FILE")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("load server certificate: %w", err)
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{cert}}
+	if mode == "" {
+		mode = ClientAuthNone
+		if caFile != "" {
+			mode = ClientAuthOptional
+		}
+	}
+	switch mode {
+	case ClientAuthNone:
+		if caFile != "" {
+			return nil, errors.New("TLS_CLIENT_AUTH=none conflicts with TLS_CLIENT_CA_FILE")
+		}
+		return cfg, nil
+	case ClientAuthOptional:
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	case ClientAuthRequired:
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("unsupported TLS_CLIENT_AUTH %q; expected none, optional or required", mode)
+	}
+	if caFile == "" {
+		return nil, fmt.Errorf("TLS_CLIENT_AUTH=%s requires TLS_CLIENT_CA_FILE", mode)
+	}
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("read client CA: %w", err)
+	}
+	cfg.ClientCAs = x509.NewCertPool()
+	if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in TLS_CLIENT_CA_FILE %s", caFile)
+	}
+	return cfg, nil
+}
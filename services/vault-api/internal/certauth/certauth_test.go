@@ -0,0 +1,138 @@
+package certauth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// issue creates a certificate for tmpl signed by parent, or self-signed
+// when parent is nil.
+func issue(t *testing.T, tmpl *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl.SerialNumber = big.NewInt(time.Now().UnixNano())
+	tmpl.NotBefore, tmpl.NotAfter = time.Now().Add(-time.Hour), time.Now().Add(time.Hour)
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestIdentify(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://acme.example/collector/cam-7")
+	cert, _ := issue(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "cam-7.collectors.acme.example", OrganizationalUnit: []string{"field"}},
+		DNSNames: []string{"cam-7.collectors.acme.example"},
+		URIs:     []*url.URL{spiffe},
+	}, nil, nil)
+
+	c, err := Parse([]byte(`{"rules":[
+		{"organizational_unit":"lab","roles":["auditor"]},
+		{"uri":"spiffe://acme.example/collector/*","subject_from":"uri","roles":["ingester"],"claims":{"tenant":"acme"}},
+		{"common_name":"*.collectors.acme.example","roles":["ingester"]}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, ok := c.Identify(cert)
+	if !ok || id.Subject != "spiffe://acme.example/collector/cam-7" || len(id.Roles) != 1 || id.Claims["tenant"] != "acme" {
+		t.Fatalf("identity = %+v %v", id, ok)
+	}
+	if !strings.HasPrefix(id.Fingerprint, "sha256:") || len(id.Fingerprint) != len("sha256:")+64 {
+		t.Fatalf("fingerprint = %q", id.Fingerprint)
+	}
+
+	byName, _ := Parse([]byte(`{"rules":[{"common_name":"*.collectors.acme.example","organizational_unit":"field","subject_prefix":"cert:","roles":["ingester"]}]}`))
+	if id, ok := byName.Identify(cert); !ok || id.Subject != "cert:cam-7.collectors.acme.example" {
+		t.Fatalf("common name identity = %+v %v", id, ok)
+	}
+	other, _ := issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "laptop.corp.example"}}, nil, nil)
+	if id, ok := byName.Identify(other); ok {
+		t.Fatalf("unmapped certificate identified as %+v", id)
+	}
+
+	for name, doc := range map[string]string{
+		"no rules":      `{"rules":[]}`,
+		"match all":     `{"rules":[{"roles":["admin"]}]}`,
+		"bad pattern":   `{"rules":[{"common_name":"[","roles":[]}]}`,
+		"subject from":  `{"rules":[{"common_name":"a","subject_from":"serial","roles":[]}]}`,
+		"sub claim":     `{"rules":[{"common_name":"a","claims":{"sub":"root"}}]}`,
+		"unknown field": `{"rules":[{"cn":"a"}]}`,
+	} {
+		if _, err := Parse([]byte(doc)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func writePEM(t *testing.T, dir, name, typ string, der []byte) string {
+	t.Helper()
+	p := filepath.Join(dir, name)
+	if err := os.WriteFile(p, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestTLSConfigFromEnv(t *testing.T) {
+	dir := t.TempDir()
+	ca, _ := issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "ca"}, IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign}, nil, nil)
+	server, key := issue(t, &x509.Certificate{Subject: pkix.Name{CommonName: "vault"}, DNSNames: []string{"localhost"}}, nil, nil)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := writePEM(t, dir, "tls.crt", "CERTIFICATE", server.Raw)
+	keyFile := writePEM(t, dir, "tls.key", "EC PRIVATE KEY", keyDER)
+	caFile := writePEM(t, dir, "ca.crt", "CERTIFICATE", ca.Raw)
+
+	if cfg, err := TLSConfigFromEnv(); cfg != nil || err != nil {
+		t.Fatalf("plain HTTP by default: %v %v", cfg, err)
+	}
+	t.Setenv("TLS_CERT_FILE", certFile)
+	t.Setenv("TLS_KEY_FILE", keyFile)
+	cfg, err := TLSConfigFromEnv()
+	if err != nil || cfg.ClientAuth != tls.NoClientCert || cfg.MinVersion != tls.VersionTLS12 {
+		t.Fatalf("server TLS: %+v %v", cfg, err)
+	}
+	t.Setenv("TLS_CLIENT_CA_FILE", caFile)
+	if cfg, err := TLSConfigFromEnv(); err != nil || cfg.ClientAuth != tls.VerifyClientCertIfGiven || cfg.ClientCAs == nil {
+		t.Fatalf("optional client auth: %+v %v", cfg, err)
+	}
+	t.Setenv("TLS_CLIENT_AUTH", "required")
+	if cfg, err := TLSConfigFromEnv(); err != nil || cfg.ClientAuth != tls.RequireAndVerifyClientCert {
+		t.Fatalf("required client auth: %+v %v", cfg, err)
+	}
+	t.Setenv("TLS_CLIENT_AUTH", "sometimes")
+	if _, err := TLSConfigFromEnv(); err == nil {
+		t.Fatal("expected unknown mode to be rejected")
+	}
+	t.Setenv("TLS_CLIENT_AUTH", "required")
+	t.Setenv("TLS_CLIENT_CA_FILE", "")
+	if _, err := TLSConfigFromEnv(); err == nil {
+		t.Fatal("expected required client auth without a CA to be rejected")
+	}
+}
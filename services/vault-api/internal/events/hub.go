@@ -0,0 +1,110 @@
+// Package events fans out evidence status transitions and checkpoint
+// notifications to live subscribers such as the SSE endpoint.
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event types published by vault-api.
+const (
+	TypeEvidenceStatus = "evidence.status"
+	TypeCheckpoint     = "checkpoint"
+)
+
+// Event is one notification. IDs increase monotonically per process so
+// clients can resume with Last-Event-ID.
+type Event struct {
+	ID   uint64      `json:"id"`
+	Type string      `json:"type"`
+	Time time.Time   `json:"time"`
+	Data interface{} `json:"data"`
+}
+
+// StatusChange is the payload of an evidence.status event.
+type StatusChange struct {
+	EvidenceID string `json:"evidence_id"`
+	From       string `json:"from,omitempty"`
+	To         string `json:"to"`
+	LeafIndex  *int64 `json:"leaf_index,omitempty"`
+}
+
+// Hub broadcasts events and keeps a bounded history for resuming clients.
+type Hub struct {
+	mu      sync.Mutex
+	seq     uint64
+	history []Event
+	limit   int
+	subs    map[*Subscription]struct{}
+}
+
+// Subscription receives events on C. C is closed when the subscriber falls
+// too far behind or is cancelled; the client should reconnect and resume
+// from the last event it saw.
+type Subscription struct {
+	C   <-chan Event
+	ch  chan Event
+	hub *Hub
+}
+
+const subscriberBuffer = 256
+
+// NewHub returns a hub that retains the last history events.
+func NewHub(history int) *Hub {
+	return &Hub{limit: history, subs: map[*Subscription]struct{}{}}
+}
+
+// Publish assigns the next ID to an event and delivers it to subscribers.
+func (h *Hub) Publish(typ string, data interface{}) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.seq++
+	e := Event{ID: h.seq, Type: typ, Time: time.Now().UTC(), Data: data}
+	if h.limit > 0 {
+		if len(h.history) == h.limit {
+			copy(h.history, h.history[1:])
+			h.history = h.history[:h.limit-1]
+		}
+		h.history = append(h.history, e)
+	}
+	for s := range h.subs {
+		select {
+		case s.ch <- e:
+		default:
+			// Slow consumer: drop it rather than block publishers.
+			delete(h.subs, s)
+			close(s.ch)
+		}
+	}
+	return e
+}
+
+// Subscribe registers a subscriber and returns the retained events newer
+// than afterID, which the caller should send before reading from C.
+func (h *Hub) Subscribe(afterID uint64) (*Subscription, []Event) {
+	ch := make(chan Event, subscriberBuffer)
+	s := &Subscription{C: ch, ch: ch, hub: h}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var replay []Event
+	if afterID > 0 {
+		for _, e := range h.history {
+			if e.ID > afterID {
+				replay = append(replay, e)
+			}
+		}
+	}
+	h.subs[s] = struct{}{}
+	return s, replay
+}
+
+// Cancel unregisters the subscription.
+func (s *Subscription) Cancel() {
+	s.hub.mu.Lock()
+	defer s.hub.mu.Unlock()
+	if _, ok := s.hub.subs[s]; ok {
+		delete(s.hub.subs, s)
+		close(s.ch)
+	}
+}
@@ -0,0 +1,36 @@
+package events
+
+import "testing"
+
+func TestHubDeliversAndReplays(t *testing.T) {
+	h := NewHub(2)
+	first := h.Publish(TypeCheckpoint, map[string]int{"tree_size": 1})
+	h.Publish(TypeCheckpoint, map[string]int{"tree_size": 2})
+	h.Publish(TypeCheckpoint, map[string]int{"tree_size": 3})
+
+	sub, replay := h.Subscribe(first.ID)
+	defer sub.Cancel()
+	if len(replay) != 2 || replay[0].ID != 2 || replay[1].ID != 3 {
+		t.Fatalf("unexpected replay %+v", replay)
+	}
+	live := h.Publish(TypeEvidenceStatus, StatusChange{EvidenceID: "e", To: "stored"})
+	if got := <-sub.C; got.ID != live.ID {
+		t.Fatalf("expected live event %d, got %d", live.ID, got.ID)
+	}
+}
+
+func TestHubDropsSlowSubscriber(t *testing.T) {
+	h := NewHub(0)
+	sub, _ := h.Subscribe(0)
+	for i := 0; i <= subscriberBuffer; i++ {
+		h.Publish(TypeCheckpoint, i)
+	}
+	n := 0
+	for range sub.C {
+		n++
+	}
+	if n != subscriberBuffer {
+		t.Fatalf("expected %d buffered events before close, got %d", subscriberBuffer, n)
+	}
+	sub.Cancel() // must not panic after the hub closed the channel
+}
@@ -0,0 +1,95 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+// ErrUnknownEvidence is returned by a LeafStore for IDs it has no record of.
+var ErrUnknownEvidence = errors.New("unknown evidence")
+
+// LeafStore assigns leaf indices from the store's sequence, which is the
+// only source of leaf indices: the Merkle trees are rebuilt from it.
+type LeafStore interface {
+	// AssignLeaf gives id the next leaf index unless it already has one. It
+	// returns the record's leaf index and whether this call assigned it.
+	AssignLeaf(ctx context.Context, id string) (int64, bool, error)
+}
+
+// Appender performs the idempotent append step of the consumer: a record
+// that already has a leaf index is skipped, so redelivered events never
+// produce a second leaf.
+type Appender struct {
+	leaves LeafStore
+}
+
+func NewAppender(leaves LeafStore) *Appender {
+	return &Appender{leaves: leaves}
+}
+
+// Append sequences ev's record unless it already is. It returns the
+// record's leaf index and whether this call sequenced it. A failed append
+// assigns nothing and can simply be retried.
+func (a *Appender) Append(ctx context.Context, ev IngestEvent) (int64, bool, error) {
+	leaf, assigned, err := a.leaves.AssignLeaf(ctx, ev.EvidenceID)
+	if err != nil {
+		return 0, false, fmt.Errorf("assign leaf index: %w", err)
+	}
+	return leaf, assigned, nil
+}
+
+// Handle is a Handler for the ingest topic. Malformed events and events for
+// unknown evidence are poison and go straight to the dead-letter topic.
+func (a *Appender) Handle(ctx context.Context, msg Message) error {
+	ev, err := decodeIngest(msg)
+	if err != nil {
+		return err
+	}
+	return a.handle(ctx, ev)
+}
+
+func (a *Appender) handle(ctx context.Context, ev IngestEvent) error {
+	_, _, err := a.Append(ctx, ev)
+	if errors.Is(err, ErrUnknownEvidence) {
+		return Permanent(err)
+	}
+	return err
+}
+
+func decodeIngest(msg Message) (IngestEvent, error) {
+	var ev IngestEvent
+	if err := json.Unmarshal(msg.Value, &ev); err != nil {
+		return ev, Permanent(fmt.Errorf("decode ingest event: %w", err))
+	}
+	if ev.EvidenceID == "" || len(ev.LeafData) == 0 {
+		return ev, Permanent(errors.New("ingest event lacks evidence_id or leaf_data"))
+	}
+	return ev, nil
+}
+
+// Appenders routes ingest events to the appender of their tenant, keyed by
+// tenant ID. Events without a tenant go to Default.
+type Appenders struct {
+	Default  string
+	ByTenant map[string]*Appender
+}
+
+// Handle is a Handler for the ingest topic of a multi-tenant vault. Events
+// for tenants without an appender are poison.
+func (r Appenders) Handle(ctx context.Context, msg Message) error {
+	ev, err := decodeIngest(msg)
+	if err != nil {
+		return err
+	}
+	tenant := ev.Tenant
+	if tenant == "" {
+		tenant = r.Default
+	}
+	a, ok := r.ByTenant[tenant]
+	if !ok {
+		return Permanent(fmt.Errorf("ingest event for unknown tenant %q", tenant))
+	}
+	return a.handle(ctx, ev)
+}
@@ -0,0 +1,190 @@
+// Package pipeline moves admitted evidence through an ingest topic to a
+// consumer group that gives it the next leaf index of its tenant's log.
+// Delivery is at-least-once; the appender makes redelivery harmless.
+package pipeline
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+)
+
+// Message is one record on a topic partition.
+type Message struct {
+	Topic     string
+	Partition int
+	Offset    int64
+	Key       []byte
+	Value     []byte
+	Headers   map[string]string
+}
+
+// Broker is the subset of a Kafka-compatible log the pipeline relies on:
+// acknowledged keyed produce and consumer group consumption. Messages with
+// the same key land on the same partition, in order.
+type Broker interface {
+	// Produce returns once the message is durably on the topic.
+	Produce(ctx context.Context, topic string, key, value []byte, headers map[string]string) (Message, error)
+	// Consume joins group on topic and calls handle with the messages of
+	// every partition assigned to this member, one goroutine per partition,
+	// strictly in offset order. An offset is committed only after handle
+	// returned true for its message; when handle returns false the
+	// partition stops where it is. Consume blocks until ctx ends.
+	Consume(ctx context.Context, group, topic string, handle func(ctx context.Context, msg Message) bool) error
+}
+
+// MemoryBroker is an in-process Broker for tests. Topics are created on
+// first use with a fixed partition count, and a group's single member is
+// assigned every partition.
+type MemoryBroker struct {
+	partitions int
+
+	mu      sync.Mutex
+	topics  map[string][][]Message
+	offsets map[offsetKey]int64
+	notify  chan struct{}
+}
+
+type offsetKey struct {
+	group     string
+	topic     string
+	partition int
+}
+
+// NewMemoryBroker returns a broker whose topics have the given number of
+// partitions.
+func NewMemoryBroker(partitions int) *MemoryBroker {
+	if partitions <= 0 {
+		partitions = 1
+	}
+	return &MemoryBroker{
+		partitions: partitions,
+		topics:     map[string][][]Message{},
+		offsets:    map[offsetKey]int64{},
+		notify:     make(chan struct{}),
+	}
+}
+
+func (b *MemoryBroker) topicLocked(topic string) [][]Message {
+	parts, ok := b.topics[topic]
+	if !ok {
+		parts = make([][]Message, b.partitions)
+		b.topics[topic] = parts
+	}
+	return parts
+}
+
+func (b *MemoryBroker) Produce(ctx context.Context, topic string, key, value []byte, headers map[string]string) (Message, error) {
+	if err := ctx.Err(); err != nil {
+		return Message{}, err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	parts := b.topicLocked(topic)
+	p := partitionFor(key, len(parts))
+	msg := Message{Topic: topic, Partition: p, Offset: int64(len(parts[p])), Key: key, Value: value, Headers: headers}
+	parts[p] = append(parts[p], msg)
+	close(b.notify)
+	b.notify = make(chan struct{})
+	return msg, nil
+}
+
+func (b *MemoryBroker) Partitions(ctx context.Context, topic string) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.topicLocked(topic)), nil
+}
+
+func (b *MemoryBroker) Fetch(ctx context.Context, topic string, partition int, offset int64, max int) ([]Message, error) {
+	for {
+		b.mu.Lock()
+		parts := b.topicLocked(topic)
+		if partition < 0 || partition >= len(parts) {
+			b.mu.Unlock()
+			return nil, errUnknownPartition
+		}
+		log := parts[partition]
+		if offset < int64(len(log)) {
+			end := int64(len(log))
+			if max > 0 && offset+int64(max) < end {
+				end = offset + int64(max)
+			}
+			out := append([]Message(nil), log[offset:end]...)
+			b.mu.Unlock()
+			return out, nil
+		}
+		wait := b.notify
+		b.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-wait:
+		}
+	}
+}
+
+func (b *MemoryBroker) CommittedOffset(ctx context.Context, group, topic string, partition int) (int64, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.offsets[offsetKey{group, topic, partition}], nil
+}
+
+func (b *MemoryBroker) CommitOffset(ctx context.Context, group, topic string, partition int, offset int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.offsets[offsetKey{group, topic, partition}] = offset
+	return nil
+}
+
+func (b *MemoryBroker) Consume(ctx context.Context, group, topic string, handle func(ctx context.Context, msg Message) bool) error {
+	n, err := b.Partitions(ctx, topic)
+	if err != nil {
+		return err
+	}
+	var wg sync.WaitGroup
+	for p := 0; p < n; p++ {
+		wg.Add(1)
+		go func(p int) {
+			defer wg.Done()
+			b.consumePartition(ctx, group, topic, p, handle)
+		}(p)
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+func (b *MemoryBroker) consumePartition(ctx context.Context, group, topic string, partition int, handle func(ctx context.Context, msg Message) bool) {
+	offset, _ := b.CommittedOffset(ctx, group, topic, partition)
+	for {
+		msgs, err := b.Fetch(ctx, topic, partition, offset, 100)
+		if err != nil {
+			return
+		}
+		for _, msg := range msgs {
+			if !handle(ctx, msg) {
+				return
+			}
+			offset = msg.Offset + 1
+			_ = b.CommitOffset(ctx, group, topic, partition, offset)
+		}
+	}
+}
+
+// Messages returns a copy of every message on topic, partition by partition.
+func (b *MemoryBroker) Messages(topic string) []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	var out []Message
+	for _, log := range b.topicLocked(topic) {
+		out = append(out, log...)
+	}
+	return out
+}
+
+// partitionFor hashes key onto a partition the way Kafka's default
+// partitioner keeps equal keys together.
+func partitionFor(key []byte, n int) int {
+	h := fnv.New32a()
+	h.Write(key)
+	return int(h.Sum32() % uint32(n))
+}
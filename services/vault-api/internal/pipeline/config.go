@@ -0,0 +1,135 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errUnknownPartition = errors.New("unknown partition")
+
+// Config selects the broker and tunes publishing and consumption.
+type Config struct {
+	// Broker names the Broker implementation; only "kafka" is built in.
+	Broker string
+	// Brokers are the Kafka seed brokers, host:port.
+	Brokers []string
+	// TLS dials the brokers over TLS, verified against the system roots.
+	TLS bool
+	// Partitions is the partition count of the memory broker in tests.
+	Partitions  int
+	IngestTopic string
+	Group       string
+	// MaxAttempts bounds publish retries and per-message processing
+	// attempts before a message is dead-lettered.
+	MaxAttempts  int
+	RetryBackoff time.Duration
+	// RetryBackoffMax caps the exponential backoff between attempts.
+	RetryBackoffMax time.Duration
+	// ReconcileAfter is how long a record may stay pending after its
+	// ingest event left the outbox before it is sequenced directly; zero
+	// disables the reconciler.
+	ReconcileAfter time.Duration
+}
+
+// DefaultConfig matches the topic and group names in the deployment
+// manifests.
+func DefaultConfig() Config {
+	return Config{
+		Broker:          "kafka",
+		Partitions:      4,
+		IngestTopic:     "vault.ingest",
+		Group:           "vault-pipeline",
+		MaxAttempts:     5,
+		RetryBackoff:    200 * time.Millisecond,
+		RetryBackoffMax: 10 * time.Second,
+		ReconcileAfter:  5 * time.Minute,
+	}
+}
+
+// DeadLetterTopic receives messages that exhausted their attempts or were
+// rejected as poison.
+func (c Config) DeadLetterTopic() string {
+	return c.IngestTopic + ".dlq"
+}
+
+// ConfigFromEnv reads PIPELINE_* settings. ok is false when
+// PIPELINE_BROKER is unset and ingest should be sequenced in-process.
+func ConfigFromEnv() (cfg Config, ok bool, err error) {
+	cfg = DefaultConfig()
+	broker := strings.ToLower(strings.TrimSpace(os.Getenv("PIPELINE_BROKER")))
+	if broker == "" {
+		return cfg, false, nil
+	}
+	if broker != "kafka" {
+		return cfg, false, fmt.Errorf("unsupported PIPELINE_BROKER %q", broker)
+	}
+	cfg.Broker = broker
+	for _, b := range strings.Split(os.Getenv("PIPELINE_BROKERS"), ",") {
+		if b = strings.TrimSpace(b); b != "" {
+			cfg.Brokers = append(cfg.Brokers, b)
+		}
+	}
+	if len(cfg.Brokers) == 0 {
+		return cfg, false, errors.New("PIPELINE_BROKERS is required with PIPELINE_BROKER=kafka")
+	}
+	if raw := strings.TrimSpace(os.Getenv("PIPELINE_TLS")); raw != "" {
+		on, err := strconv.ParseBool(raw)
+		if err != nil {
+			return cfg, false, fmt.Errorf("invalid PIPELINE_TLS %q", raw)
+		}
+		cfg.TLS = on
+	}
+	if raw := strings.TrimSpace(os.Getenv("PIPELINE_MAX_ATTEMPTS")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return cfg, false, fmt.Errorf("invalid PIPELINE_MAX_ATTEMPTS %q", raw)
+		}
+		cfg.MaxAttempts = n
+	}
+	if raw := strings.TrimSpace(os.Getenv("PIPELINE_RETRY_BACKOFF")); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return cfg, false, fmt.Errorf("invalid PIPELINE_RETRY_BACKOFF %q", raw)
+		}
+		cfg.RetryBackoff = d
+	}
+	if raw := strings.TrimSpace(os.Getenv("PIPELINE_RECONCILE_AFTER")); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return cfg, false, fmt.Errorf("invalid PIPELINE_RECONCILE_AFTER %q", raw)
+		}
+		cfg.ReconcileAfter = d
+	}
+	if v := strings.TrimSpace(os.Getenv("PIPELINE_INGEST_TOPIC")); v != "" {
+		cfg.IngestTopic = v
+	}
+	if v := strings.TrimSpace(os.Getenv("PIPELINE_CONSUMER_GROUP")); v != "" {
+		cfg.Group = v
+	}
+	return cfg, true, nil
+}
+
+// NewBroker builds the broker named by cfg.Broker.
+func NewBroker(cfg Config) (Broker, error) {
+	switch cfg.Broker {
+	case "kafka":
+		return NewKafkaBroker(cfg)
+	default:
+		return nil, fmt.Errorf("unsupported broker %q", cfg.Broker)
+	}
+}
+
+func (c Config) backoff(attempt int) time.Duration {
+	d := c.RetryBackoff
+	for i := 1; i < attempt && d < c.RetryBackoffMax; i++ {
+		d *= 2
+	}
+	if c.RetryBackoffMax > 0 && d > c.RetryBackoffMax {
+		d = c.RetryBackoffMax
+	}
+	return d
+}
@@ -2,15 +2,126 @@ package pipeline
 
 import (
 	"context"
-	"log"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Handler processes one message. Returning an error retries the message;
+// wrap it with Permanent to dead-letter it immediately.
+type Handler func(ctx context.Context, msg Message) error
+
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as unrecoverable: the message is poison and retrying
+// cannot help.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err}
+}
+
+// IsPermanent reports whether err was marked with Permanent.
+func IsPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
+
+// Headers added to dead-lettered messages.
+const (
+	HeaderError           = "x-error"
+	HeaderAttempts        = "x-attempts"
+	HeaderSourceTopic     = "x-source-topic"
+	HeaderSourcePartition = "x-source-partition"
+	HeaderSourceOffset    = "x-source-offset"
 )
 
-type Consumer struct{}
+// Consumer processes the ingest topic as a member of cfg.Group. Each
+// assigned partition is handled strictly in offset order: a message's offset
+// is committed only after it was handled or dead-lettered, so a crash or a
+// rebalance replays it.
+type Consumer struct {
+	broker  Broker
+	cfg     Config
+	handler Handler
+	// OnDeadLetter, when set, is called after a message is dead-lettered.
+	OnDeadLetter func(msg Message, err error)
+}
 
-func NewConsumer(brokers []string, group string, topics []string) *Consumer { return &Consumer{} }
+func NewConsumer(b Broker, cfg Config, h Handler) *Consumer {
+	return &Consumer{broker: b, cfg: cfg, handler: h}
+}
 
+// Start consumes the partitions the broker assigns to this member until ctx
+// ends.
 func (c *Consumer) Start(ctx context.Context) {
-	// TODO: consume messages, idempotent append, persist leaf index
-	log.Println("pipeline consumer started (stub)")
-	<-ctx.Done()
+	log.Info().Str("topic", c.cfg.IngestTopic).Str("group", c.cfg.Group).Msg("pipeline consumer started")
+	if err := c.broker.Consume(ctx, c.cfg.Group, c.cfg.IngestTopic, c.process); err != nil && ctx.Err() == nil {
+		log.Error().Err(err).Str("topic", c.cfg.IngestTopic).Str("group", c.cfg.Group).Msg("pipeline consumer stopped")
+	}
+}
+
+// process handles msg with retries and dead-letters it when it cannot be
+// handled. It returns false only when ctx ended first, leaving the message
+// uncommitted.
+func (c *Consumer) process(ctx context.Context, msg Message) bool {
+	var err error
+	attempt := 1
+	for ; ; attempt++ {
+		if err = c.handler(ctx, msg); err == nil {
+			return true
+		}
+		if ctx.Err() != nil {
+			return false
+		}
+		if IsPermanent(err) || attempt >= c.cfg.MaxAttempts {
+			break
+		}
+		log.Warn().Err(err).Int("partition", msg.Partition).Int64("offset", msg.Offset).Int("attempt", attempt).Msg("pipeline message failed; retrying")
+		if !sleep(ctx, c.cfg.backoff(attempt)) {
+			return false
+		}
+	}
+	return c.deadLetter(ctx, msg, err, attempt)
+}
+
+func (c *Consumer) deadLetter(ctx context.Context, msg Message, cause error, attempts int) bool {
+	headers := map[string]string{
+		HeaderError:           cause.Error(),
+		HeaderAttempts:        strconv.Itoa(attempts),
+		HeaderSourceTopic:     msg.Topic,
+		HeaderSourcePartition: strconv.Itoa(msg.Partition),
+		HeaderSourceOffset:    strconv.FormatInt(msg.Offset, 10),
+	}
+	// never commit past a message that is neither handled nor parked
+	for {
+		_, err := c.broker.Produce(ctx, c.cfg.DeadLetterTopic(), msg.Key, msg.Value, headers)
+		if err == nil {
+			break
+		}
+		log.Warn().Err(err).Str("topic", c.cfg.DeadLetterTopic()).Msg("pipeline dead-letter publish failed; retrying")
+		if !sleep(ctx, c.cfg.RetryBackoff) {
+			return false
+		}
+	}
+	log.Error().Err(cause).Int("partition", msg.Partition).Int64("offset", msg.Offset).Int("attempts", attempts).Str("dead_letter_topic", c.cfg.DeadLetterTopic()).Msg("pipeline message dead-lettered")
+	if c.OnDeadLetter != nil {
+		c.OnDeadLetter(msg, cause)
+	}
+	return true
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
 }
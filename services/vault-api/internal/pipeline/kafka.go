@@ -0,0 +1,231 @@
+package pipeline
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"github.com/twmb/franz-go/pkg/kgo"
+)
+
+// KafkaBroker is a Broker over a Kafka or Redpanda cluster. Produce waits
+// for every in-sync replica to acknowledge the record, and Consume joins a
+// consumer group: the group's members share the partitions, which move to
+// the survivors when a member leaves. Topics are not created here; they
+// come from the deployment.
+type KafkaBroker struct {
+	cfg      Config
+	producer *kgo.Client
+}
+
+// NewKafkaBroker connects a producer to cfg.Brokers.
+func NewKafkaBroker(cfg Config) (*KafkaBroker, error) {
+	if len(cfg.Brokers) == 0 {
+		return nil, errors.New("kafka broker: no seed brokers")
+	}
+	producer, err := kgo.NewClient(append(kafkaOptions(cfg),
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+		// keys are hashed like the Java client's, so a batch shares a
+		// partition with the records producers in other languages key alike
+		kgo.RecordPartitioner(kgo.StickyKeyPartitioner(nil)),
+	)...)
+	if err != nil {
+		return nil, err
+	}
+	return &KafkaBroker{cfg: cfg, producer: producer}, nil
+}
+
+func kafkaOptions(cfg Config) []kgo.Opt {
+	opts := []kgo.Opt{kgo.SeedBrokers(cfg.Brokers...), kgo.ClientID("vault-api")}
+	if cfg.TLS {
+		opts = append(opts, kgo.DialTLSConfig(&tls.Config{MinVersion: tls.VersionTLS12}))
+	}
+	return opts
+}
+
+// Close releases the producer's connections. Produce is synchronous, so
+// nothing is left buffered.
+func (b *KafkaBroker) Close() {
+	b.producer.Close()
+}
+
+func (b *KafkaBroker) Produce(ctx context.Context, topic string, key, value []byte, headers map[string]string) (Message, error) {
+	rec := &kgo.Record{Topic: topic, Key: key, Value: value}
+	for k, v := range headers {
+		rec.Headers = append(rec.Headers, kgo.RecordHeader{Key: k, Value: []byte(v)})
+	}
+	if err := b.producer.ProduceSync(ctx, rec).FirstErr(); err != nil {
+		return Message{}, err
+	}
+	return messageFromRecord(rec), nil
+}
+
+func (b *KafkaBroker) Consume(ctx context.Context, group, topic string, handle func(ctx context.Context, msg Message) bool) error {
+	g := &kafkaGroup{ctx: ctx, topic: topic, handle: handle, backoff: b.cfg.RetryBackoff, partitions: map[int32]*kafkaPartition{}}
+	cl, err := kgo.NewClient(append(kafkaOptions(b.cfg),
+		kgo.ConsumerGroup(group),
+		kgo.ConsumeTopics(topic),
+		kgo.ConsumeResetOffset(kgo.NewOffset().AtStart()),
+		kgo.DisableAutoCommit(),
+		// revocations wait for the records already polled to be handed
+		// over, so a partition is never fed after it was given away
+		kgo.BlockRebalanceOnPoll(),
+		kgo.OnPartitionsAssigned(g.assigned),
+		kgo.OnPartitionsRevoked(g.revoked),
+		kgo.OnPartitionsLost(g.revoked),
+	)...)
+	if err != nil {
+		return err
+	}
+	defer cl.Close()
+	defer g.stopAll()
+	for {
+		fetches := cl.PollFetches(ctx)
+		if ctx.Err() != nil || fetches.IsClientClosed() {
+			return ctx.Err()
+		}
+		fetches.EachError(func(t string, p int32, err error) {
+			log.Warn().Err(err).Str("topic", t).Int32("partition", p).Msg("pipeline fetch failed; retrying")
+		})
+		fetches.EachPartition(func(p kgo.FetchTopicPartition) {
+			if len(p.Records) > 0 {
+				g.feed(p.Partition, p.Records)
+			}
+		})
+		cl.AllowRebalance()
+	}
+}
+
+// commitTimeout bounds one offset commit.
+const commitTimeout = 10 * time.Second
+
+// kafkaGroup runs one goroutine per partition assigned to this member.
+type kafkaGroup struct {
+	ctx     context.Context
+	topic   string
+	handle  func(ctx context.Context, msg Message) bool
+	backoff time.Duration
+
+	mu         sync.Mutex
+	partitions map[int32]*kafkaPartition
+}
+
+type kafkaPartition struct {
+	cancel context.CancelFunc
+	recs   chan []*kgo.Record
+	done   chan struct{}
+}
+
+func (g *kafkaGroup) assigned(_ context.Context, cl *kgo.Client, assigned map[string][]int32) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	for _, p := range assigned[g.topic] {
+		ctx, cancel := context.WithCancel(g.ctx)
+		kp := &kafkaPartition{cancel: cancel, recs: make(chan []*kgo.Record, 4), done: make(chan struct{})}
+		g.partitions[p] = kp
+		go g.run(ctx, cl, p, kp)
+	}
+	log.Info().Str("topic", g.topic).Interface("partitions", assigned[g.topic]).Msg("pipeline partitions assigned")
+}
+
+// revoked stops the partitions this member no longer owns. Records they
+// had not committed are replayed by the new owner.
+func (g *kafkaGroup) revoked(_ context.Context, _ *kgo.Client, revoked map[string][]int32) {
+	g.stop(revoked[g.topic])
+}
+
+func (g *kafkaGroup) stop(partitions []int32) {
+	g.mu.Lock()
+	var stopped []*kafkaPartition
+	for _, p := range partitions {
+		if kp, ok := g.partitions[p]; ok {
+			kp.cancel()
+			stopped = append(stopped, kp)
+			delete(g.partitions, p)
+		}
+	}
+	g.mu.Unlock()
+	for _, kp := range stopped {
+		<-kp.done
+	}
+}
+
+func (g *kafkaGroup) stopAll() {
+	g.mu.Lock()
+	var all []int32
+	for p := range g.partitions {
+		all = append(all, p)
+	}
+	g.mu.Unlock()
+	g.stop(all)
+}
+
+func (g *kafkaGroup) feed(partition int32, recs []*kgo.Record) {
+	g.mu.Lock()
+	kp, ok := g.partitions[partition]
+	g.mu.Unlock()
+	if !ok {
+		return
+	}
+	select {
+	case kp.recs <- recs:
+	case <-kp.done:
+	}
+}
+
+// run handles a partition's records in order and commits after each
+// polled batch, or after the last handled record when it stops early.
+func (g *kafkaGroup) run(ctx context.Context, cl *kgo.Client, partition int32, kp *kafkaPartition) {
+	defer close(kp.done)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case recs := <-kp.recs:
+			var last *kgo.Record
+			for _, r := range recs {
+				if !g.handle(ctx, messageFromRecord(r)) {
+					break
+				}
+				last = r
+			}
+			if last != nil {
+				g.commit(ctx, cl, partition, last)
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}
+}
+
+// commit commits through r. It still tries once after ctx ended, while a
+// revocation waits for this partition, so the new owner replays less.
+func (g *kafkaGroup) commit(ctx context.Context, cl *kgo.Client, partition int32, r *kgo.Record) {
+	for {
+		cctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), commitTimeout)
+		err := cl.CommitRecords(cctx, r)
+		cancel()
+		if err == nil {
+			return
+		}
+		log.Warn().Err(err).Int32("partition", partition).Int64("offset", r.Offset+1).Msg("pipeline offset commit failed; retrying")
+		if !sleep(ctx, g.backoff) {
+			return
+		}
+	}
+}
+
+func messageFromRecord(r *kgo.Record) Message {
+	msg := Message{Topic: r.Topic, Partition: int(r.Partition), Offset: r.Offset, Key: r.Key, Value: r.Value}
+	if len(r.Headers) > 0 {
+		msg.Headers = make(map[string]string, len(r.Headers))
+		for _, h := range r.Headers {
+			msg.Headers[h.Key] = string(h.Value)
+		}
+	}
+	return msg
+}
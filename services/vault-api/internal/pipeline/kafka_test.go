@@ -0,0 +1,101 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// kafkaTestConfig points at the cluster in PIPELINE_TEST_BROKERS and
+// creates a fresh topic with the given partitions.
+func kafkaTestConfig(t *testing.T, partitions int32) Config {
+	t.Helper()
+	raw := os.Getenv("PIPELINE_TEST_BROKERS")
+	if raw == "" {
+		t.Skip("PIPELINE_TEST_BROKERS not set; skipping Kafka broker test")
+	}
+	cfg := testConfig()
+	cfg.Broker = "kafka"
+	cfg.Brokers = strings.Split(raw, ",")
+	cfg.IngestTopic = "vault.test." + uuid.NewString()
+	cfg.Group = "vault-test-" + uuid.NewString()
+
+	admin, err := kgo.NewClient(kgo.SeedBrokers(cfg.Brokers...))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	req := kmsg.NewPtrCreateTopicsRequest()
+	topic := kmsg.NewCreateTopicsRequestTopic()
+	topic.Topic = cfg.IngestTopic
+	topic.NumPartitions = partitions
+	topic.ReplicationFactor = 1
+	req.Topics = append(req.Topics, topic)
+	req.TimeoutMillis = 10000
+	resp, err := req.RequestWith(context.Background(), admin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code := resp.Topics[0].ErrorCode; code != 0 {
+		t.Fatalf("create topic: error code %d", code)
+	}
+	return cfg
+}
+
+func TestKafkaBrokerConsumesGroupInKeyOrder(t *testing.T) {
+	cfg := kafkaTestConfig(t, 3)
+	b, err := NewKafkaBroker(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+
+	ctx := context.Background()
+	for i := 0; i < 6; i++ {
+		key := fmt.Sprint("k", i%2)
+		if _, err := b.Produce(ctx, cfg.IngestTopic, []byte(key), []byte(fmt.Sprint(key, "-", i)), map[string]string{"n": fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var (
+		mu  sync.Mutex
+		got = map[string][]string{}
+	)
+	consume := func(stopAfter int) {
+		runCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		seen := 0
+		_ = b.Consume(runCtx, cfg.Group, cfg.IngestTopic, func(_ context.Context, msg Message) bool {
+			mu.Lock()
+			defer mu.Unlock()
+			got[string(msg.Key)] = append(got[string(msg.Key)], string(msg.Value))
+			if seen++; seen == stopAfter {
+				cancel()
+			}
+			return true
+		})
+	}
+	consume(6)
+	if fmt.Sprint(got["k0"]) != "[k0-0 k0-2 k0-4]" || fmt.Sprint(got["k1"]) != "[k1-1 k1-3 k1-5]" {
+		t.Fatalf("unexpected consumption %v", got)
+	}
+
+	// a new member of the group resumes after the committed offsets
+	if _, err := b.Produce(ctx, cfg.IngestTopic, []byte("k0"), []byte("k0-6"), nil); err != nil {
+		t.Fatal(err)
+	}
+	got = map[string][]string{}
+	consume(1)
+	if fmt.Sprint(got) != "map[k0:[k0-6]]" {
+		t.Fatalf("committed messages were replayed: %v", got)
+	}
+}
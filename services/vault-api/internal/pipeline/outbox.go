@@ -0,0 +1,82 @@
+package pipeline
+
+import (
+	"context"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+	"github.com/rs/zerolog/log"
+)
+
+// Relay publishes outbox messages to the broker and marks them delivered
+// once Produce has returned, that is once the broker acknowledged the
+// message as durable. A crash after publishing but before marking
+// republishes the message, so delivery is at-least-once; consumers must be
+// idempotent.
+type Relay struct {
+	outbox store.OutboxStore
+	broker Broker
+	cfg    Config
+
+	// Interval is the idle poll period once the outbox is drained.
+	Interval time.Duration
+	// BatchSize bounds messages claimed per round.
+	BatchSize int
+	// Lease is how long claimed messages are reserved for this relay.
+	Lease time.Duration
+}
+
+func NewRelay(outbox store.OutboxStore, b Broker, cfg Config) *Relay {
+	return &Relay{outbox: outbox, broker: b, cfg: cfg, Interval: 100 * time.Millisecond, BatchSize: 100, Lease: 30 * time.Second}
+}
+
+// Run relays until ctx ends.
+func (r *Relay) Run(ctx context.Context) {
+	for {
+		n, err := r.RelayOnce(ctx)
+		if err != nil && ctx.Err() == nil {
+			log.Warn().Err(err).Msg("outbox relay round failed")
+		}
+		if n > 0 && err == nil {
+			continue
+		}
+		if !sleep(ctx, r.Interval) {
+			return
+		}
+	}
+}
+
+// RelayOnce publishes one claimed batch and returns how many messages were
+// delivered. After a failed publish the remaining messages with the same
+// key are released untouched so they are not published out of order.
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	msgs, err := r.outbox.ClaimOutbox(ctx, r.BatchSize, r.Lease)
+	if err != nil || len(msgs) == 0 {
+		return 0, err
+	}
+	blocked := map[string]bool{}
+	var delivered, released []int64
+	for _, msg := range msgs {
+		if blocked[msg.Key] {
+			released = append(released, msg.ID)
+			continue
+		}
+		if _, err := r.broker.Produce(ctx, msg.Topic, []byte(msg.Key), msg.Payload, nil); err != nil {
+			blocked[msg.Key] = true
+			retryAt := time.Now().Add(r.cfg.backoff(msg.Attempts + 1))
+			log.Warn().Err(err).Int64("outbox_id", msg.ID).Int("attempts", msg.Attempts+1).Time("retry_at", retryAt).Msg("outbox publish failed")
+			if ferr := r.outbox.MarkOutboxFailed(ctx, msg.ID, err.Error(), retryAt); ferr != nil {
+				log.Warn().Err(ferr).Int64("outbox_id", msg.ID).Msg("outbox failure not recorded; lease will expire")
+			}
+			continue
+		}
+		delivered = append(delivered, msg.ID)
+	}
+	if err := r.outbox.ReleaseOutbox(ctx, released); err != nil {
+		log.Warn().Err(err).Msg("outbox release failed; leases will expire")
+	}
+	if err := r.outbox.MarkOutboxDelivered(ctx, delivered); err != nil {
+		return 0, err
+	}
+	return len(delivered), nil
+}
@@ -0,0 +1,405 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+)
+
+func testConfig() Config {
+	cfg := DefaultConfig()
+	cfg.Partitions = 3
+	cfg.MaxAttempts = 3
+	cfg.RetryBackoff = time.Millisecond
+	cfg.RetryBackoffMax = 5 * time.Millisecond
+	return cfg
+}
+
+// memLeaves is a LeafStore over a map with its own leaf sequence; failSet
+// makes the next AssignLeaf calls fail.
+type memLeaves struct {
+	mu      sync.Mutex
+	known   map[string]*int64
+	next    int64
+	failSet int
+}
+
+func newMemLeaves(ids ...string) *memLeaves {
+	l := &memLeaves{known: map[string]*int64{}}
+	for _, id := range ids {
+		l.known[id] = nil
+	}
+	return l
+}
+
+func (l *memLeaves) LeafIndex(id string) *int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.known[id]
+}
+
+func (l *memLeaves) AssignLeaf(ctx context.Context, id string) (int64, bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cur, ok := l.known[id]
+	if !ok {
+		return 0, false, ErrUnknownEvidence
+	}
+	if cur != nil {
+		return *cur, false, nil
+	}
+	if l.failSet > 0 {
+		l.failSet--
+		return 0, false, errors.New("database unavailable")
+	}
+	leaf := l.next
+	l.next++
+	l.known[id] = &leaf
+	return leaf, true, nil
+}
+
+func ingestValue(t *testing.T, id string) []byte {
+	t.Helper()
+	b, err := json.Marshal(IngestEvent{EvidenceID: id, LeafData: []byte("leaf:" + id), ContentHash: id})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestMemoryBrokerKeepsKeysOrderedOnOnePartition(t *testing.T) {
+	ctx := context.Background()
+	b := NewMemoryBroker(4)
+	var first Message
+	for i := 0; i < 5; i++ {
+		msg, err := b.Produce(ctx, "t", []byte("batch-1"), []byte(fmt.Sprint(i)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 0 {
+			first = msg
+		}
+		if msg.Partition != first.Partition || msg.Offset != int64(i) {
+			t.Fatalf("message %d landed on %d/%d", i, msg.Partition, msg.Offset)
+		}
+	}
+	got, err := b.Fetch(ctx, "t", first.Partition, 2, 2)
+	if err != nil || len(got) != 2 || string(got[0].Value) != "2" || string(got[1].Value) != "3" {
+		t.Fatalf("unexpected fetch %+v %v", got, err)
+	}
+
+	done := make(chan []Message)
+	go func() {
+		msgs, _ := b.Fetch(ctx, "t", first.Partition, 5, 10)
+		done <- msgs
+	}()
+	_, _ = b.Produce(ctx, "t", []byte("batch-1"), []byte("5"), nil)
+	select {
+	case msgs := <-done:
+		if len(msgs) != 1 || string(msgs[0].Value) != "5" {
+			t.Fatalf("unexpected wakeup %+v", msgs)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("fetch did not wake on produce")
+	}
+
+	_ = b.CommitOffset(ctx, "g1", "t", first.Partition, 3)
+	if off, _ := b.CommittedOffset(ctx, "g1", "t", first.Partition); off != 3 {
+		t.Fatalf("committed offset %d", off)
+	}
+	if off, _ := b.CommittedOffset(ctx, "g2", "t", first.Partition); off != 0 {
+		t.Fatalf("groups must not share offsets, got %d", off)
+	}
+}
+
+func TestConsumerRetriesInOrderAndDeadLettersPoison(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := testConfig()
+	b := NewMemoryBroker(cfg.Partitions)
+	for _, v := range []string{"ok-1", "flaky", "poison", "stuck", "ok-2"} {
+		_, _ = b.Produce(ctx, cfg.IngestTopic, []byte("k"), []byte(v), nil)
+	}
+
+	var mu sync.Mutex
+	var handled []string
+	attempts := map[string]int{}
+	var dead []string
+	c := NewConsumer(b, cfg, func(ctx context.Context, msg Message) error {
+		mu.Lock()
+		defer mu.Unlock()
+		v := string(msg.Value)
+		attempts[v]++
+		switch {
+		case v == "flaky" && attempts[v] < 3:
+			return errors.New("engine busy")
+		case v == "poison":
+			return Permanent(errors.New("cannot decode"))
+		case v == "stuck":
+			return errors.New("always failing")
+		}
+		handled = append(handled, v)
+		return nil
+	})
+	c.OnDeadLetter = func(msg Message, err error) {
+		mu.Lock()
+		dead = append(dead, string(msg.Value))
+		mu.Unlock()
+	}
+	go c.Start(ctx)
+
+	waitFor(t, func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(handled) == 3
+	})
+	mu.Lock()
+	if fmt.Sprint(handled) != "[ok-1 flaky ok-2]" {
+		t.Fatalf("handled out of order: %v", handled)
+	}
+	if attempts["poison"] != 1 || attempts["stuck"] != cfg.MaxAttempts {
+		t.Fatalf("unexpected attempts %v", attempts)
+	}
+	if fmt.Sprint(dead) != "[poison stuck]" {
+		t.Fatalf("unexpected dead letters %v", dead)
+	}
+	mu.Unlock()
+
+	dlq := b.Messages(cfg.DeadLetterTopic())
+	if len(dlq) != 2 || dlq[1].Headers[HeaderAttempts] != "3" || dlq[1].Headers[HeaderSourceOffset] != "3" || dlq[0].Headers[HeaderError] != "cannot decode" {
+		t.Fatalf("unexpected dead-letter topic %+v", dlq)
+	}
+	partition := partitionFor([]byte("k"), cfg.Partitions)
+	waitFor(t, func() bool {
+		off, _ := b.CommittedOffset(ctx, cfg.Group, cfg.IngestTopic, partition)
+		return off == 5
+	})
+}
+
+func TestAppenderIsIdempotentAcrossRedelivery(t *testing.T) {
+	cfg := testConfig()
+	b := NewMemoryBroker(cfg.Partitions)
+	leaves := newMemLeaves("a", "b", "c")
+	app := NewAppender(leaves)
+	pub := NewPublisher(b, cfg)
+
+	ctx := context.Background()
+	for _, id := range []string{"a", "b", "a", "c", "b"} {
+		if err := pub.Publish(ctx, id, ingestValue(t, id)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_ = pub.Publish(ctx, "x", []byte("{not json"))
+	_ = pub.Publish(ctx, "zz", ingestValue(t, "zz"))
+
+	// first run stops before committing anything past the first message
+	runCtx, cancel := context.WithCancel(ctx)
+	first := NewConsumer(b, cfg, func(c context.Context, msg Message) error {
+		err := app.Handle(c, msg)
+		cancel()
+		return err
+	})
+	first.Start(runCtx)
+
+	// a fresh member of the group resumes from committed offsets and sees
+	// redeliveries
+	runCtx, cancel = context.WithCancel(ctx)
+	defer cancel()
+	go NewConsumer(b, cfg, app.Handle).Start(runCtx)
+	waitFor(t, func() bool { return len(b.Messages(cfg.DeadLetterTopic())) == 2 })
+	waitFor(t, func() bool {
+		for _, id := range []string{"a", "b", "c"} {
+			if leaves.LeafIndex(id) == nil {
+				return false
+			}
+		}
+		return true
+	})
+
+	if leaves.next != 3 {
+		t.Fatalf("expected 3 leaves despite redelivery, got %d", leaves.next)
+	}
+	seen := map[int64]bool{}
+	for _, id := range []string{"a", "b", "c"} {
+		leaf := leaves.LeafIndex(id)
+		if seen[*leaf] {
+			t.Fatalf("leaf %d assigned twice", *leaf)
+		}
+		seen[*leaf] = true
+	}
+}
+
+func TestAppendersRouteByTenant(t *testing.T) {
+	ctx := context.Background()
+	defLeaves, acmeLeaves := newMemLeaves("a"), newMemLeaves("b")
+	route := Appenders{Default: "default", ByTenant: map[string]*Appender{
+		"default": NewAppender(defLeaves),
+		"acme":    NewAppender(acmeLeaves),
+	}}
+	msg := func(id, tenant string) Message {
+		b, _ := json.Marshal(IngestEvent{EvidenceID: id, LeafData: []byte("leaf:" + id), Tenant: tenant})
+		return Message{Value: b}
+	}
+
+	if err := route.Handle(ctx, msg("a", "")); err != nil {
+		t.Fatal(err)
+	}
+	if err := route.Handle(ctx, msg("b", "acme")); err != nil {
+		t.Fatal(err)
+	}
+	// each tenant's log starts at leaf 0
+	for _, l := range []struct {
+		leaves *memLeaves
+		id     string
+	}{{defLeaves, "a"}, {acmeLeaves, "b"}} {
+		if leaf := l.leaves.LeafIndex(l.id); leaf == nil || *leaf != 0 {
+			t.Fatalf("%s: leaf %v", l.id, leaf)
+		}
+	}
+	if err := route.Handle(ctx, msg("c", "initech")); !IsPermanent(err) {
+		t.Fatalf("unknown tenant must be poison, got %v", err)
+	}
+	// evidence of one tenant is unknown to another
+	if err := route.Handle(ctx, msg("a", "acme")); !IsPermanent(err) {
+		t.Fatalf("foreign evidence must be poison, got %v", err)
+	}
+}
+
+func TestAppenderRetriesFailedAssignment(t *testing.T) {
+	leaves := newMemLeaves("a", "b")
+	// a previous process sequenced b
+	leaves.AssignLeaf(context.Background(), "b")
+	leaves.failSet = 2
+	app := NewAppender(leaves)
+	ev := IngestEvent{EvidenceID: "a", LeafData: []byte("leaf:a")}
+	ctx := context.Background()
+
+	for i := 0; i < 2; i++ {
+		if _, _, err := app.Append(ctx, ev); err == nil {
+			t.Fatal("expected assignment failure")
+		}
+	}
+	leaf, sequenced, err := app.Append(ctx, ev)
+	if err != nil || !sequenced || leaf != 1 {
+		t.Fatalf("the store's sequence must continue after b: %d %v %v", leaf, sequenced, err)
+	}
+	if _, sequenced, _ := app.Append(ctx, ev); sequenced {
+		t.Fatal("second append must be a no-op")
+	}
+}
+
+type flakyBroker struct {
+	*MemoryBroker
+	fail int
+}
+
+func (f *flakyBroker) Produce(ctx context.Context, topic string, key, value []byte, headers map[string]string) (Message, error) {
+	if f.fail > 0 {
+		f.fail--
+		return Message{}, errors.New("leader not available")
+	}
+	return f.MemoryBroker.Produce(ctx, topic, key, value, headers)
+}
+
+func TestPublisherRetries(t *testing.T) {
+	cfg := testConfig()
+	b := &flakyBroker{MemoryBroker: NewMemoryBroker(1), fail: 2}
+	pub := NewPublisher(b, cfg)
+	if err := pub.PublishIngest(context.Background(), "a", IngestEvent{EvidenceID: "a", LeafData: []byte("x")}); err != nil {
+		t.Fatalf("publish should succeed on the third attempt: %v", err)
+	}
+	b.fail = cfg.MaxAttempts
+	if err := pub.Publish(context.Background(), "a", []byte("x")); err == nil {
+		t.Fatal("expected error after exhausting attempts")
+	}
+	if n := len(b.Messages(cfg.IngestTopic)); n != 1 {
+		t.Fatalf("expected 1 message, got %d", n)
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("PIPELINE_BROKER", "")
+	if _, ok, err := ConfigFromEnv(); ok || err != nil {
+		t.Fatalf("pipeline must be off by default: %v %v", ok, err)
+	}
+	t.Setenv("PIPELINE_BROKER", "kafka")
+	t.Setenv("PIPELINE_BROKERS", "")
+	if _, _, err := ConfigFromEnv(); err == nil {
+		t.Fatal("expected error without seed brokers")
+	}
+	t.Setenv("PIPELINE_BROKERS", "redpanda-0:9092, redpanda-1:9092")
+	t.Setenv("PIPELINE_INGEST_TOPIC", "custom.ingest")
+	cfg, ok, err := ConfigFromEnv()
+	if !ok || err != nil || len(cfg.Brokers) != 2 || cfg.Brokers[1] != "redpanda-1:9092" || cfg.DeadLetterTopic() != "custom.ingest.dlq" {
+		t.Fatalf("unexpected config %+v %v %v", cfg, ok, err)
+	}
+	t.Setenv("PIPELINE_MAX_ATTEMPTS", "zero")
+	if _, _, err := ConfigFromEnv(); err == nil {
+		t.Fatal("expected error for invalid attempts")
+	}
+	t.Setenv("PIPELINE_MAX_ATTEMPTS", "")
+	for _, broker := range []string{"memory", "zookeeper"} {
+		t.Setenv("PIPELINE_BROKER", broker)
+		if _, _, err := ConfigFromEnv(); err == nil {
+			t.Fatalf("expected error for unsupported broker %q", broker)
+		}
+	}
+}
+
+func TestRelayDeliversOutboxInKeyOrder(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemoryStore()
+	for i, key := range []string{"a", "a", "b"} {
+		msg := store.OutboxMessage{Topic: "vault.ingest", Key: key, Payload: []byte(fmt.Sprintf("%s-%d", key, i))}
+		if err := s.SaveEvidence(ctx, store.Evidence{ID: fmt.Sprint("ev-", i)}, nil, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// re-saving existing evidence must not enqueue another message
+	_ = s.SaveEvidence(ctx, store.Evidence{ID: "ev-0"}, nil, store.OutboxMessage{Topic: "vault.ingest", Key: "a", Payload: []byte("dup")})
+
+	cfg := testConfig()
+	cfg.RetryBackoff = 30 * time.Millisecond
+	b := &flakyBroker{MemoryBroker: NewMemoryBroker(1), fail: 1}
+	relay := NewRelay(s, b, cfg)
+
+	n, err := relay.RelayOnce(ctx)
+	if err != nil || n != 1 {
+		t.Fatalf("expected only b to be delivered, got %d %v", n, err)
+	}
+	if n, _ := relay.RelayOnce(ctx); n != 0 {
+		t.Fatalf("a-1 must wait for a-0's retry, delivered %d", n)
+	}
+	time.Sleep(40 * time.Millisecond)
+	if n, err := relay.RelayOnce(ctx); err != nil || n != 2 {
+		t.Fatalf("expected a-0 and a-1 after backoff, got %d %v", n, err)
+	}
+
+	var got []string
+	for _, m := range b.Messages("vault.ingest") {
+		got = append(got, string(m.Value))
+	}
+	if fmt.Sprint(got) != "[b-2 a-0 a-1]" {
+		t.Fatalf("unexpected publish order %v", got)
+	}
+	if left, _ := s.ClaimOutbox(ctx, 10, time.Second); len(left) != 0 {
+		t.Fatalf("outbox not drained: %+v", left)
+	}
+}
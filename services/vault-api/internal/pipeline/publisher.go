@@ -2,15 +2,63 @@ package pipeline
 
 import (
 	"context"
+	"encoding/json"
 	"time"
+
+	"github.com/rs/zerolog/log"
 )
 
-type Publisher struct{}
+// IngestEvent mirrors the IngestEvent message in api/proto/vault.proto.
+type IngestEvent struct {
+	EvidenceID  string `json:"evidence_id"`
+	LeafData    []byte `json:"leaf_data"`
+	ContentHash string `json:"content_hash"`
+	EnqueuedAt  int64  `json:"enqueued_at"`
+	IngestedBy  string `json:"ingested_by,omitempty"`
+	// Tenant names the log the record belongs to; empty is the default
+	// tenant.
+	Tenant string `json:"tenant,omitempty"`
+}
 
-func NewPublisher(brokers []string, topic string) *Publisher { return &Publisher{} }
+// Publisher writes ingest events to the ingest topic.
+type Publisher struct {
+	broker Broker
+	cfg    Config
+}
 
+func NewPublisher(b Broker, cfg Config) *Publisher {
+	return &Publisher{broker: b, cfg: cfg}
+}
+
+// Publish writes value under key, retrying broker errors with backoff up to
+// cfg.MaxAttempts times.
 func (p *Publisher) Publish(ctx context.Context, key string, value []byte) error {
-	// TODO: implement Kafka/Redpanda publish with retries
-	_ = time.Now()
-	return nil
+	var err error
+	for attempt := 1; ; attempt++ {
+		if _, err = p.broker.Produce(ctx, p.cfg.IngestTopic, []byte(key), value, nil); err == nil {
+			return nil
+		}
+		if attempt >= p.cfg.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+		log.Warn().Err(err).Int("attempt", attempt).Str("topic", p.cfg.IngestTopic).Msg("pipeline publish failed; retrying")
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(p.cfg.backoff(attempt)):
+		}
+	}
+}
+
+// PublishIngest writes ev keyed by key. Events sharing a key are consumed in
+// publish order, so callers key related records (a batch) identically.
+func (p *Publisher) PublishIngest(ctx context.Context, key string, ev IngestEvent) error {
+	if ev.EnqueuedAt == 0 {
+		ev.EnqueuedAt = time.Now().UnixMilli()
+	}
+	body, err := json.Marshal(ev)
+	if err != nil {
+		return err
+	}
+	return p.Publish(ctx, key, body)
 }
@@ -0,0 +1,158 @@
+// Package promise issues signed inclusion promises for freshly ingested
+// evidence. A promise, like a Certificate Transparency SCT, commits the vault
+// to sequencing the evidence within a maximum merge delay and lets clients
+// hold the vault to that before a checkpoint covers the record.
+package promise
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// Version is the promise payload version.
+const Version = 1
+
+// DefaultMaxMergeDelay applies when PROMISE_MAX_MERGE_DELAY is unset.
+const DefaultMaxMergeDelay = 5 * time.Minute
+
+// Promise is the signed statement returned at ingest.
+type Promise struct {
+	Version       int    `json:"version"`
+	EvidenceID    string `json:"evidence_id"`
+	ContentHash   string `json:"content_hash"`
+	Timestamp     int64  `json:"timestamp"`
+	MaxMergeDelay int64  `json:"max_merge_delay_ms"`
+	KeyID         string `json:"key_id"`
+	Signature     string `json:"signature"`
+}
+
+// payload is the signed portion of a promise, encoded as JSON in field order.
+type payload struct {
+	Version       int    `json:"version"`
+	EvidenceID    string `json:"evidence_id"`
+	ContentHash   string `json:"content_hash"`
+	Timestamp     int64  `json:"timestamp"`
+	MaxMergeDelay int64  `json:"max_merge_delay_ms"`
+}
+
+// Deadline is the latest time by which the evidence must be sequenced.
+func (p Promise) Deadline() time.Time {
+	return time.UnixMilli(p.Timestamp).Add(time.Duration(p.MaxMergeDelay) * time.Millisecond)
+}
+
+// SignedBytes returns the exact bytes covered by the signature.
+func (p Promise) SignedBytes() []byte {
+	b, _ := json.Marshal(payload{
+		Version:       p.Version,
+		EvidenceID:    p.EvidenceID,
+		ContentHash:   p.ContentHash,
+		Timestamp:     p.Timestamp,
+		MaxMergeDelay: p.MaxMergeDelay,
+	})
+	return b
+}
+
+// Signer signs promises with an Ed25519 key.
+type Signer struct {
+	key           ed25519.PrivateKey
+	keyID         string
+	maxMergeDelay time.Duration
+	Ephemeral     bool
+}
+
+// NewSigner wraps key; a nil key generates an ephemeral one whose promises
+// cannot be verified after a restart.
+func NewSigner(key ed25519.PrivateKey, maxMergeDelay time.Duration) (*Signer, error) {
+	ephemeral := false
+	if key == nil {
+		_, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		key, ephemeral = priv, true
+	}
+	if maxMergeDelay <= 0 {
+		maxMergeDelay = DefaultMaxMergeDelay
+	}
+	return &Signer{key: key, keyID: KeyID(key.Public().(ed25519.PublicKey)), maxMergeDelay: maxMergeDelay, Ephemeral: ephemeral}, nil
+}
+
+// SignerFromEnv reads PROMISE_SIGNING_KEY_B64 (a base64 32-byte seed or
+// 64-byte private key) and PROMISE_MAX_MERGE_DELAY.
+func SignerFromEnv() (*Signer, error) {
+	delay := DefaultMaxMergeDelay
+	if raw := strings.TrimSpace(os.Getenv("PROMISE_MAX_MERGE_DELAY")); raw != "" {
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid PROMISE_MAX_MERGE_DELAY %q", raw)
+		}
+		delay = d
+	}
+	var key ed25519.PrivateKey
+	if raw := strings.TrimSpace(os.Getenv("PROMISE_SIGNING_KEY_B64")); raw != "" {
+		b, err := base64.StdEncoding.DecodeString(raw)
+		if err != nil {
+			return nil, errors.New("PROMISE_SIGNING_KEY_B64 is not base64")
+		}
+		switch len(b) {
+		case ed25519.SeedSize:
+			key = ed25519.NewKeyFromSeed(b)
+		case ed25519.PrivateKeySize:
+			key = ed25519.PrivateKey(b)
+		default:
+			return nil, fmt.Errorf("PROMISE_SIGNING_KEY_B64 must decode to %d or %d bytes", ed25519.SeedSize, ed25519.PrivateKeySize)
+		}
+	}
+	return NewSigner(key, delay)
+}
+
+// KeyID identifies a public key: hex SHA-256 of the raw key, as in CT log IDs.
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:])
+}
+
+// MaxMergeDelay is the delay promised for every record.
+func (s *Signer) MaxMergeDelay() time.Duration { return s.maxMergeDelay }
+
+// PublicKey returns the verification key.
+func (s *Signer) PublicKey() ed25519.PublicKey { return s.key.Public().(ed25519.PublicKey) }
+
+// KeyID returns the identifier embedded in issued promises.
+func (s *Signer) KeyID() string { return s.keyID }
+
+// Sign issues a promise for evidence ingested at ts. Signing is
+// deterministic, so re-issuing for a replayed request yields the same bytes.
+func (s *Signer) Sign(evidenceID, contentHash string, ts time.Time) Promise {
+	p := Promise{
+		Version:       Version,
+		EvidenceID:    evidenceID,
+		ContentHash:   contentHash,
+		Timestamp:     ts.UnixMilli(),
+		MaxMergeDelay: s.maxMergeDelay.Milliseconds(),
+		KeyID:         s.keyID,
+	}
+	p.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.key, p.SignedBytes()))
+	return p
+}
+
+// Verify checks the promise signature and key ID against pub.
+func Verify(pub ed25519.PublicKey, p Promise) bool {
+	if p.KeyID != KeyID(pub) {
+		return false
+	}
+	sig, err := base64.StdEncoding.DecodeString(p.Signature)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(pub, p.SignedBytes(), sig)
+}
@@ -0,0 +1,58 @@
+package promise
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	s, err := NewSigner(nil, time.Minute)
+	if err != nil {
+		t.Fatalf("new signer: %v", err)
+	}
+	ts := time.UnixMilli(1_700_000_000_000)
+	p := s.Sign("ev-1", "abc", ts)
+	if !Verify(s.PublicKey(), p) {
+		t.Fatalf("expected promise to verify")
+	}
+	if got := p.Deadline(); !got.Equal(ts.Add(time.Minute)) {
+		t.Fatalf("unexpected deadline %v", got)
+	}
+	if again := s.Sign("ev-1", "abc", ts); again.Signature != p.Signature {
+		t.Fatalf("expected deterministic signature")
+	}
+
+	tampered := p
+	tampered.ContentHash = "abd"
+	if Verify(s.PublicKey(), tampered) {
+		t.Fatalf("tampered promise must not verify")
+	}
+	other, _ := NewSigner(nil, time.Minute)
+	if Verify(other.PublicKey(), p) {
+		t.Fatalf("promise must not verify under another key")
+	}
+}
+
+func TestSignerFromEnv(t *testing.T) {
+	seed := make([]byte, ed25519.SeedSize)
+	seed[0] = 7
+	t.Setenv("PROMISE_SIGNING_KEY_B64", base64.StdEncoding.EncodeToString(seed))
+	t.Setenv("PROMISE_MAX_MERGE_DELAY", "30s")
+	s, err := SignerFromEnv()
+	if err != nil {
+		t.Fatalf("signer from env: %v", err)
+	}
+	if s.Ephemeral || s.MaxMergeDelay() != 30*time.Second {
+		t.Fatalf("unexpected signer %+v", s)
+	}
+	if !s.PublicKey().Equal(ed25519.NewKeyFromSeed(seed).Public()) {
+		t.Fatalf("signer did not use configured seed")
+	}
+
+	t.Setenv("PROMISE_SIGNING_KEY_B64", "c2hvcnQ=")
+	if _, err := SignerFromEnv(); err == nil {
+		t.Fatalf("expected error for short key")
+	}
+}
@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net/netip"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultClass names the rule applied to route classes without their own.
+const DefaultClass = "default"
+
+// Config is the RATE_LIMIT_* configuration.
+type Config struct {
+	// Subject and IP map route classes to the rules applied per
+	// authenticated subject and per client address.
+	Subject map[string]Rule
+	IP      map[string]Rule
+	// Backend is "memory" or "redis".
+	Backend  string
+	RedisURL string
+	// TrustedProxies are the peers whose X-Forwarded-For is believed.
+	TrustedProxies []netip.Prefix
+}
+
+// Enabled reports whether any rule is configured.
+func (c Config) Enabled() bool {
+	return len(c.Subject) > 0 || len(c.IP) > 0
+}
+
+// RuleFor returns the rule for class in rules and the class it was
+// configured for, falling back to DefaultClass. Classes without their own
+// rule share the default rule's budget.
+func RuleFor(rules map[string]Rule, class string) (string, Rule, bool) {
+	if r, ok := rules[class]; ok {
+		return class, r, true
+	}
+	r, ok := rules[DefaultClass]
+	return DefaultClass, r, ok
+}
+
+// ConfigFromEnv reads RATE_LIMITS, RATE_LIMITS_IP, RATE_LIMIT_BACKEND,
+// RATE_LIMIT_REDIS_URL and RATE_LIMIT_TRUSTED_PROXIES.
+func ConfigFromEnv() (Config, error) {
+	var cfg Config
+	var err error
+	if cfg.Subject, err = ParseRules(os.Getenv("RATE_LIMITS")); err != nil {
+		return cfg, fmt.Errorf("RATE_LIMITS: %w", err)
+	}
+	if cfg.IP, err = ParseRules(os.Getenv("RATE_LIMITS_IP")); err != nil {
+		return cfg, fmt.Errorf("RATE_LIMITS_IP: %w", err)
+	}
+	cfg.Backend = strings.ToLower(strings.TrimSpace(os.Getenv("RATE_LIMIT_BACKEND")))
+	cfg.RedisURL = strings.TrimSpace(os.Getenv("RATE_LIMIT_REDIS_URL"))
+	switch cfg.Backend {
+	case "":
+		cfg.Backend = "memory"
+	case "memory":
+	case "redis":
+		if cfg.RedisURL == "" {
+			return cfg, fmt.Errorf("RATE_LIMIT_BACKEND=redis requires RATE_LIMIT_REDIS_URL")
+		}
+	default:
+		return cfg, fmt.Errorf("unsupported RATE_LIMIT_BACKEND %q", cfg.Backend)
+	}
+	for _, raw := range strings.Split(os.Getenv("RATE_LIMIT_TRUSTED_PROXIES"), ",") {
+		if raw = strings.TrimSpace(raw); raw == "" {
+			continue
+		}
+		p, err := netip.ParsePrefix(raw)
+		if err != nil {
+			addr, aerr := netip.ParseAddr(raw)
+			if aerr != nil {
+				return cfg, fmt.Errorf("invalid RATE_LIMIT_TRUSTED_PROXIES entry %q", raw)
+			}
+			p = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		cfg.TrustedProxies = append(cfg.TrustedProxies, p.Masked())
+	}
+	return cfg, nil
+}
+
+// NewLimiter builds the limiter named by cfg.Backend.
+func NewLimiter(cfg Config) (Limiter, error) {
+	if cfg.Backend == "redis" {
+		return NewRedis(cfg.RedisURL, "vault:ratelimit:")
+	}
+	return NewMemory(), nil
+}
+
+// ParseRules parses "class=limit/window" pairs separated by commas, such
+// as "ingest=100/1m,default=600/1m". A window may be a Go duration or a
+// bare unit: "10/s".
+func ParseRules(s string) (map[string]Rule, error) {
+	rules := map[string]Rule{}
+	for _, part := range strings.Split(s, ",") {
+		if part = strings.TrimSpace(part); part == "" {
+			continue
+		}
+		class, spec, ok := strings.Cut(part, "=")
+		class = strings.TrimSpace(class)
+		if !ok || class == "" {
+			return nil, fmt.Errorf("invalid rule %q", part)
+		}
+		limit, window, ok := strings.Cut(strings.TrimSpace(spec), "/")
+		n, err := strconv.Atoi(limit)
+		if !ok || err != nil || n <= 0 {
+			return nil, fmt.Errorf("invalid limit in rule %q", part)
+		}
+		if window == "s" || window == "m" || window == "h" {
+			window = "1" + window
+		}
+		d, err := time.ParseDuration(window)
+		if err != nil || d < time.Millisecond {
+			return nil, fmt.Errorf("invalid window in rule %q", part)
+		}
+		if _, dup := rules[class]; dup {
+			return nil, fmt.Errorf("duplicate rule for %q", class)
+		}
+		rules[class] = Rule{Limit: n, Window: d}
+	}
+	return rules, nil
+}
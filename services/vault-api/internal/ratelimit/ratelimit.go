@@ -0,0 +1,128 @@
+// Package ratelimit implements sliding-window request limits shared by the
+// vault-api middleware. A window is approximated from two fixed windows:
+// the count of the current one plus the previous one's count weighted by
+// how much of it still overlaps the sliding window. Limiter state lives in
+// process memory or in Redis, so limits hold across replicas.
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Rule allows Limit requests per Window.
+type Rule struct {
+	Limit  int
+	Window time.Duration
+}
+
+// Result is the outcome of one request against a rule.
+type Result struct {
+	Allowed bool
+	Limit   int
+	// Remaining is how many more requests the window admits now.
+	Remaining int
+	// Reset is when the current fixed window ends.
+	Reset time.Duration
+	// RetryAfter is how long a rejected caller should wait.
+	RetryAfter time.Duration
+}
+
+// Limiter counts requests per key. Allow records the request only when it
+// is admitted.
+type Limiter interface {
+	Allow(ctx context.Context, key string, rule Rule, now time.Time) (Result, error)
+}
+
+// windowStart returns the start of the fixed window containing now.
+func windowStart(now time.Time, w time.Duration) time.Time {
+	return time.Unix(0, now.UnixNano()/int64(w)*int64(w))
+}
+
+// evaluate decides a request given the previous and current fixed windows'
+// counts, excluding the request itself.
+func evaluate(rule Rule, now, start time.Time, prev, cur int) Result {
+	w := float64(rule.Window)
+	elapsed := float64(now.Sub(start))
+	estimate := float64(prev)*(w-elapsed)/w + float64(cur)
+	res := Result{Limit: rule.Limit, Reset: rule.Window - now.Sub(start)}
+	if estimate+1 <= float64(rule.Limit) {
+		res.Allowed = true
+		res.Remaining = int(math.Floor(float64(rule.Limit) - estimate - 1))
+		return res
+	}
+	// wait until the weighted estimate leaves room for one more request
+	room := float64(rule.Limit - 1)
+	var at float64
+	if float64(cur) <= room && prev > 0 {
+		at = w - (room-float64(cur))*w/float64(prev)
+	} else {
+		// only the next window helps: there the current count decays
+		at = w
+		if cur > 0 {
+			at += w * math.Max(0, 1-room/float64(cur))
+		}
+	}
+	res.RetryAfter = time.Duration(math.Ceil(at - elapsed))
+	if res.RetryAfter <= 0 {
+		res.RetryAfter = time.Millisecond
+	}
+	return res
+}
+
+// Memory is a Limiter for a single replica.
+type Memory struct {
+	mu        sync.Mutex
+	counters  map[string]*counter
+	lastSweep time.Time
+}
+
+type counter struct {
+	start     time.Time
+	window    time.Duration
+	prev, cur int
+}
+
+// NewMemory returns an empty in-process limiter.
+func NewMemory() *Memory {
+	return &Memory{counters: map[string]*counter{}}
+}
+
+// Allow implements Limiter.
+func (m *Memory) Allow(ctx context.Context, key string, rule Rule, now time.Time) (Result, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sweepLocked(now)
+	start := windowStart(now, rule.Window)
+	c, ok := m.counters[key]
+	switch {
+	case !ok || c.window != rule.Window:
+		c = &counter{start: start, window: rule.Window}
+		m.counters[key] = c
+	case c.start.Equal(start):
+	case c.start.Add(rule.Window).Equal(start):
+		c.start, c.prev, c.cur = start, c.cur, 0
+	default:
+		c.start, c.prev, c.cur = start, 0, 0
+	}
+	res := evaluate(rule, now, start, c.prev, c.cur)
+	if res.Allowed {
+		c.cur++
+	}
+	return res, nil
+}
+
+// sweepLocked drops counters idle for two windows, at most once a minute.
+func (m *Memory) sweepLocked(now time.Time) {
+	if now.Sub(m.lastSweep) < time.Minute {
+		return
+	}
+	m.lastSweep = now
+	for k, c := range m.counters {
+		if now.Sub(c.start) > 2*c.window {
+			delete(m.counters, k)
+		}
+	}
+}
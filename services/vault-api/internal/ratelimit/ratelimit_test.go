@@ -0,0 +1,166 @@
+package ratelimit
+
+import (
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+// exerciseSlidingWindow runs the same scenario against any Limiter.
+func exerciseSlidingWindow(t *testing.T, l Limiter) {
+	t.Helper()
+	ctx := context.Background()
+	rule := Rule{Limit: 3, Window: time.Second}
+	t0 := time.Unix(1000, 0)
+	allow := func(key string, at time.Duration) Result {
+		t.Helper()
+		res, err := l.Allow(ctx, key, rule, t0.Add(at))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res
+	}
+
+	for want := 2; want >= 0; want-- {
+		if res := allow("a", 0); !res.Allowed || res.Remaining != want {
+			t.Fatalf("request %d: %+v", 3-want, res)
+		}
+	}
+	res := allow("a", 0)
+	if res.Allowed || res.RetryAfter <= time.Second || res.RetryAfter > 1400*time.Millisecond {
+		t.Fatalf("fourth request: %+v", res)
+	}
+	if res := allow("b", 0); !res.Allowed {
+		t.Fatalf("other key throttled: %+v", res)
+	}
+	// halfway into the next window half of the previous count remains, and
+	// the rejected request above was not counted
+	if res := allow("a", 1500*time.Millisecond); !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("next window: %+v", res)
+	}
+	res = allow("a", 1500*time.Millisecond)
+	if res.Allowed || res.RetryAfter <= 0 || res.RetryAfter > 200*time.Millisecond {
+		t.Fatalf("sliding estimate: %+v", res)
+	}
+	// two windows later the history is gone
+	if res := allow("a", 3*time.Second); !res.Allowed || res.Remaining != 2 {
+		t.Fatalf("after two windows: %+v", res)
+	}
+}
+
+func TestMemorySlidingWindow(t *testing.T) {
+	exerciseSlidingWindow(t, NewMemory())
+}
+
+func TestRedisSlidingWindow(t *testing.T) {
+	srv := miniredis.RunT(t)
+	srv.RequireAuth("s3cret")
+	l, err := NewRedis("redis://:s3cret@"+srv.Addr()+"/2", "test:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	exerciseSlidingWindow(t, l)
+	srv.Select(2)
+	if keys := srv.Keys(); len(keys) == 0 || !strings.HasPrefix(keys[0], "test:") {
+		t.Fatalf("counters not kept in database 2 under the prefix: %v", keys)
+	}
+
+	bad, _ := NewRedis("redis://:wrong@"+srv.Addr(), "test:")
+	defer bad.Close()
+	if _, err := bad.Allow(context.Background(), "a", Rule{Limit: 1, Window: time.Second}, time.Now()); err == nil {
+		t.Fatal("expected an authentication error")
+	}
+}
+
+func TestRedisConcurrentRequestsNeverExceedLimit(t *testing.T) {
+	srv := miniredis.RunT(t)
+	l, err := NewRedis("redis://"+srv.Addr(), "test:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	rule := Rule{Limit: 10, Window: time.Minute}
+	now := time.Unix(1000, 0)
+	var allowed atomic.Int32
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if res, err := l.Allow(context.Background(), "a", rule, now); err == nil && res.Allowed {
+				allowed.Add(1)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := allowed.Load(); n != 10 {
+		t.Fatalf("allowed %d of 50 concurrent requests, want 10", n)
+	}
+	// rejected requests were refunded, so the window holds exactly the limit
+	keys := srv.Keys()
+	if len(keys) != 1 {
+		t.Fatalf("unexpected counters %v", keys)
+	}
+	if v, err := srv.Get(keys[0]); err != nil || v != "10" {
+		t.Fatalf("counter = %q %v, want 10", v, err)
+	}
+}
+
+func TestRedisUnavailable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	l, _ := NewRedis("redis://"+addr, "test:")
+	defer l.Close()
+	if _, err := l.Allow(context.Background(), "a", Rule{Limit: 1, Window: time.Second}, time.Now()); err == nil {
+		t.Fatal("expected a dial error")
+	}
+}
+
+func TestParseRules(t *testing.T) {
+	rules, err := ParseRules(" ingest=100/1m, upload=5/s ,default=600/1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]Rule{"ingest": {100, time.Minute}, "upload": {5, time.Second}, "default": {600, time.Hour}}
+	for k, r := range want {
+		if rules[k] != r {
+			t.Fatalf("%s = %+v, want %+v", k, rules[k], r)
+		}
+	}
+	if class, r, ok := RuleFor(rules, "proof"); !ok || class != DefaultClass || r.Limit != 600 {
+		t.Fatalf("fallback = %s %+v %v", class, r, ok)
+	}
+	for _, bad := range []string{"ingest", "ingest=0/1m", "ingest=10", "ingest=10/soon", "a=1/s,a=2/s", "=1/s"} {
+		if _, err := ParseRules(bad); err == nil {
+			t.Errorf("%q: expected an error", bad)
+		}
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Setenv("RATE_LIMITS", "ingest=10/s")
+	t.Setenv("RATE_LIMIT_TRUSTED_PROXIES", "10.0.0.0/8, 192.168.1.7")
+	cfg, err := ConfigFromEnv()
+	if err != nil || !cfg.Enabled() || cfg.Backend != "memory" || len(cfg.TrustedProxies) != 2 {
+		t.Fatalf("got %+v %v", cfg, err)
+	}
+	t.Setenv("RATE_LIMIT_BACKEND", "redis")
+	if _, err := ConfigFromEnv(); err == nil {
+		t.Fatal("expected redis without a URL to be rejected")
+	}
+	t.Setenv("RATE_LIMIT_BACKEND", "memcached")
+	if _, err := ConfigFromEnv(); err == nil {
+		t.Fatal("expected unknown backend to be rejected")
+	}
+}
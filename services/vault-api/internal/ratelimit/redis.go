@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Redis is a Limiter whose counters live in Redis, shared by every replica
+// pointed at the same server.
+type Redis struct {
+	client *redis.Client
+	prefix string
+}
+
+const (
+	redisPoolSize = 16
+	redisTimeout  = 2 * time.Second
+)
+
+// allowScript counts a request in the current window (KEYS[1]) and reads
+// the previous one (KEYS[2]), refunding the request when the weighted
+// estimate leaves no room for it. ARGV holds the limit, the window and the
+// time elapsed in it, both in nanoseconds, and the counter TTL in
+// milliseconds. The estimate is computed as in evaluate, with the same
+// float64 operations in the same order, so both always agree. It returns
+// the previous count and the current count excluding the request.
+var allowScript = redis.NewScript(`
+local cur = redis.call('INCR', KEYS[1])
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+local prev = tonumber(redis.call('GET', KEYS[2]) or '0')
+local c = cur - 1
+local w = tonumber(ARGV[2])
+if prev * (w - tonumber(ARGV[3])) / w + c + 1 > tonumber(ARGV[1]) then
+  redis.call('DECR', KEYS[1])
+end
+return {prev, c}
+`)
+
+// NewRedis connects lazily to the server at rawURL
+// (redis://[user:password@]host:port[/db], or rediss:// for TLS). Keys are
+// prefixed with prefix.
+func NewRedis(rawURL, prefix string) (*Redis, error) {
+	opts, err := redis.ParseURL(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("ratelimit: invalid redis URL %q: %w", rawURL, err)
+	}
+	opts.PoolSize = redisPoolSize
+	opts.DialTimeout, opts.ReadTimeout, opts.WriteTimeout = redisTimeout, redisTimeout, redisTimeout
+	return &Redis{client: redis.NewClient(opts), prefix: prefix}, nil
+}
+
+// Allow implements Limiter. The script counts, reads and refunds in one
+// atomic step, so concurrent replicas never admit more than the limit.
+func (r *Redis) Allow(ctx context.Context, key string, rule Rule, now time.Time) (Result, error) {
+	start := windowStart(now, rule.Window)
+	idx := start.UnixNano() / int64(rule.Window)
+	base := r.prefix + key + ":" + strconv.FormatInt(int64(rule.Window/time.Millisecond), 10) + ":"
+	keys := []string{base + strconv.FormatInt(idx, 10), base + strconv.FormatInt(idx-1, 10)}
+
+	counts, err := allowScript.Run(ctx, r.client, keys,
+		rule.Limit, int64(rule.Window), int64(now.Sub(start)), int64(2*rule.Window/time.Millisecond),
+	).Int64Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("ratelimit: redis: %w", err)
+	}
+	if len(counts) != 2 {
+		return Result{}, fmt.Errorf("ratelimit: redis: unexpected reply %v", counts)
+	}
+	return evaluate(rule, now, start, int(counts[0]), int(counts[1])), nil
+}
+
+// Close releases the client's connections.
+func (r *Redis) Close() {
+	_ = r.client.Close()
+}
@@ -0,0 +1,244 @@
+// Package regopolicy makes authorization decisions with an embedded Rego
+// policy bundle loaded from disk. The bundle defines package vault.authz
+// with a boolean allow rule and, optionally, a reason string and an audit
+// boolean that overrides the decision logging mode:
+//
+//	package vault.authz
+//
+//	default allow := false
+//
+//	allow if {
+//		every p in input.permissions { p in input.granted }
+//	}
+//
+// The input is a middleware.AuthzInput. The bundle directory is polled and
+// recompiled when its files change; a bundle that fails to compile leaves
+// the previous one in force.
+package regopolicy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/middleware"
+	"github.com/open-policy-agent/opa/v1/bundle"
+	"github.com/open-policy-agent/opa/v1/rego"
+	"github.com/rs/zerolog/log"
+)
+
+// EngineName identifies this engine in decisions and audit entries.
+const EngineName = "rego"
+
+// Decision logging modes.
+const (
+	LogAll  = "all"
+	LogDeny = "deny"
+	LogNone = "none"
+)
+
+const query = "data.vault.authz"
+
+// Engine evaluates the bundle in a directory. It is safe for concurrent use.
+type Engine struct {
+	dir     string
+	logMode string
+
+	mu          sync.RWMutex
+	prepared    rego.PreparedEvalQuery
+	version     string
+	fingerprint string
+}
+
+// Load compiles the bundle in dir. logMode is LogAll, LogDeny or LogNone.
+func Load(ctx context.Context, dir, logMode string) (*Engine, error) {
+	switch logMode {
+	case LogAll, LogDeny, LogNone:
+	default:
+		return nil, fmt.Errorf("regopolicy: unknown decision log mode %q", logMode)
+	}
+	e := &Engine{dir: dir, logMode: logMode}
+	if _, err := e.Reload(ctx); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// LoadFromEnv loads the bundle at OPA_BUNDLE_DIR with the decision log mode
+// in OPA_DECISION_LOG (default all). It returns nil when no bundle is
+// configured.
+func LoadFromEnv(ctx context.Context) (*Engine, error) {
+	dir := strings.TrimSpace(os.Getenv("OPA_BUNDLE_DIR"))
+	if dir == "" {
+		return nil, nil
+	}
+	mode := strings.ToLower(strings.TrimSpace(os.Getenv("OPA_DECISION_LOG")))
+	if mode == "" {
+		mode = LogAll
+	}
+	return Load(ctx, dir, mode)
+}
+
+// ReloadIntervalFromEnv returns OPA_RELOAD_INTERVAL, default 10s.
+func ReloadIntervalFromEnv() (time.Duration, error) {
+	v := strings.TrimSpace(os.Getenv("OPA_RELOAD_INTERVAL"))
+	if v == "" {
+		return 10 * time.Second, nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("OPA_RELOAD_INTERVAL must be a positive duration, got %q", v)
+	}
+	return d, nil
+}
+
+// Version is the bundle's manifest revision, or a digest of its files when
+// the manifest sets none.
+func (e *Engine) Version() string {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.version
+}
+
+// Reload recompiles the bundle if its files changed and reports whether it
+// did. On error the previous bundle stays in force.
+func (e *Engine) Reload(ctx context.Context) (bool, error) {
+	fingerprint, err := dirFingerprint(e.dir)
+	if err != nil {
+		return false, err
+	}
+	e.mu.RLock()
+	unchanged := fingerprint == e.fingerprint
+	e.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	b, err := bundle.NewCustomReader(bundle.NewDirectoryLoader(e.dir)).Read()
+	if err != nil {
+		return false, fmt.Errorf("regopolicy: read bundle %s: %w", e.dir, err)
+	}
+	if len(b.Modules) == 0 {
+		return false, fmt.Errorf("regopolicy: bundle %s has no Rego modules", e.dir)
+	}
+	prepared, err := rego.New(rego.Query(query), rego.ParsedBundle("vault", &b)).PrepareForEval(ctx)
+	if err != nil {
+		return false, fmt.Errorf("regopolicy: compile bundle %s: %w", e.dir, err)
+	}
+	version := b.Manifest.Revision
+	if version == "" {
+		version = "sha256:" + fingerprint[:12]
+	}
+	e.mu.Lock()
+	e.prepared, e.version, e.fingerprint = prepared, version, fingerprint
+	e.mu.Unlock()
+	return true, nil
+}
+
+// Watch reloads the bundle every interval until ctx ends.
+func (e *Engine) Watch(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		reloaded, err := e.Reload(ctx)
+		if err != nil {
+			log.Error().Err(err).Str("policy_version", e.Version()).Msg("policy bundle reload failed; keeping the current bundle")
+			continue
+		}
+		if reloaded {
+			log.Info().Str("policy_version", e.Version()).Msg("policy bundle reloaded")
+		}
+	}
+}
+
+// Authorize evaluates data.vault.authz for in. A missing or non-boolean
+// allow denies.
+func (e *Engine) Authorize(ctx context.Context, in middleware.AuthzInput) (middleware.Decision, error) {
+	e.mu.RLock()
+	prepared, version := e.prepared, e.version
+	e.mu.RUnlock()
+	d := middleware.Decision{Engine: EngineName, PolicyVersion: version}
+
+	input, err := toInput(in)
+	if err != nil {
+		return d, err
+	}
+	rs, err := prepared.Eval(ctx, rego.EvalInput(input))
+	if err != nil {
+		return d, err
+	}
+	var doc map[string]interface{}
+	if len(rs) > 0 && len(rs[0].Expressions) > 0 {
+		doc, _ = rs[0].Expressions[0].Value.(map[string]interface{})
+	}
+	if doc == nil {
+		return d, errors.New("regopolicy: policy does not define package vault.authz")
+	}
+	d.Allowed, _ = doc["allow"].(bool)
+	d.Reason, _ = doc["reason"].(string)
+	if d.Reason == "" && !d.Allowed {
+		d.Reason = "denied by policy"
+	}
+	if audit, ok := doc["audit"].(bool); ok {
+		d.Audit = audit
+	} else {
+		d.Audit = e.logMode == LogAll || (e.logMode == LogDeny && !d.Allowed)
+	}
+	return d, nil
+}
+
+// toInput converts in to plain JSON values so that policies see the same
+// shapes as in a decision log.
+func toInput(in middleware.AuthzInput) (interface{}, error) {
+	raw, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+	var out interface{}
+	err = json.Unmarshal(raw, &out)
+	return out, err
+}
+
+// dirFingerprint hashes the names and contents of the files under dir.
+func dirFingerprint(dir string) (string, error) {
+	var files []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("regopolicy: %w", err)
+	}
+	sort.Strings(files)
+	h := sha256.New()
+	for _, f := range files {
+		data, err := os.ReadFile(f)
+		if err != nil {
+			return "", fmt.Errorf("regopolicy: %w", err)
+		}
+		rel, _ := filepath.Rel(dir, f)
+		fmt.Fprintf(h, "%s\x00%d\x00", filepath.ToSlash(rel), len(data))
+		h.Write(data)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
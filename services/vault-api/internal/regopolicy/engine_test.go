@@ -0,0 +1,137 @@
+package regopolicy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/SaridakisStamatisChristos/vault-api/middleware"
+)
+
+const grantsPolicy = `package vault.authz
+
+default allow := false
+
+allow if {
+	every p in input.permissions { p in input.granted }
+	not foreign_case
+}
+
+foreign_case if {
+	input.resource.labels["case"]
+	not input.resource.labels["case"] in input.claims.cases
+}
+
+reason := "resource belongs to another case" if foreign_case
+`
+
+func writeBundle(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, body := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(body), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	dir := t.TempDir()
+	writeBundle(t, dir, map[string]string{"authz.rego": grantsPolicy, ".manifest": `{"revision":"r1"}`})
+	e, err := Load(context.Background(), dir, LogDeny)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Version() != "r1" {
+		t.Fatalf("version = %q, want manifest revision", e.Version())
+	}
+
+	read := middleware.AuthzInput{
+		Subject:     "inv",
+		Claims:      map[string]interface{}{"cases": []interface{}{"1"}},
+		Permissions: []middleware.Permission{middleware.PermEvidenceRead},
+		Granted:     []middleware.Permission{middleware.PermEvidenceRead},
+	}
+	tests := []struct {
+		name    string
+		mutate  func(in *middleware.AuthzInput)
+		allowed bool
+		reason  string
+	}{
+		{name: "granted", mutate: func(*middleware.AuthzInput) {}, allowed: true},
+		{name: "not granted", mutate: func(in *middleware.AuthzInput) { in.Granted = nil }, reason: "denied by policy"},
+		{name: "own case", mutate: func(in *middleware.AuthzInput) {
+			in.Resource = &middleware.Resource{Type: "evidence", ID: "e1", Labels: map[string]string{"case": "1"}}
+		}, allowed: true},
+		{name: "foreign case", mutate: func(in *middleware.AuthzInput) {
+			in.Resource = &middleware.Resource{Type: "evidence", ID: "e2", Labels: map[string]string{"case": "2"}}
+		}, reason: "resource belongs to another case"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			in := read
+			tt.mutate(&in)
+			d, err := e.Authorize(context.Background(), in)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if d.Allowed != tt.allowed || d.Reason != tt.reason || d.Engine != EngineName || d.PolicyVersion != "r1" {
+				t.Fatalf("got %+v", d)
+			}
+			// LogDeny audits denials only
+			if d.Audit == d.Allowed {
+				t.Fatalf("audit flag %v for allowed=%v", d.Audit, d.Allowed)
+			}
+		})
+	}
+}
+
+func TestReloadPicksUpChangesAndKeepsLastGoodBundle(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	writeBundle(t, dir, map[string]string{"authz.rego": "package vault.authz\n\ndefault allow := false\n"})
+	e, err := Load(ctx, dir, LogAll)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := e.Version()
+	if !strings.HasPrefix(first, "sha256:") {
+		t.Fatalf("version without manifest = %q", first)
+	}
+	if reloaded, err := e.Reload(ctx); err != nil || reloaded {
+		t.Fatalf("unchanged bundle reloaded: %v %v", reloaded, err)
+	}
+
+	writeBundle(t, dir, map[string]string{"authz.rego": "package vault.authz\n\nallow := true\n\naudit := false\n"})
+	if reloaded, err := e.Reload(ctx); err != nil || !reloaded {
+		t.Fatalf("changed bundle not reloaded: %v %v", reloaded, err)
+	}
+	d, err := e.Authorize(ctx, middleware.AuthzInput{})
+	if err != nil || !d.Allowed || d.Audit || e.Version() == first {
+		t.Fatalf("new bundle not in force: %+v %v version=%s", d, err, e.Version())
+	}
+
+	writeBundle(t, dir, map[string]string{"authz.rego": "package vault.authz\n\nallow if {"})
+	if _, err := e.Reload(ctx); err == nil {
+		t.Fatal("expected compile error")
+	}
+	if d, err := e.Authorize(ctx, middleware.AuthzInput{}); err != nil || !d.Allowed {
+		t.Fatalf("broken bundle must leave the previous one in force: %+v %v", d, err)
+	}
+}
+
+func TestLoadRejectsBadConfiguration(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Load(context.Background(), dir, LogAll); err == nil {
+		t.Fatal("expected an empty bundle to be rejected")
+	}
+	writeBundle(t, dir, map[string]string{"authz.rego": "package vault.authz\n\nallow := true\n"})
+	if _, err := Load(context.Background(), dir, "sometimes"); err == nil {
+		t.Fatal("expected unknown log mode to be rejected")
+	}
+	t.Setenv("OPA_RELOAD_INTERVAL", "soon")
+	if _, err := ReloadIntervalFromEnv(); err == nil {
+		t.Fatal("expected invalid reload interval to be rejected")
+	}
+}
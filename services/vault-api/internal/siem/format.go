@@ -0,0 +1,239 @@
+package siem
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Format names an audit export encoding.
+type Format string
+
+const (
+	FormatNDJSON Format = "ndjson"
+	FormatCEF    Format = "cef"
+	FormatSyslog Format = "syslog"
+)
+
+const (
+	cefVendor  = "MerkleEvidenceVault"
+	cefProduct = "vault-api"
+	cefVersion = "1.0"
+
+	syslogAppName = "vault-api"
+	// syslogPRI is facility log_audit (13) with severity informational (6).
+	syslogPRI = 13*8 + 6
+	// syslogSDID uses the RFC 5612 documentation enterprise number.
+	syslogSDID = "audit@32473"
+)
+
+// Event is a single audit trail entry in a transport-neutral shape.
+type Event struct {
+	ID         string            `json:"id"`
+	Action     string            `json:"action"`
+	ResourceID string            `json:"resource_id"`
+	Actor      string            `json:"actor"`
+	Timestamp  time.Time         `json:"timestamp"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	// Tenant names the tenant whose log the entry belongs to; it is empty
+	// for the default tenant.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// ParseFormat maps a query or env value to a Format. Empty defaults to NDJSON.
+func ParseFormat(s string) (Format, error) {
+	switch Format(strings.ToLower(strings.TrimSpace(s))) {
+	case "", FormatNDJSON:
+		return FormatNDJSON, nil
+	case FormatCEF:
+		return FormatCEF, nil
+	case FormatSyslog:
+		return FormatSyslog, nil
+	default:
+		return "", fmt.Errorf("unsupported audit export format %q", s)
+	}
+}
+
+// ContentType returns the HTTP media type used when streaming f.
+func (f Format) ContentType() string {
+	if f == FormatNDJSON {
+		return "application/x-ndjson"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// Encode writes e to w as a single newline-terminated record.
+func Encode(w io.Writer, f Format, e Event) error {
+	line, err := Marshal(f, e)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(line, '\n'))
+	return err
+}
+
+// Marshal renders e in format f without a trailing newline.
+func Marshal(f Format, e Event) ([]byte, error) {
+	switch f {
+	case FormatNDJSON:
+		return json.Marshal(e)
+	case FormatCEF:
+		return []byte(formatCEF(e)), nil
+	case FormatSyslog:
+		return []byte(formatSyslog(e)), nil
+	default:
+		return nil, fmt.Errorf("unsupported audit export format %q", f)
+	}
+}
+
+func formatCEF(e Event) string {
+	var b strings.Builder
+	b.WriteString("CEF:0|")
+	b.WriteString(cefHeader(cefVendor))
+	b.WriteString("|")
+	b.WriteString(cefHeader(cefProduct))
+	b.WriteString("|")
+	b.WriteString(cefHeader(cefVersion))
+	b.WriteString("|")
+	b.WriteString(cefHeader(e.Action))
+	b.WriteString("|")
+	b.WriteString(cefHeader(e.Action))
+	b.WriteString("|")
+	b.WriteString(strconv.Itoa(cefSeverity(e.Action)))
+	b.WriteString("|")
+
+	ext := []string{
+		"rt=" + strconv.FormatInt(e.Timestamp.UTC().UnixMilli(), 10),
+		"act=" + cefExt(e.Action),
+		"suser=" + cefExt(e.Actor),
+		"cs1Label=resourceId",
+		"cs1=" + cefExt(e.ResourceID),
+	}
+	if e.ID != "" {
+		ext = append(ext, "externalId="+cefExt(e.ID))
+	}
+	if e.Tenant != "" {
+		ext = append(ext, "cs3Label=tenant", "cs3="+cefExt(e.Tenant))
+	}
+	if len(e.Metadata) > 0 {
+		pairs := make([]string, 0, len(e.Metadata))
+		for _, k := range sortedKeys(e.Metadata) {
+			pairs = append(pairs, k+"="+e.Metadata[k])
+		}
+		ext = append(ext, "cs2Label=metadata", "cs2="+cefExt(strings.Join(pairs, ";")))
+	}
+	b.WriteString(strings.Join(ext, " "))
+	return b.String()
+}
+
+func formatSyslog(e Event) string {
+	host := hostname()
+	msgID := syslogToken(e.Action, 32)
+
+	var sd strings.Builder
+	sd.WriteString("[")
+	sd.WriteString(syslogSDID)
+	writeSDParam(&sd, "action", e.Action)
+	writeSDParam(&sd, "actor", e.Actor)
+	writeSDParam(&sd, "resource", e.ResourceID)
+	if e.ID != "" {
+		writeSDParam(&sd, "id", e.ID)
+	}
+	if e.Tenant != "" {
+		writeSDParam(&sd, "tenant", e.Tenant)
+	}
+	for _, k := range sortedKeys(e.Metadata) {
+		writeSDParam(&sd, syslogToken(k, 32), e.Metadata[k])
+	}
+	sd.WriteString("]")
+
+	msg := fmt.Sprintf("%s %s by %s", e.Action, e.ResourceID, e.Actor)
+	return fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s",
+		syslogPRI,
+		e.Timestamp.UTC().Format(time.RFC3339Nano),
+		host,
+		syslogAppName,
+		os.Getpid(),
+		msgID,
+		sd.String(),
+		msg,
+	)
+}
+
+// cefSeverity rates denied or failed actions above routine activity.
+func cefSeverity(action string) int {
+	a := strings.ToLower(action)
+	if strings.Contains(a, "fail") || strings.Contains(a, "denied") {
+		return 7
+	}
+	return 3
+}
+
+func cefHeader(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return stripNewlines(s)
+}
+
+func cefExt(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "=", `\=`)
+	s = strings.ReplaceAll(s, "\r", `\r`)
+	return strings.ReplaceAll(s, "\n", `\n`)
+}
+
+func writeSDParam(b *strings.Builder, name, value string) {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	value = strings.ReplaceAll(value, "]", `\]`)
+	b.WriteString(" ")
+	b.WriteString(name)
+	b.WriteString(`="`)
+	b.WriteString(stripNewlines(value))
+	b.WriteString(`"`)
+}
+
+// syslogToken reduces s to the printable, space-free ASCII allowed in
+// RFC 5424 header fields and SD-PARAM names, or NILVALUE when empty.
+func syslogToken(s string, max int) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r < 33 || r > 126 || r == '=' || r == ']' || r == '"' {
+			continue
+		}
+		b.WriteRune(r)
+		if b.Len() == max {
+			break
+		}
+	}
+	if b.Len() == 0 {
+		return "-"
+	}
+	return b.String()
+}
+
+func stripNewlines(s string) string {
+	return strings.NewReplacer("\r", " ", "\n", " ").Replace(s)
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func hostname() string {
+	h, err := os.Hostname()
+	if err != nil {
+		return "-"
+	}
+	return syslogToken(h, 255)
+}
@@ -0,0 +1,100 @@
+package siem
+
+import (
+	"bytes"
+	"encoding/json"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func sampleEvent() Event {
+	return Event{
+		ID:         "a1",
+		Action:     "ingest",
+		ResourceID: "ev-1",
+		Actor:      `svc|ingest=1 "x"]`,
+		Timestamp:  time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for in, want := range map[string]Format{"": FormatNDJSON, "NDJSON": FormatNDJSON, "cef": FormatCEF, " syslog ": FormatSyslog} {
+		got, err := ParseFormat(in)
+		if err != nil || got != want {
+			t.Fatalf("ParseFormat(%q) = %q, %v; want %q", in, got, err, want)
+		}
+	}
+	if _, err := ParseFormat("xml"); err == nil {
+		t.Fatalf("expected error for unsupported format")
+	}
+}
+
+func TestEncodeNDJSON(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Encode(&buf, FormatNDJSON, sampleEvent()); err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if !strings.HasSuffix(buf.String(), "\n") || strings.Count(buf.String(), "\n") != 1 {
+		t.Fatalf("expected exactly one newline-terminated record, got %q", buf.String())
+	}
+	var got Event
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if got.ResourceID != "ev-1" || got.Action != "ingest" {
+		t.Fatalf("unexpected round trip: %+v", got)
+	}
+}
+
+func TestEncodeCEFEscapesHeaderAndExtension(t *testing.T) {
+	e := sampleEvent()
+	e.Action = "checkpoint|verify"
+	b, err := Marshal(FormatCEF, e)
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	got := string(b)
+	if !strings.HasPrefix(got, `CEF:0|MerkleEvidenceVault|vault-api|1.0|checkpoint\|verify|checkpoint\|verify|3|`) {
+		t.Fatalf("unexpected CEF header: %s", got)
+	}
+	if !strings.Contains(got, `suser=svc|ingest\=1 "x"]`) {
+		t.Fatalf("expected escaped suser extension: %s", got)
+	}
+	if !strings.Contains(got, "rt=1772366400000") {
+		t.Fatalf("expected epoch millis receipt time: %s", got)
+	}
+}
+
+func TestEncodeSyslogRFC5424(t *testing.T) {
+	b, err := Marshal(FormatSyslog, sampleEvent())
+	if err != nil {
+		t.Fatalf("marshal: %v", err)
+	}
+	got := string(b)
+	header := regexp.MustCompile(`^<110>1 2026-03-01T12:00:00Z \S+ vault-api \d+ ingest \[audit@32473 `)
+	if !header.MatchString(got) {
+		t.Fatalf("unexpected syslog header: %s", got)
+	}
+	if !strings.Contains(got, `actor="svc|ingest=1 \"x\"\]"`) {
+		t.Fatalf("expected escaped SD-PARAM value: %s", got)
+	}
+}
+
+func TestEncodeCarriesTenant(t *testing.T) {
+	e := sampleEvent()
+	e.Tenant = "acme"
+	for f, want := range map[Format]string{FormatNDJSON: `"tenant":"acme"`, FormatCEF: "cs3Label=tenant cs3=acme", FormatSyslog: `tenant="acme"`} {
+		b, err := Marshal(f, e)
+		if err != nil {
+			t.Fatalf("%s: %v", f, err)
+		}
+		if !strings.Contains(string(b), want) {
+			t.Fatalf("%s: %q lacks %q", f, b, want)
+		}
+	}
+	if b, _ := Marshal(FormatNDJSON, sampleEvent()); strings.Contains(string(b), "tenant") {
+		t.Fatalf("default tenant events must not change: %s", b)
+	}
+}
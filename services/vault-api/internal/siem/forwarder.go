@@ -0,0 +1,343 @@
+package siem
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// ForwarderConfig describes the SIEM sink and local spool.
+type ForwarderConfig struct {
+	// Addr is the host:port of the TCP syslog/SIEM collector.
+	Addr string
+	// Format is the wire encoding; syslog frames use RFC 6587 octet counting,
+	// other formats are newline delimited.
+	Format Format
+	// SpoolDir holds events not yet written to the sink. When empty the
+	// spool is kept in memory and does not survive restarts.
+	SpoolDir string
+	// MaxMemory caps the in-memory spool; Enqueue refuses events beyond it
+	// with ErrSpoolFull.
+	MaxMemory int
+	// RetryInterval is the delay between reconnect attempts while the sink is down.
+	RetryInterval time.Duration
+	// DialTimeout bounds connection establishment and each write.
+	DialTimeout time.Duration
+}
+
+// defaultMaxMemory is the in-memory spool cap when none is configured.
+const defaultMaxMemory = 10000
+
+// ErrSpoolFull is returned by Enqueue when the in-memory spool is at its cap.
+var ErrSpoolFull = errors.New("siem: audit spool full")
+
+// Forwarder ships audit events to a TCP sink. Every event is spooled before
+// Enqueue returns and removed once the kernel accepts its write to the
+// connection, so events may be re-sent after a crash or a reconnect. Plain
+// TCP has no application acknowledgement: events written just before the
+// sink or the connection fails can still be lost in transit. The export
+// route is the complete record.
+type Forwarder struct {
+	cfg  ForwarderConfig
+	dial func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	mu     sync.Mutex
+	seq    uint64
+	memory []spooled
+	wake   chan struct{}
+}
+
+type spooled struct {
+	seq   uint64
+	event Event
+}
+
+// NewForwarder prepares a forwarder and recovers any spooled events.
+func NewForwarder(cfg ForwarderConfig) (*Forwarder, error) {
+	if strings.TrimSpace(cfg.Addr) == "" {
+		return nil, errors.New("siem forwarder requires an address")
+	}
+	if cfg.Format == "" {
+		cfg.Format = FormatSyslog
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = 2 * time.Second
+	}
+	if cfg.DialTimeout <= 0 {
+		cfg.DialTimeout = 5 * time.Second
+	}
+	if cfg.MaxMemory <= 0 {
+		cfg.MaxMemory = defaultMaxMemory
+	}
+	f := &Forwarder{
+		cfg:  cfg,
+		dial: (&net.Dialer{Timeout: cfg.DialTimeout}).DialContext,
+		wake: make(chan struct{}, 1),
+	}
+	if cfg.SpoolDir != "" {
+		if err := os.MkdirAll(cfg.SpoolDir, 0o700); err != nil {
+			return nil, fmt.Errorf("create spool dir: %w", err)
+		}
+		pending, err := f.readSpool()
+		if err != nil {
+			return nil, err
+		}
+		if n := len(pending); n > 0 {
+			f.seq = pending[n-1].seq
+			log.Info().Int("pending", n).Str("spool_dir", cfg.SpoolDir).Msg("recovered spooled audit events")
+		}
+	}
+	return f, nil
+}
+
+// ForwarderConfigFromEnv builds a config from AUDIT_FORWARD_* variables. It
+// returns ok=false when forwarding is not configured.
+func ForwarderConfigFromEnv() (ForwarderConfig, bool, error) {
+	addr := strings.TrimSpace(os.Getenv("AUDIT_FORWARD_ADDR"))
+	if addr == "" {
+		return ForwarderConfig{}, false, nil
+	}
+	format, err := ParseFormat(envDefault("AUDIT_FORWARD_FORMAT", string(FormatSyslog)))
+	if err != nil {
+		return ForwarderConfig{}, false, err
+	}
+	retryMs, err := strconv.Atoi(envDefault("AUDIT_FORWARD_RETRY_MS", "2000"))
+	if err != nil || retryMs < 0 {
+		return ForwarderConfig{}, false, fmt.Errorf("invalid AUDIT_FORWARD_RETRY_MS")
+	}
+	maxMemory, err := strconv.Atoi(envDefault("AUDIT_FORWARD_MAX_MEMORY", strconv.Itoa(defaultMaxMemory)))
+	if err != nil || maxMemory <= 0 {
+		return ForwarderConfig{}, false, fmt.Errorf("invalid AUDIT_FORWARD_MAX_MEMORY")
+	}
+	return ForwarderConfig{
+		Addr:          addr,
+		Format:        format,
+		SpoolDir:      strings.TrimSpace(os.Getenv("AUDIT_FORWARD_SPOOL_DIR")),
+		MaxMemory:     maxMemory,
+		RetryInterval: time.Duration(retryMs) * time.Millisecond,
+	}, true, nil
+}
+
+// Enqueue spools e for delivery. It returns an error only if the event could
+// not be persisted to the spool, or ErrSpoolFull if the in-memory spool is at
+// its cap.
+func (f *Forwarder) Enqueue(e Event) error {
+	f.mu.Lock()
+	if f.cfg.SpoolDir == "" && len(f.memory) >= f.cfg.MaxMemory {
+		f.mu.Unlock()
+		return ErrSpoolFull
+	}
+	f.seq++
+	item := spooled{seq: f.seq, event: e}
+	if f.cfg.SpoolDir == "" {
+		f.memory = append(f.memory, item)
+		f.mu.Unlock()
+	} else {
+		f.mu.Unlock()
+		if err := f.writeSpool(item); err != nil {
+			return err
+		}
+	}
+	select {
+	case f.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Pending reports how many events are waiting for delivery.
+func (f *Forwarder) Pending() int {
+	items, err := f.pending()
+	if err != nil {
+		return 0
+	}
+	return len(items)
+}
+
+// Run delivers spooled events until ctx is cancelled.
+func (f *Forwarder) Run(ctx context.Context) {
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	for {
+		items, err := f.pending()
+		if err != nil {
+			log.Error().Err(err).Msg("read audit spool")
+		}
+		for _, it := range items {
+			if conn == nil {
+				conn, err = f.dial(ctx, "tcp", f.cfg.Addr)
+				if err != nil {
+					log.Warn().Err(err).Str("addr", f.cfg.Addr).Int("pending", len(items)).Msg("audit sink unavailable; events remain spooled")
+					conn = nil
+					break
+				}
+			}
+			if err := f.send(conn, it.event); err != nil {
+				log.Warn().Err(err).Str("addr", f.cfg.Addr).Msg("audit forward failed; will retry")
+				conn.Close()
+				conn = nil
+				break
+			}
+			f.ack(it)
+		}
+
+		wait := f.cfg.RetryInterval
+		if conn != nil && f.Pending() == 0 {
+			wait = time.Hour
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-f.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+func (f *Forwarder) send(conn net.Conn, e Event) error {
+	line, err := Marshal(f.cfg.Format, e)
+	if err != nil {
+		return err
+	}
+	var frame []byte
+	if f.cfg.Format == FormatSyslog {
+		frame = append([]byte(strconv.Itoa(len(line))+" "), line...)
+	} else {
+		frame = append(line, '\n')
+	}
+	if err := conn.SetWriteDeadline(time.Now().Add(f.cfg.DialTimeout)); err != nil {
+		return err
+	}
+	_, err = conn.Write(frame)
+	return err
+}
+
+func (f *Forwarder) pending() ([]spooled, error) {
+	if f.cfg.SpoolDir != "" {
+		return f.readSpool()
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]spooled(nil), f.memory...), nil
+}
+
+func (f *Forwarder) ack(it spooled) {
+	if f.cfg.SpoolDir != "" {
+		if err := os.Remove(f.spoolPath(it.seq)); err != nil && !os.IsNotExist(err) {
+			log.Error().Err(err).Uint64("seq", it.seq).Msg("remove delivered audit event from spool")
+		}
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i, m := range f.memory {
+		if m.seq == it.seq {
+			f.memory = append(f.memory[:i], f.memory[i+1:]...)
+			return
+		}
+	}
+}
+
+func (f *Forwarder) spoolPath(seq uint64) string {
+	return filepath.Join(f.cfg.SpoolDir, fmt.Sprintf("%020d.json", seq))
+}
+
+func (f *Forwarder) writeSpool(it spooled) error {
+	b, err := json.Marshal(it.event)
+	if err != nil {
+		return err
+	}
+	final := f.spoolPath(it.seq)
+	tmp := final + ".tmp"
+	if err := writeSynced(tmp, b); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("spool audit event: %w", err)
+	}
+	if err := os.Rename(tmp, final); err != nil {
+		return fmt.Errorf("spool audit event: %w", err)
+	}
+	// the rename is only durable once the directory is
+	if err := syncDir(f.cfg.SpoolDir); err != nil {
+		return fmt.Errorf("spool audit event: %w", err)
+	}
+	return nil
+}
+
+// writeSynced writes b to a new file at path and syncs it to disk.
+func writeSynced(path string, b []byte) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(b); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+func (f *Forwarder) readSpool() ([]spooled, error) {
+	entries, err := os.ReadDir(f.cfg.SpoolDir)
+	if err != nil {
+		return nil, fmt.Errorf("read spool dir: %w", err)
+	}
+	var out []spooled
+	for _, ent := range entries {
+		name := ent.Name()
+		if ent.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, ".json"), 10, 64)
+		if err != nil {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(f.cfg.SpoolDir, name))
+		if err != nil {
+			return nil, fmt.Errorf("read spooled event: %w", err)
+		}
+		var e Event
+		if err := json.Unmarshal(b, &e); err != nil {
+			log.Error().Err(err).Str("file", name).Msg("discarding corrupt spooled audit event")
+			_ = os.Remove(filepath.Join(f.cfg.SpoolDir, name))
+			continue
+		}
+		out = append(out, spooled{seq: seq, event: e})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].seq < out[j].seq })
+	return out, nil
+}
+
+func envDefault(name, fallback string) string {
+	if v := strings.TrimSpace(os.Getenv(name)); v != "" {
+		return v
+	}
+	return fallback
+}
@@ -0,0 +1,147 @@
+package siem
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestForwarderSpoolsWhileSinkDownAndDeliversLater(t *testing.T) {
+	// reserve a port, then close it so the first delivery attempts fail
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	dir := t.TempDir()
+	f, err := NewForwarder(ForwarderConfig{Addr: addr, Format: FormatNDJSON, SpoolDir: dir, RetryInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("new forwarder: %v", err)
+	}
+	for _, id := range []string{"one", "two"} {
+		e := sampleEvent()
+		e.ResourceID = id
+		if err := f.Enqueue(e); err != nil {
+			t.Fatalf("enqueue: %v", err)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Run(ctx)
+
+	time.Sleep(60 * time.Millisecond)
+	if got := f.Pending(); got != 2 {
+		t.Fatalf("expected 2 spooled events while sink is down, got %d", got)
+	}
+
+	// a restarted forwarder must recover the spool
+	recovered, err := NewForwarder(ForwarderConfig{Addr: addr, Format: FormatNDJSON, SpoolDir: dir})
+	if err != nil {
+		t.Fatalf("recover forwarder: %v", err)
+	}
+	if got := recovered.Pending(); got != 2 {
+		t.Fatalf("expected recovered spool of 2, got %d", got)
+	}
+
+	ln, err = net.Listen("tcp", addr)
+	if err != nil {
+		t.Skipf("could not rebind sink port: %v", err)
+	}
+	defer ln.Close()
+	lines := make(chan string, 4)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		sc := bufio.NewScanner(conn)
+		for sc.Scan() {
+			lines <- sc.Text()
+		}
+	}()
+
+	for _, want := range []string{`"resource_id":"one"`, `"resource_id":"two"`} {
+		select {
+		case got := <-lines:
+			if !strings.Contains(got, want) {
+				t.Fatalf("expected %s in order, got %s", want, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("timed out waiting for %s", want)
+		}
+	}
+
+	deadline := time.Now().Add(time.Second)
+	for f.Pending() != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := f.Pending(); got != 0 {
+		t.Fatalf("expected spool drained after delivery, got %d", got)
+	}
+}
+
+func TestForwarderSyslogUsesOctetCounting(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	defer ln.Close()
+	frames := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf := make([]byte, 4096)
+		n, _ := conn.Read(buf)
+		frames <- string(buf[:n])
+	}()
+
+	f, err := NewForwarder(ForwarderConfig{Addr: ln.Addr().String(), Format: FormatSyslog, RetryInterval: 20 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("new forwarder: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go f.Run(ctx)
+	if err := f.Enqueue(sampleEvent()); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+
+	select {
+	case got := <-frames:
+		sp := strings.IndexByte(got, ' ')
+		if sp <= 0 || !strings.HasPrefix(got[sp+1:], "<110>1 ") {
+			t.Fatalf("expected octet-counted syslog frame, got %q", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatalf("timed out waiting for syslog frame")
+	}
+}
+
+func TestForwarderBoundsMemorySpool(t *testing.T) {
+	f, err := NewForwarder(ForwarderConfig{Addr: "127.0.0.1:1", Format: FormatNDJSON, MaxMemory: 2})
+	if err != nil {
+		t.Fatalf("new forwarder: %v", err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := f.Enqueue(sampleEvent()); err != nil {
+			t.Fatalf("enqueue %d: %v", i, err)
+		}
+	}
+	if err := f.Enqueue(sampleEvent()); !errors.Is(err, ErrSpoolFull) {
+		t.Fatalf("expected ErrSpoolFull past the cap, got %v", err)
+	}
+	if got := f.Pending(); got != 2 {
+		t.Fatalf("expected 2 spooled events, got %d", got)
+	}
+}
@@ -0,0 +1,218 @@
+// Package tenant configures the vault's tenants and resolves which tenant a
+// request acts for. Every tenant has its own log: evidence, leaf sequence,
+// checkpoints, idempotency keys and audit trail are kept apart in the
+// store, and its checkpoints are signed for its own origin.
+package tenant
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/SaridakisStamatisChristos/vault-api/service"
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+)
+
+// DefaultClaim is the token claim listing a caller's tenants.
+const DefaultClaim = "tenant"
+
+// Resolution failures.
+var (
+	ErrUnknownTenant = errors.New("tenant: unknown tenant")
+	ErrNotMember     = errors.New("tenant: caller is not a member of the tenant")
+	ErrAmbiguous     = errors.New("tenant: caller belongs to several tenants; select one in the path")
+	ErrClaimRequired = errors.New("tenant: token carries no tenant claim")
+)
+
+// Tenant is one tenant's configuration.
+type Tenant struct {
+	ID string `json:"id"`
+	// Origin names the tenant's log in its checkpoints. It defaults to the
+	// ID, except for the default tenant whose checkpoints predate origins.
+	Origin string `json:"origin,omitempty"`
+	// CheckpointSigningURL and CheckpointVerifyKeyB64 replace
+	// CHECKPOINT_SIGNING_URL and CHECKPOINT_VERIFY_PUBLIC_KEY_B64.
+	CheckpointSigningURL   string `json:"checkpoint_signing_url,omitempty"`
+	CheckpointVerifyKeyB64 string `json:"checkpoint_verify_public_key_b64,omitempty"`
+	// Quota caps the tenant as a whole through max_evidence,
+	// max_storage_bytes and max_bytes_per_day; zero is unlimited.
+	service.Quota
+	// SubjectQuota caps each subject ingesting into the tenant, unless
+	// SubjectQuotas lists the subject.
+	SubjectQuota  service.Quota            `json:"subject_quota"`
+	SubjectQuotas map[string]service.Quota `json:"subject_quotas,omitempty"`
+}
+
+// Quotas returns the limits the tenant's vault enforces.
+func (t Tenant) Quotas() service.Quotas {
+	return service.Quotas{Tenant: t.Quota, Subject: t.SubjectQuota, Subjects: t.SubjectQuotas}
+}
+
+// Config is the tenants file format. The default tenant always exists;
+// list it to configure it.
+type Config struct {
+	// Claim names the token claim, a string or a list of strings, naming
+	// the caller's tenants. Defaults to DefaultClaim.
+	Claim string `json:"claim,omitempty"`
+	// RequireClaim refuses tokens without the claim instead of confining
+	// them to the default tenant.
+	RequireClaim bool     `json:"require_claim,omitempty"`
+	Tenants      []Tenant `json:"tenants"`
+}
+
+// Single is the configuration of a single-tenant vault.
+func Single() *Config {
+	c, _ := Parse([]byte(`{"tenants":[]}`))
+	return c
+}
+
+// Parse decodes and validates a tenants file.
+func Parse(data []byte) (*Config, error) {
+	var c Config
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&c); err != nil {
+		return nil, fmt.Errorf("tenant: decode config: %w", err)
+	}
+	if c.Claim == "" {
+		c.Claim = DefaultClaim
+	}
+	seen := map[string]bool{}
+	for i := range c.Tenants {
+		t := &c.Tenants[i]
+		if err := store.ValidTenantID(t.ID); err != nil {
+			return nil, fmt.Errorf("tenant %d: %w", i, err)
+		}
+		if seen[t.ID] {
+			return nil, fmt.Errorf("tenant: duplicate tenant %q", t.ID)
+		}
+		seen[t.ID] = true
+		if t.Origin == "" && t.ID != store.DefaultTenant {
+			t.Origin = t.ID
+		}
+		if t.CheckpointSigningURL != "" {
+			if u, err := url.Parse(t.CheckpointSigningURL); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+				return nil, fmt.Errorf("tenant %q: invalid checkpoint_signing_url", t.ID)
+			}
+		}
+		if t.CheckpointVerifyKeyB64 != "" {
+			if raw, err := base64.StdEncoding.DecodeString(t.CheckpointVerifyKeyB64); err != nil || len(raw) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("tenant %q: checkpoint_verify_public_key_b64 must be a base64 Ed25519 public key", t.ID)
+			}
+		}
+		if err := validQuota(t.Quota); err != nil {
+			return nil, fmt.Errorf("tenant %q: %w", t.ID, err)
+		}
+		if err := validQuota(t.SubjectQuota); err != nil {
+			return nil, fmt.Errorf("tenant %q: subject_quota: %w", t.ID, err)
+		}
+		for sub, q := range t.SubjectQuotas {
+			if err := validQuota(q); err != nil {
+				return nil, fmt.Errorf("tenant %q: subject_quotas[%q]: %w", t.ID, sub, err)
+			}
+		}
+	}
+	if !seen[store.DefaultTenant] {
+		c.Tenants = append([]Tenant{{ID: store.DefaultTenant}}, c.Tenants...)
+	}
+	return &c, nil
+}
+
+func validQuota(q service.Quota) error {
+	if q.MaxEvidence < 0 || q.MaxStorageBytes < 0 || q.MaxBytesPerDay < 0 {
+		return errors.New("quota limits must not be negative")
+	}
+	return nil
+}
+
+// Load reads a tenants file.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("tenant: %w", err)
+	}
+	return Parse(data)
+}
+
+// LoadFromEnv loads the tenants file at TENANTS_FILE, or returns Single
+// when the variable is unset.
+func LoadFromEnv() (*Config, error) {
+	path := strings.TrimSpace(os.Getenv("TENANTS_FILE"))
+	if path == "" {
+		return Single(), nil
+	}
+	return Load(path)
+}
+
+// Lookup returns the configuration of tenant id.
+func (c *Config) Lookup(id string) (Tenant, bool) {
+	for _, t := range c.Tenants {
+		if t.ID == id {
+			return t, true
+		}
+	}
+	return Tenant{}, false
+}
+
+// Resolve returns the tenant a request acts for. pathTenant is the tenant
+// named in the request path, if any; it must be one of the caller's. A
+// caller without a path tenant acts for its only tenant. Callers whose
+// token lacks the claim belong to the default tenant alone, unless
+// RequireClaim is set.
+func (c *Config) Resolve(claims map[string]interface{}, pathTenant string) (string, error) {
+	member, hasClaim := claimTenants(claims[c.Claim])
+	if !hasClaim {
+		if c.RequireClaim {
+			return "", ErrClaimRequired
+		}
+		member = []string{store.DefaultTenant}
+	}
+	id := pathTenant
+	if id == "" {
+		switch len(member) {
+		case 0:
+			return "", ErrClaimRequired
+		case 1:
+			id = member[0]
+		default:
+			return "", ErrAmbiguous
+		}
+	}
+	if _, ok := c.Lookup(id); !ok {
+		return "", fmt.Errorf("%w %q", ErrUnknownTenant, id)
+	}
+	for _, m := range member {
+		if m == id {
+			return id, nil
+		}
+	}
+	return "", fmt.Errorf("%w %q", ErrNotMember, id)
+}
+
+// claimTenants reads a claim that is a string or a list of strings.
+func claimTenants(v interface{}) ([]string, bool) {
+	switch c := v.(type) {
+	case string:
+		if c == "" {
+			return nil, false
+		}
+		return []string{c}, true
+	case []string:
+		return c, true
+	case []interface{}:
+		out := make([]string, 0, len(c))
+		for _, x := range c {
+			if s, ok := x.(string); ok && s != "" {
+				out = append(out, s)
+			}
+		}
+		return out, true
+	}
+	return nil, false
+}
@@ -0,0 +1,84 @@
+package tenant
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/SaridakisStamatisChristos/vault-api/service"
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+)
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(`{"tenants":[{"id":"acme","max_evidence":10,"subject_quota":{"max_bytes_per_day":1024},"subject_quotas":{"ci":{"max_storage_bytes":4096}}},{"id":"globex","origin":"vault.globex.example/log"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Claim != DefaultClaim || len(c.Tenants) != 3 || c.Tenants[0].ID != store.DefaultTenant {
+		t.Fatalf("got %+v", c)
+	}
+	acme, _ := c.Lookup("acme")
+	if acme.Origin != "acme" || acme.MaxEvidence != 10 {
+		t.Fatalf("acme = %+v", acme)
+	}
+	if q := acme.Quotas(); q.Tenant.MaxEvidence != 10 || q.ForSubject("alice").MaxBytesPerDay != 1024 || q.ForSubject("ci") != (service.Quota{MaxStorageBytes: 4096}) {
+		t.Fatalf("acme quotas = %+v", q)
+	}
+	if globex, _ := c.Lookup("globex"); globex.Origin != "vault.globex.example/log" {
+		t.Fatalf("globex = %+v", globex)
+	}
+	if def, _ := c.Lookup(store.DefaultTenant); def.Origin != "" {
+		t.Fatalf("default tenant origin = %q", def.Origin)
+	}
+
+	for name, doc := range map[string]string{
+		"bad id":                 `{"tenants":[{"id":"Acme"}]}`,
+		"duplicate":              `{"tenants":[{"id":"acme"},{"id":"acme"}]}`,
+		"unknown field":          `{"tenants":[{"id":"acme","quota":1}]}`,
+		"signing url":            `{"tenants":[{"id":"acme","checkpoint_signing_url":"ftp://signer"}]}`,
+		"verify key":             `{"tenants":[{"id":"acme","checkpoint_verify_public_key_b64":"c2hvcnQ="}]}`,
+		"negative max":           `{"tenants":[{"id":"acme","max_evidence":-1}]}`,
+		"negative subject quota": `{"tenants":[{"id":"acme","subject_quotas":{"ci":{"max_bytes_per_day":-1}}}]}`,
+		"unknown quota":          `{"tenants":[{"id":"acme","subject_quota":{"max_records":1}}]}`,
+	} {
+		if _, err := Parse([]byte(doc)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	c, err := Parse([]byte(`{"tenants":[{"id":"acme"},{"id":"globex"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	both := map[string]interface{}{"tenant": []interface{}{"acme", "globex"}}
+	tests := []struct {
+		name   string
+		claims map[string]interface{}
+		path   string
+		want   string
+		err    error
+	}{
+		{name: "no claim", want: store.DefaultTenant},
+		{name: "no claim, other tenant", path: "acme", err: ErrNotMember},
+		{name: "single claim", claims: map[string]interface{}{"tenant": "acme"}, want: "acme"},
+		{name: "single claim, own path", claims: map[string]interface{}{"tenant": "acme"}, path: "acme", want: "acme"},
+		{name: "single claim, foreign path", claims: map[string]interface{}{"tenant": "acme"}, path: "globex", err: ErrNotMember},
+		{name: "several claims", claims: both, err: ErrAmbiguous},
+		{name: "several claims, path", claims: both, path: "globex", want: "globex"},
+		{name: "unknown tenant", claims: map[string]interface{}{"tenant": "initech"}, err: ErrUnknownTenant},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := c.Resolve(tt.claims, tt.path)
+			if !errors.Is(err, tt.err) || got != tt.want {
+				t.Fatalf("Resolve = %q, %v; want %q, %v", got, err, tt.want, tt.err)
+			}
+		})
+	}
+
+	c.RequireClaim = true
+	if _, err := c.Resolve(nil, ""); !errors.Is(err, ErrClaimRequired) {
+		t.Fatalf("err = %v, want ErrClaimRequired", err)
+	}
+}
@@ -0,0 +1,494 @@
+// Package webhook delivers vault events to subscriber endpoints with
+// HMAC-signed requests, exponential backoff, a dead-letter queue and a
+// bounded per-subscription delivery history. Subscriptions and deliveries
+// live in a store.WebhookStore, so any replica can send them.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Event types a subscription can select.
+const (
+	EventEvidenceSequenced   = "evidence.sequenced"
+	EventCheckpointPublished = "checkpoint.published"
+	EventVerificationFailed  = "verification.failed"
+	EventKeyRotated          = "key.rotated"
+)
+
+var knownEvents = map[string]bool{
+	EventEvidenceSequenced:   true,
+	EventCheckpointPublished: true,
+	EventVerificationFailed:  true,
+	EventKeyRotated:          true,
+}
+
+// Delivery states.
+const (
+	StatusPending   = store.WebhookPending
+	StatusDelivered = store.WebhookDelivered
+	StatusDead      = store.WebhookDead
+)
+
+// Headers set on every delivery.
+const (
+	HeaderSignature = "Vault-Signature"
+	HeaderEvent     = "Vault-Event"
+	HeaderDelivery  = "Vault-Delivery"
+)
+
+var (
+	ErrNotFound     = errors.New("webhook not found")
+	ErrInvalidURL   = errors.New("invalid webhook url")
+	ErrInvalidEvent = errors.New("unknown webhook event")
+)
+
+// Config controls retries and delivery.
+type Config struct {
+	MaxAttempts int
+	BackoffBase time.Duration
+	BackoffMax  time.Duration
+	Timeout     time.Duration
+	// AllowInsecure permits http:// endpoints, for local development.
+	AllowInsecure bool
+	// HistoryLimit bounds retained deliveries per subscription.
+	HistoryLimit int
+	// PollInterval bounds how long Run waits before looking for deliveries
+	// queued by other replicas.
+	PollInterval time.Duration
+}
+
+// ConfigFromEnv reads WEBHOOK_* settings.
+func ConfigFromEnv() (Config, error) {
+	cfg := Config{MaxAttempts: 8, BackoffBase: 2 * time.Second, BackoffMax: time.Hour, Timeout: 10 * time.Second, HistoryLimit: 200, PollInterval: 5 * time.Second}
+	if raw := strings.TrimSpace(os.Getenv("WEBHOOK_MAX_ATTEMPTS")); raw != "" {
+		n, err := strconv.Atoi(raw)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("invalid WEBHOOK_MAX_ATTEMPTS %q", raw)
+		}
+		cfg.MaxAttempts = n
+	}
+	for _, v := range []struct {
+		name string
+		dst  *time.Duration
+	}{
+		{"WEBHOOK_BACKOFF_BASE", &cfg.BackoffBase},
+		{"WEBHOOK_BACKOFF_MAX", &cfg.BackoffMax},
+		{"WEBHOOK_TIMEOUT", &cfg.Timeout},
+		{"WEBHOOK_POLL_INTERVAL", &cfg.PollInterval},
+	} {
+		raw := strings.TrimSpace(os.Getenv(v.name))
+		if raw == "" {
+			continue
+		}
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid %s %q", v.name, raw)
+		}
+		*v.dst = d
+	}
+	cfg.AllowInsecure = strings.EqualFold(strings.TrimSpace(os.Getenv("WEBHOOK_ALLOW_INSECURE_URLS")), "true")
+	return cfg, nil
+}
+
+// Subscription is a registered endpoint.
+type Subscription struct {
+	ID        string    `json:"id"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	CreatedBy string    `json:"created_by,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Attempt records one HTTP try.
+type Attempt struct {
+	At         time.Time `json:"at"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	Duration   int64     `json:"duration_ms"`
+}
+
+// Delivery is one event addressed to one subscription.
+type Delivery struct {
+	ID             string          `json:"id"`
+	SubscriptionID string          `json:"subscription_id"`
+	Event          string          `json:"event"`
+	Payload        json.RawMessage `json:"payload"`
+	Status         string          `json:"status"`
+	CreatedAt      time.Time       `json:"created_at"`
+	NextAttempt    time.Time       `json:"next_attempt,omitempty"`
+	Attempts       []Attempt       `json:"attempts"`
+}
+
+// claimSlack is added to the HTTP timeout for the claim on a delivery
+// being sent, so it outlives the attempt.
+const claimSlack = 5 * time.Second
+
+// Dispatcher manages one tenant's subscriptions and sends its deliveries.
+// It keeps no state of its own beyond a wake-up signal: dispatchers on
+// several replicas can share one store.
+type Dispatcher struct {
+	cfg    Config
+	store  store.WebhookStore
+	client *http.Client
+	now    func() time.Time
+	wake   chan struct{}
+}
+
+// NewDispatcher returns a dispatcher over s; call Run to start delivering.
+func NewDispatcher(cfg Config, s store.WebhookStore) *Dispatcher {
+	if cfg.MaxAttempts <= 0 {
+		cfg.MaxAttempts = 8
+	}
+	if cfg.BackoffBase <= 0 {
+		cfg.BackoffBase = 2 * time.Second
+	}
+	if cfg.BackoffMax <= 0 {
+		cfg.BackoffMax = time.Hour
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	if cfg.HistoryLimit <= 0 {
+		cfg.HistoryLimit = 200
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = 5 * time.Second
+	}
+	return &Dispatcher{
+		cfg:    cfg,
+		store:  s,
+		client: &http.Client{Timeout: cfg.Timeout},
+		now:    time.Now,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Subscribe registers url for events. An empty secret is generated; the
+// secret is returned only here.
+func (d *Dispatcher) Subscribe(ctx context.Context, rawURL string, events []string, secret, createdBy string) (Subscription, string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" || (u.Scheme != "https" && !(d.cfg.AllowInsecure && u.Scheme == "http")) {
+		return Subscription{}, "", ErrInvalidURL
+	}
+	if len(events) == 0 {
+		return Subscription{}, "", fmt.Errorf("%w: at least one event is required", ErrInvalidEvent)
+	}
+	for _, e := range events {
+		if !knownEvents[e] {
+			return Subscription{}, "", fmt.Errorf("%w: %q", ErrInvalidEvent, e)
+		}
+	}
+	if secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return Subscription{}, "", err
+		}
+		secret = hex.EncodeToString(b)
+	}
+	s := store.WebhookSubscription{ID: uuid.NewString(), URL: u.String(), Events: append([]string(nil), events...), Secret: secret, CreatedBy: createdBy, CreatedAt: d.now().UTC()}
+	if err := d.store.CreateWebhook(ctx, s); err != nil {
+		return Subscription{}, "", err
+	}
+	return fromStoreSubscription(s), secret, nil
+}
+
+// Unsubscribe removes a subscription along with its deliveries.
+func (d *Dispatcher) Unsubscribe(ctx context.Context, id string) error {
+	return notFound(d.store.DeleteWebhook(ctx, id))
+}
+
+// Subscriptions lists subscriptions ordered by creation time.
+func (d *Dispatcher) Subscriptions(ctx context.Context) ([]Subscription, error) {
+	subs, err := d.store.ListWebhooks(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Subscription, 0, len(subs))
+	for _, s := range subs {
+		out = append(out, fromStoreSubscription(s))
+	}
+	return out, nil
+}
+
+// Subscription returns one subscription.
+func (d *Dispatcher) Subscription(ctx context.Context, id string) (Subscription, error) {
+	s, err := d.store.GetWebhook(ctx, id)
+	if err != nil {
+		return Subscription{}, notFound(err)
+	}
+	return fromStoreSubscription(*s), nil
+}
+
+// Publish queues event for every subscription that selected it. Failures
+// are logged: events are not retried once publishing fails.
+func (d *Dispatcher) Publish(ctx context.Context, event string, data interface{}) {
+	body, err := json.Marshal(map[string]interface{}{"event": event, "data": data})
+	if err != nil {
+		log.Error().Err(err).Str("event", event).Msg("encode webhook payload")
+		return
+	}
+	subs, err := d.store.ListWebhooks(ctx)
+	if err != nil {
+		log.Error().Err(err).Str("event", event).Msg("list webhook subscriptions")
+		return
+	}
+	now := d.now().UTC()
+	var queued []store.WebhookDelivery
+	for _, s := range subs {
+		if contains(s.Events, event) {
+			queued = append(queued, store.WebhookDelivery{ID: uuid.NewString(), SubscriptionID: s.ID, Event: event, Payload: body, Status: StatusPending, CreatedAt: now, NextAttempt: now})
+		}
+	}
+	if len(queued) == 0 {
+		return
+	}
+	if err := d.store.QueueWebhookDeliveries(ctx, queued, d.cfg.HistoryLimit); err != nil {
+		log.Error().Err(err).Str("event", event).Msg("queue webhook deliveries")
+		return
+	}
+	d.signal()
+}
+
+// History returns deliveries for a subscription, newest first.
+func (d *Dispatcher) History(ctx context.Context, subscriptionID string) ([]Delivery, error) {
+	if _, err := d.store.GetWebhook(ctx, subscriptionID); err != nil {
+		return nil, notFound(err)
+	}
+	ds, err := d.store.WebhookDeliveries(ctx, subscriptionID, "")
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Delivery, 0, len(ds))
+	for _, del := range ds {
+		out = append(out, fromStoreDelivery(del))
+	}
+	return out, nil
+}
+
+// DeadLetters lists deliveries that exhausted their retries, oldest first.
+func (d *Dispatcher) DeadLetters(ctx context.Context) ([]Delivery, error) {
+	ds, err := d.store.WebhookDeliveries(ctx, "", StatusDead)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Delivery, 0, len(ds))
+	for i := len(ds) - 1; i >= 0; i-- {
+		out = append(out, fromStoreDelivery(ds[i]))
+	}
+	return out, nil
+}
+
+// Redeliver requeues a dead-lettered delivery with a fresh attempt budget.
+func (d *Dispatcher) Redeliver(ctx context.Context, deliveryID string) error {
+	if err := d.store.RedeliverWebhook(ctx, deliveryID, d.now().UTC()); err != nil {
+		return notFound(err)
+	}
+	d.signal()
+	return nil
+}
+
+// Run delivers due deliveries until ctx is cancelled.
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		wait := d.DeliverDue(ctx)
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-d.wake:
+			timer.Stop()
+		case <-timer.C:
+		}
+	}
+}
+
+// DeliverDue attempts every due delivery once and returns how long until
+// the next one is due, at most PollInterval. Each delivery is claimed in
+// the store before it is sent, so no two dispatchers send it at once.
+func (d *Dispatcher) DeliverDue(ctx context.Context) time.Duration {
+	for ctx.Err() == nil {
+		now := d.now()
+		claimed, err := d.store.ClaimWebhookDeliveries(ctx, now, now.Add(d.cfg.Timeout+claimSlack), 1)
+		if err != nil {
+			log.Error().Err(err).Msg("claim webhook deliveries")
+			return d.cfg.PollInterval
+		}
+		if len(claimed) == 0 {
+			break
+		}
+		d.deliver(ctx, claimed[0])
+	}
+	wait := d.cfg.PollInterval
+	next, err := d.store.NextWebhookAttempt(ctx)
+	if err != nil {
+		log.Error().Err(err).Msg("find next webhook delivery")
+		return wait
+	}
+	if !next.IsZero() && next.Sub(d.now()) < wait {
+		wait = next.Sub(d.now())
+	}
+	if wait < 0 {
+		wait = 0
+	}
+	return wait
+}
+
+// deliver sends one claimed delivery and records the outcome.
+func (d *Dispatcher) deliver(ctx context.Context, del store.WebhookDelivery) {
+	s, err := d.store.GetWebhook(ctx, del.SubscriptionID)
+	if err != nil {
+		// an unsubscribed delivery is gone with its subscription
+		if !errors.Is(err, store.ErrNotFound) {
+			log.Error().Err(err).Str("delivery_id", del.ID).Msg("load webhook subscription")
+		}
+		return
+	}
+	att := d.send(ctx, s.URL, s.Secret, del)
+	status, next := StatusPending, time.Time{}
+	switch {
+	case att.Error == "":
+		status = StatusDelivered
+	case len(del.Attempts)+1 >= d.cfg.MaxAttempts:
+		status = StatusDead
+		log.Warn().Str("delivery_id", del.ID).Str("subscription_id", del.SubscriptionID).Str("event", del.Event).Msg("webhook delivery dead-lettered")
+	default:
+		next = d.now().Add(d.backoff(len(del.Attempts) + 1))
+	}
+	err = d.store.FinishWebhookAttempt(ctx, del.ID, att, status, next)
+	if err != nil && !errors.Is(err, store.ErrNotFound) {
+		log.Error().Err(err).Str("delivery_id", del.ID).Msg("record webhook attempt")
+	}
+}
+
+// backoff doubles from BackoffBase per failed attempt, capped at BackoffMax.
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.cfg.BackoffBase
+	for i := 1; i < attempts && wait < d.cfg.BackoffMax; i++ {
+		wait *= 2
+	}
+	if wait > d.cfg.BackoffMax {
+		wait = d.cfg.BackoffMax
+	}
+	return wait
+}
+
+func (d *Dispatcher) send(ctx context.Context, target, secret string, del store.WebhookDelivery) store.WebhookAttempt {
+	start := d.now()
+	att := store.WebhookAttempt{At: start.UTC()}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(del.Payload))
+	if err != nil {
+		att.Error = err.Error()
+		return att
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, del.Event)
+	req.Header.Set(HeaderDelivery, del.ID)
+	req.Header.Set(HeaderSignature, Sign(secret, start, del.Payload))
+	resp, err := d.client.Do(req)
+	att.DurationMS = time.Since(start).Milliseconds()
+	if err != nil {
+		att.Error = err.Error()
+		return att
+	}
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	resp.Body.Close()
+	att.StatusCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		att.Error = fmt.Sprintf("endpoint returned %d", resp.StatusCode)
+	}
+	return att
+}
+
+// Sign computes the Vault-Signature header: t=<unix seconds>,v1=<hex
+// HMAC-SHA256 of "<t>.<body>">. Receivers should recompute it and reject
+// stale timestamps.
+func Sign(secret string, t time.Time, body []byte) string {
+	ts := strconv.FormatInt(t.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(ts))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + ts + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a Vault-Signature header against body, rejecting
+// signatures older than tolerance.
+func Verify(secret, header string, body []byte, now time.Time, tolerance time.Duration) bool {
+	var ts, sig string
+	for _, part := range strings.Split(header, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch k {
+		case "t":
+			ts = v
+		case "v1":
+			sig = v
+		}
+	}
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil || sig == "" {
+		return false
+	}
+	t := time.Unix(sec, 0)
+	if tolerance > 0 && (now.Sub(t) > tolerance || t.Sub(now) > tolerance) {
+		return false
+	}
+	want := Sign(secret, t, body)
+	return hmac.Equal([]byte(want), []byte("t="+ts+",v1="+sig))
+}
+
+func (d *Dispatcher) signal() {
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+}
+
+// notFound maps the store's ErrNotFound to this package's.
+func notFound(err error) error {
+	if errors.Is(err, store.ErrNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func fromStoreSubscription(s store.WebhookSubscription) Subscription {
+	return Subscription{ID: s.ID, URL: s.URL, Events: s.Events, CreatedBy: s.CreatedBy, CreatedAt: s.CreatedAt}
+}
+
+func fromStoreDelivery(del store.WebhookDelivery) Delivery {
+	out := Delivery{ID: del.ID, SubscriptionID: del.SubscriptionID, Event: del.Event, Payload: del.Payload, Status: del.Status, CreatedAt: del.CreatedAt, NextAttempt: del.NextAttempt, Attempts: []Attempt{}}
+	for _, a := range del.Attempts {
+		out.Attempts = append(out.Attempts, Attempt{At: a.At, StatusCode: a.StatusCode, Error: a.Error, Duration: a.DurationMS})
+	}
+	return out
+}
+
+func contains(list []string, v string) bool {
+	for _, s := range list {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
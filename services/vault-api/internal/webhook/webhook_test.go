@@ -0,0 +1,182 @@
+package webhook
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+)
+
+type receiver struct {
+	mu      sync.Mutex
+	fail    int
+	bodies  [][]byte
+	headers []http.Header
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.bodies = append(rc.bodies, body)
+	rc.headers = append(rc.headers, r.Header.Clone())
+	if rc.fail > 0 {
+		rc.fail--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// testDispatcher returns a dispatcher over a memory store whose clock the
+// test advances.
+func testDispatcher(maxAttempts int) (*Dispatcher, *time.Time) {
+	clock := time.Unix(1_700_000_000, 0)
+	d := NewDispatcher(Config{MaxAttempts: maxAttempts, BackoffBase: time.Second, BackoffMax: 4 * time.Second, AllowInsecure: true}, store.NewMemoryStore())
+	d.now = func() time.Time { return clock }
+	return d, &clock
+}
+
+func TestDeliverySignedAndRetried(t *testing.T) {
+	rc := &receiver{fail: 2}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	d, clock := testDispatcher(5)
+	ctx := context.Background()
+
+	sub, secret, err := d.Subscribe(ctx, srv.URL, []string{EventEvidenceSequenced}, "", "auditor")
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	d.Publish(ctx, EventCheckpointPublished, map[string]int{"tree_size": 1}) // not selected
+	d.Publish(ctx, EventEvidenceSequenced, map[string]interface{}{"evidence_id": "e1", "leaf_index": 0})
+
+	if wait := d.DeliverDue(ctx); wait != time.Second {
+		t.Fatalf("expected first backoff of 1s, got %v", wait)
+	}
+	*clock = clock.Add(time.Second)
+	if wait := d.DeliverDue(ctx); wait != 2*time.Second {
+		t.Fatalf("expected second backoff of 2s, got %v", wait)
+	}
+	*clock = clock.Add(2 * time.Second)
+	d.DeliverDue(ctx)
+
+	hist, err := d.History(ctx, sub.ID)
+	if err != nil || len(hist) != 1 {
+		t.Fatalf("history: %v %+v", err, hist)
+	}
+	if hist[0].Status != StatusDelivered || len(hist[0].Attempts) != 3 || hist[0].Attempts[0].StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected delivery %+v", hist[0])
+	}
+
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	last := rc.headers[len(rc.headers)-1]
+	if last.Get(HeaderEvent) != EventEvidenceSequenced || last.Get(HeaderDelivery) != hist[0].ID {
+		t.Fatalf("missing event headers: %v", last)
+	}
+	if !Verify(secret, last.Get(HeaderSignature), rc.bodies[len(rc.bodies)-1], *clock, 5*time.Minute) {
+		t.Fatalf("signature does not verify")
+	}
+	if Verify("wrong", last.Get(HeaderSignature), rc.bodies[len(rc.bodies)-1], *clock, 5*time.Minute) {
+		t.Fatalf("signature must not verify with another secret")
+	}
+}
+
+func TestDeadLetterAndRedeliver(t *testing.T) {
+	rc := &receiver{fail: 2}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	d, clock := testDispatcher(2)
+	ctx := context.Background()
+
+	if _, _, err := d.Subscribe(ctx, srv.URL, []string{EventKeyRotated}, "s3cret", ""); err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	d.Publish(ctx, EventKeyRotated, map[string]string{"key_ref": "k2"})
+	d.DeliverDue(ctx)
+	*clock = clock.Add(time.Second)
+	d.DeliverDue(ctx)
+
+	dead, err := d.DeadLetters(ctx)
+	if err != nil {
+		t.Fatalf("dead letters: %v", err)
+	}
+	if len(dead) != 1 || len(dead[0].Attempts) != 2 {
+		t.Fatalf("expected one dead letter after 2 attempts, got %+v", dead)
+	}
+	if err := d.Redeliver(ctx, dead[0].ID); err != nil {
+		t.Fatalf("redeliver: %v", err)
+	}
+	d.DeliverDue(ctx)
+	if dead, _ := d.DeadLetters(ctx); len(dead) != 0 {
+		t.Fatalf("redelivered message should leave the dead-letter queue")
+	}
+	if err := d.Redeliver(ctx, dead[0].ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for delivered message, got %v", err)
+	}
+}
+
+func TestSubscribeValidation(t *testing.T) {
+	d := NewDispatcher(Config{}, store.NewMemoryStore())
+	ctx := context.Background()
+	if _, _, err := d.Subscribe(ctx, "http://example.com/hook", []string{EventKeyRotated}, "", ""); !errors.Is(err, ErrInvalidURL) {
+		t.Fatalf("plain http must be rejected by default, got %v", err)
+	}
+	if _, _, err := d.Subscribe(ctx, "https://example.com/hook", []string{"evidence.deleted"}, "", ""); !errors.Is(err, ErrInvalidEvent) {
+		t.Fatalf("expected ErrInvalidEvent, got %v", err)
+	}
+	sub, secret, err := d.Subscribe(ctx, "https://example.com/hook", []string{EventKeyRotated}, "", "")
+	if err != nil || len(secret) != 64 {
+		t.Fatalf("expected generated secret, got %q %v", secret, err)
+	}
+	if err := d.Unsubscribe(ctx, sub.ID); err != nil {
+		t.Fatalf("unsubscribe: %v", err)
+	}
+	if _, err := d.History(ctx, sub.ID); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound after unsubscribe, got %v", err)
+	}
+}
+
+func TestQueuedDeliveriesSurviveRestart(t *testing.T) {
+	rc := &receiver{}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	s := store.NewMemoryStore()
+	cfg := Config{AllowInsecure: true, HistoryLimit: 2}
+	ctx := context.Background()
+
+	before := NewDispatcher(cfg, s)
+	sub, _, err := before.Subscribe(ctx, srv.URL, []string{EventKeyRotated}, "s3cret", "")
+	if err != nil {
+		t.Fatalf("subscribe: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		before.Publish(ctx, EventKeyRotated, map[string]int{"n": i})
+	}
+
+	// a dispatcher started later, or on another replica, sends them
+	after := NewDispatcher(cfg, s)
+	after.DeliverDue(ctx)
+	rc.mu.Lock()
+	sent := len(rc.bodies)
+	rc.mu.Unlock()
+	if sent != 3 {
+		t.Fatalf("expected the 3 queued deliveries to be sent, got %d", sent)
+	}
+	if again := after.DeliverDue(ctx); again != after.cfg.PollInterval {
+		t.Fatalf("nothing should be pending, next wait %v", again)
+	}
+
+	after.Publish(ctx, EventKeyRotated, map[string]int{"n": 3})
+	hist, err := after.History(ctx, sub.ID)
+	if err != nil || len(hist) != 2 || hist[0].Status != StatusPending || hist[1].Status != StatusDelivered {
+		t.Fatalf("history should keep the pending delivery and the newest delivered one: %v %+v", err, hist)
+	}
+}
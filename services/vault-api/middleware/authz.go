@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"context"
+	"sync"
+)
+
+// Resource is the target of an access, for decisions that depend on it.
+type Resource struct {
+	Type   string            `json:"type"`
+	ID     string            `json:"id"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Status string            `json:"status,omitempty"`
+}
+
+// AuthzInput is what an Authorizer decides on.
+type AuthzInput struct {
+	Method  string                 `json:"method"`
+	Path    string                 `json:"path"`
+	Subject string                 `json:"subject"`
+	Roles   []string               `json:"roles"`
+	Claims  map[string]interface{} `json:"claims"`
+	// Permissions are the permissions the route or handler requires.
+	Permissions []Permission `json:"permissions"`
+	// Granted are the permissions the role mapping, or the caller's API
+	// key, grants the caller.
+	Granted  []Permission `json:"granted"`
+	Resource *Resource    `json:"resource,omitempty"`
+	// Tenant is the tenant the request acts for, once resolved.
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// Decision is an authorization outcome.
+type Decision struct {
+	Allowed       bool
+	Reason        string
+	Engine        string
+	PolicyVersion string
+	// Audit asks for the decision to be recorded in the audit trail.
+	Audit bool
+}
+
+// Authorizer makes authorization decisions in place of the role mapping.
+type Authorizer interface {
+	Authorize(ctx context.Context, in AuthzInput) (Decision, error)
+}
+
+// DecisionRecorder persists decisions whose Audit flag is set.
+type DecisionRecorder func(ctx context.Context, in AuthzInput, d Decision)
+
+var (
+	authzMu  sync.Mutex
+	authz    Authorizer
+	recorder DecisionRecorder
+)
+
+// SetAuthorizer delegates Require and AuthorizeResource to a; nil restores
+// the role mapping.
+func SetAuthorizer(a Authorizer) {
+	authzMu.Lock()
+	defer authzMu.Unlock()
+	authz = a
+}
+
+// SetDecisionRecorder sets where audited decisions are recorded.
+func SetDecisionRecorder(r DecisionRecorder) {
+	authzMu.Lock()
+	defer authzMu.Unlock()
+	recorder = r
+}
+
+func currentAuthorizer() (Authorizer, DecisionRecorder) {
+	authzMu.Lock()
+	defer authzMu.Unlock()
+	return authz, recorder
+}
+
+type requestInfo struct {
+	method, path string
+}
+
+func authzInput(ctx context.Context, rp RolePermissions, perms []Permission, res *Resource) AuthzInput {
+	roles := RolesFromContext(ctx)
+	in := AuthzInput{
+		Subject:     SubjectFromContext(ctx),
+		Roles:       roles,
+		Claims:      ClaimsFromContext(ctx),
+		Permissions: perms,
+		Granted:     []Permission{},
+		Resource:    res,
+		Tenant:      TenantFromContext(ctx),
+	}
+	if req, ok := ctx.Value(ctxKeyRequest).(requestInfo); ok {
+		in.Method, in.Path = req.method, req.path
+	}
+	for _, p := range AllPermissions {
+		if grants(ctx, rp, p) {
+			in.Granted = append(in.Granted, p)
+		}
+	}
+	return in
+}
+
+// decide runs the authorizer and records the decision if asked to. Errors
+// deny.
+func decide(ctx context.Context, a Authorizer, rec DecisionRecorder, in AuthzInput) Decision {
+	d, err := a.Authorize(ctx, in)
+	if err != nil {
+		d = Decision{Reason: "authorizer error: " + err.Error(), Engine: d.Engine, PolicyVersion: d.PolicyVersion, Audit: true}
+	}
+	if d.Audit && rec != nil {
+		rec(ctx, in, d)
+	}
+	return d
+}
+
+// AuthorizeResource asks the configured Authorizer whether the caller may
+// use perm on res. ok is false when no Authorizer is configured, leaving
+// the decision to the caller.
+func AuthorizeResource(ctx context.Context, perm Permission, res Resource) (d Decision, ok bool) {
+	a, rec := currentAuthorizer()
+	if a == nil {
+		return Decision{}, false
+	}
+	rp, _ := ctx.Value(ctxKeyRolePermissions).(RolePermissions)
+	return decide(ctx, a, rec, authzInput(ctx, rp, []Permission{perm}, &res)), true
+}
+
+// CheckResource is AuthorizeResource without the decision recorder, for
+// filtering the resources a listing or stream shows.
+func CheckResource(ctx context.Context, perm Permission, res Resource) (d Decision, ok bool) {
+	a, _ := currentAuthorizer()
+	if a == nil {
+		return Decision{}, false
+	}
+	rp, _ := ctx.Value(ctxKeyRolePermissions).(RolePermissions)
+	return decide(ctx, a, nil, authzInput(ctx, rp, []Permission{perm}, &res)), true
+}
@@ -0,0 +1,87 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type fakeAuthorizer struct {
+	allow func(AuthzInput) bool
+	err   error
+}
+
+func (f fakeAuthorizer) Authorize(_ context.Context, in AuthzInput) (Decision, error) {
+	if f.err != nil {
+		return Decision{Engine: "fake"}, f.err
+	}
+	return Decision{Allowed: f.allow(in), Engine: "fake", PolicyVersion: "v1", Audit: true}, nil
+}
+
+func useAuthorizer(t *testing.T, a Authorizer) *[]AuthzInput {
+	t.Helper()
+	var recorded []AuthzInput
+	SetAuthorizer(a)
+	SetDecisionRecorder(func(_ context.Context, in AuthzInput, _ Decision) { recorded = append(recorded, in) })
+	t.Cleanup(func() {
+		SetAuthorizer(nil)
+		SetDecisionRecorder(nil)
+	})
+	return &recorded
+}
+
+func TestRequireDelegatesToAuthorizer(t *testing.T) {
+	t.Setenv("ENABLE_TEST_JWT", "true")
+	// The authorizer overrides the mapping: ingesters may manage, nobody else.
+	recorded := useAuthorizer(t, fakeAuthorizer{allow: func(in AuthzInput) bool {
+		return len(in.Roles) == 1 && in.Roles[0] == "ingester"
+	}})
+	h := JWT(Require(PermEvidenceManage)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := Resource{Type: "evidence", ID: "e1", Labels: map[string]string{"case": "1"}}
+		if d, ok := AuthorizeResource(r.Context(), PermEvidenceRead, res); !ok || !d.Allowed {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	for token, want := range map[string]int{"ingester-token": http.StatusOK, "auditor-token": http.StatusForbidden} {
+		req := httptest.NewRequest(http.MethodPost, "/evidence/e1/status", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Fatalf("%s: status %d, want %d", token, rr.Code, want)
+		}
+	}
+
+	// ingester: route + resource decision; auditor: route decision only.
+	if len(*recorded) != 3 {
+		t.Fatalf("recorded %d decisions, want 3", len(*recorded))
+	}
+	route, res := (*recorded)[0], (*recorded)[1]
+	if route.Method != http.MethodPost || route.Path != "/evidence/e1/status" || route.Resource != nil {
+		t.Fatalf("route input = %+v", route)
+	}
+	if len(route.Granted) == 0 || route.Permissions[0] != PermEvidenceManage {
+		t.Fatalf("route input lacks permissions: %+v", route)
+	}
+	if res.Resource == nil || res.Resource.Labels["case"] != "1" || res.Permissions[0] != PermEvidenceRead {
+		t.Fatalf("resource input = %+v", res)
+	}
+}
+
+func TestAuthorizerErrorDenies(t *testing.T) {
+	recorded := useAuthorizer(t, fakeAuthorizer{err: errors.New("boom")})
+	d, ok := AuthorizeResource(context.Background(), PermEvidenceRead, Resource{Type: "evidence", ID: "e1"})
+	if !ok || d.Allowed || d.Engine != "fake" || len(*recorded) != 1 {
+		t.Fatalf("error must deny and be recorded: %+v ok=%v recorded=%d", d, ok, len(*recorded))
+	}
+
+	SetAuthorizer(nil)
+	if _, ok := AuthorizeResource(context.Background(), PermEvidenceRead, Resource{}); ok {
+		t.Fatal("no authorizer must leave the decision to the caller")
+	}
+}
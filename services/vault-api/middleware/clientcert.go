@@ -0,0 +1,76 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"sync"
+
+	"github.com/SaridakisStamatisChristos/vault-api/internal/certauth"
+	"github.com/rs/zerolog/log"
+)
+
+// ctxKeyCertFingerprint holds the fingerprint of the client certificate a
+// request authenticated with.
+const ctxKeyCertFingerprint ctxKey = "cert_fingerprint"
+
+var (
+	clientCertMu      sync.Mutex
+	clientCertMapping *certauth.Config
+)
+
+// ConfigureClientCertAuth loads CLIENT_CERT_MAPPING_FILE. Without a mapping
+// client certificates do not authenticate requests.
+func ConfigureClientCertAuth() error {
+	cfg, err := certauth.LoadFromEnv()
+	if err != nil {
+		return err
+	}
+	SetClientCertMapping(cfg)
+	if cfg != nil {
+		log.Info().Int("rules", len(cfg.Rules)).Msg("client certificate authentication enabled")
+	}
+	return nil
+}
+
+// SetClientCertMapping installs the mapping JWT applies to verified client
+// certificates; nil disables certificate authentication.
+func SetClientCertMapping(cfg *certauth.Config) {
+	clientCertMu.Lock()
+	defer clientCertMu.Unlock()
+	clientCertMapping = cfg
+}
+
+// clientCertContext authenticates r by its verified client certificate. It
+// reports false when the connection carries no verified certificate or the
+// mapping has no rule for it.
+func clientCertContext(r *http.Request) (context.Context, bool) {
+	clientCertMu.Lock()
+	mapping := clientCertMapping
+	clientCertMu.Unlock()
+	if mapping == nil || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return nil, false
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	id, ok := mapping.Identify(cert)
+	if !ok {
+		log.Warn().Str("common_name", cert.Subject.CommonName).Str("fingerprint", certauth.Fingerprint(cert)).Msg("client certificate matches no mapping rule")
+		return nil, false
+	}
+	claims := make(map[string]interface{}, len(id.Claims)+1)
+	for k, v := range id.Claims {
+		claims[k] = v
+	}
+	claims["sub"] = id.Subject
+	ctx := context.WithValue(r.Context(), ctxKeyRoles, id.Roles)
+	ctx = context.WithValue(ctx, ctxKeySub, id.Subject)
+	ctx = context.WithValue(ctx, ctxKeyClaims, claims)
+	ctx = context.WithValue(ctx, ctxKeyCertFingerprint, id.Fingerprint)
+	return ctx, true
+}
+
+// ClientCertFingerprint returns the fingerprint of the client certificate
+// the request authenticated with, or "" for bearer tokens.
+func ClientCertFingerprint(ctx context.Context) string {
+	fp, _ := ctx.Value(ctxKeyCertFingerprint).(string)
+	return fp
+}
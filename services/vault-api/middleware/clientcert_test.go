@@ -0,0 +1,90 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/internal/certauth"
+)
+
+func TestJWTAcceptsMappedClientCertificate(t *testing.T) {
+	t.Setenv("AUTH_POLICY", "dev")
+	t.Setenv("ENV", "dev")
+	mapping, err := certauth.Parse([]byte(`{"rules":[{"common_name":"*.collectors.acme.example","subject_prefix":"cert:","roles":["ingester"],"claims":{"tenant":"acme"}}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	SetClientCertMapping(mapping)
+	t.Cleanup(func() { SetClientCertMapping(nil) })
+
+	caKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	caTmpl := &x509.Certificate{SerialNumber: big.NewInt(1), Subject: pkix.Name{CommonName: "collectors CA"}, IsCA: true, BasicConstraintsValid: true, KeyUsage: x509.KeyUsageCertSign, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+	caDER, _ := x509.CreateCertificate(rand.Reader, caTmpl, caTmpl, &caKey.PublicKey, caKey)
+	ca, _ := x509.ParseCertificate(caDER)
+	clientCert := func(cn string) tls.Certificate {
+		key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		tmpl := &x509.Certificate{SerialNumber: big.NewInt(time.Now().UnixNano()), Subject: pkix.Name{CommonName: cn}, ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, NotBefore: time.Now().Add(-time.Hour), NotAfter: time.Now().Add(time.Hour)}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, ca, &key.PublicKey, caKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+	}
+
+	srv := httptest.NewUnstartedServer(JWT(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s|%s|%v|%s", SubjectFromContext(r.Context()), strings.Join(RolesFromContext(r.Context()), ","), ClaimsFromContext(r.Context())["tenant"], ClientCertFingerprint(r.Context()))
+	})))
+	srv.TLS = &tls.Config{ClientAuth: tls.VerifyClientCertIfGiven, ClientCAs: x509.NewCertPool()}
+	srv.TLS.ClientCAs.AddCert(ca)
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(cert *tls.Certificate, bearer string) (int, string) {
+		t.Helper()
+		transport := srv.Client().Transport.(*http.Transport).Clone()
+		if cert != nil {
+			transport.TLSClientConfig.Certificates = []tls.Certificate{*cert}
+		}
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		resp, err := (&http.Client{Transport: transport}).Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	collector := clientCert("cam-7.collectors.acme.example")
+	code, body := get(&collector, "")
+	parts := strings.Split(body, "|")
+	if code != http.StatusOK || len(parts) != 4 || parts[0] != "cert:cam-7.collectors.acme.example" || parts[1] != "ingester" || parts[2] != "acme" || !strings.HasPrefix(parts[3], "sha256:") {
+		t.Fatalf("certificate auth: %d %q", code, body)
+	}
+	// a bearer token takes precedence over the certificate
+	if code, body := get(&collector, "auditor-token"); code != http.StatusOK || body != "auditor-token|auditor|<nil>|" {
+		t.Fatalf("bearer precedence: %d %q", code, body)
+	}
+	laptop := clientCert("laptop.corp.example")
+	if code, _ := get(&laptop, ""); code != http.StatusUnauthorized {
+		t.Fatalf("unmapped certificate: %d", code)
+	}
+	if code, _ := get(nil, ""); code != http.StatusUnauthorized {
+		t.Fatalf("no credentials: %d", code)
+	}
+}
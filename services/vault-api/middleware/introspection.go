@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// introspectionConfig is the INTROSPECTION_* configuration of the
+// introspection auth policy.
+type introspectionConfig struct {
+	URL          string
+	ClientID     string
+	ClientSecret string
+	Timeout      time.Duration
+	// CacheTTL bounds how long an active response is reused; it is never
+	// reused past the token's exp.
+	CacheTTL time.Duration
+	// NegativeCacheTTL is how long an inactive response is reused.
+	NegativeCacheTTL time.Duration
+	MaxEntries       int
+}
+
+func loadIntrospectionConfig() (introspectionConfig, error) {
+	cfg := introspectionConfig{
+		URL:              strings.TrimSpace(os.Getenv("INTROSPECTION_URL")),
+		ClientID:         strings.TrimSpace(os.Getenv("INTROSPECTION_CLIENT_ID")),
+		ClientSecret:     os.Getenv("INTROSPECTION_CLIENT_SECRET"),
+		Timeout:          time.Duration(parseIntEnvDefault("INTROSPECTION_TIMEOUT_MS", 5000)) * time.Millisecond,
+		CacheTTL:         time.Duration(parseIntEnvDefault("INTROSPECTION_CACHE_TTL_SECONDS", 60)) * time.Second,
+		NegativeCacheTTL: time.Duration(parseIntEnvDefault("INTROSPECTION_NEGATIVE_CACHE_TTL_SECONDS", 10)) * time.Second,
+		MaxEntries:       int(parseIntEnvDefault("INTROSPECTION_CACHE_MAX_ENTRIES", 10000)),
+	}
+	if cfg.URL == "" {
+		return cfg, fmt.Errorf("introspection requires INTROSPECTION_URL")
+	}
+	if u, err := url.Parse(cfg.URL); err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return cfg, fmt.Errorf("INTROSPECTION_URL must be an http(s) URL")
+	}
+	if (cfg.ClientID == "") != (cfg.ClientSecret == "") {
+		return cfg, fmt.Errorf("INTROSPECTION_CLIENT_ID and INTROSPECTION_CLIENT_SECRET must be set together")
+	}
+	return cfg, nil
+}
+
+// introspectionEntry is a cached introspection response; nil claims mark
+// an inactive token.
+type introspectionEntry struct {
+	claims  jwt.MapClaims
+	expires time.Time
+}
+
+// introspector validates opaque tokens at an RFC 7662 endpoint. Responses
+// are cached by token hash; failed calls are not cached.
+type introspector struct {
+	cfg    introspectionConfig
+	client *http.Client
+
+	mu    sync.Mutex
+	cache map[string]introspectionEntry
+}
+
+func newIntrospector(cfg introspectionConfig) *introspector {
+	return &introspector{cfg: cfg, client: &http.Client{Timeout: cfg.Timeout}, cache: map[string]introspectionEntry{}}
+}
+
+// introspect returns the claims of an active token, or nil for an
+// inactive one.
+func (in *introspector) introspect(ctx context.Context, token string) (jwt.MapClaims, error) {
+	sum := sha256.Sum256([]byte(token))
+	key := hex.EncodeToString(sum[:])
+	now := time.Now()
+	in.mu.Lock()
+	e, ok := in.cache[key]
+	in.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		if e.claims == nil {
+			recordIntrospection("negative_cache_hit")
+		} else {
+			recordIntrospection("cache_hit")
+		}
+		return e.claims, nil
+	}
+
+	claims, err := in.call(ctx, token)
+	if err != nil {
+		recordIntrospection("error")
+		return nil, err
+	}
+	e = introspectionEntry{claims: claims, expires: now.Add(in.cfg.NegativeCacheTTL)}
+	if claims != nil {
+		recordIntrospection("active")
+		e.expires = now.Add(in.cfg.CacheTTL)
+		if exp, ok := claims["exp"].(float64); ok && time.Unix(int64(exp), 0).Before(e.expires) {
+			e.expires = time.Unix(int64(exp), 0)
+		}
+	} else {
+		recordIntrospection("inactive")
+	}
+	if now.Before(e.expires) {
+		in.store(key, e, now)
+	}
+	return claims, nil
+}
+
+// store caches e. A full cache drops expired entries, and everything if
+// that is not enough.
+func (in *introspector) store(key string, e introspectionEntry, now time.Time) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+	if len(in.cache) >= in.cfg.MaxEntries {
+		for k, old := range in.cache {
+			if !now.Before(old.expires) {
+				delete(in.cache, k)
+			}
+		}
+		if len(in.cache) >= in.cfg.MaxEntries {
+			in.cache = map[string]introspectionEntry{}
+		}
+	}
+	in.cache[key] = e
+}
+
+func (in *introspector) call(ctx context.Context, token string) (jwt.MapClaims, error) {
+	form := url.Values{"token": {token}, "token_type_hint": {"access_token"}}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, in.cfg.URL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if in.cfg.ClientID != "" {
+		// RFC 6749 section 2.3.1: credentials are form-encoded first
+		req.SetBasicAuth(url.QueryEscape(in.cfg.ClientID), url.QueryEscape(in.cfg.ClientSecret))
+	}
+	resp, err := in.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection endpoint answered %d", resp.StatusCode)
+	}
+	var claims jwt.MapClaims
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("decode introspection response: %w", err)
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return nil, nil
+	}
+	return claims, nil
+}
@@ -12,6 +12,8 @@ import (
 	"github.com/MicahParks/keyfunc"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/rs/zerolog/log"
+
+	"github.com/SaridakisStamatisChristos/vault-api/internal/apikey"
 )
 
 type ctxKey string
@@ -19,10 +21,21 @@ type ctxKey string
 const (
 	ctxKeyRoles ctxKey = "roles"
 	ctxKeySub   ctxKey = "sub"
+	// ctxKeyClaims holds the validated token claims.
+	ctxKeyClaims ctxKey = "claims"
+	// ctxKeyRolePermissions holds the mapping applied by Require.
+	ctxKeyRolePermissions ctxKey = "role_permissions"
+	// ctxKeyRequest holds the method and path Require authorized.
+	ctxKeyRequest ctxKey = "authz_request"
+	// ctxKeyTenant holds the tenant the request acts for.
+	ctxKeyTenant ctxKey = "tenant"
 
 	authPolicyDev        = "dev"
 	authPolicyJWKSStrict = "jwks_strict"
 	authPolicyJWKSRBAC   = "jwks_rbac"
+	// authPolicyIntrospection validates bearer tokens, opaque or not, at
+	// an RFC 7662 introspection endpoint.
+	authPolicyIntrospection = "introspection"
 )
 
 type resolvedAuthPolicy struct {
@@ -33,6 +46,7 @@ type resolvedAuthPolicy struct {
 	EnforceRequiredClaim bool
 	RequireKid           bool
 	RequireRoles         bool
+	Introspect           bool
 }
 
 type startupAuthConfig struct {
@@ -49,6 +63,7 @@ type startupAuthConfig struct {
 	HasJWTIssuer       bool
 	HasJWTAudience     bool
 	HasRequiredJWTVars bool
+	HasIntrospection   bool
 }
 
 func loadStartupAuthConfig() startupAuthConfig {
@@ -59,6 +74,7 @@ func loadStartupAuthConfig() startupAuthConfig {
 	jwksURL := strings.TrimSpace(os.Getenv("JWKS_URL"))
 	jwtIssuer := strings.TrimSpace(firstNonEmptyEnv("JWT_ISSUER", "JWT_REQUIRED_ISSUER"))
 	jwtAudience := parseCSVEnvFirstNonEmpty("JWT_AUDIENCE", "JWT_REQUIRED_AUDIENCE")
+	introspectionURL := strings.TrimSpace(os.Getenv("INTROSPECTION_URL"))
 
 	policy := resolveAuthPolicy(appEnv, jwksURL)
 	if policyName == "" {
@@ -84,6 +100,7 @@ func loadStartupAuthConfig() startupAuthConfig {
 		HasJWTIssuer:       hasJWTIssuer,
 		HasJWTAudience:     hasJWTAudience,
 		HasRequiredJWTVars: hasJWKSURL && hasJWTIssuer && hasJWTAudience,
+		HasIntrospection:   introspectionURL != "",
 	}
 }
 
@@ -101,6 +118,7 @@ func ValidateAuthStartupConfig() error {
 		Bool("has_jwks_url", cfg.HasJWKSURL).
 		Bool("has_jwt_issuer", cfg.HasJWTIssuer).
 		Bool("has_jwt_audience", cfg.HasJWTAudience).
+		Bool("has_introspection_url", cfg.HasIntrospection).
 		Msg("auth startup configuration resolved")
 
 	if cfg.Policy.Mode == authPolicyDev && !(cfg.AppEnv == "dev" || cfg.AllowInsecureDev) {
@@ -115,10 +133,26 @@ func ValidateAuthStartupConfig() error {
 		return fmt.Errorf("%s requires JWKS_URL, JWT_ISSUER, and JWT_AUDIENCE", cfg.Policy.Mode)
 	}
 
+	if cfg.Policy.Mode == authPolicyIntrospection {
+		if !cfg.HasIntrospection || !cfg.HasJWTIssuer || !cfg.HasJWTAudience {
+			return fmt.Errorf("%s requires INTROSPECTION_URL, JWT_ISSUER, and JWT_AUDIENCE", cfg.Policy.Mode)
+		}
+		if _, err := loadIntrospectionConfig(); err != nil {
+			return err
+		}
+	}
+
+	if _, err := loadRoleMapping(); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-// JWT is a middleware that validates a Bearer token.
+// JWT is a middleware that validates a Bearer token. Bearer tokens shaped
+// like API keys go to the SetAPIKeyVerifier verifier instead. Requests
+// without one may authenticate with a verified client certificate mapped by
+// ConfigureClientCertAuth.
 func JWT(next http.Handler) http.Handler {
 	var jwks *keyfunc.JWKS
 	jwksURL := strings.TrimSpace(os.Getenv("JWKS_URL"))
@@ -132,6 +166,11 @@ func JWT(next http.Handler) http.Handler {
 	jwksMaxAttempts := parseIntEnvDefault("JWT_JWKS_MAX_ATTEMPTS", 12)
 	jwksRetryMs := parseIntEnvDefault("JWT_JWKS_RETRY_MS", 2000)
 	maxTokenTTLSeconds := parseIntEnvDefault("JWT_MAX_TOKEN_TTL_SECONDS", 0)
+	rolePermissions, err := loadRolePermissions()
+	if err != nil {
+		// Require fails closed on the same error
+		log.Error().Err(err).Msg("invalid RBAC configuration")
+	}
 
 	strictConfigValid := true
 	if policy.JWKSRequired && jwksURL == "" {
@@ -147,6 +186,23 @@ func JWT(next http.Handler) http.Handler {
 			Msgf("invalid JWT configuration for %s policy: JWT_REQUIRED_ISSUER and JWT_REQUIRED_AUDIENCE must both be set", policy.Mode)
 	}
 
+	roleMap, err := loadRoleMapping()
+	if err != nil {
+		strictConfigValid = false
+		log.Error().Err(err).Msg("invalid role claim mapping; JWT auth is fail-closed")
+	}
+
+	var introspect *introspector
+	if policy.Introspect {
+		cfg, err := loadIntrospectionConfig()
+		if err != nil {
+			strictConfigValid = false
+			log.Error().Err(err).Msg("invalid introspection configuration; introspection auth is fail-closed")
+		} else {
+			introspect = newIntrospector(cfg)
+		}
+	}
+
 	log.Info().
 		Str("resolved_auth_policy", policy.Mode).
 		Str("auth_policy_source", policy.Source).
@@ -155,6 +211,7 @@ func JWT(next http.Handler) http.Handler {
 		Bool("require_kid", policy.RequireKid).
 		Bool("require_roles", policy.RequireRoles).
 		Bool("enforce_required_claims", policy.EnforceRequiredClaim).
+		Bool("introspect", policy.Introspect).
 		Bool("strict_config_valid", strictConfigValid).
 		Str("jwks_url", jwksURL).
 		Str("app_env", appEnv).
@@ -165,6 +222,10 @@ func JWT(next http.Handler) http.Handler {
 		Int64("jwks_max_attempts", jwksMaxAttempts).
 		Int64("jwks_retry_ms", jwksRetryMs).
 		Int64("max_token_ttl_seconds", maxTokenTTLSeconds).
+		Int("role_claims", len(roleMap.RoleClaims)).
+		Int("group_claims", len(roleMap.GroupClaims)).
+		Int("group_role_mappings", len(roleMap.GroupRoles)).
+		Int("scope_role_mappings", len(roleMap.ScopeRoles)).
 		Msg("JWT middleware configuration")
 
 	if policy.JWKSRequired && jwksURL != "" {
@@ -186,10 +247,49 @@ func JWT(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		tokenStr, ok := extractBearerToken(r.Header.Get("Authorization"))
 		if !ok {
+			// machine clients may authenticate with a client certificate
+			// instead; a bearer token always takes precedence
+			if ctx, ok := clientCertContext(r); ok {
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
 			w.WriteHeader(http.StatusUnauthorized)
 			return
 		}
 
+		// API keys are checked against the store under every policy
+		if apikey.Is(tokenStr) {
+			ctx, ok := apiKeyContext(r, tokenStr)
+			if !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+
+		if policy.Introspect {
+			if !strictConfigValid || introspect == nil {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			claims, err := introspect.introspect(r.Context(), tokenStr)
+			if err != nil {
+				log.Warn().Err(err).Msg("token introspection failed")
+			}
+			if claims == nil || !validateStandardClaims(claims, requiredIssuer, requiredAudience, time.Now(), time.Duration(clockSkew)*time.Second, time.Duration(maxTokenTTLSeconds)*time.Second) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			roles := roleMap.roles(claims)
+			if policy.RequireRoles && !hasMinimumRBACRoles(rolePermissions, roles) {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(tokenContext(r.Context(), claims, roles)))
+			return
+		}
+
 		if policy.JWKSRequired && (!strictConfigValid || (jwksURL != "" && jwks == nil)) {
 			w.WriteHeader(http.StatusUnauthorized)
 			return
@@ -213,15 +313,12 @@ func JWT(next http.Handler) http.Handler {
 				w.WriteHeader(http.StatusUnauthorized)
 				return
 			}
-			roles := parseRoles(claims)
-			if policy.RequireRoles && !hasMinimumRBACRoles(roles) {
+			roles := roleMap.roles(claims)
+			if policy.RequireRoles && !hasMinimumRBACRoles(rolePermissions, roles) {
 				w.WriteHeader(http.StatusUnauthorized)
 				return
 			}
-			sub, _ := claims["sub"].(string)
-			ctx := context.WithValue(r.Context(), ctxKeyRoles, roles)
-			ctx = context.WithValue(ctx, ctxKeySub, sub)
-			next.ServeHTTP(w, r.WithContext(ctx))
+			next.ServeHTTP(w, r.WithContext(tokenContext(r.Context(), claims, roles)))
 			return
 		}
 
@@ -238,12 +335,33 @@ func JWT(next http.Handler) http.Handler {
 		if strings.Contains(lower, "ingest") || strings.Contains(lower, "ingester") {
 			roles = append(roles, "ingester")
 		}
+		if strings.Contains(lower, "admin") {
+			roles = append(roles, "admin")
+		}
+		sub := tokenStr
 		ctx := context.WithValue(r.Context(), ctxKeyRoles, roles)
-		ctx = context.WithValue(ctx, ctxKeySub, tokenStr)
+		// test tokens shaped like a JWT carry unverified claims for
+		// exercising attribute rules in development
+		testClaims := jwt.MapClaims{}
+		if _, _, err := new(jwt.Parser).ParseUnverified(tokenStr, testClaims); err == nil {
+			ctx = context.WithValue(ctx, ctxKeyClaims, map[string]interface{}(testClaims))
+			if s, ok := testClaims["sub"].(string); ok && s != "" {
+				sub = s
+			}
+		}
+		ctx = context.WithValue(ctx, ctxKeySub, sub)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// tokenContext records the subject, roles and claims of a validated token.
+func tokenContext(ctx context.Context, claims jwt.MapClaims, roles []string) context.Context {
+	sub, _ := claims["sub"].(string)
+	ctx = context.WithValue(ctx, ctxKeyRoles, roles)
+	ctx = context.WithValue(ctx, ctxKeySub, sub)
+	return context.WithValue(ctx, ctxKeyClaims, map[string]interface{}(claims))
+}
+
 func resolveAuthPolicy(appEnv, jwksURL string) resolvedAuthPolicy {
 	mode := strings.ToLower(strings.TrimSpace(os.Getenv("AUTH_POLICY")))
 	source := "explicit_auth_policy"
@@ -279,6 +397,8 @@ func resolveAuthPolicy(appEnv, jwksURL string) resolvedAuthPolicy {
 		return resolvedAuthPolicy{Mode: authPolicyJWKSRBAC, Source: source, JWKSRequired: true, EnforceRequiredClaim: true, RequireKid: true, RequireRoles: true}
 	case authPolicyJWKSStrict:
 		return resolvedAuthPolicy{Mode: authPolicyJWKSStrict, Source: source, JWKSRequired: strings.TrimSpace(jwksURL) != "", EnforceRequiredClaim: true, RequireKid: true}
+	case authPolicyIntrospection:
+		return resolvedAuthPolicy{Mode: authPolicyIntrospection, Source: source, EnforceRequiredClaim: true, RequireRoles: true, Introspect: true}
 	default:
 		log.Error().Str("auth_policy", mode).Msg("invalid AUTH_POLICY value; defaulting to jwks_strict")
 		return resolvedAuthPolicy{Mode: authPolicyJWKSStrict, Source: "invalid_auth_policy_default", JWKSRequired: strings.TrimSpace(jwksURL) != "", EnforceRequiredClaim: true, RequireKid: true}
@@ -306,34 +426,9 @@ func isTestJWTAllowedEnv(appEnv string) bool {
 	}
 }
 
-func hasMinimumRBACRoles(roles []string) bool {
-	for _, role := range roles {
-		if role == "auditor" || role == "ingester" {
-			return true
-		}
-	}
-	return false
-}
-
-func parseRoles(claims jwt.MapClaims) []string {
-	var roles []string
-	rc, ok := claims["roles"]
-	if !ok {
-		return roles
-	}
-	switch v := rc.(type) {
-	case []interface{}:
-		for _, it := range v {
-			if s, ok := it.(string); ok {
-				roles = append(roles, s)
-			}
-		}
-	case []string:
-		roles = append(roles, v...)
-	case string:
-		roles = append(roles, v)
-	}
-	return roles
+// hasMinimumRBACRoles reports whether roles carry any permission at all.
+func hasMinimumRBACRoles(rp RolePermissions, roles []string) bool {
+	return rp.GrantsAny(roles)
 }
 
 func extractBearerToken(auth string) (string, bool) {
@@ -465,6 +560,13 @@ func RolesFromContext(ctx context.Context) []string {
 	return nil
 }
 
+// ClaimsFromContext returns the token claims extracted by the JWT
+// middleware, or nil.
+func ClaimsFromContext(ctx context.Context) map[string]interface{} {
+	claims, _ := ctx.Value(ctxKeyClaims).(map[string]interface{})
+	return claims
+}
+
 // SubjectFromContext returns the subject (sub) claim or token string.
 func SubjectFromContext(ctx context.Context) string {
 	if v := ctx.Value(ctxKeySub); v != nil {
@@ -474,3 +576,14 @@ func SubjectFromContext(ctx context.Context) string {
 	}
 	return ""
 }
+
+// WithTenant records the tenant a request acts for.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, ctxKeyTenant, tenant)
+}
+
+// TenantFromContext returns the tenant set by WithTenant, or "".
+func TenantFromContext(ctx context.Context) string {
+	t, _ := ctx.Value(ctxKeyTenant).(string)
+	return t
+}
@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJWT_IntrospectionPolicy(t *testing.T) {
+	now := time.Now().Unix()
+	responses := map[string]map[string]interface{}{
+		"opaque-good":     {"active": true, "sub": "svc-1", "iss": "issuer-ok", "aud": "vault-api", "iat": now, "exp": now + 300, "roles": []string{"ingester"}},
+		"opaque-inactive": {"active": false},
+		"opaque-aud":      {"active": true, "sub": "svc-2", "iss": "issuer-ok", "aud": "other-api", "iat": now, "exp": now + 300, "roles": []string{"ingester"}},
+		"opaque-iss":      {"active": true, "sub": "svc-3", "iss": "issuer-other", "aud": "vault-api", "iat": now, "exp": now + 300, "roles": []string{"ingester"}},
+		"opaque-expired":  {"active": true, "sub": "svc-4", "iss": "issuer-ok", "aud": "vault-api", "iat": now - 600, "exp": now - 300, "roles": []string{"ingester"}},
+		"opaque-noroles":  {"active": true, "sub": "svc-5", "iss": "issuer-ok", "aud": []string{"vault-api"}, "iat": now, "exp": now + 300},
+	}
+	var calls int64
+	var failing atomic.Bool
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&calls, 1)
+		if user, pass, ok := r.BasicAuth(); !ok || user != "vault" || pass != "s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if failing.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if err := r.ParseForm(); err != nil || r.PostForm.Get("token_type_hint") != "access_token" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		resp, ok := responses[r.PostForm.Get("token")]
+		if !ok {
+			resp = map[string]interface{}{"active": false}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+
+	t.Setenv("AUTH_POLICY", authPolicyIntrospection)
+	t.Setenv("INTROSPECTION_URL", srv.URL)
+	t.Setenv("INTROSPECTION_CLIENT_ID", "vault")
+	t.Setenv("INTROSPECTION_CLIENT_SECRET", "s3cret")
+	t.Setenv("JWT_ISSUER", "issuer-ok")
+	t.Setenv("JWT_AUDIENCE", "vault-api")
+	if err := ValidateAuthStartupConfig(); err != nil {
+		t.Fatalf("valid introspection config rejected: %v", err)
+	}
+
+	h := JWT(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(SubjectFromContext(r.Context()) + "|" + strings.Join(RolesFromContext(r.Context()), ",")))
+	}))
+	get := func(token string) (int, string) {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		return rw.Code, rw.Body.String()
+	}
+
+	if code, body := get("opaque-good"); code != http.StatusOK || body != "svc-1|ingester" {
+		t.Fatalf("active token: %d %q", code, body)
+	}
+	for _, tok := range []string{"opaque-inactive", "opaque-aud", "opaque-iss", "opaque-expired", "opaque-noroles", "unknown"} {
+		if code, _ := get(tok); code != http.StatusUnauthorized {
+			t.Errorf("%s: %d, want 401", tok, code)
+		}
+	}
+
+	// active and inactive responses are both cached
+	before := atomic.LoadInt64(&calls)
+	if code, _ := get("opaque-good"); code != http.StatusOK {
+		t.Fatalf("cached active token: %d", code)
+	}
+	if code, _ := get("opaque-inactive"); code != http.StatusUnauthorized {
+		t.Fatalf("cached inactive token: %d", code)
+	}
+	if n := atomic.LoadInt64(&calls); n != before {
+		t.Fatalf("cached tokens were introspected again: %d calls", n-before)
+	}
+
+	// failures are not cached
+	failing.Store(true)
+	if code, _ := get("opaque-new"); code != http.StatusUnauthorized {
+		t.Fatalf("endpoint failure must fail closed: %d", code)
+	}
+	failing.Store(false)
+	responses["opaque-new"] = responses["opaque-good"]
+	if code, _ := get("opaque-new"); code != http.StatusOK {
+		t.Fatalf("failure was cached: %d", code)
+	}
+}
+
+func TestValidateAuthStartupConfig_IntrospectionPolicyMissingRequiredVars(t *testing.T) {
+	t.Setenv("ENV", "prod")
+	t.Setenv("AUTH_POLICY", authPolicyIntrospection)
+	t.Setenv("INTROSPECTION_URL", "https://idp.example/oauth2/introspect")
+	t.Setenv("JWT_ISSUER", "issuer-1")
+	t.Setenv("JWT_AUDIENCE", "")
+	if err := ValidateAuthStartupConfig(); err == nil {
+		t.Fatal("expected startup error without JWT_AUDIENCE")
+	}
+	t.Setenv("JWT_AUDIENCE", "vault-api")
+	t.Setenv("INTROSPECTION_CLIENT_ID", "vault")
+	if err := ValidateAuthStartupConfig(); err == nil {
+		t.Fatal("expected startup error for a client ID without a secret")
+	}
+}
@@ -190,13 +190,13 @@ func TestResolveAuthPolicy(t *testing.T) {
 }
 
 func TestHasMinimumRBACRoles(t *testing.T) {
-	if hasMinimumRBACRoles([]string{"viewer"}) {
+	if hasMinimumRBACRoles(DefaultRolePermissions(), []string{"viewer"}) {
 		t.Fatalf("expected viewer-only roles to fail")
 	}
-	if !hasMinimumRBACRoles([]string{"auditor"}) {
+	if !hasMinimumRBACRoles(DefaultRolePermissions(), []string{"auditor"}) {
 		t.Fatalf("expected auditor role to pass")
 	}
-	if !hasMinimumRBACRoles([]string{"ingester"}) {
+	if !hasMinimumRBACRoles(DefaultRolePermissions(), []string{"ingester"}) {
 		t.Fatalf("expected ingester role to pass")
 	}
 }
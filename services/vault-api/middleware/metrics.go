@@ -19,8 +19,73 @@ var (
 	vaultDurationSumByOperation    sync.Map // map[string]*uint64, microseconds
 	vaultDurationCountByOperation  sync.Map // map[string]*uint64
 	vaultDurationBucketsByOp       sync.Map // map[string][]*uint64
+
+	vaultPromisesBreachedTotal uint64
+	vaultPromisesOutstanding   int64
+
+	vaultPipelineDeadLettersTotal uint64
+
+	vaultRateLimitedByScopeClass sync.Map // map[string]*uint64, key=scope|class
+	vaultRateLimitErrorsTotal    uint64
+
+	vaultQuotaExceeded sync.Map // map[string]*uint64, key=tenant|scope|quota
+	vaultTenantUsage   sync.Map // map[string]*int64, key=tenant|resource
+
+	vaultIntrospectionsByResult sync.Map // map[string]*uint64
 )
 
+// RecordPromiseBreaches counts inclusion promises whose merge delay expired
+// before the evidence was sequenced.
+func RecordPromiseBreaches(n int) {
+	atomic.AddUint64(&vaultPromisesBreachedTotal, uint64(n))
+}
+
+// SetPromisesOutstanding reports how many issued promises await inclusion.
+func SetPromisesOutstanding(n int) {
+	atomic.StoreInt64(&vaultPromisesOutstanding, int64(n))
+}
+
+// RecordPipelineDeadLetter counts ingest events parked on the dead-letter
+// topic instead of being sequenced.
+func RecordPipelineDeadLetter() {
+	atomic.AddUint64(&vaultPipelineDeadLettersTotal, 1)
+}
+
+// recordRateLimited counts a request refused with 429.
+func recordRateLimited(scope, class string) {
+	ptr, _ := vaultRateLimitedByScopeClass.LoadOrStore(scope+"|"+class, new(uint64))
+	atomic.AddUint64(ptr.(*uint64), 1)
+}
+
+// recordRateLimitError counts requests let through because the limiter
+// failed.
+func recordRateLimitError() {
+	atomic.AddUint64(&vaultRateLimitErrorsTotal, 1)
+}
+
+// RecordQuotaExceeded counts an ingest refused because tenant's quota, or
+// the quota of a subject in it, was exhausted.
+func RecordQuotaExceeded(tenant, scope, quota string) {
+	ptr, _ := vaultQuotaExceeded.LoadOrStore(tenant+"|"+scope+"|"+quota, new(uint64))
+	atomic.AddUint64(ptr.(*uint64), 1)
+}
+
+// SetTenantUsage reports the records, stored payload bytes and payload
+// bytes ingested today in tenant's vault.
+func SetTenantUsage(tenant string, evidence, storageBytes, bytesToday int64) {
+	for resource, v := range map[string]int64{"evidence": evidence, "storage_bytes": storageBytes, "bytes_today": bytesToday} {
+		ptr, _ := vaultTenantUsage.LoadOrStore(tenant+"|"+resource, new(int64))
+		atomic.StoreInt64(ptr.(*int64), v)
+	}
+}
+
+// recordIntrospection counts a token lookup by the introspection policy:
+// active, inactive, error, cache_hit or negative_cache_hit.
+func recordIntrospection(result string) {
+	ptr, _ := vaultIntrospectionsByResult.LoadOrStore(result, new(uint64))
+	atomic.AddUint64(ptr.(*uint64), 1)
+}
+
 var durationBucketsSeconds = []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5}
 
 type statusRecorder struct {
@@ -33,6 +98,12 @@ func (sr *statusRecorder) WriteHeader(code int) {
 	sr.ResponseWriter.WriteHeader(code)
 }
 
+// Unwrap lets http.ResponseController reach the underlying writer for
+// flushing and per-request deadlines.
+func (sr *statusRecorder) Unwrap() http.ResponseWriter {
+	return sr.ResponseWriter
+}
+
 func Metrics(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		sr := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
@@ -138,20 +209,90 @@ func MetricsHandler() http.Handler {
 			return true
 		})
 
+		b.WriteString("# HELP vault_api_promises_breached_total Inclusion promises not honoured within their maximum merge delay.\n")
+		b.WriteString("# TYPE vault_api_promises_breached_total counter\n")
+		b.WriteString(fmt.Sprintf("vault_api_promises_breached_total %d\n", atomic.LoadUint64(&vaultPromisesBreachedTotal)))
+		b.WriteString("# HELP vault_api_promises_outstanding Issued inclusion promises awaiting sequencing.\n")
+		b.WriteString("# TYPE vault_api_promises_outstanding gauge\n")
+		b.WriteString(fmt.Sprintf("vault_api_promises_outstanding %d\n", atomic.LoadInt64(&vaultPromisesOutstanding)))
+		b.WriteString("# HELP vault_api_pipeline_dead_letters_total Ingest events moved to the dead-letter topic.\n")
+		b.WriteString("# TYPE vault_api_pipeline_dead_letters_total counter\n")
+		b.WriteString(fmt.Sprintf("vault_api_pipeline_dead_letters_total %d\n", atomic.LoadUint64(&vaultPipelineDeadLettersTotal)))
+
+		b.WriteString("# HELP vault_api_rate_limited_total Requests refused with 429 by limit scope and route class.\n")
+		b.WriteString("# TYPE vault_api_rate_limited_total counter\n")
+		vaultRateLimitedByScopeClass.Range(func(k, v interface{}) bool {
+			parts := strings.SplitN(k.(string), "|", 2)
+			b.WriteString(fmt.Sprintf("vault_api_rate_limited_total{scope=\"%s\",class=\"%s\"} %d\n", parts[0], parts[1], atomic.LoadUint64(v.(*uint64))))
+			return true
+		})
+		b.WriteString("# HELP vault_api_rate_limit_errors_total Requests allowed because the rate limiter was unavailable.\n")
+		b.WriteString("# TYPE vault_api_rate_limit_errors_total counter\n")
+		b.WriteString(fmt.Sprintf("vault_api_rate_limit_errors_total %d\n", atomic.LoadUint64(&vaultRateLimitErrorsTotal)))
+
+		b.WriteString("# HELP vault_api_quota_exceeded_total Ingests refused with quota_exceeded by tenant, quota scope and quota.\n")
+		b.WriteString("# TYPE vault_api_quota_exceeded_total counter\n")
+		vaultQuotaExceeded.Range(func(k, v interface{}) bool {
+			parts := strings.SplitN(k.(string), "|", 3)
+			b.WriteString(fmt.Sprintf("vault_api_quota_exceeded_total{tenant=\"%s\",scope=\"%s\",quota=\"%s\"} %d\n", parts[0], parts[1], parts[2], atomic.LoadUint64(v.(*uint64))))
+			return true
+		})
+		b.WriteString("# HELP vault_api_tenant_usage Tenant usage measured against quotas: evidence, storage_bytes and bytes_today.\n")
+		b.WriteString("# TYPE vault_api_tenant_usage gauge\n")
+		vaultTenantUsage.Range(func(k, v interface{}) bool {
+			parts := strings.SplitN(k.(string), "|", 2)
+			b.WriteString(fmt.Sprintf("vault_api_tenant_usage{tenant=\"%s\",resource=\"%s\"} %d\n", parts[0], parts[1], atomic.LoadInt64(v.(*int64))))
+			return true
+		})
+
+		b.WriteString("# HELP vault_api_token_introspections_total Bearer token lookups by the introspection policy by result.\n")
+		b.WriteString("# TYPE vault_api_token_introspections_total counter\n")
+		vaultIntrospectionsByResult.Range(func(k, v interface{}) bool {
+			b.WriteString(fmt.Sprintf("vault_api_token_introspections_total{result=\"%s\"} %d\n", k.(string), atomic.LoadUint64(v.(*uint64))))
+			return true
+		})
+
 		_, _ = w.Write([]byte(b.String()))
 	})
 }
 
 func classifyOperation(method string, path string) string {
+	path = untenantedPath(path)
 	if method == http.MethodPost && path == "/api/v1/evidence" {
 		return "ingest"
 	}
+	if method == http.MethodPost && path == "/api/v1/evidence:batch" {
+		return "ingest_batch"
+	}
+	if method == http.MethodGet && path == "/api/v1/evidence" {
+		return "search"
+	}
+	if path == "/api/v1/events" {
+		return "events"
+	}
+	if path == "/api/v1/evidence/upload" || strings.HasPrefix(path, "/api/v1/uploads") {
+		return "upload"
+	}
 	if method == http.MethodGet && strings.HasPrefix(path, "/api/v1/evidence/") && strings.HasSuffix(path, "/proof") {
 		return "proof"
 	}
 	return "other"
 }
 
+// untenantedPath maps /api/v1/tenants/{tenant}/... to /api/v1/... so
+// tenant routes share their operation.
+func untenantedPath(path string) string {
+	const prefix = "/api/v1/tenants/"
+	if !strings.HasPrefix(path, prefix) {
+		return path
+	}
+	rest := path[len(prefix):]
+	if i := strings.IndexByte(rest, '/'); i >= 0 {
+		return "/api/v1" + rest[i:]
+	}
+	return "/api/v1"
+}
+
 func classifyStatusClass(status int) string {
 	if status >= 500 {
 		return "5xx"
@@ -36,3 +36,18 @@ func TestMetricsMiddlewareRecordsAndServesMetrics(t *testing.T) {
 		t.Fatalf("expected operation/status counter metric in output")
 	}
 }
+
+func TestMetricsServesPromiseGauges(t *testing.T) {
+	RecordPromiseBreaches(2)
+	SetPromisesOutstanding(3)
+
+	rr := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rr.Body.String()
+	if !strings.Contains(body, "vault_api_promises_breached_total ") {
+		t.Fatalf("expected promise breach counter in output")
+	}
+	if !strings.Contains(body, "vault_api_promises_outstanding 3\n") {
+		t.Fatalf("expected outstanding promise gauge in output")
+	}
+}
@@ -1,13 +1,181 @@
 package middleware
 
 import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net"
 	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/internal/ratelimit"
+	"github.com/rs/zerolog/log"
+)
+
+// RateLimitClasses are the route classes limits can be configured for.
+// They match the operation label of the request metrics.
+var RateLimitClasses = []string{"ingest", "ingest_batch", "search", "events", "upload", "proof", "other", ratelimit.DefaultClass}
+
+var (
+	rateLimitMu      sync.Mutex
+	rateLimitCfg     ratelimit.Config
+	rateLimitLimiter ratelimit.Limiter
 )
 
-// RateLimit is a stub for Redis-backed sliding window.
+// ConfigureRateLimit applies the RATE_LIMIT* configuration. Without rules
+// RateLimit and RateLimitIP pass every request through.
+func ConfigureRateLimit() error {
+	cfg, err := ratelimit.ConfigFromEnv()
+	if err != nil {
+		return err
+	}
+	for _, rules := range []map[string]ratelimit.Rule{cfg.Subject, cfg.IP} {
+		for class := range rules {
+			if !validRateLimitClass(class) {
+				return fmt.Errorf("unknown rate limit class %q; expected one of %s", class, strings.Join(RateLimitClasses, ", "))
+			}
+		}
+	}
+	if !cfg.Enabled() {
+		SetRateLimiter(nil, cfg)
+		return nil
+	}
+	l, err := ratelimit.NewLimiter(cfg)
+	if err != nil {
+		return err
+	}
+	SetRateLimiter(l, cfg)
+	log.Info().Str("backend", cfg.Backend).Int("subject_rules", len(cfg.Subject)).Int("ip_rules", len(cfg.IP)).Msg("rate limiting enabled")
+	return nil
+}
+
+// SetRateLimiter installs l with the rules in cfg; nil disables limiting.
+func SetRateLimiter(l ratelimit.Limiter, cfg ratelimit.Config) {
+	rateLimitMu.Lock()
+	defer rateLimitMu.Unlock()
+	rateLimitLimiter, rateLimitCfg = l, cfg
+}
+
+func currentRateLimiter() (ratelimit.Limiter, ratelimit.Config) {
+	rateLimitMu.Lock()
+	defer rateLimitMu.Unlock()
+	return rateLimitLimiter, rateLimitCfg
+}
+
+func validRateLimitClass(class string) bool {
+	for _, c := range RateLimitClasses {
+		if c == class {
+			return true
+		}
+	}
+	return false
+}
+
+// RateLimit applies the per-subject limits (RATE_LIMITS) to authenticated
+// requests. It runs after JWT.
 func RateLimit(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// TODO: implement redis-based sliding window per-subject
-		next.ServeHTTP(w, r)
+		l, cfg := currentRateLimiter()
+		sub := SubjectFromContext(r.Context())
+		if l == nil || sub == "" || allowRequest(w, r, l, "subject", sub, cfg.Subject) {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// RateLimitIP applies the per-address limits (RATE_LIMITS_IP). It runs
+// before authentication so that unauthenticated floods are limited too.
+func RateLimitIP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l, cfg := currentRateLimiter()
+		if l == nil || allowRequest(w, r, l, "ip", clientIP(r, cfg.TrustedProxies), cfg.IP) {
+			next.ServeHTTP(w, r)
+		}
 	})
 }
+
+// allowRequest counts the request against the rule for its route class and
+// answers 429 when the rule is exhausted. Limiter errors let the request
+// through.
+func allowRequest(w http.ResponseWriter, r *http.Request, l ratelimit.Limiter, scope, id string, rules map[string]ratelimit.Rule) bool {
+	class, rule, ok := ratelimit.RuleFor(rules, classifyOperation(r.Method, r.URL.Path))
+	if !ok {
+		return true
+	}
+	res, err := l.Allow(r.Context(), scope+":"+class+":"+id, rule, time.Now())
+	if err != nil {
+		log.Warn().Err(err).Str("scope", scope).Str("class", class).Msg("rate limiter unavailable; allowing request")
+		recordRateLimitError()
+		return true
+	}
+	setRateLimitHeaders(w, res, rule)
+	if res.Allowed {
+		return true
+	}
+	recordRateLimited(scope, class)
+	w.Header().Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusTooManyRequests)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"error": "rate_limited", "detail": fmt.Sprintf("%s limit for %s requests exceeded", scope, class)})
+	return false
+}
+
+// setRateLimitHeaders reports res in the RateLimit-* headers unless an
+// earlier limit on the same request left fewer requests.
+func setRateLimitHeaders(w http.ResponseWriter, res ratelimit.Result, rule ratelimit.Rule) {
+	h := w.Header()
+	if prev, err := strconv.Atoi(h.Get("RateLimit-Remaining")); err == nil && prev <= res.Remaining {
+		return
+	}
+	reset := res.Reset
+	if !res.Allowed {
+		reset = res.RetryAfter
+	}
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(reset)))
+	h.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", rule.Limit, ceilSeconds(rule.Window)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// clientIP returns the address of the peer, or, when the peer is a trusted
+// proxy, the nearest untrusted address in X-Forwarded-For.
+func clientIP(r *http.Request, trusted []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	peer, err := netip.ParseAddr(host)
+	if err != nil || !isTrustedProxy(peer, trusted) {
+		return host
+	}
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		host = addr.String()
+		if !isTrustedProxy(addr, trusted) {
+			break
+		}
+	}
+	return host
+}
+
+func isTrustedProxy(addr netip.Addr, trusted []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, p := range trusted {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/internal/ratelimit"
+)
+
+func useRateLimit(t *testing.T, l ratelimit.Limiter, cfg ratelimit.Config) {
+	t.Helper()
+	SetRateLimiter(l, cfg)
+	t.Cleanup(func() { SetRateLimiter(nil, ratelimit.Config{}) })
+}
+
+func TestRateLimitPerSubjectAndClass(t *testing.T) {
+	useRateLimit(t, ratelimit.NewMemory(), ratelimit.Config{Subject: map[string]ratelimit.Rule{"ingest": {Limit: 2, Window: time.Minute}}})
+	h := RateLimit(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusAccepted) }))
+	do := func(method, path, sub string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req = req.WithContext(context.WithValue(req.Context(), ctxKeySub, sub))
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		return rw
+	}
+
+	if rw := do(http.MethodPost, "/api/v1/evidence", "alice"); rw.Code != http.StatusAccepted || rw.Header().Get("RateLimit-Remaining") != "1" || rw.Header().Get("RateLimit-Policy") != "2;w=60" {
+		t.Fatalf("first: %d %v", rw.Code, rw.Header())
+	}
+	// tenant routes share the class
+	if rw := do(http.MethodPost, "/api/v1/tenants/acme/evidence", "alice"); rw.Code != http.StatusAccepted || rw.Header().Get("RateLimit-Remaining") != "0" {
+		t.Fatalf("second: %d %v", rw.Code, rw.Header())
+	}
+	rw := do(http.MethodPost, "/api/v1/evidence", "alice")
+	if rw.Code != http.StatusTooManyRequests || rw.Header().Get("Retry-After") == "" || rw.Header().Get("RateLimit-Limit") != "2" || !strings.Contains(rw.Body.String(), "rate_limited") {
+		t.Fatalf("third: %d %v %s", rw.Code, rw.Header(), rw.Body.String())
+	}
+	if rw := do(http.MethodPost, "/api/v1/evidence", "bob"); rw.Code != http.StatusAccepted {
+		t.Fatalf("other subject throttled: %d", rw.Code)
+	}
+	// search has no rule and no default
+	if rw := do(http.MethodGet, "/api/v1/evidence", "alice"); rw.Code != http.StatusAccepted || rw.Header().Get("RateLimit-Limit") != "" {
+		t.Fatalf("unlimited class: %d %v", rw.Code, rw.Header())
+	}
+
+	metrics := httptest.NewRecorder()
+	MetricsHandler().ServeHTTP(metrics, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	if !strings.Contains(metrics.Body.String(), `vault_api_rate_limited_total{scope="subject",class="ingest"}`) {
+		t.Fatalf("throttled request not counted:\n%s", metrics.Body.String())
+	}
+}
+
+type failingLimiter struct{}
+
+func (failingLimiter) Allow(context.Context, string, ratelimit.Rule, time.Time) (ratelimit.Result, error) {
+	return ratelimit.Result{}, errors.New("redis down")
+}
+
+func TestRateLimitIPFailsOpen(t *testing.T) {
+	useRateLimit(t, failingLimiter{}, ratelimit.Config{IP: map[string]ratelimit.Rule{ratelimit.DefaultClass: {Limit: 1, Window: time.Second}}})
+	h := RateLimitIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) }))
+	for i := 0; i < 3; i++ {
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, httptest.NewRequest(http.MethodGet, "/api/v1/audit", nil))
+		if rw.Code != http.StatusOK {
+			t.Fatalf("limiter errors must not reject requests: %d", rw.Code)
+		}
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted := []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}
+	tests := []struct {
+		remote, xff, want string
+	}{
+		{"203.0.113.9:4000", "", "203.0.113.9"},
+		// forwarded headers from untrusted peers are ignored
+		{"203.0.113.9:4000", "198.51.100.1", "203.0.113.9"},
+		{"10.1.2.3:4000", "198.51.100.1, 10.0.0.2", "198.51.100.1"},
+		// spoofed leftmost entries do not escape the limit
+		{"10.1.2.3:4000", "1.1.1.1, 198.51.100.1", "198.51.100.1"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = tt.remote
+		if tt.xff != "" {
+			req.Header.Set("X-Forwarded-For", tt.xff)
+		}
+		if got := clientIP(req, trusted); got != tt.want {
+			t.Errorf("clientIP(%s, %q) = %s, want %s", tt.remote, tt.xff, got, tt.want)
+		}
+	}
+}
+
+func TestConfigureRateLimitRejectsUnknownClass(t *testing.T) {
+	t.Setenv("RATE_LIMITS", "ingestion=10/s")
+	if err := ConfigureRateLimit(); err == nil {
+		t.Fatal("expected unknown class to be rejected")
+	}
+}
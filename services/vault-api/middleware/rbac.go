@@ -0,0 +1,213 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Permission is an action on a class of vault resources. Routes require
+// permissions; tokens carry roles, which RolePermissions maps to
+// permissions.
+type Permission string
+
+const (
+	PermEvidenceWrite Permission = "evidence:write"
+	PermEvidenceRead  Permission = "evidence:read"
+	// PermEvidenceManage covers legal holds, redaction and releases.
+	PermEvidenceManage Permission = "evidence:manage"
+	PermPayloadRead    Permission = "payload:read"
+	PermAuditRead      Permission = "audit:read"
+	PermCheckpointRead Permission = "checkpoint:read"
+	// PermBundleExport covers marking evidence exported. There is no bundle
+	// export route yet.
+	PermBundleExport  Permission = "bundle:export"
+	PermWebhookManage Permission = "webhook:manage"
+	PermAdminKeys     Permission = "admin:keys"
+	// PermPolicyEvaluate covers dry-run evaluation of the access policy.
+	PermPolicyEvaluate Permission = "policy:evaluate"
+)
+
+// permissionWildcard grants every permission in a role mapping.
+const permissionWildcard = "*"
+
+// AllPermissions lists every permission known to the vault.
+var AllPermissions = []Permission{
+	PermEvidenceWrite, PermEvidenceRead, PermEvidenceManage, PermPayloadRead,
+	PermAuditRead, PermCheckpointRead, PermBundleExport, PermWebhookManage,
+	PermAdminKeys, PermPolicyEvaluate,
+}
+
+// RolePermissions maps role names to the permissions they grant.
+type RolePermissions map[string][]Permission
+
+// DefaultRolePermissions is the mapping used when RBAC_ROLE_PERMISSIONS is
+// unset. It keeps the access the auditor and ingester roles always had.
+func DefaultRolePermissions() RolePermissions {
+	return RolePermissions{
+		"ingester": {PermEvidenceWrite, PermEvidenceRead, PermCheckpointRead},
+		"auditor": {
+			PermEvidenceRead, PermPayloadRead, PermAuditRead, PermCheckpointRead,
+			PermEvidenceManage, PermBundleExport, PermWebhookManage,
+		},
+		"admin": append([]Permission(nil), AllPermissions...),
+	}
+}
+
+// Grants reports whether any of roles grants p.
+func (rp RolePermissions) Grants(roles []string, p Permission) bool {
+	for _, role := range roles {
+		for _, granted := range rp[role] {
+			if granted == p {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// GrantsAny reports whether any of roles grants at least one permission.
+func (rp RolePermissions) GrantsAny(roles []string) bool {
+	for _, role := range roles {
+		if len(rp[role]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// parseRolePermissions decodes a JSON object of role name to permission
+// list, e.g. {"auditor":["audit:read","checkpoint:read"],"ops":["*"]}.
+func parseRolePermissions(raw string) (RolePermissions, error) {
+	var decoded map[string][]string
+	if err := json.Unmarshal([]byte(raw), &decoded); err != nil {
+		return nil, fmt.Errorf("RBAC_ROLE_PERMISSIONS: %w", err)
+	}
+	known := make(map[Permission]bool, len(AllPermissions))
+	for _, p := range AllPermissions {
+		known[p] = true
+	}
+	out := make(RolePermissions, len(decoded))
+	for role, perms := range decoded {
+		role = strings.TrimSpace(role)
+		if role == "" {
+			return nil, fmt.Errorf("RBAC_ROLE_PERMISSIONS: empty role name")
+		}
+		granted := []Permission{}
+		for _, name := range perms {
+			name = strings.TrimSpace(name)
+			if name == permissionWildcard {
+				granted = append(granted, AllPermissions...)
+				continue
+			}
+			if !known[Permission(name)] {
+				return nil, fmt.Errorf("RBAC_ROLE_PERMISSIONS: role %q: unknown permission %q", role, name)
+			}
+			granted = append(granted, Permission(name))
+		}
+		out[role] = granted
+	}
+	return out, nil
+}
+
+// loadRolePermissions returns the mapping in RBAC_ROLE_PERMISSIONS, which
+// replaces the defaults entirely, or DefaultRolePermissions when unset.
+func loadRolePermissions() (RolePermissions, error) {
+	raw := strings.TrimSpace(os.Getenv("RBAC_ROLE_PERMISSIONS"))
+	if raw == "" {
+		return DefaultRolePermissions(), nil
+	}
+	return parseRolePermissions(raw)
+}
+
+// ValidateRBACConfig rejects an unparsable role mapping before server
+// startup.
+func ValidateRBACConfig() error {
+	rp, err := loadRolePermissions()
+	if err != nil {
+		return err
+	}
+	roles := make([]string, 0, len(rp))
+	for role := range rp {
+		roles = append(roles, role)
+	}
+	sort.Strings(roles)
+	log.Info().Strs("roles", roles).Msg("RBAC role mapping resolved")
+	return nil
+}
+
+// Require returns middleware that answers 403 unless the caller holds every
+// permission in perms: API keys through their scopes, other credentials
+// through their roles. A configured Authorizer decides instead, with the
+// role mapping's grants as input. It must run after JWT. An invalid role
+// mapping fails closed.
+func Require(perms ...Permission) func(http.Handler) http.Handler {
+	rp, err := loadRolePermissions()
+	if err != nil {
+		log.Error().Err(err).Msg("invalid RBAC configuration; all requests will be denied")
+	}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err != nil {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			ctx := context.WithValue(r.Context(), ctxKeyRolePermissions, rp)
+			ctx = context.WithValue(ctx, ctxKeyRequest, requestInfo{method: r.Method, path: r.URL.Path})
+			allowed := false
+			if a, rec := currentAuthorizer(); a != nil {
+				allowed = decide(ctx, a, rec, authzInput(ctx, rp, perms, nil)).Allowed
+			} else {
+				allowed = grantsAll(ctx, rp, perms)
+			}
+			if !allowed {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+func grantsAll(ctx context.Context, rp RolePermissions, perms []Permission) bool {
+	for _, p := range perms {
+		if !grants(ctx, rp, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// grants reports whether the caller holds p: API keys by their scopes,
+// other credentials by the role mapping.
+func grants(ctx context.Context, rp RolePermissions, p Permission) bool {
+	if scopes, ok := APIKeyScopes(ctx); ok {
+		for _, s := range scopes {
+			if s == p {
+				return true
+			}
+		}
+		return false
+	}
+	return rp.Grants(RolesFromContext(ctx), p)
+}
+
+// HasPermission reports whether the caller holds p, for checks that depend
+// on the request body. It uses the mapping of the enclosing Require, or its
+// Authorizer, and is false outside one.
+func HasPermission(ctx context.Context, p Permission) bool {
+	rp, ok := ctx.Value(ctxKeyRolePermissions).(RolePermissions)
+	if !ok {
+		return false
+	}
+	if a, rec := currentAuthorizer(); a != nil {
+		return decide(ctx, a, rec, authzInput(ctx, rp, []Permission{p}, nil)).Allowed
+	}
+	return grants(ctx, rp, p)
+}
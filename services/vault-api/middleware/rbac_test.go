@@ -0,0 +1,99 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseRolePermissions(t *testing.T) {
+	rp, err := parseRolePermissions(`{"reader":["evidence:read"],"ops":["*"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rp.Grants([]string{"reader"}, PermEvidenceRead) || rp.Grants([]string{"reader"}, PermEvidenceWrite) {
+		t.Fatalf("reader mapping wrong: %v", rp["reader"])
+	}
+	for _, p := range AllPermissions {
+		if !rp.Grants([]string{"ops"}, p) {
+			t.Fatalf("wildcard must grant %s", p)
+		}
+	}
+	if rp.GrantsAny([]string{"auditor"}) {
+		t.Fatal("a configured mapping replaces the defaults")
+	}
+
+	for _, raw := range []string{`{"reader":["evidence:delete"]}`, `{" ":["evidence:read"]}`, `["auditor"]`} {
+		if _, err := parseRolePermissions(raw); err == nil {
+			t.Fatalf("expected %s to be rejected", raw)
+		}
+	}
+}
+
+func TestValidateRBACConfig(t *testing.T) {
+	t.Setenv("RBAC_ROLE_PERMISSIONS", `{"reader":["evidence:raed"]}`)
+	if err := ValidateRBACConfig(); err == nil {
+		t.Fatal("expected unknown permission to fail startup validation")
+	}
+	t.Setenv("RBAC_ROLE_PERMISSIONS", "")
+	if err := ValidateRBACConfig(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRequire(t *testing.T) {
+	t.Setenv("ENABLE_TEST_JWT", "true")
+	t.Setenv("RBAC_ROLE_PERMISSIONS", `{"auditor":["audit:read","evidence:manage"],"ingester":["evidence:write"]}`)
+	h := JWT(Require(PermEvidenceManage)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if HasPermission(r.Context(), PermBundleExport) {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})))
+
+	tests := []struct {
+		token string
+		want  int
+	}{
+		{token: "auditor-token", want: http.StatusOK},
+		{token: "ingester-token", want: http.StatusForbidden},
+		{token: "nobody", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Authorization", "Bearer "+tt.token)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		if rr.Code != tt.want {
+			t.Fatalf("%s: expected %d got %d", tt.token, tt.want, rr.Code)
+		}
+	}
+
+	t.Setenv("RBAC_ROLE_PERMISSIONS", `{"auditor":["evidence:manage","bundle:export"]}`)
+	h = JWT(Require(PermEvidenceManage)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !HasPermission(r.Context(), PermBundleExport) {
+			w.WriteHeader(http.StatusForbidden)
+		}
+	})))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer auditor-token")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected bundle:export to be visible to the handler, got %d", rr.Code)
+	}
+}
+
+func TestRequireFailsClosedOnInvalidMapping(t *testing.T) {
+	t.Setenv("ENABLE_TEST_JWT", "true")
+	t.Setenv("RBAC_ROLE_PERMISSIONS", `not json`)
+	h := JWT(Require(PermEvidenceRead)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})))
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Authorization", "Bearer admin-token")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if rr.Code != http.StatusForbidden {
+		t.Fatalf("expected 403 got %d", rr.Code)
+	}
+}
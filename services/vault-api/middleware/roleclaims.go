@@ -0,0 +1,163 @@
+package middleware
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// defaultRoleClaim is where roles are read from unless JWT_ROLE_CLAIMS
+// says otherwise.
+const defaultRoleClaim = "roles"
+
+// roleMapping derives a validated token's roles from its claims. Roles are
+// read from every RoleClaims path, mapped from the groups at GroupClaims
+// through GroupRoles, and mapped from the token's scopes (scope or scp)
+// through ScopeRoles.
+type roleMapping struct {
+	RoleClaims  [][]string
+	GroupClaims [][]string
+	GroupRoles  map[string][]string
+	ScopeRoles  map[string][]string
+}
+
+// loadRoleMapping reads JWT_ROLE_CLAIMS, JWT_GROUP_CLAIMS, JWT_GROUP_ROLES
+// and JWT_SCOPE_ROLES. Claim paths are dot-separated, optionally starting
+// with "$.", as in realm_access.roles.
+func loadRoleMapping() (roleMapping, error) {
+	var m roleMapping
+	for _, p := range parseCSVEnvDefault("JWT_ROLE_CLAIMS", []string{defaultRoleClaim}) {
+		path, err := parseClaimPath(p)
+		if err != nil {
+			return m, fmt.Errorf("JWT_ROLE_CLAIMS: %w", err)
+		}
+		m.RoleClaims = append(m.RoleClaims, path)
+	}
+	var err error
+	if m.GroupRoles, err = parseRoleTable("JWT_GROUP_ROLES"); err != nil {
+		return m, err
+	}
+	if m.ScopeRoles, err = parseRoleTable("JWT_SCOPE_ROLES"); err != nil {
+		return m, err
+	}
+	groupClaims := parseCSVEnv("JWT_GROUP_CLAIMS")
+	if len(groupClaims) == 0 && len(m.GroupRoles) > 0 {
+		groupClaims = []string{"groups"}
+	}
+	for _, p := range groupClaims {
+		path, err := parseClaimPath(p)
+		if err != nil {
+			return m, fmt.Errorf("JWT_GROUP_CLAIMS: %w", err)
+		}
+		m.GroupClaims = append(m.GroupClaims, path)
+	}
+	return m, nil
+}
+
+func parseClaimPath(p string) ([]string, error) {
+	p = strings.TrimPrefix(strings.TrimSpace(p), "$.")
+	path := strings.Split(p, ".")
+	for _, seg := range path {
+		if seg == "" {
+			return nil, fmt.Errorf("invalid claim path %q", p)
+		}
+	}
+	return path, nil
+}
+
+// parseRoleTable decodes a JSON object of group or scope to role list,
+// e.g. {"vault-admins":["admin"]}.
+func parseRoleTable(name string) (map[string][]string, error) {
+	raw := strings.TrimSpace(os.Getenv(name))
+	if raw == "" {
+		return nil, nil
+	}
+	var table map[string][]string
+	if err := json.Unmarshal([]byte(raw), &table); err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	for k, roles := range table {
+		for _, role := range roles {
+			if strings.TrimSpace(role) == "" {
+				return nil, fmt.Errorf("%s: %q maps to an empty role", name, k)
+			}
+		}
+	}
+	return table, nil
+}
+
+// roles returns the roles claims grant, each once, in the order found.
+func (m roleMapping) roles(claims jwt.MapClaims) []string {
+	var roles []string
+	seen := map[string]bool{}
+	add := func(rs ...string) {
+		for _, r := range rs {
+			if r != "" && !seen[r] {
+				seen[r] = true
+				roles = append(roles, r)
+			}
+		}
+	}
+	for _, path := range m.RoleClaims {
+		add(claimStrings(lookupClaim(claims, path))...)
+	}
+	for _, path := range m.GroupClaims {
+		for _, g := range claimStrings(lookupClaim(claims, path)) {
+			add(m.GroupRoles[g]...)
+		}
+	}
+	if len(m.ScopeRoles) > 0 {
+		for _, s := range tokenScopes(claims) {
+			add(m.ScopeRoles[s]...)
+		}
+	}
+	return roles
+}
+
+// lookupClaim follows path through nested claim objects.
+func lookupClaim(claims map[string]interface{}, path []string) interface{} {
+	var v interface{} = claims
+	for _, seg := range path {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		v = obj[seg]
+	}
+	return v
+}
+
+// claimStrings reads a claim that is a string or a list of strings.
+func claimStrings(v interface{}) []string {
+	switch c := v.(type) {
+	case string:
+		return []string{c}
+	case []string:
+		return c
+	case []interface{}:
+		out := make([]string, 0, len(c))
+		for _, it := range c {
+			if s, ok := it.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
+
+// tokenScopes returns the scopes in the space-separated scope claim (RFC
+// 8693) and in scp, which Azure AD and Okta send as a string or a list.
+func tokenScopes(claims jwt.MapClaims) []string {
+	var scopes []string
+	if s, ok := claims["scope"].(string); ok {
+		scopes = append(scopes, strings.Fields(s)...)
+	}
+	for _, s := range claimStrings(claims["scp"]) {
+		scopes = append(scopes, strings.Fields(s)...)
+	}
+	return scopes
+}
@@ -0,0 +1,103 @@
+package middleware
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func TestRoleMapping(t *testing.T) {
+	tests := []struct {
+		name   string
+		env    map[string]string
+		claims jwt.MapClaims
+		want   []string
+	}{
+		{
+			name:   "default roles claim",
+			claims: jwt.MapClaims{"roles": []interface{}{"admin", "ingester", "admin"}},
+			want:   []string{"admin", "ingester"},
+		},
+		{
+			name:   "default ignores groups and scopes",
+			claims: jwt.MapClaims{"groups": []interface{}{"vault-admins"}, "scope": "vault.read"},
+		},
+		{
+			name:   "keycloak realm and client roles",
+			env:    map[string]string{"JWT_ROLE_CLAIMS": "$.realm_access.roles,resource_access.vault-api.roles"},
+			claims: jwt.MapClaims{"realm_access": map[string]interface{}{"roles": []interface{}{"ingester"}}, "resource_access": map[string]interface{}{"vault-api": map[string]interface{}{"roles": []interface{}{"auditor"}}}},
+			want:   []string{"ingester", "auditor"},
+		},
+		{
+			name:   "azure app roles and group object IDs",
+			env:    map[string]string{"JWT_GROUP_ROLES": `{"5f1c-guid":["admin"]}`},
+			claims: jwt.MapClaims{"roles": []interface{}{"ingester"}, "groups": []interface{}{"5f1c-guid", "other-guid"}},
+			want:   []string{"ingester", "admin"},
+		},
+		{
+			name:   "okta groups claim",
+			env:    map[string]string{"JWT_GROUP_CLAIMS": "groups", "JWT_GROUP_ROLES": `{"Vault Auditors":["auditor","reader"]}`},
+			claims: jwt.MapClaims{"groups": "Vault Auditors"},
+			want:   []string{"auditor", "reader"},
+		},
+		{
+			name:   "scope and scp",
+			env:    map[string]string{"JWT_SCOPE_ROLES": `{"vault.write":["ingester"],"vault.read":["reader"]}`},
+			claims: jwt.MapClaims{"scope": "openid vault.write", "scp": []interface{}{"vault.read"}},
+			want:   []string{"ingester", "reader"},
+		},
+		{
+			name:   "space-separated scp",
+			env:    map[string]string{"JWT_SCOPE_ROLES": `{"vault.read":["reader"]}`},
+			claims: jwt.MapClaims{"scp": "profile vault.read"},
+			want:   []string{"reader"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"JWT_ROLE_CLAIMS", "JWT_GROUP_CLAIMS", "JWT_GROUP_ROLES", "JWT_SCOPE_ROLES"} {
+				t.Setenv(name, tt.env[name])
+			}
+			m, err := loadRoleMapping()
+			if err != nil {
+				t.Fatalf("load: %v", err)
+			}
+			if got := m.roles(tt.claims); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("roles = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadRoleMapping_Invalid(t *testing.T) {
+	for name, env := range map[string]map[string]string{
+		"empty path segment": {"JWT_ROLE_CLAIMS": "realm_access..roles"},
+		"group table":        {"JWT_GROUP_ROLES": `["admin"]`},
+		"scope table":        {"JWT_SCOPE_ROLES": `{"vault.read":"reader"}`},
+		"empty role":         {"JWT_GROUP_ROLES": `{"admins":[""]}`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			for k, v := range env {
+				t.Setenv(k, v)
+			}
+			if _, err := loadRoleMapping(); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
+
+func TestValidateAuthStartupConfig_InvalidRoleMapping(t *testing.T) {
+	t.Setenv("AUTH_POLICY", authPolicyJWKSRBAC)
+	t.Setenv("JWKS_URL", "https://idp.example/jwks")
+	t.Setenv("JWT_ISSUER", "issuer-1")
+	t.Setenv("JWT_AUDIENCE", "vault-api")
+	if err := ValidateAuthStartupConfig(); err != nil {
+		t.Fatalf("valid config rejected: %v", err)
+	}
+	t.Setenv("JWT_SCOPE_ROLES", "{")
+	if err := ValidateAuthStartupConfig(); err == nil {
+		t.Fatal("expected startup error for invalid JWT_SCOPE_ROLES")
+	}
+}
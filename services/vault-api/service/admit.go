@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/domain/evidence"
+	"github.com/SaridakisStamatisChristos/vault-api/internal/events"
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Dedup policies applied when an ingest carries content that is already in
+// the vault within the dedup window.
+const (
+	DedupReject         = "reject"
+	DedupReturnExisting = "return-existing"
+	DedupAllowDuplicate = "allow-duplicate"
+)
+
+// Conflicts reported by Admit.
+const (
+	ConflictIdempotencyKeyReused = "idempotency_key_reused"
+	ConflictDuplicateContent     = "duplicate_content"
+	ConflictQuotaExceeded        = "quota_exceeded"
+)
+
+// Dedup selects how Admit treats repeated content and idempotency keys.
+type Dedup struct {
+	Policy string
+	// Window bounds how long idempotency keys and content hashes are
+	// remembered; zero means forever.
+	Window time.Duration
+}
+
+func (d Dedup) within(t, now time.Time) bool {
+	return d.Window == 0 || now.Sub(t) <= d.Window
+}
+
+// windowStart is the earliest ingest time still covered by the window.
+func (d Dedup) windowStart(now time.Time) time.Time {
+	if d.Window == 0 {
+		return time.Time{}
+	}
+	return now.Add(-d.Window)
+}
+
+// IdempotencyScope is the store key of an Idempotency-Key. Keys are scoped
+// by subject so clients cannot observe or collide with each other's keys.
+func IdempotencyScope(subject, key string) string {
+	return subject + "\x00" + key
+}
+
+// AdmitRequest describes one piece of evidence whose payload is already in
+// the blob store.
+type AdmitRequest struct {
+	Actor string
+	// Provenance identifies the credential the request authenticated with,
+	// such as a client certificate fingerprint. It is recorded as
+	// ingested_by in the audit entry.
+	Provenance string
+	// Key is the client's Idempotency-Key, if any.
+	Key   string
+	Draft Record
+	Dedup Dedup
+}
+
+func (req AdmitRequest) auditMetadata() map[string]string {
+	if req.Provenance == "" {
+		return nil
+	}
+	return map[string]string{"ingested_by": req.Provenance}
+}
+
+// Admission is the outcome of deduplicating an ingest request.
+type Admission struct {
+	Record Record
+	// Created is set when a new pending record was stored and still has to
+	// be queued for sequencing.
+	Created bool
+	// Relayed is set when an outbox message written with the record already
+	// queues it on the ingest pipeline.
+	Relayed  bool
+	Replayed bool
+	// Conflict is set when the request must be refused: the idempotency key
+	// was used for different content, the content exists under the reject
+	// policy, or a quota in Config.Quotas is exhausted.
+	Conflict string
+	// Quota names the exhausted quota of a ConflictQuotaExceeded.
+	Quota *QuotaViolation
+}
+
+// Admit creates a stored evidence record from req.Draft unless the
+// Idempotency-Key or the dedup policy resolve it to an existing record.
+// The draft must carry the finalised content hash. Every decision is made
+// against the store, so replicas agree on duplicates.
+func (v *Vault) Admit(ctx context.Context, req AdmitRequest) (Admission, error) {
+	contentHash := req.Draft.ContentHash
+	now := time.Now().UTC()
+	var scope string
+	if req.Key != "" {
+		scope = IdempotencyScope(req.Actor, req.Key)
+		held, err := v.store.GetIdempotencyKey(ctx, scope)
+		switch {
+		case err == nil && req.Dedup.within(held.CreatedAt, now):
+			return v.replayKey(ctx, *held, contentHash)
+		case err != nil && !errors.Is(err, store.ErrNotFound):
+			return Admission{}, err
+		}
+	}
+
+	if req.Dedup.Policy != DedupAllowDuplicate {
+		existing, err := v.store.FindEvidenceByContentHash(ctx, contentHash, req.Dedup.windowStart(now))
+		if err != nil {
+			return Admission{}, err
+		}
+		if existing != nil {
+			rec := recordFromStore(existing)
+			if req.Dedup.Policy == DedupReject {
+				return Admission{Record: rec, Conflict: ConflictDuplicateContent}, nil
+			}
+			if scope != "" {
+				if _, err := v.store.ClaimIdempotencyKey(ctx, store.IdempotencyKey{Scope: scope, EvidenceID: rec.ID, ContentHash: contentHash, CreatedAt: now}, req.Dedup.windowStart(now)); err != nil {
+					return Admission{}, err
+				}
+			}
+			v.RecordAuditMetadata(ctx, "ingest_deduplicated", rec.ID, req.Actor, req.auditMetadata())
+			return Admission{Record: rec, Replayed: true}, nil
+		}
+	}
+
+	rec := req.Draft
+	rec.ID = uuid.NewString()
+	rec.IngestedAt = now
+	rec.LeafIndex = nil
+	rec.Status = evidence.StatusReceived
+	rec.HeldFrom = ""
+	outbox := v.ingestOutbox(rec)
+	stored := store.Evidence{ID: rec.ID, ContentType: rec.ContentType, ContentHash: contentHash, PayloadRef: rec.PayloadRef, Labels: rec.Labels, Status: string(evidence.StatusStored), IngestedAt: now, Size: rec.Size, IngestedBy: req.Actor}
+	// quotas are checked in the transaction that counts the record, so
+	// concurrent admissions cannot overshoot them
+	check := v.quotaCheck(req.Actor, rec.Size)
+	var err error
+	if scope != "" {
+		// the key is claimed in the same transaction as the record, so a
+		// concurrent request with the same key replays this one and a
+		// failed save leaves the key free
+		var held store.IdempotencyKey
+		held, err = v.store.SaveKeyedEvidence(ctx, store.IdempotencyKey{Scope: scope, EvidenceID: rec.ID, ContentHash: contentHash, CreatedAt: now}, req.Dedup.windowStart(now), stored, check, outbox...)
+		if err == nil && held.EvidenceID != rec.ID {
+			return v.replayKey(ctx, held, contentHash)
+		}
+	} else {
+		err = v.store.SaveEvidence(ctx, stored, check, outbox...)
+	}
+	var exceeded *quotaError
+	if errors.As(err, &exceeded) {
+		v.observer.QuotaExceeded(exceeded.QuotaViolation)
+		return Admission{Conflict: ConflictQuotaExceeded, Quota: &exceeded.QuotaViolation}, nil
+	}
+	if err != nil {
+		log.Error().Err(err).Str("evidence_id", rec.ID).Msg("persist evidence record")
+		return Admission{}, err
+	}
+	v.publishStatus(events.StatusChange{EvidenceID: rec.ID, To: string(evidence.StatusReceived)})
+	rec.Status = evidence.StatusStored
+	v.publishStatus(events.StatusChange{EvidenceID: rec.ID, From: string(evidence.StatusReceived), To: string(evidence.StatusStored)})
+	v.RecordAuditMetadata(ctx, "ingest", rec.ID, req.Actor, req.auditMetadata())
+	return Admission{Record: rec, Created: true, Relayed: len(outbox) > 0}, nil
+}
+
+// AdmitOne admits a single draft and queues it for sequencing on its own.
+func (v *Vault) AdmitOne(ctx context.Context, req AdmitRequest) (Admission, error) {
+	adm, err := v.Admit(ctx, req)
+	if err == nil && adm.Created && !adm.Relayed {
+		v.Enqueue(adm.Record)
+	}
+	return adm, err
+}
+
+// replayKey answers a request whose idempotency key is already held.
+func (v *Vault) replayKey(ctx context.Context, held store.IdempotencyKey, contentHash string) (Admission, error) {
+	if held.ContentHash != contentHash {
+		return Admission{Conflict: ConflictIdempotencyKeyReused}, nil
+	}
+	ev, err := v.store.GetEvidence(ctx, held.EvidenceID)
+	if err != nil {
+		return Admission{}, err
+	}
+	return Admission{Record: recordFromStore(ev), Replayed: true}, nil
+}
+
+// ingestOutbox returns the outbox message announcing rec, or nil when
+// admissions are not relayed through the outbox.
+func (v *Vault) ingestOutbox(rec Record) []store.OutboxMessage {
+	v.mu.Lock()
+	topic := v.outboxTopic
+	v.mu.Unlock()
+	if topic == "" {
+		return nil
+	}
+	body, err := json.Marshal(v.ingestEvent(rec))
+	if err != nil {
+		return nil
+	}
+	return []store.OutboxMessage{{Topic: topic, Key: sequenceKey(rec), EvidenceID: rec.ID, Payload: body}}
+}
@@ -0,0 +1,202 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/SaridakisStamatisChristos/vault-api/domain/evidence"
+	"github.com/SaridakisStamatisChristos/vault-api/domain/merkle"
+	"github.com/SaridakisStamatisChristos/vault-api/internal/events"
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	// ErrEmptyTree is returned when no leaf has been sequenced yet.
+	ErrEmptyTree = errors.New("service: tree is empty")
+	// ErrNotSequenced is returned for proofs of records without a leaf in
+	// the tree.
+	ErrNotSequenced = errors.New("service: evidence not sequenced")
+)
+
+// Checkpoint is a signed tree head.
+type Checkpoint struct {
+	Origin    string `json:"origin,omitempty"`
+	TreeSize  int64  `json:"tree_size"`
+	RootHash  string `json:"root_hash"`
+	Signature string `json:"signature"`
+	KeyRef    string `json:"key_ref,omitempty"`
+}
+
+func checkpointFromStore(cp store.Checkpoint) Checkpoint {
+	return Checkpoint{Origin: cp.Origin, TreeSize: cp.TreeSize, RootHash: cp.RootHash, Signature: cp.Signature, KeyRef: cp.KeyRef}
+}
+
+// Signer signs tree heads.
+type Signer interface {
+	SignCheckpoint(ctx context.Context, treeSize int64, rootHash string) (signature, keyRef string, err error)
+}
+
+// LatestCheckpoint returns the checkpoint for the current tree, signing and
+// recording it first if no replica has yet. It returns ErrEmptyTree before
+// the first leaf is sequenced.
+func (v *Vault) LatestCheckpoint(ctx context.Context) (Checkpoint, error) {
+	v.treeMu.Lock()
+	defer v.treeMu.Unlock()
+	return v.latestCheckpointLocked(ctx)
+}
+
+// Checkpoint returns the recorded checkpoint of treeSize or
+// store.ErrNotFound.
+func (v *Vault) Checkpoint(ctx context.Context, treeSize int64) (Checkpoint, error) {
+	cp, err := v.store.GetCheckpoint(ctx, treeSize)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	return checkpointFromStore(*cp), nil
+}
+
+// Checkpoints returns the checkpoint history, largest tree first.
+func (v *Vault) Checkpoints(ctx context.Context) ([]Checkpoint, error) {
+	cps, err := v.store.ListCheckpoints(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Checkpoint, 0, len(cps))
+	for _, cp := range cps {
+		out = append(out, checkpointFromStore(cp))
+	}
+	return out, nil
+}
+
+// Proof returns the inclusion proof of a record in the current tree.
+func (v *Vault) Proof(ctx context.Context, id string) (*merkle.InclusionProof, error) {
+	ev, err := v.store.GetEvidence(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	v.treeMu.Lock()
+	defer v.treeMu.Unlock()
+	size, err := v.syncTreeLocked(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ev.LeafIndex == nil || *ev.LeafIndex >= size {
+		return nil, ErrNotSequenced
+	}
+	return v.engine.InclusionProof(*ev.LeafIndex)
+}
+
+// Receipt returns the latest checkpoint together with the inclusion proof
+// of leaf against it.
+func (v *Vault) Receipt(ctx context.Context, leaf int64) (*merkle.InclusionProof, Checkpoint, error) {
+	v.treeMu.Lock()
+	defer v.treeMu.Unlock()
+	cp, err := v.latestCheckpointLocked(ctx)
+	if err != nil {
+		return nil, Checkpoint{}, err
+	}
+	if leaf >= cp.TreeSize {
+		return nil, Checkpoint{}, ErrNotSequenced
+	}
+	proof, err := v.engine.InclusionProof(leaf)
+	if err != nil {
+		return nil, Checkpoint{}, err
+	}
+	return proof, cp, nil
+}
+
+// syncTreeLocked appends to the engine the leaves the store sequenced since
+// it was last caught up and returns the resulting tree size. It stops at
+// the first gap, left by a leaf index that is assigned but not yet
+// persisted. Callers hold treeMu.
+func (v *Vault) syncTreeLocked(ctx context.Context) (int64, error) {
+	size, err := v.engine.TreeSize()
+	if err != nil {
+		return 0, err
+	}
+	leaves, err := v.store.ListLeaves(ctx, size, 0)
+	if err != nil {
+		return 0, err
+	}
+	for _, ev := range leaves {
+		if *ev.LeafIndex != size {
+			break
+		}
+		leaf, _, err := v.engine.AppendLeaf(leafData(ev.ContentHash))
+		if err != nil {
+			return 0, err
+		}
+		if leaf != size {
+			return 0, fmt.Errorf("merkle engine appended leaf %d, store has %d", leaf, size)
+		}
+		size++
+	}
+	return size, nil
+}
+
+// latestCheckpointLocked implements LatestCheckpoint. The engine state is
+// only trusted under treeMu, so the root read and the signature match.
+func (v *Vault) latestCheckpointLocked(ctx context.Context) (Checkpoint, error) {
+	size, err := v.syncTreeLocked(ctx)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	if size == 0 {
+		return Checkpoint{}, ErrEmptyTree
+	}
+	if cp, err := v.store.GetCheckpoint(ctx, size); err == nil {
+		return checkpointFromStore(*cp), nil
+	} else if !errors.Is(err, store.ErrNotFound) {
+		return Checkpoint{}, err
+	}
+
+	root, err := v.engine.Root()
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	rootHash := hex.EncodeToString(root)
+	signature, keyRef, err := v.signer.SignCheckpoint(ctx, size, rootHash)
+	if err != nil {
+		return Checkpoint{}, fmt.Errorf("sign checkpoint: %w", err)
+	}
+	previous, err := v.store.ListCheckpoints(ctx, 1)
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	stored, created, err := v.store.SaveCheckpoint(ctx, store.Checkpoint{Origin: v.origin, TreeSize: size, RootHash: rootHash, Signature: signature, KeyRef: keyRef})
+	if err != nil {
+		return Checkpoint{}, err
+	}
+	cp := checkpointFromStore(stored)
+	if !created {
+		// another replica signed this size first
+		return cp, nil
+	}
+
+	ids, err := v.store.MarkCheckpointed(ctx, size)
+	if err != nil {
+		log.Error().Err(err).Int64("tree_size", size).Msg("persist checkpointed status")
+	}
+	var prev *Checkpoint
+	if len(previous) > 0 {
+		p := checkpointFromStore(previous[0])
+		prev = &p
+	}
+	v.observer.CheckpointPublished(cp, prev)
+	for _, id := range ids {
+		v.publishStatus(events.StatusChange{EvidenceID: id, From: string(evidence.StatusSequenced), To: string(evidence.StatusCheckpointed)})
+	}
+	return cp, nil
+}
+
+// latestCheckpointSize returns the largest checkpointed tree size.
+func (v *Vault) latestCheckpointSize(ctx context.Context) (int64, error) {
+	cps, err := v.store.ListCheckpoints(ctx, 1)
+	if err != nil || len(cps) == 0 {
+		return 0, err
+	}
+	return cps[0].TreeSize, nil
+}
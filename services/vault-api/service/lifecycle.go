@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/SaridakisStamatisChristos/vault-api/domain/evidence"
+	"github.com/SaridakisStamatisChristos/vault-api/internal/events"
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+)
+
+var statusAuditActions = map[evidence.Status]string{
+	evidence.StatusOnHold:   "evidence_hold",
+	evidence.StatusRedacted: "evidence_redact",
+	evidence.StatusExported: "evidence_export",
+}
+
+// SetStatus applies an operator transition: on-hold, redacted or exported.
+// Forbidden transitions return an error wrapping
+// evidence.ErrInvalidTransition; unknown records store.ErrNotFound.
+func (v *Vault) SetStatus(ctx context.Context, id string, next evidence.Status, actor string) (Record, error) {
+	return v.transition(ctx, id, statusAuditActions[next], actor, func(e *store.Evidence, _ int64) error {
+		current := evidence.Status(e.Status)
+		if err := evidence.CheckManualTransition(current, next); err != nil {
+			return err
+		}
+		if next == evidence.StatusOnHold && current != evidence.StatusOnHold {
+			e.HeldFrom = e.Status
+		}
+		e.Status = string(next)
+		return nil
+	})
+}
+
+// ReleaseHold lifts a legal hold. The record returns to the status it had
+// when held, advanced by any sequencing or checkpoint since.
+func (v *Vault) ReleaseHold(ctx context.Context, id, actor string) (Record, error) {
+	return v.transition(ctx, id, "evidence_release", actor, func(e *store.Evidence, checkpointed int64) error {
+		if evidence.Status(e.Status) != evidence.StatusOnHold {
+			return fmt.Errorf("%w: evidence is not on hold", evidence.ErrInvalidTransition)
+		}
+		next := derivedStatus(e, checkpointed)
+		if held := evidence.Status(e.HeldFrom); held != "" && !evidence.Advance(held, next) {
+			next = held
+		}
+		e.Status, e.HeldFrom = string(next), ""
+		return nil
+	})
+}
+
+// derivedStatus is the progress status implied by the record's leaf index
+// and the latest checkpointed tree size.
+func derivedStatus(e *store.Evidence, checkpointed int64) evidence.Status {
+	switch {
+	case e.LeafIndex == nil:
+		return evidence.StatusStored
+	case *e.LeafIndex < checkpointed:
+		return evidence.StatusCheckpointed
+	default:
+		return evidence.StatusSequenced
+	}
+}
+
+// transition runs decide on the stored record atomically, then audits and
+// publishes the change if the status moved.
+func (v *Vault) transition(ctx context.Context, id, action, actor string, decide func(e *store.Evidence, checkpointed int64) error) (Record, error) {
+	checkpointed, err := v.latestCheckpointSize(ctx)
+	if err != nil {
+		return Record{}, err
+	}
+	var change events.StatusChange
+	ev, err := v.store.UpdateEvidenceStatus(ctx, id, func(e *store.Evidence) error {
+		prev := e.Status
+		if err := decide(e, checkpointed); err != nil {
+			return err
+		}
+		change = events.StatusChange{EvidenceID: e.ID, From: prev, To: e.Status, LeafIndex: e.LeafIndex}
+		return nil
+	})
+	if err != nil {
+		return Record{}, err
+	}
+	if change.From != change.To {
+		v.RecordAudit(ctx, action, id, actor)
+		v.publishStatus(change)
+	}
+	return recordFromStore(ev), nil
+}
@@ -0,0 +1,35 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// promiseBatch bounds the breaches one CheckPromises call reports.
+const promiseBatch = 500
+
+// CheckPromises reports, as breaches, the pending records whose inclusion
+// promise expired: they were ingested more than maxMergeDelay before now
+// and still have no leaf index. The store remembers reported breaches, so
+// each is logged and audited as promise_breached once by whichever replica
+// checks first, and promises issued by replicas that have since stopped
+// are covered as well. It returns the number of breaches and of records
+// still pending.
+func (v *Vault) CheckPromises(ctx context.Context, now time.Time, maxMergeDelay time.Duration) (breached, outstanding int, err error) {
+	overdue, err := v.store.ReportOverdueEvidence(ctx, now.Add(-maxMergeDelay), promiseBatch)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, e := range overdue {
+		deadline := e.IngestedAt.Add(maxMergeDelay)
+		log.Error().Str("evidence_id", e.ID).Time("deadline", deadline).Msg("inclusion promise breached: evidence not sequenced within max merge delay")
+		v.RecordAudit(ctx, "promise_breached", e.ID, "system")
+	}
+	pending, err := v.store.CountPendingEvidence(ctx)
+	if err != nil {
+		return len(overdue), 0, err
+	}
+	return len(overdue), int(pending), nil
+}
@@ -0,0 +1,180 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+)
+
+// Quota caps what is ingested into a vault, as a whole or by one subject.
+// Zero fields are unlimited.
+type Quota struct {
+	// MaxEvidence caps the number of records.
+	MaxEvidence int64 `json:"max_evidence"`
+	// MaxStorageBytes caps the payload bytes of all records.
+	MaxStorageBytes int64 `json:"max_storage_bytes"`
+	// MaxBytesPerDay caps the payload bytes ingested per UTC day.
+	MaxBytesPerDay int64 `json:"max_bytes_per_day"`
+}
+
+func (q Quota) limited() bool {
+	return q.MaxEvidence > 0 || q.MaxStorageBytes > 0 || q.MaxBytesPerDay > 0
+}
+
+// Quotas are the limits Admit enforces.
+type Quotas struct {
+	// Tenant caps the vault as a whole.
+	Tenant Quota
+	// Subject caps each subject that has no entry in Subjects.
+	Subject  Quota
+	Subjects map[string]Quota
+}
+
+// ForSubject returns the quota that applies to subject.
+func (q Quotas) ForSubject(subject string) Quota {
+	if sq, ok := q.Subjects[subject]; ok {
+		return sq
+	}
+	return q.Subject
+}
+
+// Quota scopes.
+const (
+	QuotaScopeTenant  = "tenant"
+	QuotaScopeSubject = "subject"
+)
+
+// QuotaViolation says which quota refused an admission.
+type QuotaViolation struct {
+	// Scope is QuotaScopeTenant or QuotaScopeSubject.
+	Scope string `json:"scope"`
+	// Quota is the JSON name of the exhausted Quota field.
+	Quota string `json:"quota"`
+	Limit int64  `json:"limit"`
+	// Used is the usage before the refused admission.
+	Used int64 `json:"used"`
+}
+
+func (q QuotaViolation) String() string {
+	return fmt.Sprintf("%s quota %s exceeded: %d of %d used", q.Scope, q.Quota, q.Used, q.Limit)
+}
+
+// Usage is the usage a Quota is measured against.
+type Usage struct {
+	Evidence     int64 `json:"evidence"`
+	StorageBytes int64 `json:"storage_bytes"`
+	// BytesToday counts the payload bytes ingested since midnight UTC.
+	BytesToday int64 `json:"bytes_today"`
+}
+
+func usageFromStore(u store.Usage) Usage {
+	return Usage{Evidence: u.Evidence, StorageBytes: u.Bytes, BytesToday: u.DayBytes}
+}
+
+// QuotaUsage is a quota with the usage measured against it.
+type QuotaUsage struct {
+	Quota Quota `json:"limits"`
+	Usage Usage `json:"usage"`
+}
+
+// exceededBy returns the first limit of q that admitting size more bytes
+// on top of u would exceed.
+func (q Quota) exceededBy(scope string, u Usage, size int64) *QuotaViolation {
+	switch {
+	case q.MaxEvidence > 0 && u.Evidence+1 > q.MaxEvidence:
+		return &QuotaViolation{Scope: scope, Quota: "max_evidence", Limit: q.MaxEvidence, Used: u.Evidence}
+	case q.MaxStorageBytes > 0 && u.StorageBytes+size > q.MaxStorageBytes:
+		return &QuotaViolation{Scope: scope, Quota: "max_storage_bytes", Limit: q.MaxStorageBytes, Used: u.StorageBytes}
+	case q.MaxBytesPerDay > 0 && u.BytesToday+size > q.MaxBytesPerDay:
+		return &QuotaViolation{Scope: scope, Quota: "max_bytes_per_day", Limit: q.MaxBytesPerDay, Used: u.BytesToday}
+	}
+	return nil
+}
+
+// QuotaStatus reports the vault's and subject's usage against their
+// quotas. An empty subject has no usage of its own.
+func (v *Vault) QuotaStatus(ctx context.Context, subject string) (tenant, sub QuotaUsage, err error) {
+	now := time.Now()
+	tu, err := v.store.Usage(ctx, "", now)
+	if err != nil {
+		return tenant, sub, err
+	}
+	tenant = QuotaUsage{Quota: v.quotas.Tenant, Usage: usageFromStore(tu)}
+	v.observer.UsageMeasured(tenant.Usage)
+	if subject == "" {
+		return tenant, sub, nil
+	}
+	su, err := v.store.Usage(ctx, subject, now)
+	if err != nil {
+		return tenant, sub, err
+	}
+	return tenant, QuotaUsage{Quota: v.quotas.ForSubject(subject), Usage: usageFromStore(su)}, nil
+}
+
+// quotaError carries the quota that refused a save out of its usage check.
+type quotaError struct{ QuotaViolation }
+
+func (e *quotaError) Error() string { return e.String() }
+
+// quotaCheck returns the store check that refuses a record of size bytes
+// for subject that would exceed a quota, or nil when nothing is limited.
+func (v *Vault) quotaCheck(subject string, size int64) store.UsageCheck {
+	tq, sq := v.quotas.Tenant, v.quotas.ForSubject(subject)
+	if subject == "" {
+		sq = Quota{}
+	}
+	if !tq.limited() && !sq.limited() {
+		return nil
+	}
+	return func(tenant, sub store.Usage) error {
+		usage := usageFromStore(tenant)
+		v.observer.UsageMeasured(usage)
+		if qv := tq.exceededBy(QuotaScopeTenant, usage, size); qv != nil {
+			return &quotaError{*qv}
+		}
+		if qv := sq.exceededBy(QuotaScopeSubject, usageFromStore(sub), size); qv != nil {
+			return &quotaError{*qv}
+		}
+		return nil
+	}
+}
+
+// checkQuotas returns the quota that admitting size bytes for subject
+// would exceed, if any. It reads the store outside any save, so it only
+// refuses early; the save's quotaCheck is authoritative.
+func (v *Vault) checkQuotas(ctx context.Context, subject string, size int64, now time.Time) (*QuotaViolation, error) {
+	if q := v.quotas.Tenant; q.limited() {
+		u, err := v.store.Usage(ctx, "", now)
+		if err != nil {
+			return nil, err
+		}
+		usage := usageFromStore(u)
+		v.observer.UsageMeasured(usage)
+		if qv := q.exceededBy(QuotaScopeTenant, usage, size); qv != nil {
+			return qv, nil
+		}
+	}
+	if q := v.quotas.ForSubject(subject); q.limited() && subject != "" {
+		u, err := v.store.Usage(ctx, subject, now)
+		if err != nil {
+			return nil, err
+		}
+		if qv := q.exceededBy(QuotaScopeSubject, usageFromStore(u), size); qv != nil {
+			return qv, nil
+		}
+	}
+	return nil, nil
+}
+
+// CheckQuota returns the quota that admitting size more bytes for subject
+// would exceed, if any, so a payload can be refused before it is stored.
+// Admit checks again against the final size.
+func (v *Vault) CheckQuota(ctx context.Context, subject string, size int64) (*QuotaViolation, error) {
+	qv, err := v.checkQuotas(ctx, subject, size, time.Now().UTC())
+	if qv != nil {
+		v.observer.QuotaExceeded(*qv)
+	}
+	return qv, err
+}
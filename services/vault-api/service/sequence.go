@@ -0,0 +1,268 @@
+package service
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/domain/evidence"
+	"github.com/SaridakisStamatisChristos/vault-api/internal/events"
+	"github.com/SaridakisStamatisChristos/vault-api/internal/pipeline"
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+	"github.com/rs/zerolog/log"
+)
+
+// storePollInterval bounds how stale a waiter can be when another replica
+// sequences the record.
+const storePollInterval = 250 * time.Millisecond
+
+// sequencingGroup is a set of records assigned consecutive leaf indices.
+type sequencingGroup struct {
+	// Key partitions the group on the ingest topic.
+	Key string
+	IDs []string
+}
+
+// Enqueue queues recs as one group for sequencing by this replica.
+func (v *Vault) Enqueue(recs ...Record) {
+	if len(recs) == 0 {
+		return
+	}
+	g := sequencingGroup{Key: sequenceKey(recs[0])}
+	for _, rec := range recs {
+		g.IDs = append(g.IDs, rec.ID)
+	}
+	v.mu.Lock()
+	v.pending = append(v.pending, g)
+	v.mu.Unlock()
+}
+
+// Pending reports how many groups are queued on this replica.
+func (v *Vault) Pending() int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return len(v.pending)
+}
+
+// UsePipeline routes sequencing through the ingest pipeline: queued groups
+// are published with p instead of being assigned leaf indices here, and,
+// when outboxTopic is set, admissions write their ingest event to the
+// outbox in the same transaction as the record.
+func (v *Vault) UsePipeline(p *pipeline.Publisher, outboxTopic string) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.publisher = p
+	v.outboxTopic = outboxTopic
+}
+
+// Pipelined reports whether sequencing runs through the ingest pipeline.
+func (v *Vault) Pipelined() bool {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return v.publisher != nil
+}
+
+// CommitNext sequences the oldest queued group, or the oldest pending
+// record in the store when nothing is queued, which picks up records
+// admitted by replicas that stopped before sequencing them. It reports
+// whether any leaf index was assigned. With the ingest pipeline it only
+// publishes the queued groups and the consumer assigns leaf indices.
+func (v *Vault) CommitNext(ctx context.Context) bool {
+	v.mu.Lock()
+	p := v.publisher
+	var group sequencingGroup
+	if p == nil && len(v.pending) > 0 {
+		group = v.pending[0]
+		v.pending = v.pending[1:]
+	}
+	v.mu.Unlock()
+	if p != nil {
+		v.publishPending(ctx, p)
+		return false
+	}
+
+	if group.IDs != nil {
+		evs, err := v.store.AssignLeaves(ctx, group.IDs)
+		if err != nil {
+			log.Warn().Err(err).Int("group_size", len(group.IDs)).Msg("sequencing failed; will retry")
+			v.mu.Lock()
+			v.pending = append([]sequencingGroup{group}, v.pending...)
+			v.mu.Unlock()
+			return false
+		}
+		v.sequencedRecords(evs...)
+		return true
+	}
+
+	ev, err := v.store.AssignNextPendingLeaf(ctx)
+	if err != nil {
+		log.Warn().Err(err).Msg("sequencing failed; will retry")
+		return false
+	}
+	if ev == nil {
+		return false
+	}
+	v.sequencedRecords(*ev)
+	return true
+}
+
+// strandedBatch bounds the records one SequenceStranded call sequences.
+const strandedBatch = 100
+
+// SequenceStranded assigns leaf indices to pending records ingested
+// before before whose ingest event is no longer in the outbox: it was
+// published but never appended, for instance because it was
+// dead-lettered or the topic lost it. It returns how many records were
+// sequenced.
+func (v *Vault) SequenceStranded(ctx context.Context, before time.Time) (int, error) {
+	stranded, err := v.store.StrandedEvidence(ctx, before, strandedBatch)
+	if err != nil || len(stranded) == 0 {
+		return 0, err
+	}
+	ids := make([]string, 0, len(stranded))
+	for _, e := range stranded {
+		ids = append(ids, e.ID)
+	}
+	evs, err := v.store.AssignLeaves(ctx, ids)
+	if err != nil {
+		return 0, err
+	}
+	v.sequencedRecords(evs...)
+	return len(evs), nil
+}
+
+// CommitPending sequences every queued group, then the store's pending
+// records, and returns how many groups and records were sequenced. It
+// stops early when sequencing fails; the rest is picked up next time.
+// With the ingest pipeline it publishes the queued groups and returns 0.
+func (v *Vault) CommitPending(ctx context.Context) int {
+	n := 0
+	for v.CommitNext(ctx) {
+		n++
+	}
+	return n
+}
+
+// sequencedRecords wakes the waiters and notifies the observer of records
+// that were just given a leaf index.
+func (v *Vault) sequencedRecords(evs ...store.Evidence) {
+	v.mu.Lock()
+	close(v.sequenced)
+	v.sequenced = make(chan struct{})
+	v.mu.Unlock()
+	for i := range evs {
+		rec := recordFromStore(&evs[i])
+		if rec.Status == evidence.StatusSequenced {
+			v.publishStatus(events.StatusChange{EvidenceID: rec.ID, From: string(evidence.StatusStored), To: string(evidence.StatusSequenced), LeafIndex: rec.LeafIndex})
+		}
+		v.observer.Sequenced(rec)
+	}
+}
+
+// WaitForLeaf blocks until the record has a leaf index or ctx ends.
+func (v *Vault) WaitForLeaf(ctx context.Context, id string) (int64, error) {
+	for {
+		v.mu.Lock()
+		ch := v.sequenced
+		v.mu.Unlock()
+		ev, err := v.store.GetEvidence(ctx, id)
+		if err == nil && ev.LeafIndex != nil {
+			return *ev.LeafIndex, nil
+		}
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-ch:
+		case <-time.After(storePollInterval):
+		}
+	}
+}
+
+// publishPending moves queued groups onto the ingest topic. Records are
+// keyed by their group so a batch shares a partition and keeps its order.
+// Groups that fail to publish stay queued for the next committer tick;
+// republishing is harmless because the consumer appends idempotently.
+// Records admitted through the outbox are never queued here.
+func (v *Vault) publishPending(ctx context.Context, p *pipeline.Publisher) {
+	v.mu.Lock()
+	groups := v.pending
+	v.pending = nil
+	v.mu.Unlock()
+
+	for i, g := range groups {
+		for _, id := range g.IDs {
+			ev, err := v.store.GetEvidence(ctx, id)
+			if err == nil && ev.LeafIndex != nil {
+				continue
+			}
+			if err == nil {
+				err = p.PublishIngest(ctx, g.Key, v.ingestEvent(recordFromStore(ev)))
+			}
+			if err != nil {
+				log.Warn().Err(err).Str("evidence_id", id).Msg("pipeline publish failed; will retry")
+				v.mu.Lock()
+				v.pending = append(append([]sequencingGroup{}, groups[i:]...), v.pending...)
+				v.mu.Unlock()
+				return
+			}
+		}
+	}
+}
+
+func (v *Vault) ingestEvent(rec Record) pipeline.IngestEvent {
+	return pipeline.IngestEvent{EvidenceID: rec.ID, LeafData: leafData(rec.ContentHash), ContentHash: rec.ContentHash, EnqueuedAt: time.Now().UnixMilli(), Tenant: v.tenant}
+}
+
+// sequenceKey is the partition key of a record: its batch, or itself.
+func sequenceKey(rec Record) string {
+	if rec.Group != "" {
+		return rec.Group
+	}
+	return rec.ID
+}
+
+// leafData is the Merkle leaf input for a record: its raw content hash.
+func leafData(contentHash string) []byte {
+	if b, err := hex.DecodeString(contentHash); err == nil && len(b) > 0 {
+		return b
+	}
+	return []byte(contentHash)
+}
+
+// Leaves is the ingest consumer's view of sequencing state.
+func (v *Vault) Leaves() pipeline.LeafStore {
+	return pipelineLeaves{v}
+}
+
+type pipelineLeaves struct {
+	v *Vault
+}
+
+// AssignLeaf takes the index from the store's advisory-locked sequence,
+// like the committer does, so indices are never reused across restarts or
+// replicas. A record sequenced concurrently between the check and the
+// assignment is reported as assigned here as well; the consumer group
+// gives each partition to one member, so that only happens in a rebalance.
+func (l pipelineLeaves) AssignLeaf(ctx context.Context, id string) (int64, bool, error) {
+	ev, err := l.v.store.GetEvidence(ctx, id)
+	if err != nil {
+		return 0, false, unknownEvidence(err)
+	}
+	if ev.LeafIndex != nil {
+		return *ev.LeafIndex, false, nil
+	}
+	evs, err := l.v.store.AssignLeaves(ctx, []string{id})
+	if err != nil {
+		return 0, false, unknownEvidence(err)
+	}
+	l.v.sequencedRecords(evs...)
+	return *evs[0].LeafIndex, true, nil
+}
+
+func unknownEvidence(err error) error {
+	if errors.Is(err, store.ErrNotFound) {
+		return pipeline.ErrUnknownEvidence
+	}
+	return err
+}
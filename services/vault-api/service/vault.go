@@ -0,0 +1,199 @@
+// Package service is the vault's application layer. It owns evidence
+// admission, sequencing, checkpoints and the audit trail, and keeps every
+// piece of durable state in the injected store.Store so that any number of
+// vault-api replicas can serve one store. The merkle.Engine is treated as a
+// cache of the store's leaf sequence and is caught up from it on demand.
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/domain/evidence"
+	"github.com/SaridakisStamatisChristos/vault-api/domain/merkle"
+	"github.com/SaridakisStamatisChristos/vault-api/internal/events"
+	"github.com/SaridakisStamatisChristos/vault-api/internal/pipeline"
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+)
+
+// Record is an evidence record as the API reports it.
+type Record struct {
+	ID          string            `json:"id"`
+	ContentType string            `json:"content_type,omitempty"`
+	ContentHash string            `json:"content_hash,omitempty"`
+	PayloadRef  string            `json:"payload_ref,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Size        int64             `json:"size,omitempty"`
+	Status      evidence.Status   `json:"status"`
+	IngestedAt  time.Time         `json:"ingested_at"`
+	LeafIndex   *int64            `json:"leaf_index,omitempty"`
+	// HeldFrom is the status a record had when put on hold.
+	HeldFrom evidence.Status `json:"-"`
+	// Group keys the records of one batch together on the ingest topic.
+	Group string `json:"-"`
+}
+
+func recordFromStore(e *store.Evidence) Record {
+	return Record{ID: e.ID, ContentType: e.ContentType, ContentHash: e.ContentHash, PayloadRef: e.PayloadRef, Labels: e.Labels, Status: evidence.Status(e.Status), HeldFrom: evidence.Status(e.HeldFrom), IngestedAt: e.IngestedAt, LeafIndex: e.LeafIndex, Size: e.Size}
+}
+
+// Observer receives the vault's notifications. Calls are made after the
+// change is persisted, on the replica that made it.
+type Observer interface {
+	StatusChanged(events.StatusChange)
+	// Sequenced is called for each record that received a leaf index.
+	Sequenced(Record)
+	// CheckpointPublished is called once per newly signed checkpoint with
+	// the checkpoint it supersedes, if any.
+	CheckpointPublished(cp Checkpoint, previous *Checkpoint)
+	Audited(store.AuditEntry)
+	// UsageMeasured reports the vault's usage whenever it is read for a
+	// quota check or QuotaStatus.
+	UsageMeasured(Usage)
+	// QuotaExceeded is called for every admission a quota refuses.
+	QuotaExceeded(QuotaViolation)
+}
+
+// Config holds the optional dependencies of a Vault.
+type Config struct {
+	// Observer is notified of status changes, sequencing, checkpoints and
+	// audit entries; nil discards them.
+	Observer Observer
+	// Signer signs checkpoints. It is required before LatestCheckpoint is
+	// called.
+	Signer Signer
+	// Tenant is the tenant whose view of the store the vault serves; it
+	// tags the vault's pipeline events. Empty means store.DefaultTenant.
+	Tenant string
+	// Origin names the vault's log; it is recorded with every checkpoint
+	// and should be covered by the Signer's signature.
+	Origin string
+	// Quotas cap what Admit accepts for the vault and for each subject.
+	Quotas Quotas
+}
+
+// Vault is safe for concurrent use. Besides the store it only holds
+// per-process work queues: sequencing groups admitted here and the waiters
+// for them.
+type Vault struct {
+	store    store.Store
+	engine   merkle.Engine
+	observer Observer
+	signer   Signer
+	tenant   string
+	origin   string
+	quotas   Quotas
+
+	// treeMu serialises engine appends with reading a consistent tree.
+	treeMu sync.Mutex
+
+	mu sync.Mutex
+	// pending holds admitted evidence IDs awaiting sequencing. Each group
+	// is assigned consecutive leaf indices in one committer step, so a
+	// batch is never interleaved with other ingests.
+	pending []sequencingGroup
+	// sequenced is closed and replaced whenever leaf indices are assigned,
+	// waking every WaitForLeaf caller.
+	sequenced chan struct{}
+	// publisher is set when sequencing runs through the ingest pipeline;
+	// outboxTopic when admissions announce themselves through the outbox.
+	publisher   *pipeline.Publisher
+	outboxTopic string
+}
+
+// New returns a vault over s. engine may hold a prefix of the store's leaf
+// sequence, typically nothing; it is caught up before it is read.
+func New(s store.Store, engine merkle.Engine, cfg Config) *Vault {
+	obs := cfg.Observer
+	if obs == nil {
+		obs = nopObserver{}
+	}
+	tenant := cfg.Tenant
+	if tenant == "" {
+		tenant = store.DefaultTenant
+	}
+	return &Vault{store: s, engine: engine, observer: obs, signer: cfg.Signer, tenant: tenant, origin: cfg.Origin, quotas: cfg.Quotas, sequenced: make(chan struct{})}
+}
+
+// Store returns the backing store.
+func (v *Vault) Store() store.Store {
+	return v.store
+}
+
+// Tenant returns the tenant the vault serves.
+func (v *Vault) Tenant() string {
+	return v.tenant
+}
+
+// Get returns the record with the given ID or store.ErrNotFound.
+func (v *Vault) Get(ctx context.Context, id string) (Record, error) {
+	ev, err := v.store.GetEvidence(ctx, id)
+	if err != nil {
+		return Record{}, err
+	}
+	return recordFromStore(ev), nil
+}
+
+// Query returns the records matching q in (IngestedAt, ID) order.
+func (v *Vault) Query(ctx context.Context, q store.EvidenceQuery) ([]Record, error) {
+	found, err := v.store.QueryEvidence(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Record, 0, len(found))
+	for i := range found {
+		out = append(out, recordFromStore(&found[i]))
+	}
+	return out, nil
+}
+
+// RecordAudit persists an audit entry and passes it to the observer. A
+// store failure is logged; the action being audited has already happened.
+func (v *Vault) RecordAudit(ctx context.Context, action, resourceID, actor string) {
+	v.RecordAuditMetadata(ctx, action, resourceID, actor, nil)
+}
+
+// RecordAuditMetadata is RecordAudit with action-specific details.
+func (v *Vault) RecordAuditMetadata(ctx context.Context, action, resourceID, actor string, metadata map[string]string) {
+	entry := store.AuditEntry{ID: uuid.NewString(), Action: action, ResourceID: resourceID, Actor: actor, Timestamp: time.Now().UTC(), Metadata: metadata}
+	if err := v.store.SaveAudit(ctx, entry); err != nil {
+		log.Error().Err(err).Str("action", action).Str("resource_id", resourceID).Msg("persist audit entry")
+	}
+	v.observer.Audited(entry)
+}
+
+// QueryAudits returns the audit entries matching q, oldest first.
+func (v *Vault) QueryAudits(ctx context.Context, q store.AuditQuery) ([]store.AuditEntry, error) {
+	return v.store.QueryAudits(ctx, q)
+}
+
+// Audits returns the whole audit trail, oldest first.
+func (v *Vault) Audits(ctx context.Context) ([]store.AuditEntry, error) {
+	entries, err := v.store.ListAudits(ctx, 0)
+	if err != nil {
+		return nil, err
+	}
+	// the store lists newest first
+	for i, j := 0, len(entries)-1; i < j; i, j = i+1, j-1 {
+		entries[i], entries[j] = entries[j], entries[i]
+	}
+	return entries, nil
+}
+
+func (v *Vault) publishStatus(changes ...events.StatusChange) {
+	for _, c := range changes {
+		v.observer.StatusChanged(c)
+	}
+}
+
+type nopObserver struct{}
+
+func (nopObserver) StatusChanged(events.StatusChange)           {}
+func (nopObserver) Sequenced(Record)                            {}
+func (nopObserver) CheckpointPublished(Checkpoint, *Checkpoint) {}
+func (nopObserver) Audited(store.AuditEntry)                    {}
+func (nopObserver) UsageMeasured(Usage)                         {}
+func (nopObserver) QuotaExceeded(QuotaViolation)                {}
@@ -0,0 +1,307 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/SaridakisStamatisChristos/vault-api/domain/evidence"
+	"github.com/SaridakisStamatisChristos/vault-api/domain/merkle"
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+)
+
+type fixedSigner struct{}
+
+func (fixedSigner) SignCheckpoint(_ context.Context, _ int64, rootHash string) (string, string, error) {
+	return "sig:" + rootHash, "test", nil
+}
+
+func newReplica(s store.Store) *Vault {
+	return New(s, merkle.NewMemoryEngine(), Config{Signer: fixedSigner{}})
+}
+
+func admit(t *testing.T, v *Vault, key, payload string) Admission {
+	t.Helper()
+	sum := sha256.Sum256([]byte(payload))
+	adm, err := v.AdmitOne(context.Background(), AdmitRequest{
+		Actor: "tester",
+		Key:   key,
+		Draft: Record{ContentHash: hex.EncodeToString(sum[:])},
+		Dedup: Dedup{Policy: DedupAllowDuplicate},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return adm
+}
+
+func TestReplicasShareSequencingAndCheckpoints(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemoryStore()
+	a, b := newReplica(s), newReplica(s)
+
+	first := admit(t, a, "", "one").Record
+	second := admit(t, b, "", "two").Record
+	if !a.CommitNext(ctx) || !b.CommitNext(ctx) {
+		t.Fatal("each replica should sequence the record it admitted")
+	}
+
+	cpA, err := a.LatestCheckpoint(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpB, err := b.LatestCheckpoint(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cpA != cpB || cpA.TreeSize != 2 {
+		t.Fatalf("replicas disagree on the checkpoint: %+v vs %+v", cpA, cpB)
+	}
+	cps, err := b.Checkpoints(ctx)
+	if err != nil || len(cps) != 1 {
+		t.Fatalf("checkpoint signed twice: %v, %v", cps, err)
+	}
+
+	// b never appended first to its engine itself; it proves it from the store
+	proof, err := b.Proof(ctx, first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if proof.LeafIndex != 0 || proof.TreeSize != 2 {
+		t.Fatalf("unexpected proof %+v", proof)
+	}
+	rec, err := a.Get(ctx, second.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Status != evidence.StatusCheckpointed {
+		t.Fatalf("status = %s, want checkpointed", rec.Status)
+	}
+}
+
+func TestReplicasShareIdempotencyKeys(t *testing.T) {
+	s := store.NewMemoryStore()
+	a, b := newReplica(s), newReplica(s)
+
+	created := admit(t, a, "k", "payload")
+	replayed := admit(t, b, "k", "payload")
+	if !replayed.Replayed || replayed.Record.ID != created.Record.ID {
+		t.Fatalf("second replica did not replay the key: %+v", replayed)
+	}
+	if conflict := admit(t, b, "k", "other").Conflict; conflict != ConflictIdempotencyKeyReused {
+		t.Fatalf("conflict = %q", conflict)
+	}
+}
+
+// failingSaves fails the next fail saves of keyed evidence.
+type failingSaves struct {
+	store.Store
+	fail int
+}
+
+func (f *failingSaves) SaveKeyedEvidence(ctx context.Context, k store.IdempotencyKey, since time.Time, e store.Evidence, check store.UsageCheck, outbox ...store.OutboxMessage) (store.IdempotencyKey, error) {
+	if f.fail > 0 {
+		f.fail--
+		return store.IdempotencyKey{}, errors.New("database unavailable")
+	}
+	return f.Store.SaveKeyedEvidence(ctx, k, since, e, check, outbox...)
+}
+
+func TestFailedSaveLeavesIdempotencyKeyFree(t *testing.T) {
+	ctx := context.Background()
+	s := &failingSaves{Store: store.NewMemoryStore(), fail: 1}
+	v := newReplica(s)
+	sum := sha256.Sum256([]byte("payload"))
+	req := AdmitRequest{Actor: "tester", Key: "k", Draft: Record{ContentHash: hex.EncodeToString(sum[:])}, Dedup: Dedup{Policy: DedupAllowDuplicate}}
+	if _, err := v.AdmitOne(ctx, req); err == nil {
+		t.Fatal("expected the save to fail")
+	}
+	if _, err := s.GetIdempotencyKey(ctx, IdempotencyScope("tester", "k")); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("a failed save must not hold the key: %v", err)
+	}
+
+	retried := admit(t, v, "k", "payload")
+	if !retried.Created {
+		t.Fatalf("the retry must create the record: %+v", retried)
+	}
+	if _, err := s.GetEvidence(ctx, retried.Record.ID); err != nil {
+		t.Fatalf("record not stored: %v", err)
+	}
+	if replayed := admit(t, v, "k", "payload"); !replayed.Replayed || replayed.Record.ID != retried.Record.ID {
+		t.Fatalf("the key must now replay the stored record: %+v", replayed)
+	}
+}
+
+func TestCommitNextPicksUpOrphanedRecords(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemoryStore()
+	// a replica that admits and stops before its committer runs
+	orphan := admit(t, newReplica(s), "", "lost").Record
+
+	v := newReplica(s)
+	if !v.CommitNext(ctx) {
+		t.Fatal("pending record in the store was not sequenced")
+	}
+	leaf, err := v.WaitForLeaf(ctx, orphan.ID)
+	if err != nil || leaf != 0 {
+		t.Fatalf("leaf = %d, %v", leaf, err)
+	}
+	if _, err := v.Proof(ctx, "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("err = %v, want not found", err)
+	}
+}
+
+func TestCommitPendingDrainsEveryQueuedGroup(t *testing.T) {
+	ctx := context.Background()
+	v := newReplica(store.NewMemoryStore())
+	var ids []string
+	for _, p := range []string{"one", "two", "three", "four"} {
+		ids = append(ids, admit(t, v, "", p).Record.ID)
+	}
+	if v.Pending() != len(ids) {
+		t.Fatalf("expected %d queued groups, got %d", len(ids), v.Pending())
+	}
+	if n := v.CommitPending(ctx); n != len(ids) {
+		t.Fatalf("sequenced %d groups in one pass, want %d", n, len(ids))
+	}
+	for i, id := range ids {
+		rec, err := v.Get(ctx, id)
+		if err != nil || rec.LeafIndex == nil || *rec.LeafIndex != int64(i) {
+			t.Fatalf("record %d not sequenced in order: %+v %v", i, rec, err)
+		}
+	}
+	if v.Pending() != 0 || v.CommitPending(ctx) != 0 {
+		t.Fatal("nothing may be left to sequence")
+	}
+}
+
+func TestCheckPromisesCoversOtherReplicas(t *testing.T) {
+	ctx := context.Background()
+	s := store.NewMemoryStore()
+	// a replica that issued a promise and stopped before sequencing
+	lost := admit(t, newReplica(s), "", "lost").Record
+	a, b := newReplica(s), newReplica(s)
+
+	if n, pending, err := a.CheckPromises(ctx, time.Now(), time.Minute); err != nil || n != 0 || pending != 1 {
+		t.Fatalf("no promise is due yet: %d breaches, %d pending, %v", n, pending, err)
+	}
+	later := time.Now().Add(2 * time.Minute)
+	if n, _, err := a.CheckPromises(ctx, later, time.Minute); err != nil || n != 1 {
+		t.Fatalf("expected the orphaned promise to be breached: %d, %v", n, err)
+	}
+	if n, _, err := b.CheckPromises(ctx, later, time.Minute); err != nil || n != 0 {
+		t.Fatalf("breach reported again by another replica: %d, %v", n, err)
+	}
+	entries, err := b.Audits(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var audited int
+	for _, e := range entries {
+		if e.Action == "promise_breached" && e.ResourceID == lost.ID {
+			audited++
+		}
+	}
+	if audited != 1 {
+		t.Fatalf("breach audited %d times, want 1", audited)
+	}
+}
+
+func TestAdmitEnforcesQuotas(t *testing.T) {
+	ctx := context.Background()
+	v := New(store.NewMemoryStore(), merkle.NewMemoryEngine(), Config{Quotas: Quotas{
+		Tenant:   Quota{MaxStorageBytes: 25},
+		Subject:  Quota{MaxBytesPerDay: 10},
+		Subjects: map[string]Quota{"ci": {MaxEvidence: 1}},
+	}})
+	admitAs := func(actor, payload string) Admission {
+		t.Helper()
+		sum := sha256.Sum256([]byte(payload))
+		adm, err := v.Admit(ctx, AdmitRequest{Actor: actor, Draft: Record{ContentHash: hex.EncodeToString(sum[:]), Size: int64(len(payload))}, Dedup: Dedup{Policy: DedupAllowDuplicate}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return adm
+	}
+
+	if adm := admitAs("alice", "12345678"); !adm.Created || adm.Record.Size != 8 {
+		t.Fatalf("first ingest refused: %+v", adm)
+	}
+	adm := admitAs("alice", "123")
+	if adm.Conflict != ConflictQuotaExceeded || adm.Quota == nil || *adm.Quota != (QuotaViolation{Scope: QuotaScopeSubject, Quota: "max_bytes_per_day", Limit: 10, Used: 8}) {
+		t.Fatalf("daily subject quota not enforced: %+v %+v", adm, adm.Quota)
+	}
+	if adm := admitAs("ci", "1234567890"); !adm.Created {
+		t.Fatalf("ci has its own quota: %+v", adm)
+	}
+	if adm := admitAs("ci", "1"); adm.Quota == nil || adm.Quota.Quota != "max_evidence" {
+		t.Fatalf("ci record quota not enforced: %+v", adm)
+	}
+	if adm := admitAs("bob", "12345678"); adm.Quota == nil || adm.Quota.Scope != QuotaScopeTenant || adm.Quota.Used != 18 {
+		t.Fatalf("tenant storage quota not enforced: %+v", adm)
+	}
+
+	tenant, sub, err := v.QuotaStatus(ctx, "alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tenant.Usage != (Usage{Evidence: 2, StorageBytes: 18, BytesToday: 18}) || tenant.Quota.MaxStorageBytes != 25 {
+		t.Fatalf("tenant status = %+v", tenant)
+	}
+	if sub.Usage != (Usage{Evidence: 1, StorageBytes: 8, BytesToday: 8}) || sub.Quota.MaxBytesPerDay != 10 {
+		t.Fatalf("subject status = %+v", sub)
+	}
+}
+
+func TestAdmitQuotasHoldUnderConcurrency(t *testing.T) {
+	ctx := context.Background()
+	v := New(store.NewMemoryStore(), merkle.NewMemoryEngine(), Config{Quotas: Quotas{Tenant: Quota{MaxEvidence: 5}}})
+	var wg sync.WaitGroup
+	var created, refused atomic.Int32
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sum := sha256.Sum256([]byte{byte(i)})
+			adm, err := v.Admit(ctx, AdmitRequest{Actor: "alice", Draft: Record{ContentHash: hex.EncodeToString(sum[:]), Size: 1}, Dedup: Dedup{Policy: DedupAllowDuplicate}})
+			switch {
+			case err != nil:
+				t.Error(err)
+			case adm.Created:
+				created.Add(1)
+			case adm.Conflict == ConflictQuotaExceeded:
+				refused.Add(1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if created.Load() != 5 || refused.Load() != 15 {
+		t.Fatalf("created %d and refused %d, want 5 and 15", created.Load(), refused.Load())
+	}
+}
+
+func TestAdmitRecordsProvenance(t *testing.T) {
+	ctx := context.Background()
+	v := newReplica(store.NewMemoryStore())
+	sum := sha256.Sum256([]byte("reading"))
+	adm, err := v.Admit(ctx, AdmitRequest{Actor: "cert:cam-7", Provenance: "sha256:ab12", Draft: Record{ContentHash: hex.EncodeToString(sum[:])}, Dedup: Dedup{Policy: DedupReturnExisting}})
+	if err != nil || !adm.Created {
+		t.Fatalf("admit: %+v %v", adm, err)
+	}
+	admit(t, v, "", "unrelated")
+	entries, err := v.Audits(ctx)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("audits: %+v %v", entries, err)
+	}
+	if e := entries[0]; e.Action != "ingest" || e.Actor != "cert:cam-7" || e.Metadata["ingested_by"] != "sha256:ab12" {
+		t.Fatalf("provenance not audited: %+v", e)
+	}
+	if e := entries[1]; e.Metadata != nil {
+		t.Fatalf("bearer ingest carries provenance: %+v", e)
+	}
+}
@@ -0,0 +1,286 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+// APIKey is a long-lived credential issued to a tenant. Only a hash of its
+// secret is stored. Zero times are unset: the key never expires, was never
+// used or is not revoked.
+type APIKey struct {
+	ID     string
+	Tenant string
+	Name   string
+	// Hash is the hex SHA-256 of the secret.
+	Hash string
+	// Scopes are the permissions the key grants.
+	Scopes    []string
+	CreatedBy string
+	CreatedAt time.Time
+	ExpiresAt time.Time
+	// LastUsedAt is updated by TouchAPIKey, which callers may throttle.
+	LastUsedAt time.Time
+	// RevokedAt may lie in the future while a rotated key winds down.
+	RevokedAt time.Time
+}
+
+// Active reports whether the key authenticates requests at now.
+func (k APIKey) Active(now time.Time) bool {
+	if !k.ExpiresAt.IsZero() && !now.Before(k.ExpiresAt) {
+		return false
+	}
+	return k.RevokedAt.IsZero() || now.Before(k.RevokedAt)
+}
+
+// APIKeyStore keeps the API keys of every tenant. Keys are looked up before
+// the tenant a request acts for is known, so like the outbox they are
+// shared by all views of a store.
+type APIKeyStore interface {
+	CreateAPIKey(ctx context.Context, k APIKey) error
+	GetAPIKey(ctx context.Context, id string) (*APIKey, error)
+	// ListAPIKeys returns tenant's keys, revoked ones included, ordered by
+	// (CreatedAt, ID).
+	ListAPIKeys(ctx context.Context, tenant string) ([]APIKey, error)
+	// RevokeAPIKey revokes the key as of at and returns it. A key already
+	// revoked earlier keeps its revocation time.
+	RevokeAPIKey(ctx context.Context, id string, at time.Time) (*APIKey, error)
+	// TouchAPIKey records that the key was used at at, unless it was
+	// already used later.
+	TouchAPIKey(ctx context.Context, id string, at time.Time) error
+}
+
+// revoke applies RevokeAPIKey's rule to k.
+func (k *APIKey) revoke(at time.Time) {
+	if k.RevokedAt.IsZero() || at.Before(k.RevokedAt) {
+		k.RevokedAt = at
+	}
+}
+
+func sortAPIKeys(keys []APIKey) {
+	sort.Slice(keys, func(i, j int) bool {
+		if !keys[i].CreatedAt.Equal(keys[j].CreatedAt) {
+			return keys[i].CreatedAt.Before(keys[j].CreatedAt)
+		}
+		return keys[i].ID < keys[j].ID
+	})
+}
+
+func (m *memStore) CreateAPIKey(ctx context.Context, k APIKey) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k.Scopes = append([]string(nil), k.Scopes...)
+	m.shared.apiKeys[k.ID] = k
+	return nil
+}
+
+func (m *memStore) GetAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k, ok := m.shared.apiKeys[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &k, nil
+}
+
+func (m *memStore) ListAPIKeys(ctx context.Context, tenant string) ([]APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []APIKey{}
+	for _, k := range m.shared.apiKeys {
+		if k.Tenant == tenant {
+			out = append(out, k)
+		}
+	}
+	sortAPIKeys(out)
+	return out, nil
+}
+
+func (m *memStore) RevokeAPIKey(ctx context.Context, id string, at time.Time) (*APIKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k, ok := m.shared.apiKeys[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	k.revoke(at)
+	m.shared.apiKeys[id] = k
+	return &k, nil
+}
+
+func (m *memStore) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k, ok := m.shared.apiKeys[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if at.After(k.LastUsedAt) {
+		k.LastUsedAt = at
+		m.shared.apiKeys[id] = k
+	}
+	return nil
+}
+
+// nullTime stores the zero time as NULL.
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+func zeroTime(t *time.Time) time.Time {
+	if t == nil {
+		return time.Time{}
+	}
+	return *t
+}
+
+const apiKeyColumns = `id, tenant_id, name, hash, scopes, created_by, created_at, expires_at, last_used_at, revoked_at`
+
+func scanAPIKey(row pgx.Row) (*APIKey, error) {
+	var k APIKey
+	var expires, used, revoked *time.Time
+	if err := row.Scan(&k.ID, &k.Tenant, &k.Name, &k.Hash, &k.Scopes, &k.CreatedBy, &k.CreatedAt, &expires, &used, &revoked); err != nil {
+		return nil, notFound(err)
+	}
+	k.ExpiresAt, k.LastUsedAt, k.RevokedAt = zeroTime(expires), zeroTime(used), zeroTime(revoked)
+	return &k, nil
+}
+
+// API keys are not tenant rows, so these statements run outside inTx.
+
+func (p *pgStore) CreateAPIKey(ctx context.Context, k APIKey) error {
+	_, err := p.pool.Exec(ctx, `INSERT INTO api_keys (`+apiKeyColumns+`) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)`,
+		k.ID, k.Tenant, k.Name, k.Hash, k.Scopes, k.CreatedBy, k.CreatedAt, nullTime(k.ExpiresAt), nullTime(k.LastUsedAt), nullTime(k.RevokedAt))
+	return err
+}
+
+func (p *pgStore) GetAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	return scanAPIKey(p.pool.QueryRow(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE id=$1`, id))
+}
+
+func (p *pgStore) ListAPIKeys(ctx context.Context, tenant string) ([]APIKey, error) {
+	rows, err := p.pool.Query(ctx, `SELECT `+apiKeyColumns+` FROM api_keys WHERE tenant_id=$1 ORDER BY created_at, id`, tenant)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []APIKey{}
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *k)
+	}
+	return out, rows.Err()
+}
+
+func (p *pgStore) RevokeAPIKey(ctx context.Context, id string, at time.Time) (*APIKey, error) {
+	return scanAPIKey(p.pool.QueryRow(ctx, `
+    UPDATE api_keys SET revoked_at = CASE WHEN revoked_at IS NULL OR revoked_at > $2 THEN $2 ELSE revoked_at END
+    WHERE id=$1 RETURNING `+apiKeyColumns, id, at))
+}
+
+func (p *pgStore) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	tag, err := p.pool.Exec(ctx, `UPDATE api_keys SET last_used_at = greatest(last_used_at, $2) WHERE id=$1`, id, at)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// updateAPIKey applies fn to the stored key id and returns the result.
+func (b *boltStore) updateAPIKey(id string, fn func(*APIKey)) (*APIKey, error) {
+	var k APIKey
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketAPIKeys)
+		v := bucket.Get([]byte(id))
+		if v == nil {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(v, &k); err != nil {
+			return err
+		}
+		fn(&k)
+		v, err := json.Marshal(k)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (b *boltStore) CreateAPIKey(ctx context.Context, k APIKey) error {
+	v, err := json.Marshal(k)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketAPIKeys).Put([]byte(k.ID), v)
+	})
+}
+
+func (b *boltStore) GetAPIKey(ctx context.Context, id string) (*APIKey, error) {
+	var k APIKey
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(bucketAPIKeys).Get([]byte(id))
+		if v == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, &k)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (b *boltStore) ListAPIKeys(ctx context.Context, tenant string) ([]APIKey, error) {
+	out := []APIKey{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketAPIKeys).ForEach(func(_, v []byte) error {
+			var k APIKey
+			if err := json.Unmarshal(v, &k); err != nil {
+				return err
+			}
+			if k.Tenant == tenant {
+				out = append(out, k)
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortAPIKeys(out)
+	return out, nil
+}
+
+func (b *boltStore) RevokeAPIKey(ctx context.Context, id string, at time.Time) (*APIKey, error) {
+	return b.updateAPIKey(id, func(k *APIKey) { k.revoke(at) })
+}
+
+func (b *boltStore) TouchAPIKey(ctx context.Context, id string, at time.Time) error {
+	_, err := b.updateAPIKey(id, func(k *APIKey) {
+		if at.After(k.LastUsedAt) {
+			k.LastUsedAt = at
+		}
+	})
+	return err
+}
@@ -0,0 +1,132 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+// AuditQuery selects audit entries for QueryAudits.
+type AuditQuery struct {
+	// Since keeps entries at or after it; zero keeps all.
+	Since time.Time
+	// AfterTime and AfterID resume after the entry they name.
+	AfterTime time.Time
+	AfterID   string
+	// Limit caps the page; <= 0 returns every match.
+	Limit int
+}
+
+// Matches reports whether a falls in q, ignoring Limit.
+func (q AuditQuery) Matches(a AuditEntry) bool {
+	if !q.Since.IsZero() && a.Timestamp.Before(q.Since) {
+		return false
+	}
+	if q.AfterID != "" && !auditBefore(q.AfterTime, q.AfterID, a) {
+		return false
+	}
+	return true
+}
+
+// auditBefore reports whether (t, id) sorts before a.
+func auditBefore(t time.Time, id string, a AuditEntry) bool {
+	if !t.Equal(a.Timestamp) {
+		return t.Before(a.Timestamp)
+	}
+	return id < a.ID
+}
+
+func (m *memStore) QueryAudits(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	m.mu.Lock()
+	var res []AuditEntry
+	for _, a := range m.audits {
+		if q.Matches(a) {
+			res = append(res, a)
+		}
+	}
+	m.mu.Unlock()
+	sort.Slice(res, func(i, j int) bool { return auditBefore(res[i].Timestamp, res[i].ID, res[j]) })
+	if q.Limit > 0 && len(res) > q.Limit {
+		res = res[:q.Limit]
+	}
+	return res, nil
+}
+
+func (p *pgStore) QueryAudits(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	var since, afterTime *time.Time
+	if !q.Since.IsZero() {
+		since = &q.Since
+	}
+	var afterID *string
+	if q.AfterID != "" {
+		afterTime, afterID = &q.AfterTime, &q.AfterID
+	}
+	var n *int
+	if q.Limit > 0 {
+		n = &q.Limit
+	}
+	var res []AuditEntry
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+    SELECT id, coalesce(action, ''), coalesce(resource_id, ''), coalesce(actor, ''), created_at, metadata FROM audit_log
+    WHERE ($1::timestamptz IS NULL OR created_at >= $1)
+      AND ($3::text IS NULL OR (created_at, id) > ($2::timestamptz, $3))
+    ORDER BY created_at, id
+    LIMIT $4`, since, afterTime, afterID, n)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var a AuditEntry
+			if err := rows.Scan(&a.ID, &a.Action, &a.ResourceID, &a.Actor, &a.Timestamp, &a.Metadata); err != nil {
+				return err
+			}
+			res = append(res, a)
+		}
+		return rows.Err()
+	})
+	return res, err
+}
+
+func (b *boltStore) QueryAudits(ctx context.Context, q AuditQuery) ([]AuditEntry, error) {
+	var res []AuditEntry
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := b.tenantBuckets(tx).Bucket(bucketAudit).Cursor()
+		// start at the later of since and the cursor; Matches skips the
+		// cursor's own entry
+		start := timeKey(q.Since, "")
+		if q.Since.IsZero() {
+			start = nil
+		}
+		if after := timeKey(q.AfterTime, q.AfterID); q.AfterID != "" && bytes.Compare(after, start) > 0 {
+			start = after
+		}
+		k, v := c.First()
+		if start != nil {
+			k, v = c.Seek(start)
+		}
+		for ; k != nil; k, v = c.Next() {
+			if q.Limit > 0 && len(res) >= q.Limit {
+				break
+			}
+			var a AuditEntry
+			if err := json.Unmarshal(v, &a); err != nil {
+				return err
+			}
+			if q.Matches(a) {
+				res = append(res, a)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,675 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltSchemaVersion is bumped whenever the bucket layout changes; a file
+// written by a newer build is refused rather than misread.
+const boltSchemaVersion = 7
+
+var (
+	bucketEvidence          = []byte("evidence")
+	bucketByTime            = []byte("evidence_by_time")
+	bucketByHash            = []byte("evidence_by_hash")
+	bucketPending           = []byte("evidence_pending")
+	bucketSequenced         = []byte("evidence_sequenced")
+	bucketByLeaf            = []byte("evidence_by_leaf")
+	bucketAudit             = []byte("audit")
+	bucketOutbox            = []byte("outbox")
+	bucketCheckpoints       = []byte("checkpoints")
+	bucketIdempotency       = []byte("idempotency_keys")
+	bucketMeta              = []byte("meta")
+	bucketTenants           = []byte("tenants")
+	bucketUsage             = []byte("usage")
+	bucketAPIKeys           = []byte("api_keys")
+	bucketUploads           = []byte("upload_sessions")
+	bucketWebhooks          = []byte("webhooks")
+	bucketWebhookDeliveries = []byte("webhook_deliveries")
+
+	// tenantBucketNames are the buckets every tenant has its own copy of.
+	tenantBucketNames = [][]byte{bucketEvidence, bucketByTime, bucketByHash, bucketPending, bucketSequenced, bucketByLeaf, bucketAudit, bucketCheckpoints, bucketIdempotency, bucketMeta, bucketUsage, bucketUploads, bucketWebhooks, bucketWebhookDeliveries}
+
+	metaSchema   = []byte("schema_version")
+	metaNextLeaf = []byte("next_leaf")
+)
+
+// -- embedded store (bbolt)
+//
+// Evidence is kept as JSON keyed by ID, with index buckets maintained in
+// the same transaction:
+//
+//	evidence_by_time    ingested_at|id             QueryEvidence order
+//	evidence_by_hash    content_hash\x00ingested_at|id
+//	evidence_pending    ingested_at|id             records without a leaf
+//	evidence_sequenced  leaf_index                 status sequenced, for MarkCheckpointed
+//	evidence_by_leaf    leaf_index                 every record with a leaf, for ListLeaves
+//	usage               subject\x00day            records and bytes ingested, for Usage
+//	upload_sessions     id                         resumable uploads in progress
+//	webhooks            id                         webhook subscriptions
+//	webhook_deliveries  id                         queued, delivered and dead webhook deliveries
+//
+// DefaultTenant's buckets are at the root of the file, next to the shared
+// outbox and api_keys; every other tenant has the same set under
+// tenants/<id>.
+//
+// bbolt allows one writer at a time, so every read-modify-write below is
+// serialised without further locking.
+type boltStore struct {
+	db     *bolt.DB
+	tenant string
+}
+
+// bucketSet is where a tenant's buckets live: a *bolt.Tx for the root or
+// the tenant's *bolt.Bucket.
+type bucketSet interface {
+	Bucket(name []byte) *bolt.Bucket
+}
+
+func (b *boltStore) tenantBuckets(tx *bolt.Tx) bucketSet {
+	if b.tenant == DefaultTenant {
+		return tx
+	}
+	return tx.Bucket(bucketTenants).Bucket([]byte(b.tenant))
+}
+
+// OpenBoltStore opens or creates the database file at path. The file is
+// locked for the lifetime of the store; a second process opening it fails
+// after a short wait instead of blocking.
+func OpenBoltStore(path string) (*boltStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("open %s: %w", path, err)
+	}
+	b := &boltStore{db: db, tenant: DefaultTenant}
+	if err := b.ensureSchema(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// Close closes the file, and with it every tenant view.
+func (b *boltStore) Close() error {
+	return b.db.Close()
+}
+
+func (b *boltStore) ForTenant(tenant string) (Store, error) {
+	if err := ValidTenantID(tenant); err != nil {
+		return nil, err
+	}
+	t := &boltStore{db: b.db, tenant: tenant}
+	if tenant == DefaultTenant {
+		return t, nil
+	}
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		root, err := tx.Bucket(bucketTenants).CreateBucketIfNotExists([]byte(tenant))
+		if err != nil {
+			return err
+		}
+		for _, name := range tenantBucketNames {
+			if _, err := root.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+func (b *boltStore) CountEvidence(ctx context.Context) (int64, error) {
+	var n int64
+	err := b.db.View(func(tx *bolt.Tx) error {
+		n = int64(b.tenantBuckets(tx).Bucket(bucketEvidence).Stats().KeyN)
+		return nil
+	})
+	return n, err
+}
+
+func (b *boltStore) Usage(ctx context.Context, subject string, day time.Time) (Usage, error) {
+	var u Usage
+	err := b.db.View(func(tx *bolt.Tx) error {
+		u = readBoltUsage(b.tenantBuckets(tx), subject, day)
+		return nil
+	})
+	return u, err
+}
+
+func readBoltUsage(bk bucketSet, subject string, day time.Time) Usage {
+	var u Usage
+	d := usageDay(day)
+	c := bk.Bucket(bucketUsage).Cursor()
+	prefix := []byte(subject + "\x00")
+	k, v := c.First()
+	if subject != "" {
+		k, v = c.Seek(prefix)
+	}
+	for ; k != nil && (subject == "" || bytes.HasPrefix(k, prefix)); k, v = c.Next() {
+		_, kd, _ := bytes.Cut(k, []byte{0})
+		u.add(int64(binary.BigEndian.Uint64(v[:8])), int64(binary.BigEndian.Uint64(v[8:])), string(kd) == d)
+	}
+	return u
+}
+
+// addUsage counts e in the usage ledger.
+func addUsage(bk bucketSet, e *Evidence) error {
+	ledger := bk.Bucket(bucketUsage)
+	k := []byte(e.IngestedBy + "\x00" + usageDay(e.IngestedAt))
+	v := make([]byte, 16)
+	if old := ledger.Get(k); old != nil {
+		copy(v, old)
+	}
+	binary.BigEndian.PutUint64(v[:8], binary.BigEndian.Uint64(v[:8])+1)
+	binary.BigEndian.PutUint64(v[8:], binary.BigEndian.Uint64(v[8:])+uint64(e.Size))
+	return ledger.Put(k, v)
+}
+
+// backfillUsage counts the records of a store written before the usage
+// ledger existed.
+func backfillUsage(bk bucketSet) error {
+	return bk.Bucket(bucketEvidence).ForEach(func(k, v []byte) error {
+		var e Evidence
+		if err := json.Unmarshal(v, &e); err != nil {
+			return fmt.Errorf("decode evidence %s: %w", k, err)
+		}
+		return addUsage(bk, &e)
+	})
+}
+
+func (b *boltStore) ensureSchema() error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		for _, name := range append([][]byte{bucketOutbox, bucketTenants, bucketAPIKeys}, tenantBucketNames...) {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		meta := tx.Bucket(bucketMeta)
+		var version uint64
+		if v := meta.Get(metaSchema); v != nil {
+			version = binary.BigEndian.Uint64(v)
+			if version > boltSchemaVersion {
+				return fmt.Errorf("store schema version %d is newer than supported %d", version, boltSchemaVersion)
+			}
+		}
+		if version == 1 {
+			// version 2 added evidence_by_leaf
+			byLeaf := tx.Bucket(bucketByLeaf)
+			err := tx.Bucket(bucketEvidence).ForEach(func(k, v []byte) error {
+				var e Evidence
+				if err := json.Unmarshal(v, &e); err != nil {
+					return fmt.Errorf("decode evidence %s: %w", k, err)
+				}
+				if e.LeafIndex == nil {
+					return nil
+				}
+				return byLeaf.Put(u64(uint64(*e.LeafIndex)), k)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		// version 3 added the tenants bucket, created above
+		if version < 4 {
+			// version 4 added the usage ledger; earlier records are counted
+			// for the empty subject with unknown size
+			if err := backfillUsage(tx); err != nil {
+				return err
+			}
+			err := tx.Bucket(bucketTenants).ForEach(func(k, _ []byte) error {
+				root := tx.Bucket(bucketTenants).Bucket(k)
+				if _, err := root.CreateBucketIfNotExists(bucketUsage); err != nil {
+					return err
+				}
+				return backfillUsage(root)
+			})
+			if err != nil {
+				return err
+			}
+		}
+		// version 5 added the api_keys bucket, created above
+		if version < 6 {
+			// version 6 added upload_sessions to every tenant
+			err := tx.Bucket(bucketTenants).ForEach(func(k, _ []byte) error {
+				_, err := tx.Bucket(bucketTenants).Bucket(k).CreateBucketIfNotExists(bucketUploads)
+				return err
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if version < 7 {
+			// version 7 added webhooks and webhook_deliveries to every tenant
+			err := tx.Bucket(bucketTenants).ForEach(func(k, _ []byte) error {
+				root := tx.Bucket(bucketTenants).Bucket(k)
+				for _, name := range [][]byte{bucketWebhooks, bucketWebhookDeliveries} {
+					if _, err := root.CreateBucketIfNotExists(name); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+		}
+		return meta.Put(metaSchema, u64(boltSchemaVersion))
+	})
+}
+
+func u64(v uint64) []byte {
+	k := make([]byte, 8)
+	binary.BigEndian.PutUint64(k, v)
+	return k
+}
+
+// timeKey orders by t, then id. The sign bit is flipped so times before
+// the epoch still sort first.
+func timeKey(t time.Time, id string) []byte {
+	k := make([]byte, 8, 8+len(id))
+	binary.BigEndian.PutUint64(k, uint64(t.UnixNano())^(1<<63))
+	return append(k, id...)
+}
+
+func hashKey(hash string, t time.Time, id string) []byte {
+	return append([]byte(hash+"\x00"), timeKey(t, id)...)
+}
+
+func getEvidence(bk bucketSet, id string) (*Evidence, error) {
+	v := bk.Bucket(bucketEvidence).Get([]byte(id))
+	if v == nil {
+		return nil, ErrNotFound
+	}
+	var e Evidence
+	if err := json.Unmarshal(v, &e); err != nil {
+		return nil, fmt.Errorf("decode evidence %s: %w", id, err)
+	}
+	return &e, nil
+}
+
+// putEvidence writes e and moves it between the pending and sequenced
+// indexes. old is the record as stored before, or nil for an insert; the
+// time and hash indexes never change after insert.
+func putEvidence(bk bucketSet, old *Evidence, e *Evidence) error {
+	pending, sequenced := bk.Bucket(bucketPending), bk.Bucket(bucketSequenced)
+	// a pending entry's value marks a reported promise breach
+	var breached []byte
+	if old == nil {
+		if err := bk.Bucket(bucketByTime).Put(timeKey(e.IngestedAt, e.ID), nil); err != nil {
+			return err
+		}
+		if err := bk.Bucket(bucketByHash).Put(hashKey(e.ContentHash, e.IngestedAt, e.ID), nil); err != nil {
+			return err
+		}
+	} else {
+		if old.LeafIndex == nil {
+			breached = bytes.Clone(pending.Get(timeKey(old.IngestedAt, old.ID)))
+			if err := pending.Delete(timeKey(old.IngestedAt, old.ID)); err != nil {
+				return err
+			}
+		} else if old.Status == statusSequenced {
+			if err := sequenced.Delete(u64(uint64(*old.LeafIndex))); err != nil {
+				return err
+			}
+		}
+	}
+	if e.LeafIndex == nil {
+		if err := pending.Put(timeKey(e.IngestedAt, e.ID), breached); err != nil {
+			return err
+		}
+	} else {
+		if err := bk.Bucket(bucketByLeaf).Put(u64(uint64(*e.LeafIndex)), []byte(e.ID)); err != nil {
+			return err
+		}
+		if e.Status == statusSequenced {
+			if err := sequenced.Put(u64(uint64(*e.LeafIndex)), []byte(e.ID)); err != nil {
+				return err
+			}
+		}
+	}
+	v, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return bk.Bucket(bucketEvidence).Put([]byte(e.ID), v)
+}
+
+// assignLeaf gives e the next leaf index unless it already has one.
+func assignLeaf(bk bucketSet, e *Evidence) error {
+	if e.LeafIndex != nil {
+		return nil
+	}
+	meta := bk.Bucket(bucketMeta)
+	var next int64
+	if v := meta.Get(metaNextLeaf); v != nil {
+		next = int64(binary.BigEndian.Uint64(v))
+	}
+	old := *e
+	idx := next
+	e.LeafIndex = &idx
+	e.Status = sequencedStatus(e.Status)
+	if err := meta.Put(metaNextLeaf, u64(uint64(next+1))); err != nil {
+		return err
+	}
+	return putEvidence(bk, &old, e)
+}
+
+func (b *boltStore) SaveEvidence(ctx context.Context, e Evidence, check UsageCheck, outbox ...OutboxMessage) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return putNewEvidence(tx, b.tenant, b.tenantBuckets(tx), e, check, outbox)
+	})
+}
+
+// putNewEvidence stores e unless it exists, counting it towards its
+// subject's usage and queueing outbox for tenant with it. Write
+// transactions are serialised, so check sees the usage e is added to.
+func putNewEvidence(tx *bolt.Tx, tenant string, bk bucketSet, e Evidence, check UsageCheck, outbox []OutboxMessage) error {
+	if bk.Bucket(bucketEvidence).Get([]byte(e.ID)) != nil {
+		return nil
+	}
+	if e.IngestedAt.IsZero() {
+		e.IngestedAt = time.Now().UTC()
+	}
+	if check != nil {
+		if err := check(readBoltUsage(bk, "", e.IngestedAt), readBoltUsage(bk, e.IngestedBy, e.IngestedAt)); err != nil {
+			return err
+		}
+	}
+	if e.Status == "" {
+		e.Status = statusStored
+	}
+	e.LeafIndex = nil
+	if err := putEvidence(bk, nil, &e); err != nil {
+		return err
+	}
+	if err := addUsage(bk, &e); err != nil {
+		return err
+	}
+	return appendOutbox(tx, tenant, outbox)
+}
+
+func (b *boltStore) AssignNextPendingLeaf(ctx context.Context) (*Evidence, error) {
+	var out *Evidence
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bk := b.tenantBuckets(tx)
+		k, _ := bk.Bucket(bucketPending).Cursor().First()
+		if k == nil {
+			return nil
+		}
+		e, err := getEvidence(bk, string(k[8:]))
+		if err != nil {
+			return err
+		}
+		if err := assignLeaf(bk, e); err != nil {
+			return err
+		}
+		out = e
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (b *boltStore) AssignLeaves(ctx context.Context, ids []string) ([]Evidence, error) {
+	out := make([]Evidence, 0, len(ids))
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bk := b.tenantBuckets(tx)
+		for _, id := range ids {
+			e, err := getEvidence(bk, id)
+			if err != nil {
+				return err
+			}
+			if err := assignLeaf(bk, e); err != nil {
+				return err
+			}
+			out = append(out, *e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (b *boltStore) SetLeafIndex(ctx context.Context, id string, leaf int64) (*Evidence, error) {
+	var out *Evidence
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bk := b.tenantBuckets(tx)
+		e, err := getEvidence(bk, id)
+		if err != nil {
+			return err
+		}
+		out = e
+		if e.LeafIndex != nil {
+			return nil
+		}
+		if bk.Bucket(bucketByLeaf).Get(u64(uint64(leaf))) != nil {
+			return ErrLeafTaken
+		}
+		old := *e
+		idx := leaf
+		e.LeafIndex = &idx
+		e.Status = sequencedStatus(e.Status)
+		meta := bk.Bucket(bucketMeta)
+		if v := meta.Get(metaNextLeaf); v == nil || int64(binary.BigEndian.Uint64(v)) <= leaf {
+			if err := meta.Put(metaNextLeaf, u64(uint64(leaf+1))); err != nil {
+				return err
+			}
+		}
+		return putEvidence(bk, &old, e)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (b *boltStore) GetEvidence(ctx context.Context, id string) (*Evidence, error) {
+	var out *Evidence
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bk := b.tenantBuckets(tx)
+		e, err := getEvidence(bk, id)
+		out = e
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (b *boltStore) FindEvidenceByContentHash(ctx context.Context, hash string, since time.Time) (*Evidence, error) {
+	var out *Evidence
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bk := b.tenantBuckets(tx)
+		prefix := []byte(hash + "\x00")
+		k, _ := bk.Bucket(bucketByHash).Cursor().Seek(hashKey(hash, since, ""))
+		if k == nil || !bytes.HasPrefix(k, prefix) {
+			return nil
+		}
+		e, err := getEvidence(bk, string(k[len(prefix)+8:]))
+		out = e
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (b *boltStore) QueryEvidence(ctx context.Context, q EvidenceQuery) ([]Evidence, error) {
+	start := timeKey(q.IngestedAfter, "")
+	if q.IngestedAfter.IsZero() {
+		start = nil
+	}
+	if !q.AfterTime.IsZero() || q.AfterID != "" {
+		if cursor := timeKey(q.AfterTime, q.AfterID); bytes.Compare(cursor, start) > 0 {
+			start = cursor
+		}
+	}
+	var end []byte
+	if !q.IngestedBefore.IsZero() {
+		end = timeKey(q.IngestedBefore, "")
+	}
+	var res []Evidence
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bk := b.tenantBuckets(tx)
+		c := bk.Bucket(bucketByTime).Cursor()
+		k, _ := c.First()
+		if start != nil {
+			k, _ = c.Seek(start)
+		}
+		for ; k != nil; k, _ = c.Next() {
+			if end != nil && bytes.Compare(k[:8], end) >= 0 {
+				break
+			}
+			e, err := getEvidence(bk, string(k[8:]))
+			if err != nil {
+				return err
+			}
+			if !q.Matches(e) {
+				continue
+			}
+			res = append(res, *e)
+			if q.Limit > 0 && len(res) >= q.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (b *boltStore) ListLeaves(ctx context.Context, from int64, limit int) ([]Evidence, error) {
+	var res []Evidence
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bk := b.tenantBuckets(tx)
+		c := bk.Bucket(bucketByLeaf).Cursor()
+		for k, v := c.Seek(u64(uint64(from))); k != nil; k, v = c.Next() {
+			if limit > 0 && len(res) >= limit {
+				break
+			}
+			e, err := getEvidence(bk, string(v))
+			if err != nil {
+				return err
+			}
+			res = append(res, *e)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (b *boltStore) UpdateEvidenceStatus(ctx context.Context, id string, decide func(*Evidence) error) (*Evidence, error) {
+	var out *Evidence
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bk := b.tenantBuckets(tx)
+		e, err := getEvidence(bk, id)
+		if err != nil {
+			return err
+		}
+		old := *e
+		if err := decide(e); err != nil {
+			return err
+		}
+		// only the status fields are the caller's to change
+		next := old
+		next.Status, next.HeldFrom = e.Status, e.HeldFrom
+		out = &next
+		return putEvidence(bk, &old, &next)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (b *boltStore) MarkCheckpointed(ctx context.Context, treeSize int64) ([]string, error) {
+	var ids []string
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bk := b.tenantBuckets(tx)
+		// collect first: putEvidence deletes from the bucket being walked
+		c := bk.Bucket(bucketSequenced).Cursor()
+		for k, v := c.First(); k != nil && int64(binary.BigEndian.Uint64(k)) < treeSize; k, v = c.Next() {
+			ids = append(ids, string(v))
+		}
+		for _, id := range ids {
+			e, err := getEvidence(bk, id)
+			if err != nil {
+				return err
+			}
+			old := *e
+			e.Status = statusCheckpointed
+			if err := putEvidence(bk, &old, e); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+func (b *boltStore) SaveAudit(ctx context.Context, a AuditEntry) error {
+	if a.ID == "" {
+		a.ID = uuid.NewString()
+	}
+	v, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bk := b.tenantBuckets(tx)
+		return bk.Bucket(bucketAudit).Put(timeKey(a.Timestamp, a.ID), v)
+	})
+}
+
+// ListAudits returns the newest entries first, like the Postgres store.
+func (b *boltStore) ListAudits(ctx context.Context, limit int) ([]AuditEntry, error) {
+	var res []AuditEntry
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bk := b.tenantBuckets(tx)
+		c := bk.Bucket(bucketAudit).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if limit > 0 && len(res) >= limit {
+				break
+			}
+			var a AuditEntry
+			if err := json.Unmarshal(v, &a); err != nil {
+				return err
+			}
+			res = append(res, a)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
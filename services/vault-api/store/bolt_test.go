@@ -0,0 +1,114 @@
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestBoltStoreSurvivesReopen(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "vault.db")
+	b, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i, id := range []string{"a", "b", "c"} {
+		e := Evidence{ID: id, ContentHash: "h-" + id, Labels: map[string]string{"case": "42"}, IngestedAt: base.Add(time.Duration(i) * time.Second)}
+		if err := b.SaveEvidence(ctx, e, nil, OutboxMessage{Topic: "t", Key: id, Payload: []byte(id)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := b.AssignLeaves(ctx, []string{"b", "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenBoltStore(path); err == nil {
+		t.Fatal("a second open of a locked file must fail")
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err = OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	a, err := b.GetEvidence(ctx, "a")
+	if err != nil || a.LeafIndex == nil || *a.LeafIndex != 1 || a.Status != statusSequenced || a.Labels["case"] != "42" {
+		t.Fatalf("record not restored: %+v %v", a, err)
+	}
+	next, err := b.AssignNextPendingLeaf(ctx)
+	if err != nil || next == nil || next.ID != "c" || *next.LeafIndex != 2 {
+		t.Fatalf("leaf counter not restored: %+v %v", next, err)
+	}
+	if ids, _ := b.MarkCheckpointed(ctx, 2); len(ids) != 2 {
+		t.Fatalf("expected leaves 0 and 1 checkpointed, got %v", ids)
+	}
+	if ids, _ := b.MarkCheckpointed(ctx, 3); len(ids) != 1 || ids[0] != "c" {
+		t.Fatalf("expected only c checkpointed, got %v", ids)
+	}
+	found, err := b.FindEvidenceByContentHash(ctx, "h-b", base)
+	if err != nil || found == nil || found.ID != "b" {
+		t.Fatalf("hash index not restored: %+v %v", found, err)
+	}
+	page, err := b.QueryEvidence(ctx, EvidenceQuery{Labels: map[string]string{"case": "42"}, AfterTime: base, AfterID: "a"})
+	if err != nil || len(page) != 2 || page[0].ID != "b" || page[1].ID != "c" {
+		t.Fatalf("unexpected page after cursor: %+v %v", page, err)
+	}
+	msgs, err := b.ClaimOutbox(ctx, 10, time.Minute)
+	if err != nil || len(msgs) != 3 || msgs[0].Key != "a" || string(msgs[2].Payload) != "c" {
+		t.Fatalf("outbox not restored in order: %+v %v", msgs, err)
+	}
+}
+
+func TestBoltStoreBackfillsUsage(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "vault.db")
+	b, err := OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acme, err := b.ForTenant("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, s := range []Store{b, b, acme} {
+		if err := s.SaveEvidence(ctx, Evidence{ID: uuid.NewString(), ContentHash: "h", IngestedAt: at, Size: 10, IngestedBy: "alice"}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// rewind to the version 3 layout, which had no usage ledger
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.DeleteBucket(bucketUsage); err != nil {
+			return err
+		}
+		if err := tx.Bucket(bucketTenants).Bucket([]byte("acme")).DeleteBucket(bucketUsage); err != nil {
+			return err
+		}
+		return tx.Bucket(bucketMeta).Put(metaSchema, u64(3))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+
+	b, err = OpenBoltStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	acme, _ = b.ForTenant("acme")
+	if u, err := b.Usage(ctx, "", at); err != nil || u.Evidence != 2 || u.Bytes != 20 || u.DayBytes != 20 {
+		t.Fatalf("default usage = %+v %v", u, err)
+	}
+	if u, err := acme.Usage(ctx, "alice", at.Add(24*time.Hour)); err != nil || u.Evidence != 1 || u.DayBytes != 0 {
+		t.Fatalf("acme usage = %+v %v", u, err)
+	}
+}
@@ -0,0 +1,200 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+// Checkpoint is a signed tree head. A tree size is signed once; every
+// replica serves the checkpoint that was recorded first.
+type Checkpoint struct {
+	// Origin names the log the tree head belongs to; see service.Config.
+	Origin    string
+	TreeSize  int64
+	RootHash  string
+	Signature string
+	KeyRef    string
+	CreatedAt time.Time
+}
+
+// CheckpointStore keeps the checkpoint history.
+type CheckpointStore interface {
+	// SaveCheckpoint records cp unless a checkpoint of the same tree size
+	// exists, and returns the checkpoint now stored for that size. created
+	// reports whether cp was the one written.
+	SaveCheckpoint(ctx context.Context, cp Checkpoint) (stored Checkpoint, created bool, err error)
+	GetCheckpoint(ctx context.Context, treeSize int64) (*Checkpoint, error)
+	// ListCheckpoints returns up to limit checkpoints, largest tree size
+	// first; limit <= 0 returns all.
+	ListCheckpoints(ctx context.Context, limit int) ([]Checkpoint, error)
+}
+
+func (m *memStore) SaveCheckpoint(ctx context.Context, cp Checkpoint) (Checkpoint, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if existing, ok := m.checkpoints[cp.TreeSize]; ok {
+		return existing, false, nil
+	}
+	if cp.CreatedAt.IsZero() {
+		cp.CreatedAt = time.Now().UTC()
+	}
+	m.checkpoints[cp.TreeSize] = cp
+	return cp, true, nil
+}
+
+func (m *memStore) GetCheckpoint(ctx context.Context, treeSize int64) (*Checkpoint, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cp, ok := m.checkpoints[treeSize]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &cp, nil
+}
+
+func (m *memStore) ListCheckpoints(ctx context.Context, limit int) ([]Checkpoint, error) {
+	m.mu.Lock()
+	res := make([]Checkpoint, 0, len(m.checkpoints))
+	for _, cp := range m.checkpoints {
+		res = append(res, cp)
+	}
+	m.mu.Unlock()
+	sort.Slice(res, func(i, j int) bool { return res[i].TreeSize > res[j].TreeSize })
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+func (p *pgStore) SaveCheckpoint(ctx context.Context, cp Checkpoint) (Checkpoint, bool, error) {
+	if cp.CreatedAt.IsZero() {
+		cp.CreatedAt = time.Now().UTC()
+	}
+	stored, created := cp, false
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `INSERT INTO signed_tree_heads (origin, tree_size, root_hash, signature, key_id, published_at) VALUES ($1,$2,$3,$4,$5,$6) ON CONFLICT (tenant_id, tree_size) DO NOTHING`, cp.Origin, cp.TreeSize, cp.RootHash, cp.Signature, cp.KeyRef, cp.CreatedAt)
+		if err != nil {
+			return err
+		}
+		if created = tag.RowsAffected() > 0; created {
+			return nil
+		}
+		return scanCheckpoint(tx.QueryRow(ctx, `SELECT `+checkpointColumns+` FROM signed_tree_heads WHERE tree_size=$1`, cp.TreeSize), &stored)
+	})
+	if err != nil {
+		return Checkpoint{}, false, err
+	}
+	return stored, created, nil
+}
+
+// checkpointColumns is the select list scanCheckpoint reads.
+const checkpointColumns = `origin, tree_size, root_hash, signature, key_id, published_at`
+
+func scanCheckpoint(row pgx.Row, cp *Checkpoint) error {
+	return row.Scan(&cp.Origin, &cp.TreeSize, &cp.RootHash, &cp.Signature, &cp.KeyRef, &cp.CreatedAt)
+}
+
+func (p *pgStore) GetCheckpoint(ctx context.Context, treeSize int64) (*Checkpoint, error) {
+	var cp Checkpoint
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		return notFound(scanCheckpoint(tx.QueryRow(ctx, `SELECT `+checkpointColumns+` FROM signed_tree_heads WHERE tree_size=$1`, treeSize), &cp))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &cp, nil
+}
+
+func (p *pgStore) ListCheckpoints(ctx context.Context, limit int) ([]Checkpoint, error) {
+	var n *int
+	if limit > 0 {
+		n = &limit
+	}
+	var res []Checkpoint
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `SELECT `+checkpointColumns+` FROM signed_tree_heads ORDER BY tree_size DESC LIMIT $1`, n)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var cp Checkpoint
+			if err := scanCheckpoint(rows, &cp); err != nil {
+				return err
+			}
+			res = append(res, cp)
+		}
+		return rows.Err()
+	})
+	return res, err
+}
+
+func (b *boltStore) SaveCheckpoint(ctx context.Context, cp Checkpoint) (Checkpoint, bool, error) {
+	if cp.CreatedAt.IsZero() {
+		cp.CreatedAt = time.Now().UTC()
+	}
+	stored, created := cp, false
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bk := b.tenantBuckets(tx)
+		bucket := bk.Bucket(bucketCheckpoints)
+		key := u64(uint64(cp.TreeSize))
+		if v := bucket.Get(key); v != nil {
+			return json.Unmarshal(v, &stored)
+		}
+		v, err := json.Marshal(cp)
+		if err != nil {
+			return err
+		}
+		created = true
+		return bucket.Put(key, v)
+	})
+	if err != nil {
+		return Checkpoint{}, false, err
+	}
+	return stored, created, nil
+}
+
+func (b *boltStore) GetCheckpoint(ctx context.Context, treeSize int64) (*Checkpoint, error) {
+	var out *Checkpoint
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bk := b.tenantBuckets(tx)
+		v := bk.Bucket(bucketCheckpoints).Get(u64(uint64(treeSize)))
+		if v == nil {
+			return ErrNotFound
+		}
+		out = &Checkpoint{}
+		return json.Unmarshal(v, out)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (b *boltStore) ListCheckpoints(ctx context.Context, limit int) ([]Checkpoint, error) {
+	var res []Checkpoint
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bk := b.tenantBuckets(tx)
+		c := bk.Bucket(bucketCheckpoints).Cursor()
+		for k, v := c.Last(); k != nil; k, v = c.Prev() {
+			if limit > 0 && len(res) >= limit {
+				break
+			}
+			var cp Checkpoint
+			if err := json.Unmarshal(v, &cp); err != nil {
+				return err
+			}
+			res = append(res, cp)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
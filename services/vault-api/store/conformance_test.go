@@ -0,0 +1,63 @@
+package store_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+	"github.com/SaridakisStamatisChristos/vault-api/store/storetest"
+)
+
+func TestMemoryStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store { return store.NewMemoryStore() })
+}
+
+func TestBoltStoreConformance(t *testing.T) {
+	storetest.Run(t, func(t *testing.T) store.Store {
+		b, err := store.OpenBoltStore(filepath.Join(t.TempDir(), "vault.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { b.Close() })
+		return b
+	})
+}
+
+// TestPostgresStoreConformance runs against STORE_TEST_DATABASE_URL, giving
+// each subtest a fresh schema so it never touches existing tables.
+func TestPostgresStoreConformance(t *testing.T) {
+	dsn := os.Getenv("STORE_TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("STORE_TEST_DATABASE_URL not set; skipping Postgres conformance")
+	}
+	ctx := context.Background()
+	admin, err := pgxpool.New(ctx, dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer admin.Close()
+	storetest.Run(t, func(t *testing.T) store.Store {
+		schema := "conformance_" + strings.ToLower(t.Name()[strings.LastIndex(t.Name(), "/")+1:])
+		if _, err := admin.Exec(ctx, `DROP SCHEMA IF EXISTS `+schema+` CASCADE; CREATE SCHEMA `+schema); err != nil {
+			t.Fatal(err)
+		}
+		sep := "?"
+		if strings.Contains(dsn, "?") {
+			sep = "&"
+		}
+		pg, err := store.OpenPostgresStore(ctx, dsn+sep+"search_path="+schema, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() {
+			pg.Close()
+			admin.Exec(ctx, `DROP SCHEMA IF EXISTS `+schema+` CASCADE`)
+		})
+		return pg
+	})
+}
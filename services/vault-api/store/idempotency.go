@@ -0,0 +1,199 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+// IdempotencyKey binds a client's Idempotency-Key, scoped to its subject,
+// to the evidence record it created.
+type IdempotencyKey struct {
+	Scope       string
+	EvidenceID  string
+	ContentHash string
+	CreatedAt   time.Time
+}
+
+// IdempotencyStore remembers idempotency keys across requests and replicas.
+type IdempotencyStore interface {
+	GetIdempotencyKey(ctx context.Context, scope string) (*IdempotencyKey, error)
+	// ClaimIdempotencyKey stores k unless its scope already holds a key
+	// created at or after since, and returns the key now in effect. Callers
+	// compare the returned EvidenceID with their own to detect that a
+	// concurrent request won.
+	ClaimIdempotencyKey(ctx context.Context, k IdempotencyKey, since time.Time) (IdempotencyKey, error)
+	// SaveKeyedEvidence claims k like ClaimIdempotencyKey and, when the
+	// claim is e's, saves e like SaveEvidence, check included, in the same
+	// transaction, so a key never names a record that was not stored. When
+	// another record holds the key nothing is saved.
+	SaveKeyedEvidence(ctx context.Context, k IdempotencyKey, since time.Time, e Evidence, check UsageCheck, outbox ...OutboxMessage) (IdempotencyKey, error)
+}
+
+func (m *memStore) GetIdempotencyKey(ctx context.Context, scope string) (*IdempotencyKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	k, ok := m.idempotency[scope]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &k, nil
+}
+
+func (m *memStore) ClaimIdempotencyKey(ctx context.Context, k IdempotencyKey, since time.Time) (IdempotencyKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.claimKeyLocked(k, since), nil
+}
+
+func (m *memStore) SaveKeyedEvidence(ctx context.Context, k IdempotencyKey, since time.Time, e Evidence, check UsageCheck, outbox ...OutboxMessage) (IdempotencyKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	prev, claimed := m.idempotency[k.Scope]
+	held := m.claimKeyLocked(k, since)
+	if held.EvidenceID != e.ID {
+		return held, nil
+	}
+	if err := m.saveEvidenceLocked(e, check, outbox); err != nil {
+		if claimed {
+			m.idempotency[k.Scope] = prev
+		} else {
+			delete(m.idempotency, k.Scope)
+		}
+		return IdempotencyKey{}, err
+	}
+	return held, nil
+}
+
+func (m *memStore) claimKeyLocked(k IdempotencyKey, since time.Time) IdempotencyKey {
+	if held, ok := m.idempotency[k.Scope]; ok && !held.CreatedAt.Before(since) {
+		return held
+	}
+	m.idempotency[k.Scope] = k
+	return k
+}
+
+func (p *pgStore) GetIdempotencyKey(ctx context.Context, scope string) (*IdempotencyKey, error) {
+	k := IdempotencyKey{Scope: scope}
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		return notFound(tx.QueryRow(ctx, `SELECT evidence_id, content_hash, created_at FROM idempotency_keys WHERE scope=$1`, scope).Scan(&k.EvidenceID, &k.ContentHash, &k.CreatedAt))
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &k, nil
+}
+
+func (p *pgStore) ClaimIdempotencyKey(ctx context.Context, k IdempotencyKey, since time.Time) (IdempotencyKey, error) {
+	var held IdempotencyKey
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		held, err = claimKey(ctx, tx, k, since)
+		return err
+	})
+	if err != nil {
+		return IdempotencyKey{}, err
+	}
+	return held, nil
+}
+
+func (p *pgStore) SaveKeyedEvidence(ctx context.Context, k IdempotencyKey, since time.Time, e Evidence, check UsageCheck, outbox ...OutboxMessage) (IdempotencyKey, error) {
+	var held IdempotencyKey
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		if held, err = claimKey(ctx, tx, k, since); err != nil || held.EvidenceID != e.ID {
+			return err
+		}
+		return insertEvidence(ctx, tx, e, check, outbox)
+	})
+	if err != nil {
+		return IdempotencyKey{}, err
+	}
+	return held, nil
+}
+
+// claimKey upserts k. The upsert only replaces an expired key; either way
+// the row that survives is returned. A concurrent claim of the same scope
+// waits on the row until the first transaction ends.
+func claimKey(ctx context.Context, tx pgx.Tx, k IdempotencyKey, since time.Time) (IdempotencyKey, error) {
+	held := IdempotencyKey{Scope: k.Scope}
+	err := tx.QueryRow(ctx, `
+    INSERT INTO idempotency_keys AS k (scope, evidence_id, content_hash, created_at) VALUES ($1,$2,$3,$4)
+    ON CONFLICT (tenant_id, scope) DO UPDATE SET evidence_id = excluded.evidence_id, content_hash = excluded.content_hash, created_at = excluded.created_at
+        WHERE k.created_at < $5
+    RETURNING evidence_id, content_hash, created_at`, k.Scope, k.EvidenceID, k.ContentHash, k.CreatedAt, since).Scan(&held.EvidenceID, &held.ContentHash, &held.CreatedAt)
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return held, err
+	}
+	// no row returned: a live key holds the scope
+	err = tx.QueryRow(ctx, `SELECT evidence_id, content_hash, created_at FROM idempotency_keys WHERE scope=$1`, k.Scope).Scan(&held.EvidenceID, &held.ContentHash, &held.CreatedAt)
+	return held, err
+}
+
+func (b *boltStore) GetIdempotencyKey(ctx context.Context, scope string) (*IdempotencyKey, error) {
+	var out *IdempotencyKey
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bk := b.tenantBuckets(tx)
+		v := bk.Bucket(bucketIdempotency).Get([]byte(scope))
+		if v == nil {
+			return ErrNotFound
+		}
+		out = &IdempotencyKey{}
+		return json.Unmarshal(v, out)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (b *boltStore) ClaimIdempotencyKey(ctx context.Context, k IdempotencyKey, since time.Time) (IdempotencyKey, error) {
+	var held IdempotencyKey
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		held, err = claimBoltKey(b.tenantBuckets(tx), k, since)
+		return err
+	})
+	if err != nil {
+		return IdempotencyKey{}, err
+	}
+	return held, nil
+}
+
+func (b *boltStore) SaveKeyedEvidence(ctx context.Context, k IdempotencyKey, since time.Time, e Evidence, check UsageCheck, outbox ...OutboxMessage) (IdempotencyKey, error) {
+	var held IdempotencyKey
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bk := b.tenantBuckets(tx)
+		var err error
+		if held, err = claimBoltKey(bk, k, since); err != nil || held.EvidenceID != e.ID {
+			return err
+		}
+		return putNewEvidence(tx, b.tenant, bk, e, check, outbox)
+	})
+	if err != nil {
+		return IdempotencyKey{}, err
+	}
+	return held, nil
+}
+
+func claimBoltKey(bk bucketSet, k IdempotencyKey, since time.Time) (IdempotencyKey, error) {
+	bucket := bk.Bucket(bucketIdempotency)
+	if v := bucket.Get([]byte(k.Scope)); v != nil {
+		var current IdempotencyKey
+		if err := json.Unmarshal(v, &current); err != nil {
+			return IdempotencyKey{}, err
+		}
+		if !current.CreatedAt.Before(since) {
+			return current, nil
+		}
+	}
+	v, err := json.Marshal(k)
+	if err != nil {
+		return IdempotencyKey{}, err
+	}
+	return k, bucket.Put([]byte(k.Scope), v)
+}
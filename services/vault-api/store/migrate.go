@@ -0,0 +1,319 @@
+package store
+
+import (
+	"context"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// legacySchemaSQL converts a database created by the ensureSchema block of
+// releases before the runner into the schema of 0001_initial.
+//
+//go:embed migrations/legacy/ensure_schema.sql
+var legacySchemaSQL string
+
+// migrationLockID is the pg_advisory_lock key that serialises migration
+// runs across replicas.
+const migrationLockID = 0x7661756c745f6d67 // "vault_mg"
+
+// ErrMigrationDrift is returned when the applied migrations differ from
+// the ones compiled into this binary.
+var ErrMigrationDrift = errors.New("schema migrations drifted")
+
+// ErrMigrationsPending is returned by Verify when migrations still need to
+// be applied.
+var ErrMigrationsPending = errors.New("schema migrations pending")
+
+// Migration is one embedded migrations/NNNN_name.sql file. Applied
+// migrations are immutable: their checksum is recorded and compared on
+// every start. Files may wrap themselves in BEGIN; ... COMMIT;, as the
+// ones that predate the runner do; the runner supplies the transaction.
+type Migration struct {
+	Version  int
+	Name     string
+	Checksum string
+	SQL      string
+}
+
+// Migration states reported by Status.
+const (
+	MigrationApplied = "applied"
+	MigrationPending = "pending"
+	// MigrationDrifted means the applied file was edited afterwards.
+	MigrationDrifted = "drifted"
+	// MigrationUnknown means the database has a version this binary lacks.
+	MigrationUnknown = "unknown"
+)
+
+type MigrationStatus struct {
+	Version   int
+	Name      string
+	State     string
+	AppliedAt time.Time
+}
+
+// Migrations returns the embedded migrations in version order.
+func Migrations() ([]Migration, error) {
+	entries, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Migration, 0, len(entries))
+	for _, path := range entries {
+		base := strings.TrimSuffix(path[len("migrations/"):], ".sql")
+		num, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(num)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: name must be NNNN_description.sql", path)
+		}
+		body, err := migrationFiles.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		// a CRLF checkout must not read as drift
+		sql := strings.ReplaceAll(string(body), "\r\n", "\n")
+		sum := sha256.Sum256([]byte(sql))
+		out = append(out, Migration{Version: version, Name: name, Checksum: hex.EncodeToString(sum[:]), SQL: sql})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	for i, m := range out {
+		if m.Version != i+1 {
+			return nil, fmt.Errorf("migration versions must be contiguous from 1; found %d at position %d", m.Version, i+1)
+		}
+	}
+	return out, nil
+}
+
+// Migrator applies the embedded migrations to a Postgres database and
+// records them in schema_migrations.
+type Migrator struct {
+	pool *pgxpool.Pool
+}
+
+func NewMigrator(pool *pgxpool.Pool) *Migrator {
+	return &Migrator{pool: pool}
+}
+
+type appliedMigration struct {
+	name      string
+	checksum  string
+	appliedAt time.Time
+}
+
+func (m *Migrator) applied(ctx context.Context) (map[int]appliedMigration, error) {
+	if _, err := m.pool.Exec(ctx, `
+    CREATE TABLE IF NOT EXISTS schema_migrations (
+        version INT PRIMARY KEY,
+        name TEXT NOT NULL,
+        checksum TEXT NOT NULL,
+        applied_at timestamptz NOT NULL DEFAULT now()
+    )`); err != nil {
+		return nil, err
+	}
+	rows, err := m.pool.Query(ctx, `SELECT version, name, checksum, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[int]appliedMigration{}
+	for rows.Next() {
+		var v int
+		var a appliedMigration
+		if err := rows.Scan(&v, &a.name, &a.checksum, &a.appliedAt); err != nil {
+			return nil, err
+		}
+		out[v] = a
+	}
+	return out, rows.Err()
+}
+
+// Status lists every embedded and every applied migration.
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	applied, err := m.applied(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var out []MigrationStatus
+	for _, mig := range migrations {
+		st := MigrationStatus{Version: mig.Version, Name: mig.Name, State: MigrationPending}
+		if a, ok := applied[mig.Version]; ok {
+			st.AppliedAt = a.appliedAt
+			st.State = MigrationApplied
+			if a.checksum != mig.Checksum {
+				st.State = MigrationDrifted
+			}
+			delete(applied, mig.Version)
+		}
+		out = append(out, st)
+	}
+	for v, a := range applied {
+		out = append(out, MigrationStatus{Version: v, Name: a.name, State: MigrationUnknown, AppliedAt: a.appliedAt})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Version < out[j].Version })
+	return out, nil
+}
+
+// Verify returns ErrMigrationDrift when an applied migration was changed or
+// is unknown to this binary, and ErrMigrationsPending when the database is
+// behind.
+func (m *Migrator) Verify(ctx context.Context) error {
+	status, err := m.Status(ctx)
+	if err != nil {
+		return err
+	}
+	return verifyStatus(status)
+}
+
+func verifyStatus(status []MigrationStatus) error {
+	var drift, pending []string
+	for _, st := range status {
+		label := fmt.Sprintf("%04d_%s", st.Version, st.Name)
+		switch st.State {
+		case MigrationDrifted, MigrationUnknown:
+			drift = append(drift, label+" ("+st.State+")")
+		case MigrationPending:
+			pending = append(pending, label)
+		}
+	}
+	if len(drift) > 0 {
+		return fmt.Errorf("%w: %s", ErrMigrationDrift, strings.Join(drift, ", "))
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("%w: %s", ErrMigrationsPending, strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+// Up applies pending migrations in order, each in its own transaction, and
+// returns the ones it applied. It refuses to run on drift. Concurrent
+// callers wait on an advisory lock, so replicas starting together apply
+// each migration once. A database that predates the runner is adopted
+// first; see adoptLegacySchema.
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	conn, err := m.pool.Acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Release()
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, int64(migrationLockID)); err != nil {
+		return nil, err
+	}
+	defer conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, int64(migrationLockID))
+
+	if err := m.adoptLegacySchema(ctx, conn); err != nil {
+		return nil, err
+	}
+	status, err := m.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyStatus(status); err != nil && !errors.Is(err, ErrMigrationsPending) {
+		return nil, err
+	}
+	pending := map[int]bool{}
+	for _, st := range status {
+		pending[st.Version] = st.State == MigrationPending
+	}
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	var done []Migration
+	for _, mig := range migrations {
+		if !pending[mig.Version] {
+			continue
+		}
+		tx, err := conn.Begin(ctx)
+		if err != nil {
+			return done, err
+		}
+		if _, err := tx.Exec(ctx, unwrapTransaction(mig.SQL)); err != nil {
+			tx.Rollback(ctx)
+			return done, fmt.Errorf("migration %04d_%s: %w", mig.Version, mig.Name, err)
+		}
+		if _, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`, mig.Version, mig.Name, mig.Checksum); err != nil {
+			tx.Rollback(ctx)
+			return done, err
+		}
+		if err := tx.Commit(ctx); err != nil {
+			return done, err
+		}
+		done = append(done, mig)
+	}
+	return done, nil
+}
+
+// adoptLegacySchema records 0001_initial as applied on a database set up
+// before the runner, so Up continues with 0002 instead of failing on the
+// existing tables. Databases created by the former persistence/migrations
+// scripts already have that schema; those created by ensureSchema are
+// converted first. It does nothing once schema_migrations has rows or when
+// there is no evidence table.
+func (m *Migrator) adoptLegacySchema(ctx context.Context, conn *pgxpool.Conn) error {
+	applied, err := m.applied(ctx)
+	if err != nil || len(applied) > 0 {
+		return err
+	}
+	var hasEvidence, hasLeaves bool
+	if err := conn.QueryRow(ctx, `SELECT to_regclass('evidence') IS NOT NULL, to_regclass('tree_leaves') IS NOT NULL`).Scan(&hasEvidence, &hasLeaves); err != nil {
+		return err
+	}
+	if !hasEvidence {
+		return nil
+	}
+	migrations, err := Migrations()
+	if err != nil {
+		return err
+	}
+	initial := migrations[0]
+	tx, err := conn.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if !hasLeaves {
+		if _, err := tx.Exec(ctx, legacySchemaSQL); err != nil {
+			return fmt.Errorf("convert ensureSchema database: %w", err)
+		}
+	}
+	if _, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`, initial.Version, initial.Name, initial.Checksum); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// unwrapTransaction strips a BEGIN; ... COMMIT; pair enclosing the whole
+// of sql, keeping the lines in between.
+func unwrapTransaction(sql string) string {
+	lines := strings.Split(sql, "\n")
+	first, last := -1, -1
+	for i, l := range lines {
+		if t := strings.TrimSpace(l); t != "" && !strings.HasPrefix(t, "--") {
+			if first < 0 {
+				first = i
+			}
+			last = i
+		}
+	}
+	if first < 0 || first == last || !strings.EqualFold(strings.TrimSpace(lines[first]), "BEGIN;") || !strings.EqualFold(strings.TrimSpace(lines[last]), "COMMIT;") {
+		return sql
+	}
+	return strings.Join(lines[first+1:last], "\n")
+}
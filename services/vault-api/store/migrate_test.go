@@ -0,0 +1,57 @@
+package store
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestEmbeddedMigrationsAreOrdered(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) < 3 || migrations[0].Name != "initial" || migrations[1].Name != "rls" {
+		t.Fatalf("unexpected migrations: %+v", migrations)
+	}
+	seen := map[string]bool{}
+	for i, m := range migrations {
+		if m.Version != i+1 || len(m.Checksum) != 64 || strings.Contains(m.SQL, "\r") {
+			t.Fatalf("bad migration %d: %+v", i, m)
+		}
+		if seen[m.Checksum] {
+			t.Fatalf("duplicate checksum for %04d_%s", m.Version, m.Name)
+		}
+		seen[m.Checksum] = true
+	}
+}
+
+func TestVerifyStatusRefusesDrift(t *testing.T) {
+	ok := []MigrationStatus{{Version: 1, Name: "initial", State: MigrationApplied}}
+	if err := verifyStatus(ok); err != nil {
+		t.Fatalf("clean schema: %v", err)
+	}
+	pending := append(ok, MigrationStatus{Version: 2, Name: "rls", State: MigrationPending})
+	if err := verifyStatus(pending); !errors.Is(err, ErrMigrationsPending) {
+		t.Fatalf("want ErrMigrationsPending, got %v", err)
+	}
+	for _, state := range []string{MigrationDrifted, MigrationUnknown} {
+		drifted := append(pending, MigrationStatus{Version: 3, Name: "reconcile", State: state})
+		err := verifyStatus(drifted)
+		if !errors.Is(err, ErrMigrationDrift) || !strings.Contains(err.Error(), "0003_reconcile") {
+			t.Fatalf("%s: want ErrMigrationDrift naming the migration, got %v", state, err)
+		}
+	}
+}
+
+func TestUnwrapTransaction(t *testing.T) {
+	wrapped := "-- 001_initial.sql\nBEGIN;\n\nCREATE TABLE t (id TEXT);\n\nCOMMIT;\n"
+	if got := unwrapTransaction(wrapped); strings.Contains(got, "BEGIN") || strings.Contains(got, "COMMIT") || !strings.Contains(got, "CREATE TABLE t") {
+		t.Fatalf("wrapped migration not unwrapped: %q", got)
+	}
+	for _, sql := range []string{"CREATE TABLE t (id TEXT);\n", "BEGIN;\nCREATE TABLE t (id TEXT);\n", "COMMIT;\n"} {
+		if got := unwrapTransaction(sql); got != sql {
+			t.Fatalf("unwrapTransaction(%q) = %q", sql, got)
+		}
+	}
+}
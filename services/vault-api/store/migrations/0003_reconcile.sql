@@ -0,0 +1,84 @@
+-- 0003_reconcile.sql
+-- Brings the schema of 0001_initial and 0002_rls in line with what the
+-- service reads and writes:
+--  * evidence and audit_log IDs are the service's UUID strings, compared
+--    bytewise like the other backends;
+--  * evidence carries its lifecycle status, and labels and ingested_by are
+--    never NULL;
+--  * content_hash is no longer unique. The dedup policy decides whether
+--    repeated content is refused, and allow-duplicate keeps every copy;
+--  * evidence stays append-only apart from sequencing and the lifecycle:
+--    leaf_index is set once, status and held_from move. The blanket
+--    evidence_no_update trigger of 0001 is replaced accordingly;
+--  * signed_tree_heads gets the UPDATE/DELETE guard the other append-only
+--    tables have;
+--  * the outbox relays admissions to the ingest pipeline;
+--  * the 0002_rls roles get the privileges the service needs.
+-- Databases adopted from the ensureSchema block of earlier releases may
+-- already have status, the outbox and the indexes; those statements are
+-- idempotent.
+DROP TRIGGER evidence_no_update ON evidence;
+
+ALTER TABLE evidence DROP CONSTRAINT IF EXISTS evidence_content_hash_key;
+ALTER TABLE evidence ALTER COLUMN id TYPE TEXT COLLATE "C";
+UPDATE evidence SET labels = '{}'::jsonb WHERE labels IS NULL;
+ALTER TABLE evidence ALTER COLUMN labels SET DEFAULT '{}'::jsonb, ALTER COLUMN labels SET NOT NULL;
+UPDATE evidence SET ingested_by = '' WHERE ingested_by IS NULL;
+ALTER TABLE evidence ALTER COLUMN ingested_by SET DEFAULT '', ALTER COLUMN ingested_by SET NOT NULL;
+ALTER TABLE evidence ADD COLUMN IF NOT EXISTS status TEXT;
+UPDATE evidence SET status = CASE WHEN leaf_index IS NULL THEN 'stored' ELSE 'sequenced' END WHERE status IS NULL;
+ALTER TABLE evidence ALTER COLUMN status SET DEFAULT 'stored', ALTER COLUMN status SET NOT NULL;
+
+DROP INDEX IF EXISTS evidence_created_at_id_idx;
+CREATE INDEX evidence_ingested_at_id_idx ON evidence (ingested_at, id);
+DROP INDEX IF EXISTS evidence_content_hash_idx;
+CREATE INDEX evidence_content_hash_idx ON evidence (content_hash, ingested_at);
+CREATE INDEX IF NOT EXISTS evidence_labels_idx ON evidence USING GIN (labels jsonb_path_ops);
+CREATE INDEX IF NOT EXISTS evidence_status_leaf_idx ON evidence (status, leaf_index);
+
+CREATE FUNCTION evidence_append_only() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'DELETE' THEN
+    RAISE EXCEPTION 'modification not allowed on append-only table %', TG_TABLE_NAME;
+  END IF;
+  IF (OLD.leaf_index IS NOT NULL AND NEW.leaf_index IS DISTINCT FROM OLD.leaf_index)
+     OR to_jsonb(NEW) - '{leaf_index,status,held_from}'::text[] IS DISTINCT FROM to_jsonb(OLD) - '{leaf_index,status,held_from}'::text[] THEN
+    RAISE EXCEPTION 'evidence % may only change status, held_from and an unset leaf_index', OLD.id;
+  END IF;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER evidence_append_only BEFORE UPDATE OR DELETE ON evidence
+  FOR EACH ROW EXECUTE FUNCTION evidence_append_only();
+
+ALTER TABLE audit_log ALTER COLUMN id DROP DEFAULT;
+ALTER TABLE audit_log ALTER COLUMN id TYPE TEXT COLLATE "C" USING id::text;
+DROP SEQUENCE IF EXISTS audit_log_id_seq;
+CREATE INDEX audit_log_created_at_id_idx ON audit_log (created_at DESC, id DESC);
+
+CREATE TRIGGER signed_tree_heads_no_update BEFORE UPDATE OR DELETE ON signed_tree_heads
+  FOR EACH ROW EXECUTE FUNCTION deny_update_delete();
+
+CREATE TABLE IF NOT EXISTS outbox (
+    id BIGSERIAL PRIMARY KEY,
+    topic TEXT NOT NULL,
+    key TEXT NOT NULL,
+    payload BYTEA NOT NULL,
+    created_at timestamptz NOT NULL DEFAULT now(),
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at timestamptz NOT NULL DEFAULT now(),
+    claimed_until timestamptz,
+    delivered_at timestamptz
+);
+CREATE INDEX IF NOT EXISTS outbox_undelivered_idx ON outbox (key, id) WHERE delivered_at IS NULL;
+
+-- vault_api updates evidence only as the trigger above allows
+GRANT SELECT, UPDATE ON evidence TO vault_api;
+GRANT SELECT ON tree_leaves, signed_tree_heads, audit_log TO vault_api;
+GRANT SELECT, INSERT, UPDATE ON outbox TO vault_api;
+GRANT USAGE ON SEQUENCE outbox_id_seq TO vault_api;
+GRANT SELECT, INSERT ON evidence TO vault_ingester;
+GRANT SELECT ON evidence, tree_leaves, signed_tree_heads, audit_log TO vault_auditor;
+REVOKE UPDATE, DELETE ON evidence, tree_leaves, signed_tree_heads, audit_log FROM PUBLIC;
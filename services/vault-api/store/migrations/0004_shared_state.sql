@@ -0,0 +1,25 @@
+-- 0004_shared_state.sql
+-- State that replicas used to keep in memory: hold origins, the checkpoint
+-- history and idempotency keys.
+ALTER TABLE evidence ADD COLUMN held_from TEXT NOT NULL DEFAULT '';
+CREATE INDEX evidence_leaf_index_idx ON evidence (leaf_index) WHERE leaf_index IS NOT NULL;
+
+-- signed_tree_heads holds the checkpoint history, one signed head per tree
+-- size, so replicas that sign the same size concurrently keep one of them
+ALTER TABLE signed_tree_heads DROP COLUMN id, ADD PRIMARY KEY (tree_size);
+ALTER TABLE signed_tree_heads ALTER COLUMN key_id SET DEFAULT '';
+
+CREATE TABLE idempotency_keys (
+    scope TEXT PRIMARY KEY,
+    evidence_id TEXT NOT NULL,
+    content_hash TEXT NOT NULL,
+    created_at timestamptz NOT NULL
+);
+
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'vault_api') THEN
+    GRANT SELECT, INSERT, UPDATE ON idempotency_keys TO vault_api;
+  END IF;
+END
+$$;
@@ -0,0 +1,68 @@
+-- 0005_tenants.sql
+-- Tenant isolation. Every tenant-owned row carries tenant_id, filled in
+-- from the vault.tenant setting each store transaction makes, and forced
+-- row-level security confines statements to that tenant, table owner
+-- included. Existing rows belong to the default tenant. Sessions that do
+-- not set vault.tenant (ad hoc vault_auditor queries among them) see no
+-- rows until they do: SET vault.tenant = '<id>'.
+--
+-- This builds on 0002_rls rather than replacing it. The vault_api,
+-- vault_ingester and vault_auditor roles it creates keep their grants (as
+-- extended by 0003 and 0004), which decide the statements each role may
+-- run; the policies below decide the rows, for every role alike. The new
+-- tenant_id columns are covered by the existing table grants.
+ALTER TABLE evidence ADD COLUMN tenant_id TEXT COLLATE "C" NOT NULL DEFAULT 'default';
+ALTER TABLE audit_log ADD COLUMN tenant_id TEXT COLLATE "C" NOT NULL DEFAULT 'default';
+ALTER TABLE signed_tree_heads ADD COLUMN tenant_id TEXT COLLATE "C" NOT NULL DEFAULT 'default';
+ALTER TABLE idempotency_keys ADD COLUMN tenant_id TEXT COLLATE "C" NOT NULL DEFAULT 'default';
+ALTER TABLE tree_leaves ADD COLUMN tenant_id TEXT COLLATE "C" NOT NULL DEFAULT 'default';
+ALTER TABLE evidence ALTER COLUMN tenant_id SET DEFAULT current_setting('vault.tenant');
+ALTER TABLE audit_log ALTER COLUMN tenant_id SET DEFAULT current_setting('vault.tenant');
+ALTER TABLE signed_tree_heads ALTER COLUMN tenant_id SET DEFAULT current_setting('vault.tenant');
+ALTER TABLE idempotency_keys ALTER COLUMN tenant_id SET DEFAULT current_setting('vault.tenant');
+ALTER TABLE tree_leaves ALTER COLUMN tenant_id SET DEFAULT current_setting('vault.tenant');
+
+-- checkpoints name the log they were signed for
+ALTER TABLE signed_tree_heads ADD COLUMN origin TEXT NOT NULL DEFAULT '';
+
+-- keys and indexes lead with the tenant; each tenant has its own leaf
+-- sequence and checkpoint history
+ALTER TABLE evidence DROP CONSTRAINT evidence_pkey, ADD PRIMARY KEY (tenant_id, id);
+ALTER TABLE signed_tree_heads DROP CONSTRAINT signed_tree_heads_pkey, ADD PRIMARY KEY (tenant_id, tree_size);
+ALTER TABLE idempotency_keys DROP CONSTRAINT idempotency_keys_pkey, ADD PRIMARY KEY (tenant_id, scope);
+ALTER TABLE tree_leaves DROP CONSTRAINT tree_leaves_pkey, ADD PRIMARY KEY (tenant_id, leaf_index);
+DROP INDEX evidence_ingested_at_id_idx;
+CREATE INDEX evidence_ingested_at_id_idx ON evidence (tenant_id, ingested_at, id);
+DROP INDEX evidence_content_hash_idx;
+CREATE INDEX evidence_content_hash_idx ON evidence (tenant_id, content_hash, ingested_at);
+DROP INDEX evidence_leaf_index_idx;
+CREATE INDEX evidence_leaf_index_idx ON evidence (tenant_id, leaf_index) WHERE leaf_index IS NOT NULL;
+DROP INDEX audit_log_created_at_id_idx;
+CREATE INDEX audit_log_created_at_id_idx ON audit_log (tenant_id, created_at DESC, id DESC);
+
+ALTER TABLE evidence ENABLE ROW LEVEL SECURITY;
+ALTER TABLE evidence FORCE ROW LEVEL SECURITY;
+ALTER TABLE audit_log ENABLE ROW LEVEL SECURITY;
+ALTER TABLE audit_log FORCE ROW LEVEL SECURITY;
+ALTER TABLE signed_tree_heads ENABLE ROW LEVEL SECURITY;
+ALTER TABLE signed_tree_heads FORCE ROW LEVEL SECURITY;
+ALTER TABLE idempotency_keys ENABLE ROW LEVEL SECURITY;
+ALTER TABLE idempotency_keys FORCE ROW LEVEL SECURITY;
+ALTER TABLE tree_leaves ENABLE ROW LEVEL SECURITY;
+ALTER TABLE tree_leaves FORCE ROW LEVEL SECURITY;
+
+CREATE POLICY tenant_isolation ON evidence
+    USING (tenant_id = nullif(current_setting('vault.tenant', true), ''))
+    WITH CHECK (tenant_id = nullif(current_setting('vault.tenant', true), ''));
+CREATE POLICY tenant_isolation ON audit_log
+    USING (tenant_id = nullif(current_setting('vault.tenant', true), ''))
+    WITH CHECK (tenant_id = nullif(current_setting('vault.tenant', true), ''));
+CREATE POLICY tenant_isolation ON signed_tree_heads
+    USING (tenant_id = nullif(current_setting('vault.tenant', true), ''))
+    WITH CHECK (tenant_id = nullif(current_setting('vault.tenant', true), ''));
+CREATE POLICY tenant_isolation ON idempotency_keys
+    USING (tenant_id = nullif(current_setting('vault.tenant', true), ''))
+    WITH CHECK (tenant_id = nullif(current_setting('vault.tenant', true), ''));
+CREATE POLICY tenant_isolation ON tree_leaves
+    USING (tenant_id = nullif(current_setting('vault.tenant', true), ''))
+    WITH CHECK (tenant_id = nullif(current_setting('vault.tenant', true), ''));
@@ -0,0 +1,40 @@
+-- 0006_usage.sql
+-- Ingest quotas. Evidence records its payload size next to the subject
+-- that ingested it, and evidence_usage keeps running totals per tenant,
+-- subject and UTC day so quota checks do not scan the evidence table. Records
+-- ingested before this migration are counted for the tenant, under the
+-- empty subject, with unknown (zero) size.
+ALTER TABLE evidence ADD COLUMN size BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE evidence_usage (
+    tenant_id TEXT COLLATE "C" NOT NULL DEFAULT current_setting('vault.tenant'),
+    subject TEXT NOT NULL,
+    day DATE NOT NULL,
+    evidence BIGINT NOT NULL,
+    bytes BIGINT NOT NULL,
+    PRIMARY KEY (tenant_id, subject, day)
+);
+
+INSERT INTO evidence_usage (tenant_id, subject, day, evidence, bytes)
+    SELECT tenant_id, '', (ingested_at AT TIME ZONE 'UTC')::date, count(*), 0
+    FROM evidence GROUP BY 1, 3;
+
+ALTER TABLE evidence_usage ENABLE ROW LEVEL SECURITY;
+ALTER TABLE evidence_usage FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON evidence_usage
+    USING (tenant_id = nullif(current_setting('vault.tenant', true), ''))
+    WITH CHECK (tenant_id = nullif(current_setting('vault.tenant', true), ''));
+
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'vault_api') THEN
+    GRANT SELECT, INSERT, UPDATE ON evidence_usage TO vault_api;
+  END IF;
+  IF EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'vault_ingester') THEN
+    GRANT SELECT, INSERT, UPDATE ON evidence_usage TO vault_ingester;
+  END IF;
+  IF EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'vault_auditor') THEN
+    GRANT SELECT ON evidence_usage TO vault_auditor;
+  END IF;
+END
+$$;
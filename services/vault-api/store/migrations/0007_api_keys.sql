@@ -0,0 +1,25 @@
+-- 0007_api_keys.sql
+-- API keys. Keys are looked up by ID before the tenant a request acts for
+-- is known, so the table carries tenant_id but no row-level security, like
+-- the outbox. Only the SHA-256 of each secret is stored.
+CREATE TABLE api_keys (
+    id TEXT PRIMARY KEY,
+    tenant_id TEXT COLLATE "C" NOT NULL,
+    name TEXT NOT NULL,
+    hash TEXT NOT NULL,
+    scopes TEXT[] NOT NULL,
+    created_by TEXT NOT NULL,
+    created_at timestamptz NOT NULL,
+    expires_at timestamptz,
+    last_used_at timestamptz,
+    revoked_at timestamptz
+);
+CREATE INDEX api_keys_tenant_idx ON api_keys (tenant_id, created_at, id);
+
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'vault_api') THEN
+    GRANT SELECT, INSERT, UPDATE ON api_keys TO vault_api;
+  END IF;
+END
+$$;
@@ -0,0 +1,35 @@
+-- 0008_uploads.sql
+-- Resumable upload sessions, so an upload survives restarts and can be
+-- continued on any replica sharing the payload store. The staged blob is
+-- named by the session ID; hash_state is the SHA-256 state over the
+-- upload_offset bytes staged so far. claim is set while one append holds
+-- the session and lapses at claimed_until.
+CREATE TABLE upload_sessions (
+    tenant_id TEXT COLLATE "C" NOT NULL DEFAULT current_setting('vault.tenant'),
+    id TEXT NOT NULL,
+    actor TEXT NOT NULL,
+    content_type TEXT NOT NULL,
+    labels JSONB NOT NULL DEFAULT '{}'::jsonb,
+    length BIGINT NOT NULL,
+    upload_offset BIGINT NOT NULL,
+    hash_state BYTEA NOT NULL,
+    updated_at timestamptz NOT NULL,
+    claim TEXT,
+    claimed_until timestamptz,
+    PRIMARY KEY (tenant_id, id)
+);
+CREATE INDEX upload_sessions_updated_at_idx ON upload_sessions (tenant_id, updated_at);
+
+ALTER TABLE upload_sessions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE upload_sessions FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON upload_sessions
+    USING (tenant_id = nullif(current_setting('vault.tenant', true), ''))
+    WITH CHECK (tenant_id = nullif(current_setting('vault.tenant', true), ''));
+
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'vault_api') THEN
+    GRANT SELECT, INSERT, UPDATE, DELETE ON upload_sessions TO vault_api;
+  END IF;
+END
+$$;
@@ -0,0 +1,53 @@
+-- 0009_webhooks.sql
+-- Webhook subscriptions and their deliveries, so queued, delivered and
+-- dead-lettered deliveries survive restarts and are sent by whichever
+-- replica claims them first. secret keys the HMAC signature of each
+-- delivery and is kept as given. attempts is the JSON array of HTTP tries;
+-- claimed_until is set while a dispatcher is sending the delivery.
+CREATE TABLE webhook_subscriptions (
+    tenant_id TEXT COLLATE "C" NOT NULL DEFAULT current_setting('vault.tenant'),
+    id TEXT NOT NULL,
+    url TEXT NOT NULL,
+    events TEXT[] NOT NULL,
+    secret TEXT NOT NULL,
+    created_by TEXT NOT NULL,
+    created_at timestamptz NOT NULL,
+    PRIMARY KEY (tenant_id, id)
+);
+
+CREATE TABLE webhook_deliveries (
+    tenant_id TEXT COLLATE "C" NOT NULL DEFAULT current_setting('vault.tenant'),
+    id TEXT NOT NULL,
+    subscription_id TEXT NOT NULL,
+    event TEXT NOT NULL,
+    payload BYTEA NOT NULL,
+    status TEXT NOT NULL,
+    created_at timestamptz NOT NULL,
+    next_attempt_at timestamptz,
+    attempts JSONB NOT NULL DEFAULT '[]'::jsonb,
+    claimed_until timestamptz,
+    PRIMARY KEY (tenant_id, id),
+    FOREIGN KEY (tenant_id, subscription_id) REFERENCES webhook_subscriptions (tenant_id, id) ON DELETE CASCADE
+);
+CREATE INDEX webhook_deliveries_subscription_idx ON webhook_deliveries (tenant_id, subscription_id, created_at DESC, id DESC);
+CREATE INDEX webhook_deliveries_due_idx ON webhook_deliveries (tenant_id, next_attempt_at, id) WHERE status = 'pending';
+CREATE INDEX webhook_deliveries_dead_idx ON webhook_deliveries (tenant_id, created_at) WHERE status = 'dead';
+
+ALTER TABLE webhook_subscriptions ENABLE ROW LEVEL SECURITY;
+ALTER TABLE webhook_subscriptions FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON webhook_subscriptions
+    USING (tenant_id = nullif(current_setting('vault.tenant', true), ''))
+    WITH CHECK (tenant_id = nullif(current_setting('vault.tenant', true), ''));
+ALTER TABLE webhook_deliveries ENABLE ROW LEVEL SECURITY;
+ALTER TABLE webhook_deliveries FORCE ROW LEVEL SECURITY;
+CREATE POLICY tenant_isolation ON webhook_deliveries
+    USING (tenant_id = nullif(current_setting('vault.tenant', true), ''))
+    WITH CHECK (tenant_id = nullif(current_setting('vault.tenant', true), ''));
+
+DO $$
+BEGIN
+  IF EXISTS (SELECT 1 FROM pg_roles WHERE rolname = 'vault_api') THEN
+    GRANT SELECT, INSERT, UPDATE, DELETE ON webhook_subscriptions, webhook_deliveries TO vault_api;
+  END IF;
+END
+$$;
@@ -0,0 +1,10 @@
+-- 0010_unique_leaf_index.sql
+-- A leaf index names exactly one record of a tenant's log. The constraint
+-- replaces the plain index of 0005_tenants; records without a leaf index
+-- are NULL and do not conflict. It cannot be added while a tenant has two
+-- records on one leaf; find them with
+--   SELECT tenant_id, leaf_index, array_agg(id) FROM evidence
+--   WHERE leaf_index IS NOT NULL GROUP BY 1, 2 HAVING count(*) > 1;
+-- and resolve them before upgrading.
+DROP INDEX evidence_leaf_index_idx;
+ALTER TABLE evidence ADD CONSTRAINT evidence_tenant_leaf_index_key UNIQUE (tenant_id, leaf_index);
@@ -0,0 +1,15 @@
+-- 0011_outbox_evidence.sql
+-- Each outbox message names the tenant and record it announces, so the
+-- reconciler can find pending records whose message left the outbox
+-- without them being sequenced. Undelivered messages are backfilled from
+-- their ingest event; delivered ones are never consulted and keep NULL.
+ALTER TABLE outbox
+    ADD COLUMN tenant_id TEXT COLLATE "C" NOT NULL DEFAULT 'default',
+    ADD COLUMN evidence_id TEXT;
+
+UPDATE outbox SET
+    tenant_id = coalesce(nullif(convert_from(payload, 'UTF8')::jsonb ->> 'tenant', ''), 'default'),
+    evidence_id = convert_from(payload, 'UTF8')::jsonb ->> 'evidence_id'
+WHERE delivered_at IS NULL;
+
+CREATE INDEX outbox_evidence_undelivered_idx ON outbox (tenant_id, evidence_id) WHERE delivered_at IS NULL;
@@ -0,0 +1,9 @@
+-- 0012_promise_breaches.sql
+-- Inclusion promises are checked against the store rather than the
+-- replica that issued them: a pending record ingested longer ago than the
+-- maximum merge delay breaches its promise, and promise_breached records
+-- that the breach was reported, so every replica reports it only once.
+ALTER TABLE evidence ADD COLUMN promise_breached BOOLEAN NOT NULL DEFAULT false;
+
+CREATE INDEX evidence_promise_due_idx ON evidence (tenant_id, ingested_at, id)
+    WHERE leaf_index IS NULL AND NOT promise_breached;
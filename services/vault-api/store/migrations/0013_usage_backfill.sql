@@ -0,0 +1,29 @@
+-- 0013_usage_backfill.sql
+-- 0006_usage ran its backfill under the forced row-level security of
+-- 0005_tenants without vault.tenant set, so unless a superuser migrated
+-- it saw no evidence and counted none of the records ingested before it.
+-- Usage is only ever counted as records are inserted, so for each tenant
+-- and day the records the ledger lacks are exactly those; they are added
+-- as 0006 intended, under the empty subject with unknown (zero) size.
+-- Where 0006 did count them nothing changes.
+--
+-- Migrations run as the tables' owner, whom row-level security exempts
+-- unless forced; FORCE is lifted for this transaction only.
+ALTER TABLE evidence NO FORCE ROW LEVEL SECURITY;
+ALTER TABLE evidence_usage NO FORCE ROW LEVEL SECURITY;
+
+INSERT INTO evidence_usage AS u (tenant_id, subject, day, evidence, bytes)
+    SELECT e.tenant_id, '', e.day, e.n - coalesce(c.n, 0), 0
+    FROM (
+        SELECT tenant_id, (ingested_at AT TIME ZONE 'UTC')::date AS day, count(*) AS n
+        FROM evidence GROUP BY 1, 2
+    ) e
+    LEFT JOIN (
+        SELECT tenant_id, day, sum(evidence) AS n
+        FROM evidence_usage GROUP BY 1, 2
+    ) c USING (tenant_id, day)
+    WHERE e.n > coalesce(c.n, 0)
+ON CONFLICT (tenant_id, subject, day) DO UPDATE SET evidence = u.evidence + EXCLUDED.evidence;
+
+ALTER TABLE evidence FORCE ROW LEVEL SECURITY;
+ALTER TABLE evidence_usage FORCE ROW LEVEL SECURITY;
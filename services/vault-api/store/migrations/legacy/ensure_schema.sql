@@ -0,0 +1,65 @@
+-- ensure_schema.sql
+-- Converts a database created by the startup ensureSchema block of releases
+-- before the migration runner into the schema of 0001_initial, after which
+-- the runner records 0001 as applied and continues with 0002. The block
+-- grew over those releases, so columns it may have added are added only if
+-- missing. content_hash is left without the UNIQUE constraint of 0001:
+-- those releases could store repeated content, and 0003_reconcile drops
+-- the constraint anyway.
+ALTER TABLE evidence ADD COLUMN IF NOT EXISTS content_type TEXT;
+ALTER TABLE evidence ADD COLUMN IF NOT EXISTS content_hash TEXT;
+ALTER TABLE evidence ADD COLUMN IF NOT EXISTS payload_ref TEXT;
+ALTER TABLE evidence ADD COLUMN IF NOT EXISTS labels JSONB;
+ALTER TABLE evidence ADD COLUMN IF NOT EXISTS ingested_by TEXT;
+ALTER TABLE evidence ALTER COLUMN id TYPE TEXT;
+ALTER TABLE evidence RENAME COLUMN created_at TO ingested_at;
+UPDATE evidence SET ingested_at = now() WHERE ingested_at IS NULL;
+ALTER TABLE evidence ALTER COLUMN ingested_at SET NOT NULL;
+UPDATE evidence SET content_type = '' WHERE content_type IS NULL;
+ALTER TABLE evidence ALTER COLUMN content_type SET NOT NULL;
+UPDATE evidence SET content_hash = '' WHERE content_hash IS NULL;
+ALTER TABLE evidence ALTER COLUMN content_hash SET NOT NULL;
+
+ALTER TABLE audit RENAME TO audit_log;
+ALTER TABLE audit_log ALTER COLUMN id TYPE TEXT;
+ALTER TABLE audit_log ALTER COLUMN resource_id TYPE TEXT;
+ALTER TABLE audit_log RENAME COLUMN timestamp TO created_at;
+ALTER TABLE audit_log ADD COLUMN IF NOT EXISTS action TEXT;
+ALTER TABLE audit_log ADD COLUMN metadata JSONB;
+UPDATE audit_log SET action = '' WHERE action IS NULL;
+UPDATE audit_log SET actor = '' WHERE actor IS NULL;
+UPDATE audit_log SET created_at = now() WHERE created_at IS NULL;
+ALTER TABLE audit_log ALTER COLUMN action SET NOT NULL;
+ALTER TABLE audit_log ALTER COLUMN actor SET NOT NULL;
+ALTER TABLE audit_log ALTER COLUMN created_at SET DEFAULT now(), ALTER COLUMN created_at SET NOT NULL;
+
+CREATE TABLE tree_leaves (
+  leaf_index BIGINT PRIMARY KEY,
+  leaf_hash TEXT NOT NULL,
+  inserted_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE TABLE signed_tree_heads (
+  id BIGSERIAL PRIMARY KEY,
+  tree_size BIGINT NOT NULL,
+  root_hash TEXT NOT NULL,
+  key_id TEXT NOT NULL,
+  signature TEXT NOT NULL,
+  published_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT now()
+);
+
+CREATE OR REPLACE FUNCTION deny_update_delete() RETURNS trigger AS $$
+BEGIN
+  IF TG_OP = 'UPDATE' OR TG_OP = 'DELETE' THEN
+    RAISE EXCEPTION 'modification not allowed on append-only table';
+  END IF;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER evidence_no_update BEFORE UPDATE OR DELETE ON evidence
+  FOR EACH ROW EXECUTE FUNCTION deny_update_delete();
+CREATE TRIGGER tree_leaves_no_update BEFORE UPDATE OR DELETE ON tree_leaves
+  FOR EACH ROW EXECUTE FUNCTION deny_update_delete();
+CREATE TRIGGER audit_log_no_update BEFORE UPDATE OR DELETE ON audit_log
+  FOR EACH ROW EXECUTE FUNCTION deny_update_delete();
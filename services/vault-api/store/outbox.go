@@ -0,0 +1,425 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+// OutboxMessage is a pipeline message written in the same transaction as
+// the evidence it announces, so a crash can delay it but never lose it.
+type OutboxMessage struct {
+	ID    int64
+	Topic string
+	Key   string
+	// EvidenceID names the record of the saving tenant the message
+	// announces.
+	EvidenceID string
+	Payload    []byte
+	CreatedAt  time.Time
+	// Attempts counts failed publishes; LastError describes the latest.
+	Attempts  int
+	LastError string
+}
+
+// OutboxStore is the relay's view of the outbox.
+type OutboxStore interface {
+	// ClaimOutbox leases up to limit undelivered messages that are due, in
+	// ID order. A message is not returned while an earlier message with the
+	// same key is undelivered and not claimable, which keeps per-key order
+	// across retries. Leases that expire make messages claimable again.
+	ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]OutboxMessage, error)
+	MarkOutboxDelivered(ctx context.Context, ids []int64) error
+	// MarkOutboxFailed records a failed publish and defers the message
+	// until retryAt.
+	MarkOutboxFailed(ctx context.Context, id int64, reason string, retryAt time.Time) error
+	// ReleaseOutbox drops the lease on messages that were claimed but not
+	// attempted.
+	ReleaseOutbox(ctx context.Context, ids []int64) error
+}
+
+// memOutbox carries the delivery state the interface does not expose.
+type memOutbox struct {
+	OutboxMessage
+	// owner is the tenant that saved the message.
+	owner        *memStore
+	nextAttempt  time.Time
+	claimedUntil time.Time
+	delivered    bool
+}
+
+func (m *memStore) appendOutboxLocked(msgs []OutboxMessage) {
+	now := time.Now().UTC()
+	for _, msg := range msgs {
+		msg.ID = int64(len(m.shared.outbox) + 1)
+		msg.CreatedAt = now
+		m.shared.outbox = append(m.shared.outbox, &memOutbox{OutboxMessage: msg, owner: m, nextAttempt: now})
+	}
+}
+
+func (m *memStore) StrandedEvidence(ctx context.Context, before time.Time, limit int) ([]Evidence, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	announced := map[string]bool{}
+	for _, msg := range m.shared.outbox {
+		if !msg.delivered && msg.owner == m {
+			announced[msg.EvidenceID] = true
+		}
+	}
+	var res []*Evidence
+	for _, e := range m.ev {
+		if e.LeafIndex == nil && e.IngestedAt.Before(before) && !announced[e.ID] {
+			res = append(res, e)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return evidenceBefore(res[i], res[j]) })
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	out := make([]Evidence, 0, len(res))
+	for _, e := range res {
+		out = append(out, *e)
+	}
+	return out, nil
+}
+
+func (m *memStore) ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]OutboxMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	blocked := map[string]bool{}
+	var out []OutboxMessage
+	for _, msg := range m.shared.outbox {
+		if msg.delivered {
+			continue
+		}
+		claimable := msg.claimedUntil.Before(now) && !msg.nextAttempt.After(now)
+		if !claimable || blocked[msg.Key] {
+			blocked[msg.Key] = true
+			continue
+		}
+		if limit > 0 && len(out) >= limit {
+			break
+		}
+		msg.claimedUntil = now.Add(lease)
+		out = append(out, msg.OutboxMessage)
+	}
+	return out, nil
+}
+
+func (m *memStore) outboxLocked(id int64) *memOutbox {
+	if id <= 0 || id > int64(len(m.shared.outbox)) {
+		return nil
+	}
+	return m.shared.outbox[id-1]
+}
+
+func (m *memStore) MarkOutboxDelivered(ctx context.Context, ids []int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range ids {
+		if msg := m.outboxLocked(id); msg != nil {
+			msg.delivered = true
+		}
+	}
+	return nil
+}
+
+func (m *memStore) MarkOutboxFailed(ctx context.Context, id int64, reason string, retryAt time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if msg := m.outboxLocked(id); msg != nil {
+		msg.Attempts++
+		msg.LastError = reason
+		msg.nextAttempt = retryAt
+		msg.claimedUntil = time.Time{}
+	}
+	return nil
+}
+
+func (m *memStore) ReleaseOutbox(ctx context.Context, ids []int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range ids {
+		if msg := m.outboxLocked(id); msg != nil {
+			msg.claimedUntil = time.Time{}
+		}
+	}
+	return nil
+}
+
+// insertOutbox queues msgs for the transaction's tenant.
+func insertOutbox(ctx context.Context, tx pgx.Tx, msgs []OutboxMessage) error {
+	for _, msg := range msgs {
+		if _, err := tx.Exec(ctx, `INSERT INTO outbox (topic, key, payload, tenant_id, evidence_id) VALUES ($1,$2,$3,current_setting('vault.tenant'),nullif($4,''))`, msg.Topic, msg.Key, msg.Payload, msg.EvidenceID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *pgStore) StrandedEvidence(ctx context.Context, before time.Time, limit int) ([]Evidence, error) {
+	var n *int
+	if limit > 0 {
+		n = &limit
+	}
+	var res []Evidence
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+    SELECT `+evidenceColumns+` FROM evidence e
+    WHERE e.leaf_index IS NULL AND e.ingested_at < $1
+      AND NOT EXISTS (
+          SELECT 1 FROM outbox o
+          WHERE o.delivered_at IS NULL AND o.tenant_id = current_setting('vault.tenant') AND o.evidence_id = e.id
+      )
+    ORDER BY e.ingested_at, e.id
+    LIMIT $2`, before, n)
+		if err != nil {
+			return err
+		}
+		res, err = scanEvidenceRows(rows)
+		return err
+	})
+	return res, err
+}
+
+func (p *pgStore) ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]OutboxMessage, error) {
+	rows, err := p.pool.Query(ctx, `
+    WITH claim AS (
+        SELECT o.id FROM outbox o
+        WHERE o.delivered_at IS NULL
+          AND (o.claimed_until IS NULL OR o.claimed_until < now()) AND o.next_attempt_at <= now()
+          AND NOT EXISTS (
+              SELECT 1 FROM outbox e
+              WHERE e.key = o.key AND e.id < o.id AND e.delivered_at IS NULL
+                AND NOT ((e.claimed_until IS NULL OR e.claimed_until < now()) AND e.next_attempt_at <= now())
+          )
+        ORDER BY o.id
+        LIMIT $1
+        FOR UPDATE SKIP LOCKED
+    )
+    UPDATE outbox SET claimed_until = now() + $2 * interval '1 millisecond'
+    FROM claim WHERE outbox.id = claim.id
+    RETURNING outbox.id, outbox.topic, outbox.key, coalesce(outbox.evidence_id, ''), outbox.payload, outbox.created_at, outbox.attempts, coalesce(outbox.last_error, '')`,
+		limit, lease.Milliseconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var out []OutboxMessage
+	for rows.Next() {
+		var msg OutboxMessage
+		if err := rows.Scan(&msg.ID, &msg.Topic, &msg.Key, &msg.EvidenceID, &msg.Payload, &msg.CreatedAt, &msg.Attempts, &msg.LastError); err != nil {
+			return nil, err
+		}
+		out = append(out, msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// UPDATE ... RETURNING does not preserve the CTE order
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out, nil
+}
+
+func (p *pgStore) MarkOutboxDelivered(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := p.pool.Exec(ctx, `UPDATE outbox SET delivered_at = now(), claimed_until = NULL WHERE id = ANY($1)`, ids)
+	return err
+}
+
+func (p *pgStore) MarkOutboxFailed(ctx context.Context, id int64, reason string, retryAt time.Time) error {
+	_, err := p.pool.Exec(ctx, `UPDATE outbox SET attempts = attempts + 1, last_error = $2, next_attempt_at = $3, claimed_until = NULL WHERE id = $1`, id, reason, retryAt)
+	return err
+}
+
+func (p *pgStore) ReleaseOutbox(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	_, err := p.pool.Exec(ctx, `UPDATE outbox SET claimed_until = NULL WHERE id = ANY($1)`, ids)
+	return err
+}
+
+// boltOutbox is the stored form of an outbox message. Delivered messages
+// are deleted rather than flagged.
+type boltOutbox struct {
+	OutboxMessage
+	// Tenant saved the message; empty is the default tenant.
+	Tenant       string `json:",omitempty"`
+	NextAttempt  time.Time
+	ClaimedUntil time.Time
+}
+
+// appendOutbox queues msgs for tenant.
+func appendOutbox(tx *bolt.Tx, tenant string, msgs []OutboxMessage) error {
+	if tenant == DefaultTenant {
+		tenant = ""
+	}
+	bucket := tx.Bucket(bucketOutbox)
+	now := time.Now().UTC()
+	for _, msg := range msgs {
+		id, err := bucket.NextSequence()
+		if err != nil {
+			return err
+		}
+		msg.ID = int64(id)
+		msg.CreatedAt = now
+		if err := putOutbox(bucket, &boltOutbox{OutboxMessage: msg, Tenant: tenant, NextAttempt: now}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func putOutbox(bucket *bolt.Bucket, msg *boltOutbox) error {
+	v, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(u64(uint64(msg.ID)), v)
+}
+
+// updateOutbox applies fn to each listed message that still exists.
+func (b *boltStore) updateOutbox(ids []int64, fn func(*boltOutbox)) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketOutbox)
+		for _, id := range ids {
+			v := bucket.Get(u64(uint64(id)))
+			if v == nil {
+				continue
+			}
+			var msg boltOutbox
+			if err := json.Unmarshal(v, &msg); err != nil {
+				return err
+			}
+			fn(&msg)
+			if err := putOutbox(bucket, &msg); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *boltStore) ClaimOutbox(ctx context.Context, limit int, lease time.Duration) ([]OutboxMessage, error) {
+	var out []OutboxMessage
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketOutbox)
+		now := time.Now()
+		blocked := map[string]bool{}
+		var claimed []*boltOutbox
+		c := bucket.Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			var msg boltOutbox
+			if err := json.Unmarshal(v, &msg); err != nil {
+				return err
+			}
+			claimable := msg.ClaimedUntil.Before(now) && !msg.NextAttempt.After(now)
+			if !claimable || blocked[msg.Key] {
+				blocked[msg.Key] = true
+				continue
+			}
+			if limit > 0 && len(claimed) >= limit {
+				break
+			}
+			msg.ClaimedUntil = now.Add(lease)
+			claimed = append(claimed, &msg)
+		}
+		for _, msg := range claimed {
+			if err := putOutbox(bucket, msg); err != nil {
+				return err
+			}
+			out = append(out, msg.OutboxMessage)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (b *boltStore) StrandedEvidence(ctx context.Context, before time.Time, limit int) ([]Evidence, error) {
+	tenant := b.tenant
+	if tenant == DefaultTenant {
+		tenant = ""
+	}
+	var res []Evidence
+	err := b.db.View(func(tx *bolt.Tx) error {
+		// every stored outbox message is undelivered
+		announced := map[string]bool{}
+		err := tx.Bucket(bucketOutbox).ForEach(func(_, v []byte) error {
+			var msg boltOutbox
+			if err := json.Unmarshal(v, &msg); err != nil {
+				return err
+			}
+			if msg.Tenant == tenant {
+				announced[msg.EvidenceID] = true
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		bk := b.tenantBuckets(tx)
+		end := timeKey(before, "")
+		c := bk.Bucket(bucketPending).Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, end) < 0; k, _ = c.Next() {
+			id := string(k[8:])
+			if announced[id] {
+				continue
+			}
+			e, err := getEvidence(bk, id)
+			if err != nil {
+				return err
+			}
+			res = append(res, *e)
+			if limit > 0 && len(res) == limit {
+				break
+			}
+		}
+		return nil
+	})
+	return res, err
+}
+
+func (b *boltStore) MarkOutboxDelivered(ctx context.Context, ids []int64) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(bucketOutbox)
+		for _, id := range ids {
+			if err := bucket.Delete(u64(uint64(id))); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (b *boltStore) MarkOutboxFailed(ctx context.Context, id int64, reason string, retryAt time.Time) error {
+	return b.updateOutbox([]int64{id}, func(msg *boltOutbox) {
+		msg.Attempts++
+		msg.LastError = reason
+		msg.NextAttempt = retryAt
+		msg.ClaimedUntil = time.Time{}
+	})
+}
+
+func (b *boltStore) ReleaseOutbox(ctx context.Context, ids []int64) error {
+	return b.updateOutbox(ids, func(msg *boltOutbox) {
+		msg.ClaimedUntil = time.Time{}
+	})
+}
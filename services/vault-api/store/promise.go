@@ -0,0 +1,131 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+func (m *memStore) ReportOverdueEvidence(ctx context.Context, before time.Time, limit int) ([]Evidence, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var res []*Evidence
+	for _, e := range m.ev {
+		if e.LeafIndex == nil && e.IngestedAt.Before(before) && !m.overdue[e.ID] {
+			res = append(res, e)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool { return evidenceBefore(res[i], res[j]) })
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	out := make([]Evidence, 0, len(res))
+	for _, e := range res {
+		m.overdue[e.ID] = true
+		out = append(out, *e)
+	}
+	return out, nil
+}
+
+func (m *memStore) CountPendingEvidence(ctx context.Context) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var n int64
+	for _, e := range m.ev {
+		if e.LeafIndex == nil {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (p *pgStore) ReportOverdueEvidence(ctx context.Context, before time.Time, limit int) ([]Evidence, error) {
+	var n *int
+	if limit > 0 {
+		n = &limit
+	}
+	var res []Evidence
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		// SKIP LOCKED leaves rows another replica is reporting to it
+		rows, err := tx.Query(ctx, `
+    UPDATE evidence SET promise_breached = true
+    WHERE id IN (
+        SELECT id FROM evidence
+        WHERE leaf_index IS NULL AND NOT promise_breached AND ingested_at < $1
+        ORDER BY ingested_at, id
+        LIMIT $2
+        FOR UPDATE SKIP LOCKED
+    )
+    RETURNING `+evidenceColumns, before, n)
+		if err != nil {
+			return err
+		}
+		res, err = scanEvidenceRows(rows)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(res, func(i, j int) bool { return evidenceBefore(&res[i], &res[j]) })
+	return res, nil
+}
+
+func (p *pgStore) CountPendingEvidence(ctx context.Context) (int64, error) {
+	var n int64
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		return tx.QueryRow(ctx, `SELECT count(*) FROM evidence WHERE leaf_index IS NULL`).Scan(&n)
+	})
+	return n, err
+}
+
+// overdueMark is the evidence_pending value of a record whose promise
+// breach was reported.
+var overdueMark = []byte{1}
+
+func (b *boltStore) ReportOverdueEvidence(ctx context.Context, before time.Time, limit int) ([]Evidence, error) {
+	var res []Evidence
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bk := b.tenantBuckets(tx)
+		pending := bk.Bucket(bucketPending)
+		end := timeKey(before, "")
+		var keys [][]byte
+		c := pending.Cursor()
+		for k, v := c.First(); k != nil && bytes.Compare(k, end) < 0; k, v = c.Next() {
+			if len(v) > 0 {
+				continue
+			}
+			e, err := getEvidence(bk, string(k[8:]))
+			if err != nil {
+				return err
+			}
+			res = append(res, *e)
+			keys = append(keys, bytes.Clone(k))
+			if limit > 0 && len(res) == limit {
+				break
+			}
+		}
+		for _, k := range keys {
+			if err := pending.Put(k, overdueMark); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+func (b *boltStore) CountPendingEvidence(ctx context.Context) (int64, error) {
+	var n int64
+	err := b.db.View(func(tx *bolt.Tx) error {
+		n = int64(b.tenantBuckets(tx).Bucket(bucketPending).Stats().KeyN)
+		return nil
+	})
+	return n, err
+}
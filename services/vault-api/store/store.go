@@ -3,101 +3,352 @@ package store
 import (
 	"context"
 	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
 type Evidence struct {
-	ID        string
-	LeafIndex *int64
+	ID          string
+	ContentType string
+	ContentHash string
+	PayloadRef  string
+	Labels      map[string]string
+	// Status is the lifecycle state (see domain/evidence.Status).
+	Status string
+	// HeldFrom is the status the record had when put on hold.
+	HeldFrom   string
+	IngestedAt time.Time
+	LeafIndex  *int64
+	// Size is the payload length in bytes and IngestedBy the subject that
+	// ingested the record; both are counted by Usage.
+	Size       int64
+	IngestedBy string
+}
+
+// EvidenceQuery selects evidence by labels and ingestion time. Results are
+// ordered by (IngestedAt, ID); AfterTime/AfterID continue from a cursor.
+type EvidenceQuery struct {
+	Labels         map[string]string
+	IngestedAfter  time.Time
+	IngestedBefore time.Time
+	AfterTime      time.Time
+	AfterID        string
+	Limit          int
 }
 
 type AuditEntry struct {
 	ID         string
+	Action     string
 	ResourceID string
 	Actor      string
 	Timestamp  time.Time
+	// Metadata holds action-specific details, such as the rule behind an
+	// access decision.
+	Metadata map[string]string
 }
 
+// ErrNotFound is returned, possibly wrapped, when a record does not exist.
+var ErrNotFound = errors.New("store: not found")
+
+// ErrLeafTaken is returned by SetLeafIndex when another record already has
+// the leaf index.
+var ErrLeafTaken = errors.New("store: leaf index already assigned")
+
+// Store is implemented by every storage backend. store/storetest holds the
+// conformance suite that pins down the behaviour described here.
 type Store interface {
-	SaveEvidence(ctx context.Context, id string) error
+	// SaveEvidence persists e and, in the same transaction, any outbox
+	// messages announcing it. Nothing is written when e already exists. A
+	// non-nil check runs first in that transaction, serialised with the
+	// checks of every other save into the tenant.
+	SaveEvidence(ctx context.Context, e Evidence, check UsageCheck, outbox ...OutboxMessage) error
+	// AssignNextPendingLeaf gives the earliest pending record by
+	// (IngestedAt, ID) the next leaf index and returns it, or nil when
+	// nothing is pending. Concurrent callers never share an index.
 	AssignNextPendingLeaf(ctx context.Context) (*Evidence, error)
+	// StrandedEvidence returns up to limit pending records ingested before
+	// before that no undelivered outbox message announces, earliest first
+	// by (IngestedAt, ID): their ingest event left the outbox but they were
+	// never sequenced.
+	StrandedEvidence(ctx context.Context, before time.Time, limit int) ([]Evidence, error)
+	// ReportOverdueEvidence returns up to limit pending records ingested
+	// before before, earliest first by (IngestedAt, ID), and marks them
+	// reported in the same transaction: each record is returned once, to
+	// whichever caller asks first.
+	ReportOverdueEvidence(ctx context.Context, before time.Time, limit int) ([]Evidence, error)
+	// CountPendingEvidence returns the number of records without a leaf
+	// index.
+	CountPendingEvidence(ctx context.Context) (int64, error)
+	// AssignLeaves gives the pending records in ids consecutive leaf indices
+	// in the given order within one transaction. Records that already have
+	// a leaf index are returned unchanged. Nothing is assigned when an ID
+	// is unknown.
+	AssignLeaves(ctx context.Context, ids []string) ([]Evidence, error)
+	// SetLeafIndex records a leaf index assigned by the Merkle engine unless
+	// the record already has one, and returns the record as persisted. It
+	// fails with ErrLeafTaken when another record has leaf.
+	SetLeafIndex(ctx context.Context, id string, leaf int64) (*Evidence, error)
 	GetEvidence(ctx context.Context, id string) (*Evidence, error)
+	// FindEvidenceByContentHash returns the earliest evidence with the given
+	// content hash ingested at or after since, or nil when there is none.
+	FindEvidenceByContentHash(ctx context.Context, hash string, since time.Time) (*Evidence, error)
+	// QueryEvidence returns up to q.Limit records matching every label in
+	// q.Labels within the requested ingestion window.
+	QueryEvidence(ctx context.Context, q EvidenceQuery) ([]Evidence, error)
+	// ListLeaves returns up to limit records with a leaf index of at least
+	// from, in leaf order; limit <= 0 returns all.
+	ListLeaves(ctx context.Context, from int64, limit int) ([]Evidence, error)
+	// UpdateEvidenceStatus runs decide on the current record and persists
+	// the Status and HeldFrom it leaves behind, atomically with respect to
+	// other writers. Nothing is written when decide returns an error, which
+	// is passed through.
+	UpdateEvidenceStatus(ctx context.Context, id string, decide func(*Evidence) error) (*Evidence, error)
+	// MarkCheckpointed moves sequenced records with a leaf index below
+	// treeSize to checkpointed and returns their IDs.
+	MarkCheckpointed(ctx context.Context, treeSize int64) ([]string, error)
 	SaveAudit(ctx context.Context, e AuditEntry) error
+	// ListAudits returns up to limit entries, newest first by (Timestamp,
+	// ID); limit <= 0 returns all.
 	ListAudits(ctx context.Context, limit int) ([]AuditEntry, error)
+	// QueryAudits returns the entries matching q, oldest first by
+	// (Timestamp, ID).
+	QueryAudits(ctx context.Context, q AuditQuery) ([]AuditEntry, error)
+	// CountEvidence returns the number of records in the store.
+	CountEvidence(ctx context.Context) (int64, error)
+	// Usage totals the records ingested by subject, or every record when
+	// subject is empty. Usage.DayBytes covers the UTC day containing day.
+	Usage(ctx context.Context, subject string, day time.Time) (Usage, error)
+	// ForTenant returns the view of the store holding tenant's data, or
+	// ErrInvalidTenant. Views share nothing but the outbox: each has its
+	// own evidence, leaf sequence, checkpoints, idempotency keys, upload
+	// sessions, webhooks and audit log, and IDs in one are unknown to the others.
+	ForTenant(tenant string) (Store, error)
+	CheckpointStore
+	IdempotencyStore
+	UploadStore
+	WebhookStore
+	OutboxStore
+	APIKeyStore
 }
 
-var (
-	mu      sync.Mutex
-	current Store
-)
-
-func Current() Store {
-	mu.Lock()
-	defer mu.Unlock()
-	return current
-}
-
+// Init opens the backend selected by the environment: Postgres at
+// DATABASE_URL, bbolt under STORE_DIR, or an in-memory store when neither
+// is set. It returns the DefaultTenant view.
 func Init(ctx context.Context) (Store, error) {
-	mu.Lock()
-	defer mu.Unlock()
-	if current != nil {
-		return current, nil
-	}
 	dbURL := os.Getenv("DATABASE_URL")
+	dir := os.Getenv("STORE_DIR")
+	if dbURL != "" && dir != "" {
+		return nil, errors.New("DATABASE_URL and STORE_DIR are mutually exclusive")
+	}
+	if dir != "" {
+		b, err := OpenBoltStore(filepath.Join(dir, "vault.db"))
+		if err != nil {
+			return nil, err
+		}
+		return b, nil
+	}
 	if dbURL == "" {
 		// fallback to in-memory
-		mem := NewMemoryStore()
-		current = mem
-		return current, nil
+		return NewMemoryStore(), nil
 	}
-	pool, err := pgxpool.New(ctx, dbURL)
-	if err != nil {
-		return nil, err
+	autoMigrate := true
+	if v := os.Getenv("DB_AUTO_MIGRATE"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("DB_AUTO_MIGRATE: %w", err)
+		}
+		autoMigrate = b
 	}
-	pg := &pgStore{pool: pool}
-	if err := pg.ensureSchema(ctx); err != nil {
+	pg, err := OpenPostgresStore(ctx, dbURL, autoMigrate)
+	if err != nil {
 		return nil, err
 	}
-	current = pg
-	return current, nil
+	return pg, nil
 }
 
 // -- memory store (fallback)
+//
+// Each tenant has its own memStore; the views of one store share a lock,
+// the outbox and the tenant registry through memShared.
 type memStore struct {
-	mu     sync.Mutex
-	ev     map[string]*Evidence
-	audits []AuditEntry
-	next   int64
+	mu          *sync.Mutex
+	shared      *memShared
+	ev          map[string]*Evidence
+	audits      []AuditEntry
+	next        int64
+	checkpoints map[int64]Checkpoint
+	idempotency map[string]IdempotencyKey
+	uploads     map[string]UploadSession
+	webhooks    map[string]WebhookSubscription
+	deliveries  map[string]WebhookDelivery
+	// overdue holds the pending records ReportOverdueEvidence returned.
+	overdue map[string]bool
+}
+
+type memShared struct {
+	mu      sync.Mutex
+	outbox  []*memOutbox
+	tenants map[string]*memStore
+	apiKeys map[string]APIKey
 }
 
+// NewMemoryStore returns an empty store's DefaultTenant view.
 func NewMemoryStore() *memStore {
-	return &memStore{ev: map[string]*Evidence{}, audits: []AuditEntry{}, next: 0}
+	shared := &memShared{tenants: map[string]*memStore{}, apiKeys: map[string]APIKey{}}
+	m := newMemTenant(shared)
+	shared.tenants[DefaultTenant] = m
+	return m
+}
+
+func newMemTenant(shared *memShared) *memStore {
+	return &memStore{mu: &shared.mu, shared: shared, ev: map[string]*Evidence{}, audits: []AuditEntry{}, checkpoints: map[int64]Checkpoint{}, idempotency: map[string]IdempotencyKey{}, uploads: map[string]UploadSession{}, webhooks: map[string]WebhookSubscription{}, deliveries: map[string]WebhookDelivery{}, overdue: map[string]bool{}}
+}
+
+func (m *memStore) ForTenant(tenant string) (Store, error) {
+	if err := ValidTenantID(tenant); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	t, ok := m.shared.tenants[tenant]
+	if !ok {
+		t = newMemTenant(m.shared)
+		m.shared.tenants[tenant] = t
+	}
+	return t, nil
 }
 
-func (m *memStore) SaveEvidence(ctx context.Context, id string) error {
+func (m *memStore) CountEvidence(ctx context.Context) (int64, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	m.ev[id] = &Evidence{ID: id, LeafIndex: nil}
+	return int64(len(m.ev)), nil
+}
+
+func (m *memStore) Usage(ctx context.Context, subject string, day time.Time) (Usage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.usageLocked(subject, day), nil
+}
+
+func (m *memStore) usageLocked(subject string, day time.Time) Usage {
+	var u Usage
+	d := usageDay(day)
+	for _, e := range m.ev {
+		if subject == "" || e.IngestedBy == subject {
+			u.add(1, e.Size, usageDay(e.IngestedAt) == d)
+		}
+	}
+	return u
+}
+
+func (m *memStore) SaveEvidence(ctx context.Context, e Evidence, check UsageCheck, outbox ...OutboxMessage) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.saveEvidenceLocked(e, check, outbox)
+}
+
+func (m *memStore) saveEvidenceLocked(e Evidence, check UsageCheck, outbox []OutboxMessage) error {
+	if _, exists := m.ev[e.ID]; exists {
+		return nil
+	}
+	if e.IngestedAt.IsZero() {
+		e.IngestedAt = time.Now().UTC()
+	}
+	if check != nil {
+		if err := check(m.usageLocked("", e.IngestedAt), m.usageLocked(e.IngestedBy, e.IngestedAt)); err != nil {
+			return err
+		}
+	}
+	m.appendOutboxLocked(outbox)
+	if e.Status == "" {
+		e.Status = statusStored
+	}
+	e.LeafIndex = nil
+	if e.Labels != nil {
+		labels := make(map[string]string, len(e.Labels))
+		for k, v := range e.Labels {
+			labels[k] = v
+		}
+		e.Labels = labels
+	}
+	m.ev[e.ID] = &e
 	return nil
 }
 
 func (m *memStore) AssignNextPendingLeaf(ctx context.Context) (*Evidence, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
-	for _, e := range m.ev {
+	var e *Evidence
+	for _, c := range m.ev {
+		if c.LeafIndex == nil && (e == nil || evidenceBefore(c, e)) {
+			e = c
+		}
+	}
+	if e == nil {
+		return nil, nil
+	}
+	idx := m.next
+	m.next++
+	e.LeafIndex = &idx
+	e.Status = sequencedStatus(e.Status)
+	out := *e
+	return &out, nil
+}
+
+func (m *memStore) AssignLeaves(ctx context.Context, ids []string) ([]Evidence, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, id := range ids {
+		if _, ok := m.ev[id]; !ok {
+			return nil, ErrNotFound
+		}
+	}
+	out := make([]Evidence, 0, len(ids))
+	for _, id := range ids {
+		e := m.ev[id]
 		if e.LeafIndex == nil {
 			idx := m.next
 			m.next++
 			e.LeafIndex = &idx
-			return e, nil
+			e.Status = sequencedStatus(e.Status)
 		}
+		out = append(out, *e)
 	}
-	return nil, nil
+	return out, nil
+}
+
+func (m *memStore) SetLeafIndex(ctx context.Context, id string, leaf int64) (*Evidence, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.ev[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if e.LeafIndex == nil {
+		for _, other := range m.ev {
+			if other.LeafIndex != nil && *other.LeafIndex == leaf {
+				return nil, ErrLeafTaken
+			}
+		}
+		idx := leaf
+		e.LeafIndex = &idx
+		e.Status = sequencedStatus(e.Status)
+		if leaf >= m.next {
+			m.next = leaf + 1
+		}
+	}
+	out := *e
+	return &out, nil
 }
 
 func (m *memStore) GetEvidence(ctx context.Context, id string) (*Evidence, error) {
@@ -105,12 +356,153 @@ func (m *memStore) GetEvidence(ctx context.Context, id string) (*Evidence, error
 	defer m.mu.Unlock()
 	e, ok := m.ev[id]
 	if !ok {
-		return nil, errors.New("not found")
+		return nil, ErrNotFound
+	}
+	out := *e
+	return &out, nil
+}
+
+func (m *memStore) FindEvidenceByContentHash(ctx context.Context, hash string, since time.Time) (*Evidence, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var found *Evidence
+	for _, e := range m.ev {
+		if e.ContentHash != hash || e.IngestedAt.Before(since) {
+			continue
+		}
+		if found == nil || evidenceBefore(e, found) {
+			found = e
+		}
+	}
+	if found == nil {
+		return nil, nil
+	}
+	out := *found
+	return &out, nil
+}
+
+func (m *memStore) QueryEvidence(ctx context.Context, q EvidenceQuery) ([]Evidence, error) {
+	m.mu.Lock()
+	var res []Evidence
+	for _, e := range m.ev {
+		if q.Matches(e) {
+			res = append(res, *e)
+		}
+	}
+	m.mu.Unlock()
+	SortEvidence(res)
+	if q.Limit > 0 && len(res) > q.Limit {
+		res = res[:q.Limit]
+	}
+	return res, nil
+}
+
+func (m *memStore) ListLeaves(ctx context.Context, from int64, limit int) ([]Evidence, error) {
+	m.mu.Lock()
+	var res []Evidence
+	for _, e := range m.ev {
+		if e.LeafIndex != nil && *e.LeafIndex >= from {
+			res = append(res, *e)
+		}
+	}
+	m.mu.Unlock()
+	sort.Slice(res, func(i, j int) bool { return *res[i].LeafIndex < *res[j].LeafIndex })
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
+	}
+	return res, nil
+}
+
+func (m *memStore) UpdateEvidenceStatus(ctx context.Context, id string, decide func(*Evidence) error) (*Evidence, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.ev[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	next := *e
+	if err := decide(&next); err != nil {
+		return nil, err
+	}
+	e.Status, e.HeldFrom = next.Status, next.HeldFrom
+	out := *e
+	return &out, nil
+}
+
+func (m *memStore) MarkCheckpointed(ctx context.Context, treeSize int64) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var ids []string
+	for _, e := range m.ev {
+		if e.Status == statusSequenced && e.LeafIndex != nil && *e.LeafIndex < treeSize {
+			e.Status = statusCheckpointed
+			ids = append(ids, e.ID)
+		}
+	}
+	return ids, nil
+}
+
+// Lifecycle values the store moves records through on its own; the full
+// set lives in domain/evidence.
+const (
+	statusReceived     = "received"
+	statusStored       = "stored"
+	statusSequenced    = "sequenced"
+	statusCheckpointed = "checkpointed"
+)
+
+// sequencedStatus is the status after a leaf index is assigned: records
+// still in intake move to sequenced, held or redacted ones keep theirs.
+func sequencedStatus(current string) string {
+	if current == "" || current == statusReceived || current == statusStored {
+		return statusSequenced
+	}
+	return current
+}
+
+// Matches reports whether e satisfies the query filters and lies after the
+// cursor position.
+func (q EvidenceQuery) Matches(e *Evidence) bool {
+	for k, v := range q.Labels {
+		if got, ok := e.Labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	if !q.IngestedAfter.IsZero() && !e.IngestedAt.After(q.IngestedAfter) {
+		return false
+	}
+	if !q.IngestedBefore.IsZero() && !e.IngestedAt.Before(q.IngestedBefore) {
+		return false
+	}
+	if !q.AfterTime.IsZero() || q.AfterID != "" {
+		cursor := Evidence{ID: q.AfterID, IngestedAt: q.AfterTime}
+		if !evidenceBefore(&cursor, e) {
+			return false
+		}
 	}
-	return e, nil
+	return true
+}
+
+// SortEvidence orders records the way QueryEvidence returns them.
+func SortEvidence(res []Evidence) {
+	sort.Slice(res, func(i, j int) bool { return evidenceBefore(&res[i], &res[j]) })
+}
+
+func evidenceBefore(a, b *Evidence) bool {
+	if !a.IngestedAt.Equal(b.IngestedAt) {
+		return a.IngestedAt.Before(b.IngestedAt)
+	}
+	return a.ID < b.ID
 }
 
 func (m *memStore) SaveAudit(ctx context.Context, a AuditEntry) error {
+	if a.Metadata != nil {
+		metadata := make(map[string]string, len(a.Metadata))
+		for k, v := range a.Metadata {
+			metadata[k] = v
+		}
+		a.Metadata = metadata
+	}
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.audits = append(m.audits, a)
@@ -119,109 +511,449 @@ func (m *memStore) SaveAudit(ctx context.Context, a AuditEntry) error {
 
 func (m *memStore) ListAudits(ctx context.Context, limit int) ([]AuditEntry, error) {
 	m.mu.Lock()
-	defer m.mu.Unlock()
-	if limit <= 0 || limit > len(m.audits) {
-		limit = len(m.audits)
-	}
-	res := make([]AuditEntry, 0, limit)
-	for i := 0; i < limit; i++ {
-		res = append(res, m.audits[i])
+	res := append([]AuditEntry{}, m.audits...)
+	m.mu.Unlock()
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].Timestamp.Equal(res[j].Timestamp) {
+			return res[i].Timestamp.After(res[j].Timestamp)
+		}
+		return res[i].ID > res[j].ID
+	})
+	if limit > 0 && len(res) > limit {
+		res = res[:limit]
 	}
 	return res, nil
 }
 
 // -- pg store
+//
+// Tenant isolation is row-level security (see migration 0005): every
+// statement runs in a transaction that sets vault.tenant, and the policies
+// only let it see and write that tenant's rows. Outbox rows are shared.
 type pgStore struct {
-	pool *pgxpool.Pool
-}
-
-func (p *pgStore) ensureSchema(ctx context.Context) error {
-	_, err := p.pool.Exec(ctx, `
-    CREATE TABLE IF NOT EXISTS evidence (
-        id UUID PRIMARY KEY,
-        leaf_index bigint NULL,
-        created_at timestamptz DEFAULT now()
-    );
-    CREATE TABLE IF NOT EXISTS audit (
-        id UUID PRIMARY KEY,
-        resource_id UUID,
-        actor TEXT,
-        timestamp timestamptz
-    );
-    `)
+	pool   *pgxpool.Pool
+	tenant string
+}
+
+// OpenPostgresStore connects to dbURL and applies pending migrations. With
+// autoMigrate false it only verifies them, so a database that is behind is
+// refused instead of upgraded. Drift is always refused.
+func OpenPostgresStore(ctx context.Context, dbURL string, autoMigrate bool) (*pgStore, error) {
+	pool, err := pgxpool.New(ctx, dbURL)
+	if err != nil {
+		return nil, err
+	}
+	migrator := NewMigrator(pool)
+	if autoMigrate {
+		_, err = migrator.Up(ctx)
+	} else {
+		err = migrator.Verify(ctx)
+	}
+	if err != nil {
+		pool.Close()
+		return nil, err
+	}
+	return &pgStore{pool: pool, tenant: DefaultTenant}, nil
+}
+
+// Close closes the pool, and with it every tenant view.
+func (p *pgStore) Close() {
+	p.pool.Close()
+}
+
+func (p *pgStore) ForTenant(tenant string) (Store, error) {
+	if err := ValidTenantID(tenant); err != nil {
+		return nil, err
+	}
+	return &pgStore{pool: p.pool, tenant: tenant}, nil
+}
+
+// begin starts a transaction confined to the store's tenant.
+func (p *pgStore) begin(ctx context.Context) (pgx.Tx, error) {
+	tx, err := p.pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.Exec(ctx, `SELECT set_config('vault.tenant', $1, true)`, p.tenant); err != nil {
+		tx.Rollback(ctx)
+		return nil, err
+	}
+	return tx, nil
+}
+
+// inTx runs fn in a transaction confined to the store's tenant and commits
+// it if fn succeeds.
+func (p *pgStore) inTx(ctx context.Context, fn func(pgx.Tx) error) error {
+	tx, err := p.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// lockSequence serialises the tenant's sequencers until tx ends:
+// max(leaf_index)+1 is only unique under the lock.
+func lockSequence(ctx context.Context, tx pgx.Tx) error {
+	_, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('vault.sequence'), hashtext(current_setting('vault.tenant')))`)
 	return err
 }
 
-func (p *pgStore) SaveEvidence(ctx context.Context, id string) error {
-	_, err := p.pool.Exec(ctx, `INSERT INTO evidence (id) VALUES ($1) ON CONFLICT DO NOTHING`, id)
+// evidenceColumns is the select list scanEvidence reads.
+const evidenceColumns = `id::text, coalesce(content_type, ''), coalesce(content_hash, ''), coalesce(payload_ref, ''), labels, status, held_from, ingested_at, leaf_index, size, ingested_by`
+
+func scanEvidence(row pgx.Row, e *Evidence) error {
+	return row.Scan(&e.ID, &e.ContentType, &e.ContentHash, &e.PayloadRef, &e.Labels, &e.Status, &e.HeldFrom, &e.IngestedAt, &e.LeafIndex, &e.Size, &e.IngestedBy)
+}
+
+// scanEvidenceRows collects the rows of an evidenceColumns query.
+func scanEvidenceRows(rows pgx.Rows) ([]Evidence, error) {
+	defer rows.Close()
+	var res []Evidence
+	for rows.Next() {
+		var e Evidence
+		if err := scanEvidence(rows, &e); err != nil {
+			return nil, err
+		}
+		res = append(res, e)
+	}
+	return res, rows.Err()
+}
+
+// notFound maps pgx's no-rows error to ErrNotFound.
+func notFound(err error) error {
+	if errors.Is(err, pgx.ErrNoRows) {
+		return ErrNotFound
+	}
 	return err
 }
 
+func (p *pgStore) SaveEvidence(ctx context.Context, e Evidence, check UsageCheck, outbox ...OutboxMessage) error {
+	return p.inTx(ctx, func(tx pgx.Tx) error {
+		return insertEvidence(ctx, tx, e, check, outbox)
+	})
+}
+
+// insertEvidence inserts e unless it exists, counting it towards its
+// subject's usage and queueing outbox with it. A non-nil check sees the
+// usage before e under the tenant's usage lock, which is held until tx ends,
+// so concurrent saves cannot both pass it on the same usage.
+func insertEvidence(ctx context.Context, tx pgx.Tx, e Evidence, check UsageCheck, outbox []OutboxMessage) error {
+	if e.IngestedAt.IsZero() {
+		e.IngestedAt = time.Now().UTC()
+	}
+	labels := e.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	if e.Status == "" {
+		e.Status = statusStored
+	}
+	tag, err := tx.Exec(ctx, `INSERT INTO evidence (id, content_type, content_hash, payload_ref, labels, status, ingested_at, size, ingested_by) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9) ON CONFLICT DO NOTHING`, e.ID, e.ContentType, e.ContentHash, e.PayloadRef, labels, e.Status, e.IngestedAt, e.Size, e.IngestedBy)
+	if err != nil || tag.RowsAffected() == 0 {
+		return err
+	}
+	if check != nil {
+		if _, err := tx.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtext('vault.usage'), hashtext(current_setting('vault.tenant')))`); err != nil {
+			return err
+		}
+		tenant, err := readUsage(ctx, tx, "", e.IngestedAt)
+		if err != nil {
+			return err
+		}
+		subject, err := readUsage(ctx, tx, e.IngestedBy, e.IngestedAt)
+		if err != nil {
+			return err
+		}
+		if err := check(tenant, subject); err != nil {
+			return err
+		}
+	}
+	_, err = tx.Exec(ctx, `
+    INSERT INTO evidence_usage (subject, day, evidence, bytes) VALUES ($1, ($2::timestamptz AT TIME ZONE 'UTC')::date, 1, $3)
+    ON CONFLICT (tenant_id, subject, day) DO UPDATE SET evidence = evidence_usage.evidence + 1, bytes = evidence_usage.bytes + EXCLUDED.bytes`, e.IngestedBy, e.IngestedAt, e.Size)
+	if err != nil {
+		return err
+	}
+	return insertOutbox(ctx, tx, outbox)
+}
+
 func (p *pgStore) AssignNextPendingLeaf(ctx context.Context) (*Evidence, error) {
-	tx, err := p.pool.Begin(ctx)
+	var out *Evidence
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		if err := lockSequence(ctx, tx); err != nil {
+			return err
+		}
+		// pick one pending record
+		var id string
+		err := tx.QueryRow(ctx, `SELECT id::text FROM evidence WHERE leaf_index IS NULL ORDER BY ingested_at, id LIMIT 1`).Scan(&id)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var e Evidence
+		err = scanEvidence(tx.QueryRow(ctx, `
+    UPDATE evidence SET leaf_index = (SELECT coalesce(max(leaf_index) + 1, 0) FROM evidence), status=`+sequencedStatusSQL+`
+    WHERE id=$1
+    RETURNING `+evidenceColumns, id), &e)
+		if err != nil {
+			return err
+		}
+		out = &e
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
-	defer tx.Rollback(ctx)
-	// pick one pending record
-	var id string
-	err = tx.QueryRow(ctx, `SELECT id FROM evidence WHERE leaf_index IS NULL ORDER BY created_at FOR UPDATE SKIP LOCKED LIMIT 1`).Scan(&id)
+	return out, nil
+}
+
+// sequencedStatusSQL mirrors sequencedStatus for UPDATE statements.
+const sequencedStatusSQL = `CASE WHEN status IN ('received', 'stored') THEN 'sequenced' ELSE status END`
+
+func (p *pgStore) AssignLeaves(ctx context.Context, ids []string) ([]Evidence, error) {
+	out := make([]Evidence, 0, len(ids))
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		// the batch receives a contiguous range
+		if err := lockSequence(ctx, tx); err != nil {
+			return err
+		}
+		var maxLeaf *int64
+		if err := tx.QueryRow(ctx, `SELECT max(leaf_index) FROM evidence`).Scan(&maxLeaf); err != nil {
+			return err
+		}
+		nextLeaf := int64(0)
+		if maxLeaf != nil {
+			nextLeaf = *maxLeaf + 1
+		}
+		for _, id := range ids {
+			var e Evidence
+			if err := scanEvidence(tx.QueryRow(ctx, `SELECT `+evidenceColumns+` FROM evidence WHERE id=$1`, id), &e); err != nil {
+				return notFound(err)
+			}
+			if e.LeafIndex == nil {
+				li := nextLeaf
+				nextLeaf++
+				err := scanEvidence(tx.QueryRow(ctx, `UPDATE evidence SET leaf_index=$1, status=`+sequencedStatusSQL+` WHERE id=$2 RETURNING `+evidenceColumns, li, id), &e)
+				if err != nil {
+					return err
+				}
+			}
+			out = append(out, e)
+		}
+		return nil
+	})
 	if err != nil {
-		return nil, nil
+		return nil, err
 	}
-	// compute max leaf
-	var maxLeaf *int64
-	var tmp int64
-	err = tx.QueryRow(ctx, `SELECT max(leaf_index) FROM evidence`).Scan(&maxLeaf)
+	return out, nil
+}
+
+func (p *pgStore) SetLeafIndex(ctx context.Context, id string, leaf int64) (*Evidence, error) {
+	var e Evidence
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `UPDATE evidence SET leaf_index=$1, status=`+sequencedStatusSQL+` WHERE id=$2 AND leaf_index IS NULL`, leaf, id)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.ConstraintName == "evidence_tenant_leaf_index_key" {
+			return ErrLeafTaken
+		}
+		if err != nil {
+			return err
+		}
+		return notFound(scanEvidence(tx.QueryRow(ctx, `SELECT `+evidenceColumns+` FROM evidence WHERE id=$1`, id), &e))
+	})
 	if err != nil {
 		return nil, err
 	}
-	if maxLeaf == nil {
-		tmp = 0
-	} else {
-		tmp = *maxLeaf + 1
+	return &e, nil
+}
+
+func (p *pgStore) GetEvidence(ctx context.Context, id string) (*Evidence, error) {
+	var e Evidence
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		return notFound(scanEvidence(tx.QueryRow(ctx, `SELECT `+evidenceColumns+` FROM evidence WHERE id=$1`, id), &e))
+	})
+	if err != nil {
+		return nil, err
 	}
-	_, err = tx.Exec(ctx, `UPDATE evidence SET leaf_index=$1 WHERE id=$2`, tmp, id)
+	return &e, nil
+}
+
+func (p *pgStore) FindEvidenceByContentHash(ctx context.Context, hash string, since time.Time) (*Evidence, error) {
+	var out *Evidence
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		var e Evidence
+		err := scanEvidence(tx.QueryRow(ctx, `SELECT `+evidenceColumns+` FROM evidence WHERE content_hash=$1 AND ingested_at >= $2 ORDER BY ingested_at, id LIMIT 1`, hash, since), &e)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil
+		}
+		out = &e
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	if err := tx.Commit(ctx); err != nil {
+	return out, nil
+}
+
+func (p *pgStore) QueryEvidence(ctx context.Context, q EvidenceQuery) ([]Evidence, error) {
+	labels := q.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	limit := q.Limit
+	if limit <= 0 {
+		limit = 1000
+	}
+	var after, before, cursorAt *time.Time
+	if !q.IngestedAfter.IsZero() {
+		after = &q.IngestedAfter
+	}
+	if !q.IngestedBefore.IsZero() {
+		before = &q.IngestedBefore
+	}
+	if !q.AfterTime.IsZero() || q.AfterID != "" {
+		cursorAt = &q.AfterTime
+	}
+	var res []Evidence
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+    SELECT `+evidenceColumns+`
+    FROM evidence
+    WHERE labels @> $1
+      AND ($2::timestamptz IS NULL OR ingested_at > $2)
+      AND ($3::timestamptz IS NULL OR ingested_at < $3)
+      AND ($4::timestamptz IS NULL OR (ingested_at, id::text) > ($4, $5))
+    ORDER BY ingested_at, id
+    LIMIT $6`, labels, after, before, cursorAt, q.AfterID, limit)
+		if err != nil {
+			return err
+		}
+		res, err = scanEvidenceRows(rows)
+		return err
+	})
+	return res, err
+}
+
+func (p *pgStore) ListLeaves(ctx context.Context, from int64, limit int) ([]Evidence, error) {
+	var n *int
+	if limit > 0 {
+		n = &limit
+	}
+	var res []Evidence
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `SELECT `+evidenceColumns+` FROM evidence WHERE leaf_index >= $1 ORDER BY leaf_index LIMIT $2`, from, n)
+		if err != nil {
+			return err
+		}
+		res, err = scanEvidenceRows(rows)
+		return err
+	})
+	return res, err
+}
+
+func (p *pgStore) UpdateEvidenceStatus(ctx context.Context, id string, decide func(*Evidence) error) (*Evidence, error) {
+	var e Evidence
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		if err := scanEvidence(tx.QueryRow(ctx, `SELECT `+evidenceColumns+` FROM evidence WHERE id=$1 FOR UPDATE`, id), &e); err != nil {
+			return notFound(err)
+		}
+		if err := decide(&e); err != nil {
+			return err
+		}
+		_, err := tx.Exec(ctx, `UPDATE evidence SET status=$1, held_from=$2 WHERE id=$3`, e.Status, e.HeldFrom, id)
+		return err
+	})
+	if err != nil {
 		return nil, err
 	}
-	li := tmp
-	return &Evidence{ID: id, LeafIndex: &li}, nil
+	return &e, nil
 }
 
-func (p *pgStore) GetEvidence(ctx context.Context, id string) (*Evidence, error) {
-	var li *int64
-	err := p.pool.QueryRow(ctx, `SELECT leaf_index FROM evidence WHERE id=$1`, id).Scan(&li)
+func (p *pgStore) MarkCheckpointed(ctx context.Context, treeSize int64) ([]string, error) {
+	var ids []string
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `UPDATE evidence SET status='checkpointed' WHERE status='sequenced' AND leaf_index < $1 RETURNING id::text`, treeSize)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+		return rows.Err()
+	})
 	if err != nil {
 		return nil, err
 	}
-	return &Evidence{ID: id, LeafIndex: li}, nil
+	return ids, nil
+}
+
+func (p *pgStore) CountEvidence(ctx context.Context) (int64, error) {
+	var n int64
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		return tx.QueryRow(ctx, `SELECT count(*) FROM evidence`).Scan(&n)
+	})
+	return n, err
+}
+
+func (p *pgStore) Usage(ctx context.Context, subject string, day time.Time) (Usage, error) {
+	var u Usage
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		u, err = readUsage(ctx, tx, subject, day)
+		return err
+	})
+	return u, err
+}
+
+func readUsage(ctx context.Context, tx pgx.Tx, subject string, day time.Time) (Usage, error) {
+	var u Usage
+	err := tx.QueryRow(ctx, `
+    SELECT coalesce(sum(evidence), 0)::bigint, coalesce(sum(bytes), 0)::bigint, coalesce(sum(bytes) FILTER (WHERE day = ($2::timestamptz AT TIME ZONE 'UTC')::date), 0)::bigint
+    FROM evidence_usage WHERE $1 = '' OR subject = $1`, subject, day).Scan(&u.Evidence, &u.Bytes, &u.DayBytes)
+	return u, err
 }
 
 func (p *pgStore) SaveAudit(ctx context.Context, a AuditEntry) error {
 	if a.ID == "" {
 		a.ID = uuid.NewString()
 	}
-	_, err := p.pool.Exec(ctx, `INSERT INTO audit (id, resource_id, actor, timestamp) VALUES ($1,$2,$3,$4)`, a.ID, a.ResourceID, a.Actor, a.Timestamp)
-	return err
+	return p.inTx(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `INSERT INTO audit_log (id, action, resource_id, actor, created_at, metadata) VALUES ($1,$2,$3,$4,$5,$6)`, a.ID, a.Action, a.ResourceID, a.Actor, a.Timestamp, a.Metadata)
+		return err
+	})
 }
 
 func (p *pgStore) ListAudits(ctx context.Context, limit int) ([]AuditEntry, error) {
-	rows, err := p.pool.Query(ctx, `SELECT id, resource_id, actor, timestamp FROM audit ORDER BY timestamp DESC LIMIT $1`, limit)
-	if err != nil {
-		return nil, err
+	var n *int
+	if limit > 0 {
+		n = &limit
 	}
-	defer rows.Close()
 	var res []AuditEntry
-	for rows.Next() {
-		var a AuditEntry
-		if err := rows.Scan(&a.ID, &a.ResourceID, &a.Actor, &a.Timestamp); err != nil {
-			return nil, err
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `SELECT id, coalesce(action, ''), coalesce(resource_id, ''), coalesce(actor, ''), created_at, metadata FROM audit_log ORDER BY created_at DESC, id DESC LIMIT $1`, n)
+		if err != nil {
+			return err
 		}
-		res = append(res, a)
-	}
-	return res, nil
+		defer rows.Close()
+		for rows.Next() {
+			var a AuditEntry
+			if err := rows.Scan(&a.ID, &a.Action, &a.ResourceID, &a.Actor, &a.Timestamp, &a.Metadata); err != nil {
+				return err
+			}
+			res = append(res, a)
+		}
+		return rows.Err()
+	})
+	return res, err
 }
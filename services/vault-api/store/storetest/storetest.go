@@ -0,0 +1,1309 @@
+// Package storetest is the conformance suite every store.Store backend must
+// pass. A backend's test calls Run with a constructor for empty stores.
+package storetest
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/SaridakisStamatisChristos/vault-api/store"
+)
+
+// Run runs the suite. newStore must return an empty store for each call;
+// the suite does not close it.
+func Run(t *testing.T, newStore func(t *testing.T) store.Store) {
+	tests := []struct {
+		name string
+		fn   func(*testing.T, store.Store)
+	}{
+		{"SaveAndGet", testSaveAndGet},
+		{"SaveIsIdempotent", testSaveIsIdempotent},
+		{"NotFound", testNotFound},
+		{"AssignLeaves", testAssignLeaves},
+		{"AssignNextPendingLeafOrder", testAssignNextPendingLeafOrder},
+		{"AssignNextPendingLeafConcurrent", testAssignNextPendingLeafConcurrent},
+		{"SetLeafIndex", testSetLeafIndex},
+		{"FindEvidenceByContentHash", testFindEvidenceByContentHash},
+		{"QueryEvidence", testQueryEvidence},
+		{"MarkCheckpointed", testMarkCheckpointed},
+		{"UpdateEvidenceStatus", testUpdateEvidenceStatus},
+		{"ListLeaves", testListLeaves},
+		{"ListAudits", testListAudits},
+		{"QueryAudits", testQueryAudits},
+		{"Checkpoints", testCheckpoints},
+		{"IdempotencyKeys", testIdempotencyKeys},
+		{"SaveKeyedEvidence", testSaveKeyedEvidence},
+		{"Outbox", testOutbox},
+		{"StrandedEvidence", testStrandedEvidence},
+		{"ReportOverdueEvidence", testReportOverdueEvidence},
+		{"TenantIsolation", testTenantIsolation},
+		{"Usage", testUsage},
+		{"UsageCheck", testUsageCheck},
+		{"APIKeys", testAPIKeys},
+		{"UploadSessions", testUploadSessions},
+		{"Webhooks", testWebhooks},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) { tt.fn(t, newStore(t)) })
+	}
+}
+
+// base is truncated to microseconds, the precision every backend keeps.
+var base = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+// ids returns n fresh evidence IDs in ascending order.
+func ids(n int) []string {
+	out := make([]string, n)
+	for i := range out {
+		out[i] = uuid.NewString()
+	}
+	sort.Strings(out)
+	return out
+}
+
+func save(t *testing.T, s store.Store, e store.Evidence, outbox ...store.OutboxMessage) {
+	t.Helper()
+	if err := s.SaveEvidence(context.Background(), e, nil, outbox...); err != nil {
+		t.Fatalf("save %s: %v", e.ID, err)
+	}
+}
+
+func get(t *testing.T, s store.Store, id string) *store.Evidence {
+	t.Helper()
+	e, err := s.GetEvidence(context.Background(), id)
+	if err != nil {
+		t.Fatalf("get %s: %v", id, err)
+	}
+	return e
+}
+
+func setStatus(t *testing.T, s store.Store, id, status string) {
+	t.Helper()
+	if _, err := s.UpdateEvidenceStatus(context.Background(), id, func(e *store.Evidence) error {
+		e.Status = status
+		return nil
+	}); err != nil {
+		t.Fatalf("set status of %s: %v", id, err)
+	}
+}
+
+func leaf(e *store.Evidence) int64 {
+	if e == nil || e.LeafIndex == nil {
+		return -1
+	}
+	return *e.LeafIndex
+}
+
+func evidenceIDs(evs []store.Evidence) []string {
+	out := make([]string, len(evs))
+	for i, e := range evs {
+		out[i] = e.ID
+	}
+	return out
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func testSaveAndGet(t *testing.T, s store.Store) {
+	id := ids(1)[0]
+	at := base.Add(1500 * time.Microsecond)
+	save(t, s, store.Evidence{ID: id, ContentType: "application/pdf", ContentHash: "aa", PayloadRef: "blob/aa", Labels: map[string]string{"case": "42"}, IngestedAt: at})
+	e := get(t, s, id)
+	if e.ID != id || e.ContentType != "application/pdf" || e.ContentHash != "aa" || e.PayloadRef != "blob/aa" {
+		t.Fatalf("fields not round-tripped: %+v", e)
+	}
+	if len(e.Labels) != 1 || e.Labels["case"] != "42" {
+		t.Fatalf("labels not round-tripped: %v", e.Labels)
+	}
+	if !e.IngestedAt.Equal(at) {
+		t.Fatalf("ingested_at %v, want %v", e.IngestedAt, at)
+	}
+	if e.Status != "stored" || e.LeafIndex != nil {
+		t.Fatalf("new evidence must be stored without a leaf: %+v", e)
+	}
+
+	other := ids(1)[0]
+	before := time.Now().Add(-time.Second)
+	save(t, s, store.Evidence{ID: other, ContentHash: "bb", Status: "received"})
+	e = get(t, s, other)
+	if e.Status != "received" || e.IngestedAt.Before(before) {
+		t.Fatalf("explicit status must be kept and ingested_at defaulted: %+v", e)
+	}
+}
+
+func testSaveIsIdempotent(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	id := ids(1)[0]
+	msg := store.OutboxMessage{Topic: "vault.ingest", Key: id, Payload: []byte("first")}
+	save(t, s, store.Evidence{ID: id, ContentHash: "aa", IngestedAt: base}, msg)
+	if _, err := s.AssignLeaves(ctx, []string{id}); err != nil {
+		t.Fatal(err)
+	}
+	msg.Payload = []byte("second")
+	save(t, s, store.Evidence{ID: id, ContentHash: "bb", IngestedAt: base.Add(time.Hour)}, msg)
+
+	e := get(t, s, id)
+	if e.ContentHash != "aa" || !e.IngestedAt.Equal(base) || leaf(e) != 0 || e.Status != "sequenced" {
+		t.Fatalf("second save must not overwrite: %+v", e)
+	}
+	msgs, err := s.ClaimOutbox(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || string(msgs[0].Payload) != "first" {
+		t.Fatalf("second save must not write outbox messages: %+v", msgs)
+	}
+}
+
+func testNotFound(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	missing := ids(1)[0]
+	if _, err := s.GetEvidence(ctx, missing); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetEvidence: want ErrNotFound, got %v", err)
+	}
+	if _, err := s.SetLeafIndex(ctx, missing, 0); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("SetLeafIndex: want ErrNotFound, got %v", err)
+	}
+	if _, err := s.UpdateEvidenceStatus(ctx, missing, func(*store.Evidence) error { return nil }); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("UpdateEvidenceStatus: want ErrNotFound, got %v", err)
+	}
+	if _, err := s.GetCheckpoint(ctx, 1); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetCheckpoint: want ErrNotFound, got %v", err)
+	}
+	if _, err := s.GetIdempotencyKey(ctx, "nobody:key"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetIdempotencyKey: want ErrNotFound, got %v", err)
+	}
+	if e, err := s.FindEvidenceByContentHash(ctx, "none", time.Time{}); e != nil || err != nil {
+		t.Fatalf("FindEvidenceByContentHash: want nil, nil, got %+v %v", e, err)
+	}
+
+	known := ids(1)[0]
+	save(t, s, store.Evidence{ID: known, ContentHash: "aa", IngestedAt: base})
+	if _, err := s.AssignLeaves(ctx, []string{known, missing}); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("AssignLeaves: want ErrNotFound, got %v", err)
+	}
+	if e := get(t, s, known); e.LeafIndex != nil {
+		t.Fatalf("a failed AssignLeaves must not assign anything: %+v", e)
+	}
+}
+
+func testAssignLeaves(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	id := ids(3)
+	for i, x := range id {
+		save(t, s, store.Evidence{ID: x, ContentHash: "h", IngestedAt: base.Add(time.Duration(i) * time.Second)})
+	}
+	setStatus(t, s, id[1], "on-hold")
+	// caller order, not ingestion order
+	evs, err := s.AssignLeaves(ctx, []string{id[2], id[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evs) != 2 || evs[0].ID != id[2] || leaf(&evs[0]) != 0 || evs[1].ID != id[0] || leaf(&evs[1]) != 1 {
+		t.Fatalf("unexpected assignment: %+v", evs)
+	}
+	evs, err = s.AssignLeaves(ctx, []string{id[0], id[1], id[2]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if leaf(&evs[0]) != 1 || leaf(&evs[1]) != 2 || leaf(&evs[2]) != 0 {
+		t.Fatalf("assigned records must be returned unchanged: %+v", evs)
+	}
+	if e := get(t, s, id[0]); e.Status != "sequenced" {
+		t.Fatalf("stored record must become sequenced, got %s", e.Status)
+	}
+	if e := get(t, s, id[1]); e.Status != "on-hold" || leaf(e) != 2 {
+		t.Fatalf("held record must keep its status: %+v", e)
+	}
+}
+
+func testAssignNextPendingLeafOrder(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	if e, err := s.AssignNextPendingLeaf(ctx); e != nil || err != nil {
+		t.Fatalf("empty store: want nil, nil, got %+v %v", e, err)
+	}
+	id := ids(3)
+	// id[2] first, then id[0] and id[1] at the same instant
+	save(t, s, store.Evidence{ID: id[1], ContentHash: "b", IngestedAt: base.Add(time.Second)})
+	save(t, s, store.Evidence{ID: id[2], ContentHash: "c", IngestedAt: base})
+	save(t, s, store.Evidence{ID: id[0], ContentHash: "a", IngestedAt: base.Add(time.Second)})
+	var got []string
+	for i := 0; i < 3; i++ {
+		e, err := s.AssignNextPendingLeaf(ctx)
+		if err != nil || e == nil {
+			t.Fatalf("assign %d: %+v %v", i, e, err)
+		}
+		if leaf(e) != int64(i) || e.Status != "sequenced" || e.ContentHash == "" {
+			t.Fatalf("assign %d must return the full sequenced record: %+v", i, e)
+		}
+		got = append(got, e.ID)
+	}
+	if want := []string{id[2], id[0], id[1]}; !equal(got, want) {
+		t.Fatalf("pending records must be assigned by (ingested_at, id): got %v want %v", got, want)
+	}
+	if e, err := s.AssignNextPendingLeaf(ctx); e != nil || err != nil {
+		t.Fatalf("drained store: want nil, nil, got %+v %v", e, err)
+	}
+}
+
+func testAssignNextPendingLeafConcurrent(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	const records, workers = 40, 8
+	for i, x := range ids(records) {
+		save(t, s, store.Evidence{ID: x, ContentHash: "h", IngestedAt: base.Add(time.Duration(i) * time.Millisecond)})
+	}
+	var (
+		mu     sync.Mutex
+		leaves = map[int64]string{}
+		wg     sync.WaitGroup
+		errs   = make(chan error, workers)
+	)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				e, err := s.AssignNextPendingLeaf(ctx)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if e == nil {
+					return
+				}
+				mu.Lock()
+				if other, dup := leaves[leaf(e)]; dup {
+					mu.Unlock()
+					errs <- errors.New("leaf " + e.ID + " duplicates " + other)
+					return
+				}
+				leaves[leaf(e)] = e.ID
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	if len(leaves) != records {
+		t.Fatalf("expected %d leaves, got %d", records, len(leaves))
+	}
+	for i := int64(0); i < records; i++ {
+		if _, ok := leaves[i]; !ok {
+			t.Fatalf("leaf indices must be contiguous; %d missing", i)
+		}
+	}
+}
+
+func testSetLeafIndex(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	id := ids(2)
+	save(t, s, store.Evidence{ID: id[0], ContentHash: "a", IngestedAt: base})
+	save(t, s, store.Evidence{ID: id[1], ContentHash: "b", IngestedAt: base.Add(time.Second)})
+	e, err := s.SetLeafIndex(ctx, id[0], 7)
+	if err != nil || leaf(e) != 7 || e.Status != "sequenced" || e.ContentHash != "a" {
+		t.Fatalf("unexpected record: %+v %v", e, err)
+	}
+	e, err = s.SetLeafIndex(ctx, id[0], 9)
+	if err != nil || leaf(e) != 7 {
+		t.Fatalf("an existing leaf index must win: %+v %v", e, err)
+	}
+	if _, err := s.SetLeafIndex(ctx, id[1], 7); !errors.Is(err, store.ErrLeafTaken) {
+		t.Fatalf("a leaf index names one record: want ErrLeafTaken, got %v", err)
+	}
+	if e, _ := s.GetEvidence(ctx, id[1]); e.LeafIndex != nil {
+		t.Fatalf("a refused leaf index must not be stored: %+v", e)
+	}
+	e, err = s.AssignNextPendingLeaf(ctx)
+	if err != nil || e == nil || e.ID != id[1] || leaf(e) != 8 {
+		t.Fatalf("sequencing must continue after engine-assigned leaves: %+v %v", e, err)
+	}
+}
+
+func testFindEvidenceByContentHash(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	id := ids(3)
+	save(t, s, store.Evidence{ID: id[2], ContentHash: "dup", IngestedAt: base})
+	save(t, s, store.Evidence{ID: id[1], ContentHash: "dup", IngestedAt: base.Add(time.Minute)})
+	save(t, s, store.Evidence{ID: id[0], ContentHash: "dup", IngestedAt: base.Add(time.Minute)})
+	cases := []struct {
+		since time.Time
+		want  string
+	}{
+		{time.Time{}, id[2]},
+		{base, id[2]},
+		{base.Add(time.Second), id[0]},
+		{base.Add(time.Hour), ""},
+	}
+	for _, c := range cases {
+		e, err := s.FindEvidenceByContentHash(ctx, "dup", c.since)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := ""
+		if e != nil {
+			got = e.ID
+		}
+		if got != c.want {
+			t.Fatalf("since %v: got %q want %q", c.since, got, c.want)
+		}
+	}
+}
+
+func testQueryEvidence(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	id := ids(5)
+	at := []time.Time{base, base.Add(time.Second), base.Add(time.Second), base.Add(2 * time.Second), base.Add(3 * time.Second)}
+	for i, x := range id {
+		labels := map[string]string{"case": "42"}
+		if i == 3 {
+			labels = map[string]string{"case": "7"}
+		}
+		if i == 4 {
+			labels["team"] = "red"
+		}
+		// save out of order; results must not depend on it
+		save(t, s, store.Evidence{ID: x, ContentHash: "h", Labels: labels, IngestedAt: at[i]})
+	}
+	q := func(q store.EvidenceQuery) []string {
+		t.Helper()
+		evs, err := s.QueryEvidence(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return evidenceIDs(evs)
+	}
+	case42 := map[string]string{"case": "42"}
+	if got, want := q(store.EvidenceQuery{Labels: case42}), []string{id[0], id[1], id[2], id[4]}; !equal(got, want) {
+		t.Fatalf("label filter: got %v want %v", got, want)
+	}
+	if got, want := q(store.EvidenceQuery{Labels: map[string]string{"case": "42", "team": "red"}}), []string{id[4]}; !equal(got, want) {
+		t.Fatalf("all labels must match: got %v want %v", got, want)
+	}
+	if got, want := q(store.EvidenceQuery{IngestedAfter: base, IngestedBefore: at[3]}), []string{id[1], id[2]}; !equal(got, want) {
+		t.Fatalf("time window is exclusive: got %v want %v", got, want)
+	}
+
+	// page through with the cursor of the last result
+	var pages []string
+	cursor := store.EvidenceQuery{Labels: case42, Limit: 2}
+	for n := 0; n < 5; n++ {
+		evs, err := s.QueryEvidence(ctx, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(evs) == 0 {
+			break
+		}
+		pages = append(pages, evidenceIDs(evs)...)
+		last := evs[len(evs)-1]
+		cursor.AfterTime, cursor.AfterID = last.IngestedAt, last.ID
+	}
+	if want := []string{id[0], id[1], id[2], id[4]}; !equal(pages, want) {
+		t.Fatalf("paging: got %v want %v", pages, want)
+	}
+}
+
+func testMarkCheckpointed(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	id := ids(4)
+	for i, x := range id {
+		save(t, s, store.Evidence{ID: x, ContentHash: "h", IngestedAt: base.Add(time.Duration(i) * time.Second)})
+	}
+	if _, err := s.AssignLeaves(ctx, id[:3]); err != nil {
+		t.Fatal(err)
+	}
+	setStatus(t, s, id[1], "on-hold")
+	got, err := s.MarkCheckpointed(ctx, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(got)
+	if want := []string{id[0], id[2]}; !equal(got, want) {
+		t.Fatalf("got %v want %v", got, want)
+	}
+	if got, _ := s.MarkCheckpointed(ctx, 3); len(got) != 0 {
+		t.Fatalf("records must be checkpointed once, got %v again", got)
+	}
+	for i, want := range []string{"checkpointed", "on-hold", "checkpointed", "stored"} {
+		if e := get(t, s, id[i]); e.Status != want {
+			t.Fatalf("record %d: status %s want %s", i, e.Status, want)
+		}
+	}
+}
+
+func testUpdateEvidenceStatus(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	id := ids(1)[0]
+	save(t, s, store.Evidence{ID: id, ContentHash: "h", Labels: map[string]string{"case": "1"}, IngestedAt: base})
+	if _, err := s.AssignLeaves(ctx, []string{id}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.UpdateEvidenceStatus(ctx, id, func(e *store.Evidence) error {
+		if e.Status != "sequenced" {
+			t.Errorf("decide must see the stored status, got %s", e.Status)
+		}
+		e.HeldFrom, e.Status = e.Status, "on-hold"
+		return nil
+	})
+	if err != nil || got.Status != "on-hold" || got.HeldFrom != "sequenced" {
+		t.Fatalf("update: %+v %v", got, err)
+	}
+	if e := get(t, s, id); e.Status != "on-hold" || e.HeldFrom != "sequenced" || leaf(e) != 0 || e.Labels["case"] != "1" {
+		t.Fatalf("status fields not persisted or other fields changed: %+v", e)
+	}
+	// held records are not checkpointed, and leave the sequenced index
+	if ids, _ := s.MarkCheckpointed(ctx, 1); len(ids) != 0 {
+		t.Fatalf("held record checkpointed: %v", ids)
+	}
+
+	refused := errors.New("refused")
+	if _, err := s.UpdateEvidenceStatus(ctx, id, func(e *store.Evidence) error {
+		e.Status = "redacted"
+		return refused
+	}); !errors.Is(err, refused) {
+		t.Fatalf("decide error must be returned, got %v", err)
+	}
+	if e := get(t, s, id); e.Status != "on-hold" {
+		t.Fatalf("a refused update must not write, got %s", e.Status)
+	}
+}
+
+func testListLeaves(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	id := ids(4)
+	for i, x := range id {
+		save(t, s, store.Evidence{ID: x, ContentHash: "h", IngestedAt: base.Add(time.Duration(i) * time.Second)})
+	}
+	if got, err := s.ListLeaves(ctx, 0, 0); err != nil || len(got) != 0 {
+		t.Fatalf("nothing sequenced yet: %+v %v", got, err)
+	}
+	if _, err := s.AssignLeaves(ctx, []string{id[3], id[1], id[0]}); err != nil {
+		t.Fatal(err)
+	}
+	setStatus(t, s, id[1], "on-hold")
+	all, err := s.ListLeaves(ctx, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := evidenceIDs(all), []string{id[3], id[1], id[0]}; !equal(got, want) {
+		t.Fatalf("leaf order: got %v want %v", got, want)
+	}
+	page, err := s.ListLeaves(ctx, 1, 1)
+	if err != nil || len(page) != 1 || page[0].ID != id[1] || leaf(&page[0]) != 1 {
+		t.Fatalf("from/limit: %+v %v", page, err)
+	}
+}
+
+func testCheckpoints(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	if got, err := s.ListCheckpoints(ctx, 0); err != nil || len(got) != 0 {
+		t.Fatalf("empty store: %+v %v", got, err)
+	}
+	for _, size := range []int64{2, 5, 3} {
+		cp := store.Checkpoint{TreeSize: size, RootHash: "root", Signature: "sig", KeyRef: "k1", CreatedAt: base}
+		if stored, created, err := s.SaveCheckpoint(ctx, cp); err != nil || !created || stored.TreeSize != size {
+			t.Fatalf("save %d: %+v %v %v", size, stored, created, err)
+		}
+	}
+	stored, created, err := s.SaveCheckpoint(ctx, store.Checkpoint{TreeSize: 5, RootHash: "other", Signature: "other", KeyRef: "k2"})
+	if err != nil || created || stored.RootHash != "root" || stored.KeyRef != "k1" {
+		t.Fatalf("a tree size is signed once; got %+v created=%v %v", stored, created, err)
+	}
+	cp, err := s.GetCheckpoint(ctx, 3)
+	if err != nil || cp.RootHash != "root" || cp.Signature != "sig" || cp.KeyRef != "k1" || !cp.CreatedAt.Equal(base) {
+		t.Fatalf("get: %+v %v", cp, err)
+	}
+	all, err := s.ListCheckpoints(ctx, 0)
+	if err != nil || len(all) != 3 || all[0].TreeSize != 5 || all[1].TreeSize != 3 || all[2].TreeSize != 2 {
+		t.Fatalf("checkpoints must be largest first: %+v %v", all, err)
+	}
+	if latest, _ := s.ListCheckpoints(ctx, 1); len(latest) != 1 || latest[0].TreeSize != 5 {
+		t.Fatalf("limit must keep the latest: %+v", latest)
+	}
+}
+
+func testIdempotencyKeys(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	scope := "alice:" + uuid.NewString()
+	first := store.IdempotencyKey{Scope: scope, EvidenceID: "ev-1", ContentHash: "h1", CreatedAt: base}
+	if held, err := s.ClaimIdempotencyKey(ctx, first, base.Add(-time.Hour)); err != nil || held.EvidenceID != "ev-1" {
+		t.Fatalf("first claim: %+v %v", held, err)
+	}
+	second := store.IdempotencyKey{Scope: scope, EvidenceID: "ev-2", ContentHash: "h2", CreatedAt: base.Add(time.Minute)}
+	held, err := s.ClaimIdempotencyKey(ctx, second, base.Add(-time.Hour))
+	if err != nil || held.EvidenceID != "ev-1" || held.ContentHash != "h1" || !held.CreatedAt.Equal(base) {
+		t.Fatalf("a live key must win: %+v %v", held, err)
+	}
+	if got, err := s.GetIdempotencyKey(ctx, scope); err != nil || got.EvidenceID != "ev-1" {
+		t.Fatalf("get: %+v %v", got, err)
+	}
+	// once the first key is outside the window it is replaced
+	if held, err := s.ClaimIdempotencyKey(ctx, second, base.Add(time.Second)); err != nil || held.EvidenceID != "ev-2" {
+		t.Fatalf("expired key must be replaced: %+v %v", held, err)
+	}
+	if got, _ := s.GetIdempotencyKey(ctx, scope); got == nil || got.EvidenceID != "ev-2" {
+		t.Fatalf("replacement not persisted: %+v", got)
+	}
+}
+
+func testSaveKeyedEvidence(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	scope := "alice:" + uuid.NewString()
+	id := ids(3)
+	first := store.IdempotencyKey{Scope: scope, EvidenceID: id[0], ContentHash: "h1", CreatedAt: base}
+	if held, err := s.SaveKeyedEvidence(ctx, first, base.Add(-time.Hour), store.Evidence{ID: id[0], ContentHash: "h1", IngestedAt: base}, nil); err != nil || held.EvidenceID != id[0] {
+		t.Fatalf("first save: %+v %v", held, err)
+	}
+	if _, err := s.GetEvidence(ctx, id[0]); err != nil {
+		t.Fatalf("the claiming record must be stored: %v", err)
+	}
+
+	// a concurrent request with the same key stores nothing
+	second := store.IdempotencyKey{Scope: scope, EvidenceID: id[1], ContentHash: "h1", CreatedAt: base.Add(time.Minute)}
+	held, err := s.SaveKeyedEvidence(ctx, second, base.Add(-time.Hour), store.Evidence{ID: id[1], ContentHash: "h1", IngestedAt: base}, nil, store.OutboxMessage{Topic: "vault.ingest", Key: id[1], Payload: []byte("x")})
+	if err != nil || held.EvidenceID != id[0] {
+		t.Fatalf("a live key must win: %+v %v", held, err)
+	}
+	if _, err := s.GetEvidence(ctx, id[1]); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("the losing record must not be stored: %v", err)
+	}
+	if queued, _ := s.ClaimOutbox(ctx, 10, time.Second); len(queued) != 0 {
+		t.Fatalf("the losing record must not reach the outbox: %+v", queued)
+	}
+
+	// outside the window the key moves to the new record
+	third := store.IdempotencyKey{Scope: scope, EvidenceID: id[2], ContentHash: "h2", CreatedAt: base.Add(time.Hour)}
+	if held, err := s.SaveKeyedEvidence(ctx, third, base.Add(time.Second), store.Evidence{ID: id[2], ContentHash: "h2", IngestedAt: base.Add(time.Hour)}, nil); err != nil || held.EvidenceID != id[2] {
+		t.Fatalf("expired key must be replaced: %+v %v", held, err)
+	}
+	if _, err := s.GetEvidence(ctx, id[2]); err != nil {
+		t.Fatalf("the new record must be stored: %v", err)
+	}
+}
+
+func testListAudits(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	if got, err := s.ListAudits(ctx, 10); err != nil || len(got) != 0 {
+		t.Fatalf("empty store: %+v %v", got, err)
+	}
+	id := ids(4)
+	resource := uuid.NewString()
+	// saved out of timestamp order
+	for _, i := range []int{1, 3, 0, 2} {
+		a := store.AuditEntry{ID: id[i], Action: "ingest", ResourceID: resource, Actor: "alice", Timestamp: base.Add(time.Duration(i) * time.Second)}
+		if i == 3 {
+			a.Metadata = map[string]string{"rule": "own-cases"}
+		}
+		if err := s.SaveAudit(ctx, a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	all, err := s.ListAudits(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, a := range all {
+		got = append(got, a.ID)
+	}
+	if want := []string{id[3], id[2], id[1], id[0]}; !equal(got, want) {
+		t.Fatalf("audits must be newest first: got %v want %v", got, want)
+	}
+	if a := all[0]; a.Action != "ingest" || a.ResourceID != resource || a.Actor != "alice" || !a.Timestamp.Equal(base.Add(3*time.Second)) || a.Metadata["rule"] != "own-cases" {
+		t.Fatalf("fields not round-tripped: %+v", a)
+	}
+	if got, _ := s.ListAudits(ctx, 2); len(got) != 2 || got[0].ID != id[3] {
+		t.Fatalf("limit must keep the newest: %+v", got)
+	}
+}
+
+func testQueryAudits(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	id := ids(5)
+	// id[1] and id[2] share a timestamp, so pages must break ties by ID
+	at := []time.Duration{0, time.Second, time.Second, 2 * time.Second, 3 * time.Second}
+	for _, i := range []int{3, 1, 4, 0, 2} {
+		if err := s.SaveAudit(ctx, store.AuditEntry{ID: id[i], Action: "ingest", Timestamp: base.Add(at[i])}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	page := func(q store.AuditQuery) []string {
+		t.Helper()
+		got, err := s.QueryAudits(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var res []string
+		for _, a := range got {
+			res = append(res, a.ID)
+		}
+		return res
+	}
+
+	if got := page(store.AuditQuery{}); !equal(got, id) {
+		t.Fatalf("audits must be oldest first: got %v want %v", got, id)
+	}
+	if got, want := page(store.AuditQuery{Since: base.Add(time.Second)}), id[1:]; !equal(got, want) {
+		t.Fatalf("since must keep entries at or after it: got %v want %v", got, want)
+	}
+	var paged []string
+	q := store.AuditQuery{Since: base.Add(time.Second), Limit: 2}
+	for {
+		got, err := s.QueryAudits(ctx, q)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, a := range got {
+			paged = append(paged, a.ID)
+		}
+		if len(got) < q.Limit {
+			break
+		}
+		last := got[len(got)-1]
+		q.AfterTime, q.AfterID = last.Timestamp, last.ID
+	}
+	if want := id[1:]; !equal(paged, want) {
+		t.Fatalf("pages must resume after the cursor: got %v want %v", paged, want)
+	}
+}
+
+func testOutbox(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	id := ids(3)
+	keys := []string{"k1", "k1", "k2"}
+	for i, x := range id {
+		save(t, s, store.Evidence{ID: x, ContentHash: "h", IngestedAt: base}, store.OutboxMessage{Topic: "vault.ingest", Key: keys[i], Payload: []byte(x)})
+	}
+	claim := func() []store.OutboxMessage {
+		t.Helper()
+		msgs, err := s.ClaimOutbox(ctx, 10, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msgs
+	}
+	msgs := claim()
+	if len(msgs) != 3 || string(msgs[0].Payload) != id[0] || string(msgs[1].Payload) != id[1] || string(msgs[2].Payload) != id[2] {
+		t.Fatalf("messages must be claimed in write order: %+v", msgs)
+	}
+	if msgs[0].Topic != "vault.ingest" || msgs[0].Key != "k1" || msgs[0].CreatedAt.IsZero() || msgs[0].ID >= msgs[1].ID {
+		t.Fatalf("fields not round-tripped: %+v", msgs[0])
+	}
+	if again := claim(); len(again) != 0 {
+		t.Fatalf("leased messages must not be claimed twice: %+v", again)
+	}
+
+	// the first k1 message fails; the second must wait for it
+	if err := s.MarkOutboxFailed(ctx, msgs[0].ID, "broker down", time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReleaseOutbox(ctx, []int64{msgs[1].ID, msgs[2].ID}); err != nil {
+		t.Fatal(err)
+	}
+	retry := claim()
+	if len(retry) != 1 || retry[0].ID != msgs[2].ID {
+		t.Fatalf("only the k2 message is due: %+v", retry)
+	}
+	if err := s.MarkOutboxDelivered(ctx, []int64{retry[0].ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.MarkOutboxFailed(ctx, msgs[0].ID, "still down", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	retry = claim()
+	if len(retry) != 2 || retry[0].ID != msgs[0].ID || retry[1].ID != msgs[1].ID {
+		t.Fatalf("k1 messages must be due again in order: %+v", retry)
+	}
+	if retry[0].Attempts != 2 || retry[0].LastError != "still down" {
+		t.Fatalf("failures must be recorded: %+v", retry[0])
+	}
+	if err := s.MarkOutboxDelivered(ctx, []int64{retry[0].ID, retry[1].ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.ReleaseOutbox(ctx, []int64{retry[0].ID}); err != nil {
+		t.Fatal(err)
+	}
+	if left := claim(); len(left) != 0 {
+		t.Fatalf("delivered messages must not be claimed again: %+v", left)
+	}
+}
+
+func testStrandedEvidence(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	acme, err := s.ForTenant("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := ids(5)
+	announce := func(id string) store.OutboxMessage {
+		return store.OutboxMessage{Topic: "vault.ingest", Key: id, EvidenceID: id, Payload: []byte(id)}
+	}
+	// id[0] and id[1] were relayed, id[2] is still in the outbox, id[3]
+	// is sequenced and id[4] is too recent
+	save(t, s, store.Evidence{ID: id[1], ContentHash: "h", IngestedAt: base}, announce(id[1]))
+	save(t, s, store.Evidence{ID: id[0], ContentHash: "h", IngestedAt: base.Add(time.Second)}, announce(id[0]))
+	save(t, s, store.Evidence{ID: id[3], ContentHash: "h", IngestedAt: base}, announce(id[3]))
+	msgs, err := s.ClaimOutbox(ctx, 10, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 3 || msgs[0].EvidenceID != id[1] {
+		t.Fatalf("evidence ID not round-tripped: %+v", msgs)
+	}
+	if err := s.MarkOutboxDelivered(ctx, []int64{msgs[0].ID, msgs[1].ID, msgs[2].ID}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AssignLeaves(ctx, []string{id[3]}); err != nil {
+		t.Fatal(err)
+	}
+	save(t, s, store.Evidence{ID: id[2], ContentHash: "h", IngestedAt: base}, announce(id[2]))
+	save(t, s, store.Evidence{ID: id[4], ContentHash: "h", IngestedAt: base.Add(time.Hour)}, announce(id[4]))
+	// the same ID in another tenant is announced but does not cover it
+	save(t, acme, store.Evidence{ID: id[0], ContentHash: "h", IngestedAt: base}, announce(id[0]))
+
+	stranded := func(s store.Store, limit int) []string {
+		t.Helper()
+		evs, err := s.StrandedEvidence(ctx, base.Add(time.Minute), limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, e := range evs {
+			out = append(out, e.ID)
+		}
+		return out
+	}
+	if got, want := stranded(s, 0), []string{id[1], id[0]}; !equal(got, want) {
+		t.Fatalf("stranded = %v, want %v in ingestion order", got, want)
+	}
+	if got, want := stranded(s, 1), []string{id[1]}; !equal(got, want) {
+		t.Fatalf("limit ignored: %v", got)
+	}
+	if got := stranded(acme, 0); len(got) != 0 {
+		t.Fatalf("acme's announced record reported stranded: %v", got)
+	}
+}
+
+func testReportOverdueEvidence(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	acme, err := s.ForTenant("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := ids(5)
+	// id[3] is sequenced and id[4] is not overdue yet
+	save(t, s, store.Evidence{ID: id[1], ContentHash: "h", IngestedAt: base})
+	save(t, s, store.Evidence{ID: id[0], ContentHash: "h", IngestedAt: base.Add(time.Second)})
+	save(t, s, store.Evidence{ID: id[2], ContentHash: "h", IngestedAt: base.Add(2 * time.Second)})
+	save(t, s, store.Evidence{ID: id[3], ContentHash: "h", IngestedAt: base})
+	save(t, s, store.Evidence{ID: id[4], ContentHash: "h", IngestedAt: base.Add(time.Hour)})
+	save(t, acme, store.Evidence{ID: id[0], ContentHash: "h", IngestedAt: base})
+	if _, err := s.AssignLeaves(ctx, []string{id[3]}); err != nil {
+		t.Fatal(err)
+	}
+	// a status change keeps the report
+	if _, err := s.UpdateEvidenceStatus(ctx, id[1], func(e *store.Evidence) error { e.HeldFrom, e.Status = e.Status, "on-hold"; return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	report := func(s store.Store, limit int) []string {
+		t.Helper()
+		evs, err := s.ReportOverdueEvidence(ctx, base.Add(time.Minute), limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out []string
+		for _, e := range evs {
+			out = append(out, e.ID)
+		}
+		return out
+	}
+	if got, want := report(s, 2), []string{id[1], id[0]}; !equal(got, want) {
+		t.Fatalf("overdue = %v, want %v in ingestion order", got, want)
+	}
+	if _, err := s.UpdateEvidenceStatus(ctx, id[1], func(e *store.Evidence) error { e.Status, e.HeldFrom = e.HeldFrom, ""; return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := report(s, 0), []string{id[2]}; !equal(got, want) {
+		t.Fatalf("overdue = %v, want only the unreported %v", got, want)
+	}
+	if got := report(s, 0); len(got) != 0 {
+		t.Fatalf("records reported twice: %v", got)
+	}
+	if got, want := report(acme, 0), []string{id[0]}; !equal(got, want) {
+		t.Fatalf("acme overdue = %v, want %v", got, want)
+	}
+	if n, err := s.CountPendingEvidence(ctx); err != nil || n != 4 {
+		t.Fatalf("pending = %d, %v; want 4", n, err)
+	}
+}
+
+func testTenantIsolation(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	if _, err := s.ForTenant("Not A Tenant"); !errors.Is(err, store.ErrInvalidTenant) {
+		t.Fatalf("want ErrInvalidTenant, got %v", err)
+	}
+	acme, err := s.ForTenant("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the same ID in two tenants names two records
+	id := ids(2)
+	save(t, s, store.Evidence{ID: id[0], ContentHash: "h-default", IngestedAt: base})
+	save(t, s, store.Evidence{ID: id[1], ContentHash: "h-default", IngestedAt: base.Add(time.Second)})
+	save(t, acme, store.Evidence{ID: id[0], ContentHash: "h-acme", IngestedAt: base}, store.OutboxMessage{Topic: "vault.ingest", Key: id[0], Payload: []byte("acme")})
+	if got := get(t, acme, id[0]); got.ContentHash != "h-acme" {
+		t.Fatalf("acme sees %+v", got)
+	}
+	if got := get(t, s, id[0]); got.ContentHash != "h-default" {
+		t.Fatalf("default tenant sees %+v", got)
+	}
+	if _, err := acme.GetEvidence(ctx, id[1]); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("another tenant's record must be unknown, got %v", err)
+	}
+	if found, err := acme.FindEvidenceByContentHash(ctx, "h-default", time.Time{}); err != nil || found != nil {
+		t.Fatalf("content hashes must not match across tenants: %+v %v", found, err)
+	}
+	if res, err := acme.QueryEvidence(ctx, store.EvidenceQuery{}); err != nil || len(res) != 1 {
+		t.Fatalf("acme query: %+v %v", res, err)
+	}
+	if n, err := s.CountEvidence(ctx); err != nil || n != 2 {
+		t.Fatalf("default count = %d %v", n, err)
+	}
+	if n, err := acme.CountEvidence(ctx); err != nil || n != 1 {
+		t.Fatalf("acme count = %d %v", n, err)
+	}
+
+	// each tenant has its own leaf sequence and checkpoint history
+	for _, v := range []store.Store{s, acme} {
+		e, err := v.AssignNextPendingLeaf(ctx)
+		if err != nil || e == nil || e.ID != id[0] || leaf(e) != 0 {
+			t.Fatalf("first leaf of each tenant is 0: %+v %v", e, err)
+		}
+	}
+	if _, _, err := s.SaveCheckpoint(ctx, store.Checkpoint{TreeSize: 1, RootHash: "r-default", Signature: "sig"}); err != nil {
+		t.Fatal(err)
+	}
+	cp, created, err := acme.SaveCheckpoint(ctx, store.Checkpoint{Origin: "vault.example/acme", TreeSize: 1, RootHash: "r-acme", Signature: "sig"})
+	if err != nil || !created || cp.RootHash != "r-acme" {
+		t.Fatalf("acme checkpoint: %+v %v %v", cp, created, err)
+	}
+	if got, err := acme.GetCheckpoint(ctx, 1); err != nil || got.RootHash != "r-acme" || got.Origin != "vault.example/acme" {
+		t.Fatalf("acme checkpoint not round-tripped: %+v %v", got, err)
+	}
+	if all, _ := s.ListCheckpoints(ctx, 0); len(all) != 1 || all[0].RootHash != "r-default" {
+		t.Fatalf("default checkpoints: %+v", all)
+	}
+
+	scope := "alice\x00key"
+	if _, err := s.ClaimIdempotencyKey(ctx, store.IdempotencyKey{Scope: scope, EvidenceID: id[1], ContentHash: "h-default", CreatedAt: base}, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	if held, err := acme.ClaimIdempotencyKey(ctx, store.IdempotencyKey{Scope: scope, EvidenceID: id[0], ContentHash: "h-acme", CreatedAt: base}, time.Time{}); err != nil || held.EvidenceID != id[0] {
+		t.Fatalf("idempotency keys must not collide across tenants: %+v %v", held, err)
+	}
+
+	if err := s.SaveAudit(ctx, store.AuditEntry{ID: uuid.NewString(), Action: "ingest", ResourceID: id[0], Actor: "alice", Timestamp: base}); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := acme.ListAudits(ctx, 0); err != nil || len(got) != 0 {
+		t.Fatalf("acme sees another tenant's audit trail: %+v %v", got, err)
+	}
+
+	// a second view of a tenant shares its data; the outbox is shared by all
+	again, err := s.ForTenant("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := get(t, again, id[0]); got.ContentHash != "h-acme" {
+		t.Fatalf("second acme view sees %+v", got)
+	}
+	if msgs, err := s.ClaimOutbox(ctx, 10, time.Minute); err != nil || len(msgs) != 1 || string(msgs[0].Payload) != "acme" {
+		t.Fatalf("outbox must be shared: %+v %v", msgs, err)
+	}
+}
+
+func testUsage(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	id := ids(4)
+	day := base.Add(-13 * time.Hour)
+	save(t, s, store.Evidence{ID: id[0], ContentHash: "a", IngestedAt: day, Size: 100, IngestedBy: "alice"})
+	save(t, s, store.Evidence{ID: id[1], ContentHash: "b", IngestedAt: base, Size: 20, IngestedBy: "alice"})
+	save(t, s, store.Evidence{ID: id[2], ContentHash: "c", IngestedAt: base.Add(time.Hour), Size: 3, IngestedBy: "bob"})
+	// saving an existing record again is not counted twice
+	save(t, s, store.Evidence{ID: id[1], ContentHash: "b", IngestedAt: base, Size: 20, IngestedBy: "alice"})
+	if got := get(t, s, id[1]); got.Size != 20 || got.IngestedBy != "alice" {
+		t.Fatalf("size and subject not round-tripped: %+v", got)
+	}
+
+	usage := func(subject string, at time.Time) store.Usage {
+		t.Helper()
+		u, err := s.Usage(ctx, subject, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	if u := usage("", base); u != (store.Usage{Evidence: 3, Bytes: 123, DayBytes: 23}) {
+		t.Fatalf("store usage = %+v", u)
+	}
+	if u := usage("alice", base); u != (store.Usage{Evidence: 2, Bytes: 120, DayBytes: 20}) {
+		t.Fatalf("alice usage = %+v", u)
+	}
+	if u := usage("alice", day); u.DayBytes != 100 {
+		t.Fatalf("alice usage the day before = %+v", u)
+	}
+	if u := usage("carol", base); u != (store.Usage{}) {
+		t.Fatalf("unknown subject usage = %+v", u)
+	}
+
+	acme, err := s.ForTenant("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	save(t, acme, store.Evidence{ID: id[3], ContentHash: "d", IngestedAt: base, Size: 7, IngestedBy: "alice"})
+	if u, err := acme.Usage(ctx, "alice", base); err != nil || u != (store.Usage{Evidence: 1, Bytes: 7, DayBytes: 7}) {
+		t.Fatalf("acme usage = %+v %v", u, err)
+	}
+	if u := usage("alice", base); u.Evidence != 2 {
+		t.Fatalf("usage must not cross tenants: %+v", u)
+	}
+}
+
+func testAPIKeys(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	if _, err := s.GetAPIKey(ctx, "missing"); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetAPIKey: want ErrNotFound, got %v", err)
+	}
+	if _, err := s.RevokeAPIKey(ctx, "missing", base); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("RevokeAPIKey: want ErrNotFound, got %v", err)
+	}
+	acme, err := s.ForTenant("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ci := store.APIKey{ID: "k1", Tenant: "acme", Name: "ci", Hash: "ab", Scopes: []string{"evidence:write", "evidence:read"}, CreatedBy: "alice", CreatedAt: base, ExpiresAt: base.Add(24 * time.Hour)}
+	if err := acme.CreateAPIKey(ctx, ci); err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []store.APIKey{
+		{ID: "k0", Tenant: "acme", Name: "old", Hash: "cd", Scopes: []string{"evidence:read"}, CreatedBy: "alice", CreatedAt: base.Add(-time.Hour)},
+		{ID: "k2", Tenant: store.DefaultTenant, Name: "other", Hash: "ef", Scopes: []string{"audit:read"}, CreatedBy: "bob", CreatedAt: base},
+	} {
+		if err := s.CreateAPIKey(ctx, k); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// keys are shared by every view of the store
+	got, err := s.GetAPIKey(ctx, "k1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Tenant != "acme" || got.Name != "ci" || got.Hash != "ab" || !equal(got.Scopes, ci.Scopes) || got.CreatedBy != "alice" ||
+		!got.CreatedAt.Equal(base) || !got.ExpiresAt.Equal(ci.ExpiresAt) || !got.LastUsedAt.IsZero() || !got.RevokedAt.IsZero() {
+		t.Fatalf("fields not round-tripped: %+v", got)
+	}
+	list, err := s.ListAPIKeys(ctx, "acme")
+	if err != nil || len(list) != 2 || list[0].ID != "k0" || list[1].ID != "k1" {
+		t.Fatalf("acme keys in creation order: %+v %v", list, err)
+	}
+	if list, err := acme.ListAPIKeys(ctx, "globex"); err != nil || len(list) != 0 {
+		t.Fatalf("unknown tenant has no keys: %+v %v", list, err)
+	}
+
+	used := base.Add(time.Minute)
+	if err := s.TouchAPIKey(ctx, "k1", used); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.TouchAPIKey(ctx, "k1", base); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.GetAPIKey(ctx, "k1"); !got.LastUsedAt.Equal(used) {
+		t.Fatalf("last use must not move back: %v", got.LastUsedAt)
+	}
+	if err := s.TouchAPIKey(ctx, "missing", used); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("TouchAPIKey: want ErrNotFound, got %v", err)
+	}
+
+	// a later revocation keeps the earlier one; an earlier one replaces it
+	grace := base.Add(time.Hour)
+	if k, err := s.RevokeAPIKey(ctx, "k1", grace); err != nil || !k.RevokedAt.Equal(grace) {
+		t.Fatalf("revoke: %+v %v", k, err)
+	}
+	if k, err := s.RevokeAPIKey(ctx, "k1", grace.Add(time.Hour)); err != nil || !k.RevokedAt.Equal(grace) {
+		t.Fatalf("later revocation: %+v %v", k, err)
+	}
+	if k, err := s.RevokeAPIKey(ctx, "k1", base); err != nil || !k.RevokedAt.Equal(base) {
+		t.Fatalf("earlier revocation: %+v %v", k, err)
+	}
+	if got, _ := s.GetAPIKey(ctx, "k1"); !got.RevokedAt.Equal(base) || !got.LastUsedAt.Equal(used) {
+		t.Fatalf("revocation not persisted: %+v", got)
+	}
+}
+
+func testUploadSessions(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	id := uuid.NewString()
+	if _, err := s.GetUploadSession(ctx, id); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("GetUploadSession: want ErrNotFound, got %v", err)
+	}
+	sess := store.UploadSession{ID: id, Actor: "alice", ContentType: "application/pcap", Labels: map[string]string{"case": "42"}, Length: 10, HashState: []byte{1, 2}, UpdatedAt: base}
+	if err := s.CreateUploadSession(ctx, sess); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.GetUploadSession(ctx, id)
+	if err != nil || got.Actor != "alice" || got.ContentType != "application/pcap" || got.Labels["case"] != "42" || got.Length != 10 ||
+		got.Offset != 0 || string(got.HashState) != "\x01\x02" || !got.UpdatedAt.Equal(base) || got.Claim != "" {
+		t.Fatalf("fields not round-tripped: %+v %v", got, err)
+	}
+
+	now := base.Add(time.Second)
+	claimed, err := s.ClaimUploadSession(ctx, id, 0, now, now.Add(time.Minute))
+	if err != nil || claimed.Claim == "" {
+		t.Fatalf("claim: %+v %v", claimed, err)
+	}
+	if cur, err := s.ClaimUploadSession(ctx, id, 0, now, now.Add(time.Minute)); !errors.Is(err, store.ErrUploadConflict) || cur.Offset != 0 {
+		t.Fatalf("held session: want ErrUploadConflict, got %+v %v", cur, err)
+	}
+	if err := s.DeleteUploadSession(ctx, id, "", now); !errors.Is(err, store.ErrUploadConflict) {
+		t.Fatalf("delete of held session: want ErrUploadConflict, got %v", err)
+	}
+	if err := s.RenewUploadClaim(ctx, id, "other", now.Add(time.Hour)); !errors.Is(err, store.ErrUploadConflict) {
+		t.Fatalf("renew by another claim: want ErrUploadConflict, got %v", err)
+	}
+	if err := s.RenewUploadClaim(ctx, id, claimed.Claim, now.Add(2*time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	// the renewed claim still holds after the first lease would have ended
+	if _, err := s.ClaimUploadSession(ctx, id, 0, now.Add(90*time.Second), now.Add(time.Hour)); !errors.Is(err, store.ErrUploadConflict) {
+		t.Fatalf("renewed claim lost: %v", err)
+	}
+	saved := now.Add(time.Minute)
+	if err := s.SaveUploadProgress(ctx, id, claimed.Claim, 4, []byte{3}, saved); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SaveUploadProgress(ctx, id, claimed.Claim, 8, []byte{4}, saved); !errors.Is(err, store.ErrUploadConflict) {
+		t.Fatalf("progress after release: want ErrUploadConflict, got %v", err)
+	}
+	got, _ = s.GetUploadSession(ctx, id)
+	if got.Offset != 4 || string(got.HashState) != "\x03" || !got.UpdatedAt.Equal(saved) || got.Claim != "" {
+		t.Fatalf("progress not persisted: %+v", got)
+	}
+	if cur, err := s.ClaimUploadSession(ctx, id, 0, saved, saved.Add(time.Minute)); !errors.Is(err, store.ErrUploadConflict) || cur.Offset != 4 {
+		t.Fatalf("stale offset: want ErrUploadConflict at 4, got %+v %v", cur, err)
+	}
+
+	// a lapsed claim does not block the next append
+	stale, err := s.ClaimUploadSession(ctx, id, 4, saved, saved.Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	later := saved.Add(2 * time.Minute)
+	next, err := s.ClaimUploadSession(ctx, id, 4, later, later.Add(time.Minute))
+	if err != nil || next.Claim == stale.Claim {
+		t.Fatalf("claim after lapse: %+v %v", next, err)
+	}
+
+	if ids, err := s.ExpiredUploadSessions(ctx, saved); err != nil || len(ids) != 0 {
+		t.Fatalf("no session is idle before %v: %v %v", saved, ids, err)
+	}
+	if ids, err := s.ExpiredUploadSessions(ctx, saved.Add(time.Microsecond)); err != nil || len(ids) != 1 || ids[0] != id {
+		t.Fatalf("expired sessions: %v %v", ids, err)
+	}
+	acme, err := s.ForTenant("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acme.GetUploadSession(ctx, id); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("session visible to another tenant: %v", err)
+	}
+	if err := s.DeleteUploadSession(ctx, id, stale.Claim, later); !errors.Is(err, store.ErrUploadConflict) {
+		t.Fatalf("delete under a lost claim: want ErrUploadConflict, got %v", err)
+	}
+	if err := s.DeleteUploadSession(ctx, id, next.Claim, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteUploadSession(ctx, id, "", later); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("second delete: want ErrNotFound, got %v", err)
+	}
+}
+
+func testWebhooks(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	sub := store.WebhookSubscription{ID: uuid.NewString(), URL: "https://example.com/hook", Events: []string{"key.rotated"}, Secret: "s3cret", CreatedBy: "alice", CreatedAt: base}
+	other := store.WebhookSubscription{ID: uuid.NewString(), URL: "https://example.com/other", Events: []string{"key.rotated"}, Secret: "x", CreatedAt: base.Add(time.Second)}
+	for _, w := range []store.WebhookSubscription{other, sub} {
+		if err := s.CreateWebhook(ctx, w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got, err := s.GetWebhook(ctx, sub.ID)
+	if err != nil || got.URL != sub.URL || got.Secret != "s3cret" || got.CreatedBy != "alice" || len(got.Events) != 1 || !got.CreatedAt.Equal(base) {
+		t.Fatalf("subscription not round-tripped: %+v %v", got, err)
+	}
+	subs, err := s.ListWebhooks(ctx)
+	if err != nil || len(subs) != 2 || subs[0].ID != sub.ID || subs[1].ID != other.ID {
+		t.Fatalf("ListWebhooks must order by creation: %+v %v", subs, err)
+	}
+
+	delivery := func(id, subID string, at time.Time) store.WebhookDelivery {
+		return store.WebhookDelivery{ID: id, SubscriptionID: subID, Event: "key.rotated", Payload: []byte(`{"n":1}`), Status: store.WebhookPending, CreatedAt: at, NextAttempt: at}
+	}
+	queued := []store.WebhookDelivery{
+		delivery("d1", sub.ID, base),
+		delivery("d2", sub.ID, base.Add(time.Second)),
+		delivery("d3", other.ID, base.Add(2*time.Second)),
+		delivery("orphan", uuid.NewString(), base),
+	}
+	if err := s.QueueWebhookDeliveries(ctx, queued, 2); err != nil {
+		t.Fatal(err)
+	}
+	if all, err := s.WebhookDeliveries(ctx, "", ""); err != nil || len(all) != 3 || all[0].ID != "d3" || all[2].ID != "d1" {
+		t.Fatalf("deliveries of unknown subscriptions must be skipped, newest first: %+v %v", all, err)
+	}
+
+	now := base.Add(time.Second)
+	claimed, err := s.ClaimWebhookDeliveries(ctx, now, now.Add(time.Minute), 10)
+	if err != nil || len(claimed) != 2 || claimed[0].ID != "d1" || claimed[1].ID != "d2" || string(claimed[0].Payload) != `{"n":1}` {
+		t.Fatalf("claim must return due deliveries in due order: %+v %v", claimed, err)
+	}
+	if again, err := s.ClaimWebhookDeliveries(ctx, now, now.Add(time.Minute), 10); err != nil || len(again) != 0 {
+		t.Fatalf("claimed deliveries must not be claimed twice: %+v %v", again, err)
+	}
+	if next, err := s.NextWebhookAttempt(ctx); err != nil || !next.Equal(base.Add(2*time.Second)) {
+		t.Fatalf("NextWebhookAttempt: %v %v", next, err)
+	}
+
+	fail := store.WebhookAttempt{At: now, StatusCode: 503, Error: "endpoint returned 503", DurationMS: 7}
+	if err := s.FinishWebhookAttempt(ctx, "d1", fail, store.WebhookPending, now.Add(time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.FinishWebhookAttempt(ctx, "d2", fail, store.WebhookDead, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+	hist, err := s.WebhookDeliveries(ctx, sub.ID, "")
+	if err != nil || len(hist) != 2 || hist[1].ID != "d1" || len(hist[1].Attempts) != 1 || hist[1].Attempts[0].StatusCode != 503 ||
+		hist[1].Attempts[0].Error != fail.Error || hist[1].Attempts[0].DurationMS != 7 || !hist[1].NextAttempt.Equal(now.Add(time.Second)) || !hist[1].ClaimedUntil.IsZero() {
+		t.Fatalf("attempt not recorded: %+v %v", hist, err)
+	}
+	// the released d1 is due again after its backoff
+	if again, err := s.ClaimWebhookDeliveries(ctx, now.Add(time.Second), now.Add(time.Minute), 1); err != nil || len(again) != 1 || again[0].ID != "d1" {
+		t.Fatalf("retry not claimable: %+v %v", again, err)
+	}
+	if err := s.FinishWebhookAttempt(ctx, "d1", store.WebhookAttempt{At: now, StatusCode: 204}, store.WebhookDelivered, time.Time{}); err != nil {
+		t.Fatal(err)
+	}
+
+	dead, err := s.WebhookDeliveries(ctx, "", store.WebhookDead)
+	if err != nil || len(dead) != 1 || dead[0].ID != "d2" {
+		t.Fatalf("dead letters: %+v %v", dead, err)
+	}
+	if err := s.RedeliverWebhook(ctx, "d1", now); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("redelivering a delivered webhook: want ErrNotFound, got %v", err)
+	}
+	if err := s.RedeliverWebhook(ctx, "d2", now.Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	hist, _ = s.WebhookDeliveries(ctx, sub.ID, store.WebhookPending)
+	if len(hist) != 1 || hist[0].ID != "d2" || len(hist[0].Attempts) != 0 || !hist[0].NextAttempt.Equal(now.Add(time.Hour)) {
+		t.Fatalf("redelivered webhook not pending afresh: %+v", hist)
+	}
+
+	// the history limit drops finished deliveries only
+	if err := s.QueueWebhookDeliveries(ctx, []store.WebhookDelivery{delivery("d4", sub.ID, base.Add(3*time.Second))}, 2); err != nil {
+		t.Fatal(err)
+	}
+	hist, _ = s.WebhookDeliveries(ctx, sub.ID, "")
+	if len(hist) != 2 || hist[0].ID != "d4" || hist[1].ID != "d2" {
+		t.Fatalf("history not trimmed to the pending deliveries: %+v", hist)
+	}
+
+	acme, err := s.ForTenant("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := acme.GetWebhook(ctx, sub.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("subscription visible to another tenant: %v", err)
+	}
+	if ds, err := acme.WebhookDeliveries(ctx, "", ""); err != nil || len(ds) != 0 {
+		t.Fatalf("deliveries visible to another tenant: %+v %v", ds, err)
+	}
+
+	if err := s.DeleteWebhook(ctx, sub.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteWebhook(ctx, sub.ID); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("second delete: want ErrNotFound, got %v", err)
+	}
+	if all, _ := s.WebhookDeliveries(ctx, "", ""); len(all) != 1 || all[0].ID != "d3" {
+		t.Fatalf("deliveries must go with their subscription: %+v", all)
+	}
+	if err := s.FinishWebhookAttempt(ctx, "d2", fail, store.WebhookDead, time.Time{}); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("finishing a removed delivery: want ErrNotFound, got %v", err)
+	}
+}
+
+func testUsageCheck(t *testing.T, s store.Store) {
+	ctx := context.Background()
+	id := ids(3)
+	save(t, s, store.Evidence{ID: id[0], ContentHash: "a", IngestedAt: base, Size: 10, IngestedBy: "alice"})
+	save(t, s, store.Evidence{ID: id[1], ContentHash: "b", IngestedAt: base, Size: 5, IngestedBy: "bob"})
+
+	var seenTenant, seenSubject store.Usage
+	refuse := errors.New("over quota")
+	check := func(tenant, subject store.Usage) error {
+		seenTenant, seenSubject = tenant, subject
+		return refuse
+	}
+	e := store.Evidence{ID: id[2], ContentHash: "c", IngestedAt: base, Size: 1, IngestedBy: "alice"}
+	if err := s.SaveEvidence(ctx, e, check, store.OutboxMessage{Topic: "vault.ingest", Key: id[2], EvidenceID: id[2], Payload: []byte("x")}); !errors.Is(err, refuse) {
+		t.Fatalf("the check's error must be passed through, got %v", err)
+	}
+	if seenTenant != (store.Usage{Evidence: 2, Bytes: 15, DayBytes: 15}) || seenSubject != (store.Usage{Evidence: 1, Bytes: 10, DayBytes: 10}) {
+		t.Fatalf("check saw tenant %+v, subject %+v", seenTenant, seenSubject)
+	}
+	k := store.IdempotencyKey{Scope: "alice:k", EvidenceID: id[2], ContentHash: "c", CreatedAt: base}
+	if _, err := s.SaveKeyedEvidence(ctx, k, base.Add(-time.Hour), e, check); !errors.Is(err, refuse) {
+		t.Fatalf("keyed save: want the check's error, got %v", err)
+	}
+	if _, err := s.GetEvidence(ctx, id[2]); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("a refused record must not be stored: %v", err)
+	}
+	if _, err := s.GetIdempotencyKey(ctx, k.Scope); !errors.Is(err, store.ErrNotFound) {
+		t.Fatalf("a refused save must not claim its key: %v", err)
+	}
+	if u, err := s.Usage(ctx, "", base); err != nil || u.Evidence != 2 {
+		t.Fatalf("a refused record must not be counted: %+v %v", u, err)
+	}
+	if msgs, err := s.ClaimOutbox(ctx, 10, time.Minute); err != nil || len(msgs) != 0 {
+		t.Fatalf("a refused record must not be announced: %+v %v", msgs, err)
+	}
+
+	if err := s.SaveEvidence(ctx, e, func(store.Usage, store.Usage) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if u, err := s.Usage(ctx, "alice", base); err != nil || u.Evidence != 2 {
+		t.Fatalf("an admitted record must be counted: %+v %v", u, err)
+	}
+}
@@ -0,0 +1,26 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// DefaultTenant owns the data of single-tenant deployments and of stores
+// written before tenants existed. Init and the Open functions return its
+// view.
+const DefaultTenant = "default"
+
+// ErrInvalidTenant is returned for tenant IDs outside ValidTenantID.
+var ErrInvalidTenant = errors.New("store: invalid tenant id")
+
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// ValidTenantID reports whether id may name a tenant: 1 to 63 lowercase
+// letters, digits, '-' or '_', starting with a letter or digit.
+func ValidTenantID(id string) error {
+	if !tenantIDPattern.MatchString(id) {
+		return fmt.Errorf("%w %q", ErrInvalidTenant, id)
+	}
+	return nil
+}
@@ -0,0 +1,382 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+// UploadSession is a resumable upload in progress. Its ID names the blob
+// staged in the payload store, and HashState is the marshalled SHA-256
+// state covering the Offset bytes staged so far, so completion never has to
+// re-read the payload.
+type UploadSession struct {
+	ID          string
+	Actor       string
+	ContentType string
+	Labels      map[string]string
+	// Length is the declared total size, or -1 when not declared.
+	Length    int64
+	Offset    int64
+	HashState []byte
+	UpdatedAt time.Time
+	// Claim identifies the append holding the session until ClaimedUntil;
+	// it is empty when no append does.
+	Claim        string
+	ClaimedUntil time.Time
+}
+
+// heldAt reports whether an unexpired claim holds s at now.
+func (s *UploadSession) heldAt(now time.Time) bool {
+	return s.Claim != "" && now.Before(s.ClaimedUntil)
+}
+
+// ErrUploadConflict is returned when an upload session is at another offset
+// than expected or another append holds it.
+var ErrUploadConflict = errors.New("store: upload session conflict")
+
+// UploadStore keeps resumable upload sessions, so an upload survives
+// restarts and can continue on any replica sharing the payload store. Each
+// session is staged by one append at a time: the append claims it at its
+// offset, renews the claim while streaming and releases it with the new
+// offset and hash state. A crashed append's claim lapses at ClaimedUntil.
+type UploadStore interface {
+	CreateUploadSession(ctx context.Context, s UploadSession) error
+	GetUploadSession(ctx context.Context, id string) (*UploadSession, error)
+	// ClaimUploadSession claims the session until until and returns it with
+	// its new Claim. It fails with ErrUploadConflict, returning the session
+	// as stored, when the session is not at offset or a claim holds it at
+	// now.
+	ClaimUploadSession(ctx context.Context, id string, offset int64, now, until time.Time) (*UploadSession, error)
+	// RenewUploadClaim extends claim until until, or fails with
+	// ErrUploadConflict when the session is no longer held by claim.
+	RenewUploadClaim(ctx context.Context, id, claim string, until time.Time) error
+	// SaveUploadProgress records offset and hashState as of at and releases
+	// claim, or fails with ErrUploadConflict when claim no longer holds the
+	// session.
+	SaveUploadProgress(ctx context.Context, id, claim string, offset int64, hashState []byte, at time.Time) error
+	// DeleteUploadSession removes the session. With an empty claim it fails
+	// with ErrUploadConflict while any claim holds the session at now;
+	// otherwise claim must hold it.
+	DeleteUploadSession(ctx context.Context, id, claim string, now time.Time) error
+	// ExpiredUploadSessions returns the IDs of sessions last updated before
+	// before.
+	ExpiredUploadSessions(ctx context.Context, before time.Time) ([]string, error)
+}
+
+// claim applies ClaimUploadSession's rule to s.
+func (s *UploadSession) claim(offset int64, now, until time.Time) error {
+	if s.Offset != offset || s.heldAt(now) {
+		return ErrUploadConflict
+	}
+	s.Claim, s.ClaimedUntil = uuid.NewString(), until
+	return nil
+}
+
+// checkClaim applies DeleteUploadSession's rule to s; the other claim
+// holders pass a non-empty claim.
+func (s *UploadSession) checkClaim(claim string, now time.Time) error {
+	if claim == "" {
+		if s.heldAt(now) {
+			return ErrUploadConflict
+		}
+		return nil
+	}
+	if s.Claim != claim {
+		return ErrUploadConflict
+	}
+	return nil
+}
+
+// renew applies RenewUploadClaim's rule to s.
+func (s *UploadSession) renew(claim string, until time.Time) error {
+	if claim == "" || s.Claim != claim {
+		return ErrUploadConflict
+	}
+	s.ClaimedUntil = until
+	return nil
+}
+
+// progress applies SaveUploadProgress's rule to s.
+func (s *UploadSession) progress(claim string, offset int64, hashState []byte, at time.Time) error {
+	if claim == "" || s.Claim != claim {
+		return ErrUploadConflict
+	}
+	s.Offset, s.HashState, s.UpdatedAt = offset, hashState, at
+	s.Claim, s.ClaimedUntil = "", time.Time{}
+	return nil
+}
+
+func (m *memStore) CreateUploadSession(ctx context.Context, s UploadSession) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.uploads[s.ID] = s
+	return nil
+}
+
+func (m *memStore) GetUploadSession(ctx context.Context, id string) (*UploadSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.uploads[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &s, nil
+}
+
+// updateUploadSessionLocked applies fn to the stored session id and keeps
+// the result unless fn fails. Callers hold mu.
+func (m *memStore) updateUploadSessionLocked(id string, fn func(*UploadSession) error) (*UploadSession, error) {
+	s, ok := m.uploads[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if err := fn(&s); err != nil {
+		return &s, err
+	}
+	m.uploads[id] = s
+	return &s, nil
+}
+
+func (m *memStore) ClaimUploadSession(ctx context.Context, id string, offset int64, now, until time.Time) (*UploadSession, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.updateUploadSessionLocked(id, func(s *UploadSession) error { return s.claim(offset, now, until) })
+}
+
+func (m *memStore) RenewUploadClaim(ctx context.Context, id, claim string, until time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := m.updateUploadSessionLocked(id, func(s *UploadSession) error { return s.renew(claim, until) })
+	return err
+}
+
+func (m *memStore) SaveUploadProgress(ctx context.Context, id, claim string, offset int64, hashState []byte, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, err := m.updateUploadSessionLocked(id, func(s *UploadSession) error { return s.progress(claim, offset, hashState, at) })
+	return err
+}
+
+func (m *memStore) DeleteUploadSession(ctx context.Context, id, claim string, now time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.uploads[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := s.checkClaim(claim, now); err != nil {
+		return err
+	}
+	delete(m.uploads, id)
+	return nil
+}
+
+func (m *memStore) ExpiredUploadSessions(ctx context.Context, before time.Time) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []string
+	for id, s := range m.uploads {
+		if s.UpdatedAt.Before(before) {
+			out = append(out, id)
+		}
+	}
+	return out, nil
+}
+
+const uploadSessionColumns = `id, actor, content_type, labels, length, upload_offset, hash_state, updated_at, coalesce(claim, ''), claimed_until`
+
+func scanUploadSession(row pgx.Row) (*UploadSession, error) {
+	var s UploadSession
+	var claimedUntil *time.Time
+	if err := row.Scan(&s.ID, &s.Actor, &s.ContentType, &s.Labels, &s.Length, &s.Offset, &s.HashState, &s.UpdatedAt, &s.Claim, &claimedUntil); err != nil {
+		return nil, notFound(err)
+	}
+	s.ClaimedUntil = zeroTime(claimedUntil)
+	return &s, nil
+}
+
+func (p *pgStore) CreateUploadSession(ctx context.Context, s UploadSession) error {
+	labels := s.Labels
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	return p.inTx(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `INSERT INTO upload_sessions (id, actor, content_type, labels, length, upload_offset, hash_state, updated_at) VALUES ($1,$2,$3,$4,$5,$6,$7,$8)`,
+			s.ID, s.Actor, s.ContentType, labels, s.Length, s.Offset, s.HashState, s.UpdatedAt)
+		return err
+	})
+}
+
+func (p *pgStore) GetUploadSession(ctx context.Context, id string) (*UploadSession, error) {
+	var out *UploadSession
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		out, err = scanUploadSession(tx.QueryRow(ctx, `SELECT `+uploadSessionColumns+` FROM upload_sessions WHERE id=$1`, id))
+		return err
+	})
+	return out, err
+}
+
+// updateUploadSession locks the session row id, applies fn and writes back
+// the claim, offset and hash state fn leaves unless fn fails.
+func (p *pgStore) updateUploadSession(ctx context.Context, id string, fn func(*UploadSession) error) (*UploadSession, error) {
+	var out *UploadSession
+	var fnErr error
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		s, err := scanUploadSession(tx.QueryRow(ctx, `SELECT `+uploadSessionColumns+` FROM upload_sessions WHERE id=$1 FOR UPDATE`, id))
+		if err != nil {
+			return err
+		}
+		out = s
+		if fnErr = fn(s); fnErr != nil {
+			return nil
+		}
+		_, err = tx.Exec(ctx, `UPDATE upload_sessions SET upload_offset=$2, hash_state=$3, updated_at=$4, claim=nullif($5, ''), claimed_until=$6 WHERE id=$1`,
+			id, s.Offset, s.HashState, s.UpdatedAt, s.Claim, nullTime(s.ClaimedUntil))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, fnErr
+}
+
+func (p *pgStore) ClaimUploadSession(ctx context.Context, id string, offset int64, now, until time.Time) (*UploadSession, error) {
+	return p.updateUploadSession(ctx, id, func(s *UploadSession) error { return s.claim(offset, now, until) })
+}
+
+func (p *pgStore) RenewUploadClaim(ctx context.Context, id, claim string, until time.Time) error {
+	_, err := p.updateUploadSession(ctx, id, func(s *UploadSession) error { return s.renew(claim, until) })
+	return err
+}
+
+func (p *pgStore) SaveUploadProgress(ctx context.Context, id, claim string, offset int64, hashState []byte, at time.Time) error {
+	_, err := p.updateUploadSession(ctx, id, func(s *UploadSession) error { return s.progress(claim, offset, hashState, at) })
+	return err
+}
+
+func (p *pgStore) DeleteUploadSession(ctx context.Context, id, claim string, now time.Time) error {
+	return p.inTx(ctx, func(tx pgx.Tx) error {
+		s, err := scanUploadSession(tx.QueryRow(ctx, `SELECT `+uploadSessionColumns+` FROM upload_sessions WHERE id=$1 FOR UPDATE`, id))
+		if err != nil {
+			return err
+		}
+		if err := s.checkClaim(claim, now); err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, `DELETE FROM upload_sessions WHERE id=$1`, id)
+		return err
+	})
+}
+
+func (p *pgStore) ExpiredUploadSessions(ctx context.Context, before time.Time) ([]string, error) {
+	var out []string
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `SELECT id FROM upload_sessions WHERE updated_at < $1`, before)
+		if err != nil {
+			return err
+		}
+		out, err = pgx.CollectRows(rows, pgx.RowTo[string])
+		return err
+	})
+	return out, err
+}
+
+func (b *boltStore) CreateUploadSession(ctx context.Context, s UploadSession) error {
+	v, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return b.tenantBuckets(tx).Bucket(bucketUploads).Put([]byte(s.ID), v)
+	})
+}
+
+func (b *boltStore) GetUploadSession(ctx context.Context, id string) (*UploadSession, error) {
+	var s UploadSession
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := b.tenantBuckets(tx).Bucket(bucketUploads).Get([]byte(id))
+		if v == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, &s)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// updateUploadSession applies fn to the stored session id and keeps the
+// result unless fn fails; del removes the session instead.
+func (b *boltStore) updateUploadSession(id string, fn func(*UploadSession) error, del bool) (*UploadSession, error) {
+	var s UploadSession
+	var fnErr error
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := b.tenantBuckets(tx).Bucket(bucketUploads)
+		v := bucket.Get([]byte(id))
+		if v == nil {
+			return ErrNotFound
+		}
+		if err := json.Unmarshal(v, &s); err != nil {
+			return err
+		}
+		if fnErr = fn(&s); fnErr != nil {
+			return nil
+		}
+		if del {
+			return bucket.Delete([]byte(id))
+		}
+		v, err := json.Marshal(s)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), v)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s, fnErr
+}
+
+func (b *boltStore) ClaimUploadSession(ctx context.Context, id string, offset int64, now, until time.Time) (*UploadSession, error) {
+	return b.updateUploadSession(id, func(s *UploadSession) error { return s.claim(offset, now, until) }, false)
+}
+
+func (b *boltStore) RenewUploadClaim(ctx context.Context, id, claim string, until time.Time) error {
+	_, err := b.updateUploadSession(id, func(s *UploadSession) error { return s.renew(claim, until) }, false)
+	return err
+}
+
+func (b *boltStore) SaveUploadProgress(ctx context.Context, id, claim string, offset int64, hashState []byte, at time.Time) error {
+	_, err := b.updateUploadSession(id, func(s *UploadSession) error { return s.progress(claim, offset, hashState, at) }, false)
+	return err
+}
+
+func (b *boltStore) DeleteUploadSession(ctx context.Context, id, claim string, now time.Time) error {
+	_, err := b.updateUploadSession(id, func(s *UploadSession) error { return s.checkClaim(claim, now) }, true)
+	return err
+}
+
+func (b *boltStore) ExpiredUploadSessions(ctx context.Context, before time.Time) ([]string, error) {
+	var out []string
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return b.tenantBuckets(tx).Bucket(bucketUploads).ForEach(func(k, v []byte) error {
+			var s UploadSession
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			if s.UpdatedAt.Before(before) {
+				out = append(out, string(k))
+			}
+			return nil
+		})
+	})
+	return out, err
+}
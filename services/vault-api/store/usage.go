@@ -0,0 +1,33 @@
+package store
+
+import "time"
+
+// Usage totals the evidence ingested into a store.
+type Usage struct {
+	// Evidence and Bytes count the records and their payload bytes.
+	Evidence int64
+	Bytes    int64
+	// DayBytes counts the payload bytes ingested on the requested UTC day.
+	DayBytes int64
+}
+
+// add counts evidence records holding bytes payload bytes; today marks
+// them as ingested on the requested day.
+func (u *Usage) add(evidence, bytes int64, today bool) {
+	u.Evidence += evidence
+	u.Bytes += bytes
+	if today {
+		u.DayBytes += bytes
+	}
+}
+
+// UsageCheck decides, in the transaction that saves a record, whether the
+// usage of the tenant and of the record's subject before it, with DayBytes
+// for the day of its IngestedAt, leave room for it. An error aborts the
+// save and is passed through.
+type UsageCheck func(tenant, subject Usage) error
+
+// usageDay is the key of the UTC day containing t in the usage ledgers.
+func usageDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
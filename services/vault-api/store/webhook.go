@@ -0,0 +1,713 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	bolt "go.etcd.io/bbolt"
+)
+
+// WebhookSubscription is an endpoint registered for webhook events. Secret
+// keys the HMAC signature of every delivery, so it is stored as given.
+type WebhookSubscription struct {
+	ID        string
+	URL       string
+	Events    []string
+	Secret    string
+	CreatedBy string
+	CreatedAt time.Time
+}
+
+// WebhookAttempt records one HTTP try of a delivery.
+type WebhookAttempt struct {
+	At         time.Time
+	StatusCode int
+	Error      string
+	DurationMS int64
+}
+
+// Webhook delivery states.
+const (
+	WebhookPending   = "pending"
+	WebhookDelivered = "delivered"
+	WebhookDead      = "dead"
+)
+
+// WebhookDelivery is one event addressed to one subscription. A pending
+// delivery is due at NextAttempt; ClaimedUntil is set while a dispatcher
+// is sending it.
+type WebhookDelivery struct {
+	ID             string
+	SubscriptionID string
+	Event          string
+	Payload        []byte
+	Status         string
+	CreatedAt      time.Time
+	NextAttempt    time.Time
+	Attempts       []WebhookAttempt
+	ClaimedUntil   time.Time
+}
+
+// WebhookStore keeps a tenant's webhook subscriptions and their deliveries,
+// the dead-lettered ones included, so that any replica can send them and
+// none are lost on restart.
+type WebhookStore interface {
+	CreateWebhook(ctx context.Context, s WebhookSubscription) error
+	GetWebhook(ctx context.Context, id string) (*WebhookSubscription, error)
+	// ListWebhooks returns every subscription ordered by (CreatedAt, ID).
+	ListWebhooks(ctx context.Context) ([]WebhookSubscription, error)
+	// DeleteWebhook removes the subscription and all of its deliveries.
+	DeleteWebhook(ctx context.Context, id string) error
+	// QueueWebhookDeliveries saves ds, skipping deliveries whose
+	// subscription no longer exists. It then drops the finished deliveries
+	// of those subscriptions beyond the newest keep; pending ones stay.
+	QueueWebhookDeliveries(ctx context.Context, ds []WebhookDelivery, keep int) error
+	// WebhookDeliveries returns the deliveries of subscriptionID, or of every
+	// subscription when it is empty, in status, or in any status when it is
+	// empty. They are ordered newest first by (CreatedAt, ID).
+	WebhookDeliveries(ctx context.Context, subscriptionID, status string) ([]WebhookDelivery, error)
+	// ClaimWebhookDeliveries claims up to limit pending deliveries due at
+	// now and not claimed by another dispatcher until until, oldest due
+	// first.
+	ClaimWebhookDeliveries(ctx context.Context, now, until time.Time, limit int) ([]WebhookDelivery, error)
+	// NextWebhookAttempt returns when the earliest pending delivery becomes
+	// claimable, or the zero time when none is pending.
+	NextWebhookAttempt(ctx context.Context) (time.Time, error)
+	// FinishWebhookAttempt appends att to the delivery, moves it to status
+	// with its next attempt due at next and releases its claim.
+	FinishWebhookAttempt(ctx context.Context, id string, att WebhookAttempt, status string, next time.Time) error
+	// RedeliverWebhook returns a dead delivery to pending with no attempts,
+	// due at at. Deliveries that are not dead are ErrNotFound.
+	RedeliverWebhook(ctx context.Context, id string, at time.Time) error
+}
+
+// due reports whether d can be claimed at now.
+func (d *WebhookDelivery) due(now time.Time) bool {
+	return d.Status == WebhookPending && !d.NextAttempt.After(now) && !d.ClaimedUntil.After(now)
+}
+
+// claimableAt is when a pending d can next be claimed.
+func (d *WebhookDelivery) claimableAt() time.Time {
+	if d.ClaimedUntil.After(d.NextAttempt) {
+		return d.ClaimedUntil
+	}
+	return d.NextAttempt
+}
+
+func (d *WebhookDelivery) finish(att WebhookAttempt, status string, next time.Time) {
+	d.Attempts = append(d.Attempts, att)
+	d.Status, d.NextAttempt, d.ClaimedUntil = status, next, time.Time{}
+}
+
+func (d *WebhookDelivery) redeliver(at time.Time) error {
+	if d.Status != WebhookDead {
+		return ErrNotFound
+	}
+	d.Status, d.Attempts, d.NextAttempt, d.ClaimedUntil = WebhookPending, nil, at, time.Time{}
+	return nil
+}
+
+// newestFirst orders deliveries by (CreatedAt, ID), newest first.
+func newestFirst(ds []WebhookDelivery) {
+	sort.Slice(ds, func(i, j int) bool {
+		if !ds[i].CreatedAt.Equal(ds[j].CreatedAt) {
+			return ds[i].CreatedAt.After(ds[j].CreatedAt)
+		}
+		return ds[i].ID > ds[j].ID
+	})
+}
+
+// dueFirst orders claimable deliveries by (NextAttempt, ID).
+func dueFirst(ds []WebhookDelivery) {
+	sort.Slice(ds, func(i, j int) bool {
+		if !ds[i].NextAttempt.Equal(ds[j].NextAttempt) {
+			return ds[i].NextAttempt.Before(ds[j].NextAttempt)
+		}
+		return ds[i].ID < ds[j].ID
+	})
+}
+
+// overHistory returns the IDs of the finished deliveries in ds, one
+// subscription's, beyond the newest keep.
+func overHistory(ds []WebhookDelivery, keep int) []string {
+	newestFirst(ds)
+	var drop []string
+	for i, d := range ds {
+		if i >= keep && d.Status != WebhookPending {
+			drop = append(drop, d.ID)
+		}
+	}
+	return drop
+}
+
+// selectDeliveries filters ds by subscription and status as
+// WebhookDeliveries does.
+func selectDeliveries(ds []WebhookDelivery, subscriptionID, status string) []WebhookDelivery {
+	out := []WebhookDelivery{}
+	for _, d := range ds {
+		if (subscriptionID == "" || d.SubscriptionID == subscriptionID) && (status == "" || d.Status == status) {
+			out = append(out, d)
+		}
+	}
+	newestFirst(out)
+	return out
+}
+
+func sortWebhooks(subs []WebhookSubscription) {
+	sort.Slice(subs, func(i, j int) bool {
+		if !subs[i].CreatedAt.Equal(subs[j].CreatedAt) {
+			return subs[i].CreatedAt.Before(subs[j].CreatedAt)
+		}
+		return subs[i].ID < subs[j].ID
+	})
+}
+
+func (m *memStore) CreateWebhook(ctx context.Context, s WebhookSubscription) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s.Events = append([]string(nil), s.Events...)
+	m.webhooks[s.ID] = s
+	return nil
+}
+
+func (m *memStore) GetWebhook(ctx context.Context, id string) (*WebhookSubscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.webhooks[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &s, nil
+}
+
+func (m *memStore) ListWebhooks(ctx context.Context) ([]WebhookSubscription, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := []WebhookSubscription{}
+	for _, s := range m.webhooks {
+		out = append(out, s)
+	}
+	sortWebhooks(out)
+	return out, nil
+}
+
+func (m *memStore) DeleteWebhook(ctx context.Context, id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.webhooks[id]; !ok {
+		return ErrNotFound
+	}
+	delete(m.webhooks, id)
+	for did, d := range m.deliveries {
+		if d.SubscriptionID == id {
+			delete(m.deliveries, did)
+		}
+	}
+	return nil
+}
+
+func (m *memStore) QueueWebhookDeliveries(ctx context.Context, ds []WebhookDelivery, keep int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	subs := map[string]bool{}
+	for _, d := range ds {
+		if _, ok := m.webhooks[d.SubscriptionID]; ok {
+			m.deliveries[d.ID] = d
+			subs[d.SubscriptionID] = true
+		}
+	}
+	for sub := range subs {
+		for _, id := range overHistory(selectDeliveries(m.deliveryList(), sub, ""), keep) {
+			delete(m.deliveries, id)
+		}
+	}
+	return nil
+}
+
+// deliveryList returns the tenant's deliveries; m.mu must be held.
+func (m *memStore) deliveryList() []WebhookDelivery {
+	out := make([]WebhookDelivery, 0, len(m.deliveries))
+	for _, d := range m.deliveries {
+		out = append(out, d)
+	}
+	return out
+}
+
+func (m *memStore) WebhookDeliveries(ctx context.Context, subscriptionID, status string) ([]WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return selectDeliveries(m.deliveryList(), subscriptionID, status), nil
+}
+
+func (m *memStore) ClaimWebhookDeliveries(ctx context.Context, now, until time.Time, limit int) ([]WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out []WebhookDelivery
+	for _, d := range m.deliveries {
+		if d.due(now) {
+			out = append(out, d)
+		}
+	}
+	dueFirst(out)
+	if len(out) > limit {
+		out = out[:limit]
+	}
+	for i := range out {
+		out[i].ClaimedUntil = until
+		m.deliveries[out[i].ID] = out[i]
+	}
+	return out, nil
+}
+
+func (m *memStore) NextWebhookAttempt(ctx context.Context) (time.Time, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var next time.Time
+	for _, d := range m.deliveries {
+		if d.Status == WebhookPending && (next.IsZero() || d.claimableAt().Before(next)) {
+			next = d.claimableAt()
+		}
+	}
+	return next, nil
+}
+
+func (m *memStore) FinishWebhookAttempt(ctx context.Context, id string, att WebhookAttempt, status string, next time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.deliveries[id]
+	if !ok {
+		return ErrNotFound
+	}
+	d.Attempts = append([]WebhookAttempt(nil), d.Attempts...)
+	d.finish(att, status, next)
+	m.deliveries[id] = d
+	return nil
+}
+
+func (m *memStore) RedeliverWebhook(ctx context.Context, id string, at time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	d, ok := m.deliveries[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if err := d.redeliver(at); err != nil {
+		return err
+	}
+	m.deliveries[id] = d
+	return nil
+}
+
+const webhookColumns = `id, url, events, secret, created_by, created_at`
+
+func scanWebhook(row pgx.Row) (*WebhookSubscription, error) {
+	var s WebhookSubscription
+	if err := row.Scan(&s.ID, &s.URL, &s.Events, &s.Secret, &s.CreatedBy, &s.CreatedAt); err != nil {
+		return nil, notFound(err)
+	}
+	return &s, nil
+}
+
+const webhookDeliveryColumns = `id, subscription_id, event, payload, status, created_at, next_attempt_at, attempts, claimed_until`
+
+func scanWebhookDelivery(row pgx.Row) (*WebhookDelivery, error) {
+	var d WebhookDelivery
+	var next, claimed *time.Time
+	if err := row.Scan(&d.ID, &d.SubscriptionID, &d.Event, &d.Payload, &d.Status, &d.CreatedAt, &next, &d.Attempts, &claimed); err != nil {
+		return nil, notFound(err)
+	}
+	d.NextAttempt, d.ClaimedUntil = zeroTime(next), zeroTime(claimed)
+	return &d, nil
+}
+
+func collectWebhookDeliveries(rows pgx.Rows) ([]WebhookDelivery, error) {
+	defer rows.Close()
+	out := []WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, *d)
+	}
+	return out, rows.Err()
+}
+
+func (p *pgStore) CreateWebhook(ctx context.Context, s WebhookSubscription) error {
+	return p.inTx(ctx, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `INSERT INTO webhook_subscriptions (`+webhookColumns+`) VALUES ($1,$2,$3,$4,$5,$6)`,
+			s.ID, s.URL, s.Events, s.Secret, s.CreatedBy, s.CreatedAt)
+		return err
+	})
+}
+
+func (p *pgStore) GetWebhook(ctx context.Context, id string) (*WebhookSubscription, error) {
+	var out *WebhookSubscription
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		var err error
+		out, err = scanWebhook(tx.QueryRow(ctx, `SELECT `+webhookColumns+` FROM webhook_subscriptions WHERE id=$1`, id))
+		return err
+	})
+	return out, err
+}
+
+func (p *pgStore) ListWebhooks(ctx context.Context) ([]WebhookSubscription, error) {
+	out := []WebhookSubscription{}
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `SELECT `+webhookColumns+` FROM webhook_subscriptions ORDER BY created_at, id`)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+		for rows.Next() {
+			s, err := scanWebhook(rows)
+			if err != nil {
+				return err
+			}
+			out = append(out, *s)
+		}
+		return rows.Err()
+	})
+	return out, err
+}
+
+// DeleteWebhook relies on the foreign key cascading to the deliveries.
+func (p *pgStore) DeleteWebhook(ctx context.Context, id string) error {
+	return p.inTx(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `DELETE FROM webhook_subscriptions WHERE id=$1`, id)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (p *pgStore) QueueWebhookDeliveries(ctx context.Context, ds []WebhookDelivery, keep int) error {
+	return p.inTx(ctx, func(tx pgx.Tx) error {
+		subs := map[string]bool{}
+		for _, d := range ds {
+			// the row lock keeps the subscription from being deleted
+			// until this transaction ends
+			var sub string
+			err := tx.QueryRow(ctx, `SELECT id FROM webhook_subscriptions WHERE id=$1 FOR SHARE`, d.SubscriptionID).Scan(&sub)
+			if errors.Is(err, pgx.ErrNoRows) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			attempts := d.Attempts
+			if attempts == nil {
+				attempts = []WebhookAttempt{}
+			}
+			_, err = tx.Exec(ctx, `INSERT INTO webhook_deliveries (`+webhookDeliveryColumns+`) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
+				d.ID, d.SubscriptionID, d.Event, d.Payload, d.Status, d.CreatedAt, nullTime(d.NextAttempt), attempts, nullTime(d.ClaimedUntil))
+			if err != nil {
+				return err
+			}
+			subs[sub] = true
+		}
+		for sub := range subs {
+			_, err := tx.Exec(ctx, `
+        DELETE FROM webhook_deliveries WHERE subscription_id=$1 AND status <> $3 AND id IN (
+          SELECT id FROM webhook_deliveries WHERE subscription_id=$1
+          ORDER BY created_at DESC, id DESC OFFSET $2)`, sub, keep, WebhookPending)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (p *pgStore) WebhookDeliveries(ctx context.Context, subscriptionID, status string) ([]WebhookDelivery, error) {
+	var out []WebhookDelivery
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `SELECT `+webhookDeliveryColumns+` FROM webhook_deliveries
+      WHERE ($1 = '' OR subscription_id = $1) AND ($2 = '' OR status = $2)
+      ORDER BY created_at DESC, id DESC`, subscriptionID, status)
+		if err != nil {
+			return err
+		}
+		out, err = collectWebhookDeliveries(rows)
+		return err
+	})
+	return out, err
+}
+
+// ClaimWebhookDeliveries skips rows locked by a concurrent claim, so
+// dispatchers on several replicas never claim the same delivery.
+func (p *pgStore) ClaimWebhookDeliveries(ctx context.Context, now, until time.Time, limit int) ([]WebhookDelivery, error) {
+	var out []WebhookDelivery
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, `
+      UPDATE webhook_deliveries SET claimed_until=$3 WHERE id IN (
+        SELECT id FROM webhook_deliveries
+        WHERE status=$4 AND next_attempt_at <= $1 AND (claimed_until IS NULL OR claimed_until <= $1)
+        ORDER BY next_attempt_at, id LIMIT $2 FOR UPDATE SKIP LOCKED)
+      RETURNING `+webhookDeliveryColumns, now, limit, until, WebhookPending)
+		if err != nil {
+			return err
+		}
+		out, err = collectWebhookDeliveries(rows)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	dueFirst(out)
+	return out, nil
+}
+
+func (p *pgStore) NextWebhookAttempt(ctx context.Context) (time.Time, error) {
+	var next *time.Time
+	err := p.inTx(ctx, func(tx pgx.Tx) error {
+		return tx.QueryRow(ctx, `SELECT min(greatest(next_attempt_at, claimed_until)) FROM webhook_deliveries WHERE status=$1`, WebhookPending).Scan(&next)
+	})
+	return zeroTime(next), err
+}
+
+func (p *pgStore) FinishWebhookAttempt(ctx context.Context, id string, att WebhookAttempt, status string, next time.Time) error {
+	return p.inTx(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `
+      UPDATE webhook_deliveries SET attempts = attempts || jsonb_build_array($2::jsonb), status=$3, next_attempt_at=$4, claimed_until=NULL
+      WHERE id=$1`, id, att, status, nullTime(next))
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (p *pgStore) RedeliverWebhook(ctx context.Context, id string, at time.Time) error {
+	return p.inTx(ctx, func(tx pgx.Tx) error {
+		tag, err := tx.Exec(ctx, `
+      UPDATE webhook_deliveries SET status=$2, attempts='[]'::jsonb, next_attempt_at=$3, claimed_until=NULL
+      WHERE id=$1 AND status=$4`, id, WebhookPending, at, WebhookDead)
+		if err != nil {
+			return err
+		}
+		if tag.RowsAffected() == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+func (b *boltStore) CreateWebhook(ctx context.Context, s WebhookSubscription) error {
+	v, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return b.tenantBuckets(tx).Bucket(bucketWebhooks).Put([]byte(s.ID), v)
+	})
+}
+
+func (b *boltStore) GetWebhook(ctx context.Context, id string) (*WebhookSubscription, error) {
+	var s WebhookSubscription
+	err := b.db.View(func(tx *bolt.Tx) error {
+		v := b.tenantBuckets(tx).Bucket(bucketWebhooks).Get([]byte(id))
+		if v == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, &s)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+func (b *boltStore) ListWebhooks(ctx context.Context) ([]WebhookSubscription, error) {
+	out := []WebhookSubscription{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return b.tenantBuckets(tx).Bucket(bucketWebhooks).ForEach(func(_, v []byte) error {
+			var s WebhookSubscription
+			if err := json.Unmarshal(v, &s); err != nil {
+				return err
+			}
+			out = append(out, s)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	sortWebhooks(out)
+	return out, nil
+}
+
+func (b *boltStore) DeleteWebhook(ctx context.Context, id string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		buckets := b.tenantBuckets(tx)
+		subs := buckets.Bucket(bucketWebhooks)
+		if subs.Get([]byte(id)) == nil {
+			return ErrNotFound
+		}
+		if err := subs.Delete([]byte(id)); err != nil {
+			return err
+		}
+		ds, err := boltDeliveries(buckets.Bucket(bucketWebhookDeliveries))
+		if err != nil {
+			return err
+		}
+		for _, d := range ds {
+			if d.SubscriptionID == id {
+				if err := buckets.Bucket(bucketWebhookDeliveries).Delete([]byte(d.ID)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// boltDeliveries decodes every delivery in bucket.
+func boltDeliveries(bucket *bolt.Bucket) ([]WebhookDelivery, error) {
+	var out []WebhookDelivery
+	err := bucket.ForEach(func(_, v []byte) error {
+		var d WebhookDelivery
+		if err := json.Unmarshal(v, &d); err != nil {
+			return err
+		}
+		out = append(out, d)
+		return nil
+	})
+	return out, err
+}
+
+func putDelivery(bucket *bolt.Bucket, d WebhookDelivery) error {
+	v, err := json.Marshal(d)
+	if err != nil {
+		return err
+	}
+	return bucket.Put([]byte(d.ID), v)
+}
+
+func (b *boltStore) QueueWebhookDeliveries(ctx context.Context, ds []WebhookDelivery, keep int) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		buckets := b.tenantBuckets(tx)
+		bucket := buckets.Bucket(bucketWebhookDeliveries)
+		subs := map[string]bool{}
+		for _, d := range ds {
+			if buckets.Bucket(bucketWebhooks).Get([]byte(d.SubscriptionID)) == nil {
+				continue
+			}
+			if err := putDelivery(bucket, d); err != nil {
+				return err
+			}
+			subs[d.SubscriptionID] = true
+		}
+		all, err := boltDeliveries(bucket)
+		if err != nil {
+			return err
+		}
+		for sub := range subs {
+			for _, id := range overHistory(selectDeliveries(all, sub, ""), keep) {
+				if err := bucket.Delete([]byte(id)); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+func (b *boltStore) WebhookDeliveries(ctx context.Context, subscriptionID, status string) ([]WebhookDelivery, error) {
+	var all []WebhookDelivery
+	err := b.db.View(func(tx *bolt.Tx) error {
+		var err error
+		all, err = boltDeliveries(b.tenantBuckets(tx).Bucket(bucketWebhookDeliveries))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return selectDeliveries(all, subscriptionID, status), nil
+}
+
+func (b *boltStore) ClaimWebhookDeliveries(ctx context.Context, now, until time.Time, limit int) ([]WebhookDelivery, error) {
+	var out []WebhookDelivery
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := b.tenantBuckets(tx).Bucket(bucketWebhookDeliveries)
+		all, err := boltDeliveries(bucket)
+		if err != nil {
+			return err
+		}
+		for _, d := range all {
+			if d.due(now) {
+				out = append(out, d)
+			}
+		}
+		dueFirst(out)
+		if len(out) > limit {
+			out = out[:limit]
+		}
+		for i := range out {
+			out[i].ClaimedUntil = until
+			if err := putDelivery(bucket, out[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (b *boltStore) NextWebhookAttempt(ctx context.Context) (time.Time, error) {
+	var next time.Time
+	err := b.db.View(func(tx *bolt.Tx) error {
+		all, err := boltDeliveries(b.tenantBuckets(tx).Bucket(bucketWebhookDeliveries))
+		for _, d := range all {
+			if d.Status == WebhookPending && (next.IsZero() || d.claimableAt().Before(next)) {
+				next = d.claimableAt()
+			}
+		}
+		return err
+	})
+	return next, err
+}
+
+// updateDelivery applies fn to the stored delivery id unless fn fails.
+func (b *boltStore) updateDelivery(id string, fn func(*WebhookDelivery) error) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := b.tenantBuckets(tx).Bucket(bucketWebhookDeliveries)
+		v := bucket.Get([]byte(id))
+		if v == nil {
+			return ErrNotFound
+		}
+		var d WebhookDelivery
+		if err := json.Unmarshal(v, &d); err != nil {
+			return err
+		}
+		if err := fn(&d); err != nil {
+			return err
+		}
+		return putDelivery(bucket, d)
+	})
+}
+
+func (b *boltStore) FinishWebhookAttempt(ctx context.Context, id string, att WebhookAttempt, status string, next time.Time) error {
+	return b.updateDelivery(id, func(d *WebhookDelivery) error {
+		d.finish(att, status, next)
+		return nil
+	})
+}
+
+func (b *boltStore) RedeliverWebhook(ctx context.Context, id string, at time.Time) error {
+	return b.updateDelivery(id, func(d *WebhookDelivery) error { return d.redeliver(at) })
+}